@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensourceways/community-robot-lib/giteeclient"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultActivityRecentPRs       = 20
+	defaultActivityRefreshInterval = 6 * time.Hour
+)
+
+type activityRanking struct {
+	// Disabled stops suggesting the recently active approvers first.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// RecentPRs is the number of the latest merged PRs whose comments are
+	// looked at to find out the activity of approvers. The default is 20.
+	RecentPRs int `json:"recent_prs,omitempty"`
+
+	// RefreshHours is the interval of refreshing the activity in hours. The
+	// default is 6.
+	RefreshHours int `json:"refresh_hours,omitempty"`
+}
+
+func (r *activityRanking) recentPRs() int {
+	if r.RecentPRs > 0 {
+		return r.RecentPRs
+	}
+	return defaultActivityRecentPRs
+}
+
+func (r *activityRanking) refreshInterval() time.Duration {
+	if r.RefreshHours > 0 {
+		return time.Duration(r.RefreshHours) * time.Hour
+	}
+	return defaultActivityRefreshInterval
+}
+
+type repoActivity struct {
+	lastActive  map[string]time.Time
+	refreshedAt time.Time
+	refreshing  bool
+}
+
+// activityCache caches the time of the latest comment of each user on the
+// recently merged PRs of repos.
+type activityCache struct {
+	cli   iClient
+	lock  sync.Mutex
+	items map[string]*repoActivity
+}
+
+func newActivityCache(cli iClient) *activityCache {
+	return &activityCache{cli: cli, items: map[string]*repoActivity{}}
+}
+
+// get returns the cached activity of the repo, and refreshes it in background
+// if it is stale. It is empty before the first refresh is done.
+func (c *activityCache) get(org, repo string, cfg *activityRanking) map[string]time.Time {
+	k := org + "/" + repo
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	v, ok := c.items[k]
+	if !ok {
+		v = &repoActivity{}
+		c.items[k] = v
+	}
+
+	if !v.refreshing && time.Since(v.refreshedAt) > cfg.refreshInterval() {
+		v.refreshing = true
+		go c.refresh(org, repo, cfg.recentPRs())
+	}
+
+	return v.lastActive
+}
+
+func (c *activityCache) refresh(org, repo string, n int) {
+	lastActive, err := c.fetch(org, repo, n)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	v := c.items[org+"/"+repo]
+	v.refreshing = false
+
+	if err != nil {
+		logrus.WithError(err).Errorf("refresh activity of %s/%s", org, repo)
+		return
+	}
+
+	v.lastActive = lastActive
+	v.refreshedAt = time.Now()
+}
+
+func (c *activityCache) fetch(org, repo string, n int) (map[string]time.Time, error) {
+	prs, err := c.cli.GetPullRequests(org, repo, giteeclient.ListPullRequestOpt{State: "merged"})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(prs, func(i, j int) bool {
+		return prs[i].MergedAt > prs[j].MergedAt
+	})
+	if len(prs) > n {
+		prs = prs[:n]
+	}
+
+	lastActive := map[string]time.Time{}
+	for i := range prs {
+		comments, err := c.cli.ListPRComments(org, repo, prs[i].Number)
+		if err != nil {
+			return nil, err
+		}
+
+		for j := range comments {
+			cm := &comments[j]
+			if cm.User == nil {
+				continue
+			}
+
+			t, err := time.Parse(time.RFC3339, cm.CreatedAt)
+			if err != nil {
+				continue
+			}
+
+			login := strings.ToLower(cm.User.Login)
+			if t.After(lastActive[login]) {
+				lastActive[login] = t
+			}
+		}
+	}
+
+	return lastActive, nil
+}
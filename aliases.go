@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/opensourceways/repo-owners-cache/repoowners"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+// aliasedOwners expands the alias groups in the approvers of the OWNERS files.
+type aliasedOwners struct {
+	repoowners.RepoOwner
+
+	aliases approvers.RepoAliases
+}
+
+func (o aliasedOwners) Approvers(path string) sets.String {
+	return o.aliases.Expand(o.RepoOwner.Approvers(path))
+}
+
+func (o aliasedOwners) LeafApprovers(path string) sets.String {
+	return o.aliases.Expand(o.RepoOwner.LeafApprovers(path))
+}
+
+func (o aliasedOwners) TopLevelApprovers() sets.String {
+	return o.aliases.Expand(o.RepoOwner.TopLevelApprovers())
+}
+
+// soleOwners replaces the approvers of all the OWNERS files with the same
+// ones, as if there is only the root OWNERS file.
+type soleOwners struct {
+	repoowners.RepoOwner
+
+	approvers sets.String
+}
+
+func (o soleOwners) FindApproverOwnersForFile(path string) string {
+	return ""
+}
+
+func (o soleOwners) IsNoParentOwners(path string) bool {
+	return false
+}
+
+func (o soleOwners) Approvers(path string) sets.String {
+	return o.approvers
+}
+
+func (o soleOwners) LeafApprovers(path string) sets.String {
+	return o.approvers
+}
+
+func (o soleOwners) TopLevelApprovers() sets.String {
+	return o.approvers
+}
+
+// loadOwners is like loadRepoOwners, but replaces the approvers with the sole
+// ones and expands the alias groups as configured.
+func (bot *robot) loadOwners(org, repo, base string, cfg *botConfig) (repoowners.RepoOwner, error) {
+	oc, err := bot.lookupRepoOwners(org, repo, base)
+	if err != nil {
+		if !cfg.OwnersFallback {
+			return nil, err
+		}
+
+		fetched, ferr := bot.fetchOwners(org, repo, base)
+		if ferr != nil {
+			return nil, fmt.Errorf("%v, and fetching the OWNERS files failed: %v", err, ferr)
+		}
+		oc = fetched
+	}
+
+	if len(cfg.soleApprovers) > 0 {
+		v := sets.NewString()
+		for _, a := range cfg.soleApprovers {
+			v.Insert(strings.ToLower(a))
+		}
+		oc = soleOwners{RepoOwner: oc, approvers: v}
+	}
+
+	if cfg.OwnersAliasesFile == "" {
+		return oc, nil
+	}
+
+	aliases, err := bot.loadAliases(org, repo, base, cfg.OwnersAliasesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return aliasedOwners{RepoOwner: oc, aliases: aliases}, nil
+}
+
+func (bot *robot) loadAliases(org, repo, base, file string) (approvers.RepoAliases, error) {
+	c, err := bot.cli.cli.GetPathContent(org, repo, strings.Trim(file, "/"), base)
+	if err != nil {
+		return nil, fmt.Errorf("get aliases file %s: %v", file, err)
+	}
+
+	b, err := base64.StdEncoding.DecodeString(c.Content)
+	if err != nil {
+		return nil, fmt.Errorf("decode aliases file %s: %v", file, err)
+	}
+
+	aliases, err := approvers.ParseAliases(b)
+	if err != nil {
+		return nil, fmt.Errorf("parse aliases file %s: %v", file, err)
+	}
+
+	return aliases, nil
+}
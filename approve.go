@@ -1,28 +1,45 @@
 package main
 
 import (
+	"fmt"
 	"net/url"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
-	sdk "github.com/opensourceways/go-gitee/gitee"
 	"github.com/opensourceways/repo-owners-cache/repoowners"
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/test-infra/prow/github"
+	"k8s.io/test-infra/prow/labels"
 
 	"github.com/opensourceways/robot-gitee-approve/approve"
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
 	"github.com/opensourceways/robot-gitee-approve/approve/config"
+	"github.com/opensourceways/robot-gitee-approve/metrics"
 )
 
 const (
 	approveCommand = "APPROVE"
 	lgtmCommand    = "LGTM"
+
+	// inputsMaxAge is how long the inputs of a PR from outside, such as the
+	// requirements of federation, are taken as unchanged since they were
+	// loaded, so that the PR unchanged otherwise is skipped without loading
+	// them.
+	inputsMaxAge = 10 * time.Minute
 )
 
-var commandReg = regexp.MustCompile(`(?m)^/([^\s]+)[\t ]*([^\n\r]*)`)
+var (
+	commandReg   = regexp.MustCompile(`(?m)^/([^\s]+)[\t ]*([^\n\r]*)`)
+	dependsOnReg = regexp.MustCompile(`(?mi)^depends-on:[\t ]*#(\d+)`)
+)
 
 func (bot *robot) loadRepoOwners(org, repo, base string) (repoowners.RepoOwner, error) {
-	return repoowners.NewRepoOwners(
+	start := time.Now()
+	oc, err := repoowners.NewRepoOwners(
 		repoowners.RepoBranch{
 			Platform: "gitee",
 			Org:      org,
@@ -31,53 +48,224 @@ func (bot *robot) loadRepoOwners(org, repo, base string) (repoowners.RepoOwner,
 		},
 		bot.cacheCli,
 	)
+	metrics.ObserveOwnersLookup(start, err)
+	bot.tracer.record("owners.lookup", org, repo, 0, start, err)
+
+	return oc, err
 }
 
-func (bot *robot) handle(org, repo string, pr *sdk.PullRequestHook, cfg *botConfig, log *logrus.Entry) error {
-	targetBranch := pr.GetBase().GetRef()
-	oc, err := bot.loadRepoOwners(org, repo, targetBranch)
+func (bot *robot) handle(org, repo string, pr prInfo, cfg *botConfig, refresh bool, log *logrus.Entry) (err error) {
+	start := time.Now()
+	span, log := bot.tracer.startSpan("approve.handle", org, repo, pr.number, map[string]string{
+		"refresh": strconv.FormatBool(refresh),
+	}, log)
+	defer func() {
+		metrics.ObserveHandle(org, repo, start, err)
+		span.finish(err)
+	}()
+
+	cfg = cfg.forAuthor(pr.author)
+	oc, err := bot.loadOwners(org, repo, pr.base, cfg)
 	if err != nil {
+		bot.noticeOwnersUnavailable(bot.cli.withDryRun(cfg.DryRun), org, repo, pr, err, log)
 		return err
 	}
+	bot.ownersNotices.clear(org, repo, pr.number)
 
-	var assignees []github.User
+	state := bot.newState(org, repo, pr, cfg, oc, log)
+	if v, ok := bot.snapshots.get(org, repo, pr.number); ok {
+		state.SetLastFingerprint(v.Fingerprint)
+	}
+	state.SetCommentsHint(bot.hints.get(org, repo, pr.number))
+	state.SetRefresh(refresh)
+	c := transformConfig(org, cfg)
+	cli := bot.cli.withDryRun(cfg.DryRun)
+
+	r, err := approve.Handle(
+		log, cli, oc,
+		getGiteeOption(), &c, state,
+	)
+	bot.hints.update(org, repo, pr.number, r, err)
+	bot.audit.record(org, repo, pr, &r, cli.dryRun)
+	bot.recordLabelProvenance(org, repo, pr, &r, cli.dryRun, log)
+	if err != nil {
+		return err
+	}
+
+	// The fingerprint recorded keeps the digest of the inputs loaded.
+	if state.InputsLoaded() && !r.Skipped {
+		bot.snapshots.setInputsLoaded(org, repo, pr, time.Now())
+	}
+
+	before, _ := bot.snapshots.get(org, repo, pr.number)
+	bot.snapshots.record(org, repo, pr, cfg.Series.topic(pr), r)
+
+	if len(cfg.ChatRoutes) > 0 && !r.Skipped {
+		notifyPending(org, repo, pr, cfg.ChatRoutes, oc, before.PendingPaths, r.UnapprovedFiles, cli.dryRun, log)
+	}
 
-	as := pr.GetAssignees()
-	if n := len(as); n > 0 {
-		assignees := make([]github.User, n)
-		for i := range as {
-			assignees[i] = github.User{Login: as[i].GetLogin()}
+	metrics.CommentClockSkew(org, repo, "future", r.FutureComments)
+	metrics.CommentClockSkew(org, repo, "out_of_order", r.ReorderedComments)
+
+	if !r.Skipped {
+		bot.suggested.record(org, repo, pr.number, r.SuggestedApprovers)
+		bot.pingExpiredApprovers(cli, org, repo, pr, before.ExpiredApprovers, r.ExpiredApprovers, log)
+	}
+
+	if cfg.ApprovalCheckRun && !r.Skipped {
+		if err := bot.reportCheckRun(cli, org, repo, pr, r); err != nil {
+			log.WithError(err).Error("report the check run")
+		}
+	}
+
+	return nil
+}
+
+// recordLabelProvenance remembers when the robot changed the approved label,
+// and reports the changes of the other robots to it which are reverted.
+func (bot *robot) recordLabelProvenance(org, repo string, pr prInfo, r *approve.Result, dryRun bool, log *logrus.Entry) {
+	if p := r.LabelConflict; p != nil {
+		metrics.ApprovedLabelConflict(org, repo, p.Action)
+		log.WithFields(logrus.Fields{
+			"actor": p.Actor, "action": p.Action, "at": p.At,
+		}).Warn("Conflict with another robot on the approved label.")
+	}
+
+	if dryRun {
+		return
+	}
+
+	changes := sets.NewString(r.LabelsAdded...).Union(sets.NewString(r.LabelsRemoved...))
+	if changes.Has(labels.Approved) {
+		bot.snapshots.setApprovedLabelChanged(org, repo, pr, time.Now())
+	}
+}
+
+func (bot *robot) newState(org, repo string, pr prInfo, cfg *botConfig, oc repoowners.RepoOwner, log *logrus.Entry) *approve.State {
+	var assignees []github.User
+	for _, a := range pr.assignees {
+		for _, login := range expandAssignee(a, cfg, oc) {
+			assignees = append(assignees, github.User{Login: login})
 		}
 	}
 
 	state := approve.NewState(
 		org, repo,
-		targetBranch,
-		pr.GetBody(),
-		pr.GetUser().GetLogin(),
-		pr.GetHtmlURL(),
-		int(pr.GetNumber()),
+		pr.base,
+		pr.body,
+		pr.author,
+		pr.htmlURL,
+		pr.number,
 		assignees,
 	)
 
-	c := transformConfig(org, cfg)
+	state.SetRevisions(pr.headSHA, pr.baseSHA)
 
-	return approve.Handle(
-		log, &bot.cli, oc,
-		getGiteeOption(), &c, state,
-	)
+	last, ok := bot.snapshots.get(org, repo, pr.number)
+	if ok {
+		state.SetLastPush(last.PushedAt)
+		state.SetOwnLabelChange(last.ApprovedLabelAt)
+		state.SetApprovalsExpireAt(last.ApprovalsExpireAt)
+	}
+
+	if !cfg.ActivityRanking.Disabled {
+		state.SetActivity(bot.activity.get(org, repo, &cfg.ActivityRanking))
+	}
+
+	state.SetOverloaded(bot.capacity.overloaded(org, repo, &cfg.ApproverCapacity))
+
+	if cfg.SuggestionStrategy == approvers.SuggestLeastLoaded {
+		state.SetSuggestionLoad(bot.suggested.load(org, repo, pr.number))
+	}
+
+	if topic, prs := bot.seriesOf(org, repo, pr, cfg); topic != "" {
+		state.SetSeries(topic, prs)
+	}
+
+	state.SetFileRequirements(func(files []string) []approvers.Requirement {
+		return bot.requiredOwnersRequirements(org, repo, pr, files, log)
+	})
+
+	// The inputs from outside are loaded by the handling only if they are
+	// needed. It fails if the requirements of federation can't be told,
+	// rather than handling the PR without them.
+	state.SetInputs(func(s *approve.State) error {
+		if cfg.StackedPRs {
+			s.SetDependencies(bot.getDependencies(org, repo, pr.body, log))
+		}
+
+		reqs, err := bot.federatedRequirements(org, repo, pr.number, cfg, log)
+		if err != nil {
+			return fmt.Errorf("get the requirements of federation: %v", err)
+		}
+		s.SetRequirements(reqs)
+
+		s.SetExternalApprovals(bot.externalApprovals(org, repo, pr, cfg, log))
+
+		return nil
+	}, ok && time.Since(last.InputsAt) < inputsMaxAge)
+
+	return state
+}
+
+// expandAssignee returns the approvers of the team's OWNERS file if the
+// assignee stands for a team, otherwise the assignee itself.
+func expandAssignee(login string, cfg *botConfig, oc repoowners.RepoOwner) []string {
+	dir, ok := cfg.TeamAssignees[login]
+	if !ok {
+		return []string{login}
+	}
+
+	return oc.Approvers(filepath.Join(dir, "OWNERS")).List()
 }
 
-func isApproveCommand(comment string, lgtmActsAsApprove bool) bool {
-	for _, match := range commandReg.FindAllStringSubmatch(comment, -1) {
+// getDependencies returns the PRs referenced by the "Depends-on: #number" lines
+// of the PR body along with their approval state.
+func (bot *robot) getDependencies(org, repo, body string, log *logrus.Entry) []approvers.Dependency {
+	var deps []approvers.Dependency
+
+	for _, m := range dependsOnReg.FindAllStringSubmatch(body, -1) {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+
+		ls, err := bot.cli.GetIssueLabels(org, repo, n)
+		if err != nil {
+			log.WithError(err).Errorf("get labels of dependency #%d", n)
+		}
+
+		approved := false
+		for i := range ls {
+			if ls[i].Name == labels.Approved {
+				approved = true
+				break
+			}
+		}
+
+		deps = append(deps, approvers.Dependency{Number: n, Approved: approved})
+	}
+
+	return deps
+}
+
+// parseApproveCommands reports whether the comment has any command which
+// changes the approval, and whether any of them cancels an approval, such as
+// "/approve cancel" or "/lgtm cancel" when lgtm acts as approve.
+func parseApproveCommands(comment string, lgtmActsAsApprove bool) (found bool, cancel bool) {
+	for _, match := range commandReg.FindAllStringSubmatch(approve.SanitizeCommandText(comment), -1) {
 		cmd := strings.ToUpper(match[1])
 
 		if cmd == approveCommand || (cmd == lgtmCommand && lgtmActsAsApprove) {
-			return true
+			found = true
+
+			if approve.IsCancelArguments(match[2]) {
+				cancel = true
+			}
 		}
 	}
 
-	return false
+	return
 }
 
 func getGiteeOption() config.GitHubOptions {
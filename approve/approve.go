@@ -17,15 +17,14 @@ limitations under the License.
 package approve
 
 import (
+	"errors"
 	"fmt"
-	"net/url"
 	"regexp"
-	"sort"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/test-infra/prow/github"
 	"k8s.io/test-infra/prow/labels"
 
@@ -39,12 +38,25 @@ const (
 	cancelArgument  = "cancel"
 	lgtmCommand     = "LGTM"
 	noIssueArgument = "no-issue"
+	setArgument     = "set"
+	statusArgument  = "status"
+	refreshArgument = "refresh"
+	configArgument  = "config"
+	ownersArgument  = "owners"
+
+	// logModule is the field of log entries naming the module which logs them.
+	logModule = "module"
 )
 
 var (
-	associatedIssueRegexFormat = `(?:%s/[^/]+/issues/|#)(\d+)`
+	// associatedIssueRegexFormat matches the issues of the org referenced
+	// by the links, such as https://gitee.com/org/repo/issues/I5ABCD, or
+	// by the IDs, such as #I5ABCD. The IDs of Gitee are alphanumeric, while
+	// those of GitHub are numeric.
+	associatedIssueRegexFormat = `(?:%s/[^/\s]+/issues/|#)(I[0-9A-Z]+|\d+)\b`
 	commandRegex               = regexp.MustCompile(`(?m)^/([^\s]+)[\t ]*([^\n\r]*)`)
 	notificationRegex          = regexp.MustCompile(`(?is)^\[` + approvers.ApprovalNotificationName + `\] *?([^\n]*)(?:\n\n(.*))?`)
+	instructionsRegex          = regexp.MustCompile(`(?i)^\[` + approvers.ApprovalInstructionsName + `\]`)
 
 	// deprecatedBotNames are the names of the bots that previously handled approvals.
 	// Each can be removed once every PR approved by the old bot has been merged or unapproved.
@@ -59,14 +71,18 @@ type githubClient interface {
 	GetPullRequestChanges(org, repo string, number int) ([]github.PullRequestChange, error)
 	GetIssueLabels(org, repo string, number int) ([]github.Label, error)
 	ListIssueComments(org, repo string, number int) ([]github.IssueComment, error)
+	GetIssueComment(org, repo string, ID int) (*github.IssueComment, error)
 	ListReviews(org, repo string, number int) ([]github.Review, error)
 	ListPullRequestComments(org, repo string, number int) ([]github.ReviewComment, error)
 	DeleteComment(org, repo string, ID int) error
+	EditComment(org, repo string, ID int, comment string) error
 	CreateComment(org, repo string, number int, comment string) error
 	BotName() (string, error)
 	AddLabel(org, repo string, number int, label string) error
 	RemoveLabel(org, repo string, number int, label string) error
 	ListIssueEvents(org, repo string, num int) ([]github.ListedIssueEvent, error)
+	ListCommentReactions(org, repo string, ID int) ([]Reaction, error)
+	GetPRCommitTime(org, repo string, number int, sha string) (time.Time, error)
 }
 
 type state struct {
@@ -79,24 +95,201 @@ type state struct {
 	author    string
 	assignees []github.User
 	htmlURL   string
+
+	dependencies []approvers.Dependency
+
+	// seriesTopic is the topic of the series of the PR, and series are the
+	// other PRs of it.
+	seriesTopic string
+	series      []approvers.Dependency
+
+	// headSHA and baseSHA are the revisions of the source and target branches.
+	headSHA string
+	baseSHA string
+
+	// activity is the time of the latest activity of approvers.
+	activity map[string]time.Time
+	// overloaded are the lower case logins of the overloaded approvers.
+	overloaded sets.String
+
+	requirements []approvers.Requirement
+	// fileRequirements returns the extra requirements derived from the files
+	// changed by the PR.
+	fileRequirements func(files []string) []approvers.Requirement
+
+	// externalApprovals are the approvals made in the review systems outside.
+	externalApprovals []ExternalApproval
+
+	// suggestionLoad is the number of the PRs which each approver is
+	// suggested for recently, keyed by the lower case login.
+	suggestionLoad map[string]int
+
+	// lastFingerprint is the fingerprint of the PR when it was handled last
+	// time, which is persisted outside.
+	lastFingerprint string
+
+	// commentsHint is what is known of the comments without listing them.
+	commentsHint *CommentsHint
+
+	// refresh regenerates the notification even if nothing has changed.
+	refresh bool
+
+	// lastPushAt is when the source branch was pushed last time.
+	lastPushAt time.Time
+
+	// ownLabelAt is when the robot changed the approved label last time.
+	ownLabelAt time.Time
+
+	// inputs loads the inputs of the PR from outside, which are the
+	// dependencies, the requirements and the external approvals. They are
+	// loaded only if the PR can't be told unchanged without them. inputsFresh
+	// means those of the last handling, whose digest the last fingerprint
+	// keeps, are recent enough to be taken as the current ones.
+	inputs       func(s *State) error
+	inputsFresh  bool
+	inputsLoaded bool
+
+	// approvalsExpireAt is when the earliest of the approvals counted in the
+	// last handling expires.
+	approvalsExpireAt time.Time
 }
 
-// Returns associated issue, or 0 if it can't find any.
-// This is really simple, and could be improved later.
-func findAssociatedIssue(body, org string) (int, error) {
-	associatedIssueRegex, err := regexp.Compile(fmt.Sprintf(associatedIssueRegexFormat, org))
-	if err != nil {
-		return 0, err
+// Result summarizes the decision made by handle for a PR.
+type Result struct {
+	// Skipped reports whether the handling was skipped because nothing has
+	// changed since the latest notification. The other fields are not set
+	// if it is true.
+	Skipped bool
+	// Approved reports whether the PR ended up fully approved.
+	Approved bool
+	// Approvers is the list of logins whose approval is currently counted.
+	Approvers []string
+	// UnapprovedFiles is the list of OWNERS paths which still need approval.
+	UnapprovedFiles []string
+	// OwnersFiles is the number of all the OWNERS paths of the PR.
+	OwnersFiles int
+	// FirstApprovalAt is the creation time of the earliest approval given
+	// by someone other than the author. It is zero if there is none.
+	FirstApprovalAt time.Time
+	// RequiredApprovers is the size of the smallest set of approvers found
+	// to cover every OWNERS file of the PR. It is only computed once the PR
+	// is approved.
+	RequiredApprovers int
+	// Approvals records the comment which established each current approval.
+	Approvals []ApprovalRecord
+	// Fingerprint identifies the state of the PR which the decision is made
+	// on. It is empty if the state can't be identified.
+	Fingerprint string
+	// SuggestedApprovers are the approvers suggested in the notification.
+	SuggestedApprovers []string
+	// Comments is what is learned of the comments when they are listed. It
+	// is nil if they are not listed, such as when the handling is skipped by
+	// the hint of them.
+	Comments *CommentsHint
+	// FutureComments is the number of the comments created in the future by
+	// their timestamps, and ReorderedComments is the number of those whose
+	// timestamps disagree with the order they were made in, which tell the
+	// clock skew of the platform.
+	FutureComments    int
+	ReorderedComments int
+	// LabelsAdded and LabelsRemoved are the labels changed successfully.
+	LabelsAdded   []string
+	LabelsRemoved []string
+	// Files are the files changed by the PR, and OwnersApprovers maps the
+	// OWNERS paths of them to the current approvers counted on each.
+	Files           []string
+	OwnersApprovers map[string][]string
+	// ExpiredApprovers are those whose approvals have expired, and
+	// ApprovalsExpireAt is when the earliest of the approvals counted
+	// expires. They are only set if the approvals have a ttl.
+	ExpiredApprovers  []string
+	ApprovalsExpireAt time.Time
+	// LabelConflict is the change to the approved label made by another
+	// robot, which the handling reverts. It is nil if there is none.
+	LabelConflict *LabelProvenance
+}
+
+// ExternalApproval is an approval of the PR made in a review system outside,
+// such as a Code-Review+2 vote on the linked change in Gerrit, which counts
+// as "/approve".
+type ExternalApproval struct {
+	Login string
+	// URL is where the approval was made.
+	URL string
+	// Source is the name of the review system.
+	Source string
+}
+
+// TooManyFilesError means the files changed by a PR are more than the
+// platform lists, so the approval of the files unseen can't be evaluated.
+type TooManyFilesError struct {
+	// Listed is the number of the files listed.
+	Listed int
+}
+
+func (e *TooManyFilesError) Error() string {
+	return fmt.Sprintf("the PR changes more files than the %d listed", e.Listed)
+}
+
+// WriteError is returned by Handle if some of the changes to the PR failed
+// to be made, such as the approved label, so that the PR can be handled
+// again. The result is still returned along with it.
+type WriteError struct {
+	Errs []error
+}
+
+func (e *WriteError) Error() string {
+	s := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		s[i] = err.Error()
 	}
-	match := associatedIssueRegex.FindStringSubmatch(body)
-	if len(match) == 0 {
-		return 0, nil
+
+	return fmt.Sprintf("failed to change the PR: %s", strings.Join(s, "; "))
+}
+
+// Reaction is an emoji reaction to a comment.
+type Reaction struct {
+	Login string
+	// Content is the name of the emoji, such as "+1".
+	Content string
+}
+
+// ApprovalRecord is the evidence of an approval.
+type ApprovalRecord struct {
+	Login string `json:"login"`
+	How   string `json:"how"`
+	// CommentID is the ID of the comment or review which approved. It is 0
+	// if the approval is implied, such as the one of the author.
+	CommentID int    `json:"comment_id,omitempty"`
+	URL       string `json:"url"`
+}
+
+func approvalRecords(ap approvers.Approvers) []ApprovalRecord {
+	approvals := ap.ListApprovals()
+	r := make([]ApprovalRecord, len(approvals))
+	for i := range approvals {
+		a := &approvals[i]
+		r[i] = ApprovalRecord{Login: a.Login, How: a.How, CommentID: a.CommentID, URL: a.Reference}
+	}
+	return r
+}
+
+// Returns the ID of associated issue, or empty if it can't find any. The
+// pattern, if set, replaces the default one, and its first group is the ID.
+func findAssociatedIssue(body, org, pattern string) (string, error) {
+	if pattern == "" {
+		// The org is escaped, and made valid UTF-8 which the regex requires.
+		pattern = fmt.Sprintf(associatedIssueRegexFormat, regexp.QuoteMeta(strings.ToValidUTF8(org, "\uFFFD")))
 	}
-	v, err := strconv.Atoi(match[1])
+	associatedIssueRegex, err := regexp.Compile(pattern)
 	if err != nil {
-		return 0, err
+		return "", err
 	}
-	return v, nil
+	match := associatedIssueRegex.FindStringSubmatch(SanitizeCommandText(body))
+	if len(match) < 2 {
+		return "", nil
+	}
+	return match[1], nil
 }
 
 // handle is the workhorse the will actually make updates to the PR.
@@ -114,176 +307,631 @@ func findAssociatedIssue(body, org string) (int, error) {
 // - Iff all files have been approved, the bot will add the "approved" label.
 // - Iff a cancel command is found, that reviewer will be removed from the approverSet
 // 	and the munger will remove the approved label if it has been applied
-func handle(log *logrus.Entry, ghc githubClient, repo approvers.Repo, githubConfig config.GitHubOptions, opts *plugins.Approve, pr *state) error {
+func handle(log *logrus.Entry, ghc githubClient, repo approvers.Repo, githubConfig config.GitHubOptions, opts *plugins.Approve, pr *state) (Result, error) {
+	log = log.WithField(logModule, "approve")
 	funcStart := time.Now()
 	defer func() {
 		log.WithField("duration", time.Since(funcStart).String()).Debug("Completed handle")
 	}()
-	fetchErr := func(context string, err error) error {
-		return fmt.Errorf("failed to get %s for %s/%s#%d: %v", context, pr.org, pr.repo, pr.number, err)
+
+	var result Result
+
+	// The inputs from outside are loaded first if the digest of them in the
+	// last handling can't be used, otherwise only if the PR has changed.
+	if pr.inputsDigest() == "" {
+		if err := pr.loadInputs(); err != nil {
+			return result, err
+		}
 	}
 
-	start := time.Now()
-	changes, err := ghc.GetPullRequestChanges(pr.org, pr.repo, pr.number)
+	if !pr.refresh && unchangedByHint(log, ghc, opts, pr) {
+		log.Debug("Nothing changed since the latest notification by the hint of comments, skip handling")
+		result.Skipped = true
+		return result, nil
+	}
+
+	e, err := prefetch(log, ghc, opts, pr)
 	if err != nil {
-		return fetchErr("PR file changes", err)
+		return result, err
 	}
-	var filenames []string
-	for _, change := range changes {
-		filenames = append(filenames, change.Filename)
+
+	notifications := filterComments(e.issueComments, notificationMatcher(e.botName))
+	latestNotification := getLast(notifications)
+	fingerprint := e.fingerprint(pr, opts)
+	result.Comments = &CommentsHint{LastCommentID: e.lastComment}
+	if latestNotification != nil {
+		result.Comments.NotificationID = latestNotification.ID
 	}
-	issueLabels, err := ghc.GetIssueLabels(pr.org, pr.repo, pr.number)
-	if err != nil {
-		return fetchErr("issue labels", err)
+	// The notification keeps the fingerprint of the last change to it, while
+	// the last fingerprint is updated on every handling, including those
+	// which leave the notification as it is.
+	if !pr.refresh && fingerprint != "" && latestNotification != nil &&
+		(parseFingerprint(latestNotification.Body) == fingerprint || pr.lastFingerprint == fingerprint) {
+		log.Debug("Nothing changed since the latest notification, skip handling")
+		result.Skipped = true
+		return result, nil
 	}
-	hasApprovedLabel := false
-	for _, label := range issueLabels {
-		if label.Name == labels.Approved {
-			hasApprovedLabel = true
-			break
+
+	if !pr.inputsLoaded {
+		if err := pr.loadInputs(); err != nil {
+			return result, err
 		}
+		fingerprint = e.fingerprint(pr, opts)
 	}
-	botName, err := ghc.BotName()
-	if err != nil {
-		return fetchErr("bot name", err)
+
+	if err := e.compute(log, ghc, repo, opts, pr); err != nil {
+		var tooMany *TooManyFilesError
+		if errors.As(err, &tooMany) {
+			result.Comments = nil
+			err := e.notifyTooManyFiles(log, ghc, opts, pr, notifications, tooMany.Listed)
+			if err == nil && e.hasApprovedLabel {
+				result.LabelsRemoved = []string{labels.Approved}
+			}
+			return result, err
+		}
+		return result, err
 	}
-	issueComments, err := ghc.ListIssueComments(pr.org, pr.repo, pr.number)
-	if err != nil {
-		return fetchErr("issue comments", err)
+	approversHandler := e.approvers
+
+	// The labels are changed before the notification, which keeps the
+	// fingerprint only if they are all changed, so that the PR isn't skipped
+	// when it is handled again.
+	var writeErrs []error
+	start := time.Now()
+	add, remove := e.labelChanges(opts)
+	if e.labelConflict(add, remove) {
+		result.LabelConflict = e.provenance()
 	}
-	reviewComments, err := ghc.ListPullRequestComments(pr.org, pr.repo, pr.number)
-	if err != nil {
-		return fetchErr("review comments", err)
+	for _, label := range remove {
+		if err := ghc.RemoveLabel(pr.org, pr.repo, pr.number, label); err != nil {
+			log.WithError(err).Errorf("Failed to remove %q label from %s/%s#%d.", label, pr.org, pr.repo, pr.number)
+			writeErrs = append(writeErrs, fmt.Errorf("remove label %q: %w", label, err))
+		} else {
+			result.LabelsRemoved = append(result.LabelsRemoved, label)
+		}
 	}
-	reviews, err := ghc.ListReviews(pr.org, pr.repo, pr.number)
-	if err != nil {
-		return fetchErr("reviews", err)
+	for _, label := range add {
+		if err := ghc.AddLabel(pr.org, pr.repo, pr.number, label); err != nil {
+			log.WithError(err).Errorf("Failed to add %q label to %s/%s#%d.", label, pr.org, pr.repo, pr.number)
+			writeErrs = append(writeErrs, fmt.Errorf("add label %q: %w", label, err))
+		} else {
+			result.LabelsAdded = append(result.LabelsAdded, label)
+		}
 	}
-	log.WithField("duration", time.Since(start).String()).Debug("Completed github functions in handle")
+	log.WithField("duration", time.Since(start).String()).Debug("Completed adding/deleting approval labels in handle")
 
 	start = time.Now()
-	approversHandler := approvers.NewApprovers(
-		approvers.NewOwners(
-			log,
-			filenames,
-			repo,
-			int64(pr.number),
-		),
-	)
-	approversHandler.AssociatedIssue, err = findAssociatedIssue(pr.body, pr.org)
+	commandURL := GetBotCommandLink(pr.htmlURL)
+	var message *string
+	rendered, err := RenderNotification(e.approvalState(pr), RenderOptions{
+		Style:      NotificationStyle(opts),
+		LinkURL:    githubConfig.LinkURL,
+		CommandURL: commandURL,
+	})
 	if err != nil {
-		log.WithError(err).Errorf("Failed to find associated issue from PR body: %v", err)
-	}
-	approversHandler.RequireIssue = opts.IssueRequired
-	approversHandler.ManuallyApproved = humanAddedApproved(ghc, log, pr.org, pr.repo, pr.number, botName, hasApprovedLabel)
-
-	// Author implicitly approves their own PR if config allows it
-	if opts.HasSelfApproval() {
-		approversHandler.AddAuthorSelfApprover(pr.author, pr.htmlURL+"#", false)
+		log.WithError(err).Error("Failed to render the notification.")
 	} else {
-		// Treat the author as an assignee, and suggest them if possible
-		approversHandler.AddAssignees(pr.author)
+		// The payload is a part of the message, so that the notification is
+		// updated once the state in it changes.
+		if payload, err := payloadMetadata(e.notificationPayload()); err != nil {
+			log.WithError(err).Error("Failed to marshal the notification payload.")
+		} else {
+			rendered += payload
+		}
+		message = &rendered
 	}
-	log.WithField("duration", time.Since(start).String()).Debug("Completed configuring approversHandler in handle")
-
-	start = time.Now()
-	commentsFromIssueComments := commentsFromIssueComments(issueComments)
-	comments := append(commentsFromReviewComments(reviewComments), commentsFromIssueComments...)
-	comments = append(comments, commentsFromReviews(reviews)...)
-	sort.SliceStable(comments, func(i, j int) bool {
-		return comments[i].CreatedAt.Before(comments[j].CreatedAt)
-	})
-	approveComments := filterComments(comments, approvalMatcher(botName, opts.LgtmActsAsApprove, opts.ConsiderReviewState()))
-	addApprovers(&approversHandler, approveComments, pr.author, opts.ConsiderReviewState())
-	log.WithField("duration", time.Since(start).String()).Debug("Completed filering approval comments in handle")
-
-	for _, user := range pr.assignees {
-		approversHandler.AddAssignees(user.Login)
+	newMessage := updateNotification(latestNotification, message)
+	if pr.refresh {
+		newMessage = message
+	}
+	if newMessage != nil && fingerprint != "" && len(writeErrs) == 0 {
+		*newMessage += fingerprintMetadata(fingerprint)
 	}
-
-	start = time.Now()
-	notifications := filterComments(commentsFromIssueComments, notificationMatcher(botName))
-	latestNotification := getLast(notifications)
-	commandURL := GetBotCommandLink(pr.htmlURL)
-	newMessage := updateNotification(githubConfig.LinkURL, pr.org, pr.repo, pr.branch, commandURL, latestNotification, approversHandler)
 	log.WithField("duration", time.Since(start).String()).Debug("Completed getting notifications in handle")
 	start = time.Now()
 	if newMessage != nil {
+		rewrite := rewritesNotification(opts) && latestNotification != nil
 		for _, notif := range notifications {
+			if rewrite && notif == latestNotification {
+				continue
+			}
 			if err := ghc.DeleteComment(pr.org, pr.repo, notif.ID); err != nil {
 				log.WithError(err).Errorf("Failed to delete comment from %s/%s#%d, ID: %d.", pr.org, pr.repo, pr.number, notif.ID)
 			}
 		}
-		if err := ghc.CreateComment(pr.org, pr.repo, pr.number, *newMessage); err != nil {
-			log.WithError(err).Errorf("Failed to create comment on %s/%s#%d: %q.", pr.org, pr.repo, pr.number, *newMessage)
+		if rewrite {
+			if err := ghc.EditComment(pr.org, pr.repo, latestNotification.ID, *newMessage); err != nil {
+				log.WithError(err).Errorf("Failed to edit comment on %s/%s#%d, ID: %d.", pr.org, pr.repo, pr.number, latestNotification.ID)
+				writeErrs = append(writeErrs, fmt.Errorf("edit the notification: %w", err))
+			}
+		} else {
+			// The ID of the new notification is unknown until the comments
+			// are listed next time.
+			result.Comments.NotificationID = 0
+			if err := ghc.CreateComment(pr.org, pr.repo, pr.number, *newMessage); err != nil {
+				log.WithError(err).Errorf("Failed to create comment on %s/%s#%d: %q.", pr.org, pr.repo, pr.number, *newMessage)
+				writeErrs = append(writeErrs, fmt.Errorf("create the notification: %w", err))
+			}
+		}
+	}
+	if opts.SplitNotification && !opts.MinimalMode && len(filterComments(e.issueComments, instructionsMatcher(e.botName))) == 0 {
+		if msg := approvers.GetInstructionsMessage(pr.org, pr.repo, commandURL); msg != nil {
+			if err := ghc.CreateComment(pr.org, pr.repo, pr.number, *msg); err != nil {
+				log.WithError(err).Errorf("Failed to create instructions comment on %s/%s#%d.", pr.org, pr.repo, pr.number)
+			}
 		}
 	}
 	log.WithField("duration", time.Since(start).String()).Debug("Completed adding/deleting approval comments in handle")
 
-	start = time.Now()
-	if !approversHandler.IsApproved() {
-		if hasApprovedLabel {
-			if err := ghc.RemoveLabel(pr.org, pr.repo, pr.number, labels.Approved); err != nil {
-				log.WithError(err).Errorf("Failed to remove %q label from %s/%s#%d.", labels.Approved, pr.org, pr.repo, pr.number)
+	result.Approved = approversHandler.IsApproved()
+	result.Approvers = approversHandler.GetCurrentApproversSetCased().List()
+	result.UnapprovedFiles = approversHandler.UnapprovedFiles().List()
+	result.OwnersFiles = len(approversHandler.GetFilesApprovers())
+	result.Approvals = approvalRecords(approversHandler)
+	result.SuggestedApprovers = approversHandler.GetCCs()
+	result.Fingerprint = fingerprint
+	result.FutureComments = e.futureComments
+	result.ReorderedComments = e.reorderedComments
+	result.ExpiredApprovers = approversHandler.ExpiredApprovers
+	result.ApprovalsExpireAt = e.approvalsExpireAt
+	result.Files = e.owners.GetFilenames()
+	result.OwnersApprovers = map[string][]string{}
+	for fn, v := range approversHandler.GetFilesApprovers() {
+		result.OwnersApprovers[fn] = v.List()
+	}
+	author := newRenames(opts.RenamedLogins).current(pr.author)
+	for _, c := range e.approveComments {
+		if c.Author != author {
+			result.FirstApprovalAt = c.CreatedAt
+			break
+		}
+	}
+	if result.Approved {
+		owners := e.owners
+		reverseMap := owners.GetReverseMap(owners.GetLeafApprovers())
+		result.RequiredApprovers = owners.GetSuggestedApprovers(reverseMap, owners.GetAllPotentialApprovers()).Len()
+	}
+	if len(writeErrs) > 0 {
+		return result, &WriteError{Errs: writeErrs}
+	}
+	return result, nil
+}
+
+// rewritesNotification reports whether the status comment is rewritten in
+// place, which is when the notification is split or minimal, or when the
+// reactions on it approve, which would be lost with it.
+func rewritesNotification(opts *plugins.Approve) bool {
+	return opts.SplitNotification || opts.MinimalMode || opts.ReactionActsAsApprove
+}
+
+// labelChanges returns the labels of approval to add to and remove from the
+// PR by its approval state.
+func (e *evaluation) labelChanges(opts *plugins.Approve) (add, remove []string) {
+	if !e.approvers.IsApproved() {
+		if e.hasApprovedLabel {
+			remove = append(remove, labels.Approved)
+		}
+	} else if !e.hasApprovedLabel {
+		add = append(add, labels.Approved)
+	}
+	if label := opts.ConditionalApprovedLabel; label != "" {
+		if e.approvers.ConditionallyApproved() {
+			if !e.labels.Has(label) {
+				add = append(add, label)
 			}
+		} else if e.labels.Has(label) {
+			remove = append(remove, label)
 		}
-	} else if !hasApprovedLabel {
-		if err := ghc.AddLabel(pr.org, pr.repo, pr.number, labels.Approved); err != nil {
-			log.WithError(err).Errorf("Failed to add %q label to %s/%s#%d.", labels.Approved, pr.org, pr.repo, pr.number)
+	}
+	return add, remove
+}
+
+// notifyTooManyFiles replaces the notification with the one telling that the
+// PR can't be approved as the files changed are too many to be listed, and
+// removes the approved label.
+func (e *evaluation) notifyTooManyFiles(log *logrus.Entry, ghc githubClient, opts *plugins.Approve, pr *state, notifications []*comment, listed int) error {
+	log.WithField("listed", listed).Warn("The PR changes too many files to list.")
+
+	latestNotification := getLast(notifications)
+	if message := updateNotification(latestNotification, approvers.GetTooManyFilesMessage(listed)); message != nil {
+		rewrite := rewritesNotification(opts) && latestNotification != nil
+		for _, notif := range notifications {
+			if rewrite && notif == latestNotification {
+				continue
+			}
+			if err := ghc.DeleteComment(pr.org, pr.repo, notif.ID); err != nil {
+				log.WithError(err).Errorf("Failed to delete comment from %s/%s#%d, ID: %d.", pr.org, pr.repo, pr.number, notif.ID)
+			}
+		}
+		if rewrite {
+			if err := ghc.EditComment(pr.org, pr.repo, latestNotification.ID, *message); err != nil {
+				return err
+			}
+		} else if err := ghc.CreateComment(pr.org, pr.repo, pr.number, *message); err != nil {
+			return err
 		}
 	}
-	log.WithField("duration", time.Since(start).String()).Debug("Completed adding/deleting approval labels in handle")
+
+	if e.hasApprovedLabel {
+		return ghc.RemoveLabel(pr.org, pr.repo, pr.number, labels.Approved)
+	}
 	return nil
 }
 
-func humanAddedApproved(ghc githubClient, log *logrus.Entry, org, repo string, number int, botName string, hasLabel bool) func() bool {
-	findOut := func() bool {
-		if !hasLabel {
-			return false
+// evaluation is the approval state of a PR computed from the data fetched
+// from the platform.
+type evaluation struct {
+	owners           approvers.Owners
+	approvers        approvers.Approvers
+	botName          string
+	hasApprovedLabel bool
+	labels           sets.String
+	issueComments    []*comment
+	approveComments  []*comment
+
+	// lastComment is the ID of the latest comment not made by the bot.
+	lastComment int
+
+	// futureComments and reorderedComments count the comments whose
+	// timestamps are skewed, which are found by sortComments.
+	futureComments    int
+	reorderedComments int
+
+	// approvalReactions are the reactions approving on the latest
+	// notification, which is at reactionURL.
+	approvalReactions []Reaction
+	reactionURL       string
+
+	// reviews are the reviews of the PR if the review state is considered.
+	reviews []github.Review
+
+	// provenance finds out who made the approved label as it is now.
+	provenance func() *LabelProvenance
+
+	// approvalTTL is the ttl of the approvals, and approvalsExpireAt is when
+	// the earliest of the approvals counted expires.
+	approvalTTL       time.Duration
+	approvalsExpireAt time.Time
+}
+
+// evaluate fetches the data of a PR and computes its approval state without
+// making any change to the PR.
+func evaluate(log *logrus.Entry, ghc githubClient, repo approvers.Repo, opts *plugins.Approve, pr *state) (*evaluation, error) {
+	log = log.WithField(logModule, "approve")
+	if err := pr.loadInputs(); err != nil {
+		return nil, err
+	}
+
+	e, err := prefetch(log, ghc, opts, pr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := e.compute(log, ghc, repo, opts, pr); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func fetchErr(pr *state, context string, err error) error {
+	return fmt.Errorf("failed to get %s for %s/%s#%d: %w", context, pr.org, pr.repo, pr.number, err)
+}
+
+// prefetch fetches the data which is enough to tell whether the PR has
+// changed since the latest notification.
+func prefetch(log *logrus.Entry, ghc githubClient, opts *plugins.Approve, pr *state) (*evaluation, error) {
+	var (
+		issueLabels   []github.Label
+		issueComments []github.IssueComment
+		reviews       []github.Review
+	)
+	f := newFetcher(pr)
+	f.fetch("issue labels", func() (interface{}, error) {
+		return ghc.GetIssueLabels(pr.org, pr.repo, pr.number)
+	}, func(v interface{}) { issueLabels = v.([]github.Label) })
+	f.fetch("issue comments", func() (interface{}, error) {
+		return ghc.ListIssueComments(pr.org, pr.repo, pr.number)
+	}, func(v interface{}) { issueComments = v.([]github.IssueComment) })
+	if opts.ConsiderReviewState() {
+		// The reviews are fetched here since they are part of the
+		// fingerprint, like the comments.
+		f.fetch("reviews", func() (interface{}, error) {
+			return ghc.ListReviews(pr.org, pr.repo, pr.number)
+		}, func(v interface{}) { reviews = v.([]github.Review) })
+	}
+	if err := f.wait(); err != nil {
+		return nil, err
+	}
+	labelSet := sets.NewString()
+	for _, label := range issueLabels {
+		labelSet.Insert(label.Name)
+	}
+	// Get the bot name after listing the comments, so that a rename of the
+	// bot account detected from them is taken into account.
+	botName, err := ghc.BotName()
+	if err != nil {
+		return nil, fetchErr(pr, "bot name", err)
+	}
+
+	e := &evaluation{
+		botName:          botName,
+		hasApprovedLabel: labelSet.Has(labels.Approved),
+		labels:           labelSet,
+		issueComments:    commentsFromIssueComments(issueComments),
+		reviews:          reviews,
+	}
+	for _, c := range e.issueComments {
+		if c.Author != e.botName && c.ID > e.lastComment {
+			e.lastComment = c.ID
 		}
-		events, err := ghc.ListIssueEvents(org, repo, number)
-		if err != nil {
-			log.WithError(err).Errorf("Failed to list issue events for %s/%s#%d.", org, repo, number)
-			return false
+	}
+	if opts.ReactionActsAsApprove {
+		e.fetchApprovalReactions(log, ghc, pr, getLast(filterComments(e.issueComments, notificationMatcher(e.botName))))
+	}
+
+	return e, nil
+}
+
+// fetchApprovalReactions fetches the reactions approving on the latest
+// notification. The reactions don't count if they can't be fetched, rather
+// than failing the handling.
+func (e *evaluation) fetchApprovalReactions(log *logrus.Entry, ghc githubClient, pr *state, notification *comment) {
+	if notification == nil {
+		return
+	}
+
+	reactions, err := ghc.ListCommentReactions(pr.org, pr.repo, notification.ID)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to list reactions on comment %d of %s/%s#%d.", notification.ID, pr.org, pr.repo, pr.number)
+		return
+	}
+
+	for _, r := range reactions {
+		if isApprovalReaction(r.Content) && r.Login != e.botName {
+			e.approvalReactions = append(e.approvalReactions, r)
 		}
-		var lastAdded github.ListedIssueEvent
-		for _, event := range events {
-			// Only consider "approved" label added events.
-			if event.Event != github.IssueActionLabeled || event.Label.Name != labels.Approved {
-				continue
-			}
-			lastAdded = event
+	}
+	e.reactionURL = notification.HTMLURL
+}
+
+func isApprovalReaction(content string) bool {
+	switch strings.ToLower(content) {
+	case "+1", "thumbsup", "👍":
+		return true
+	}
+	return false
+}
+
+// compute fetches the rest of data and computes the approval state.
+func (e *evaluation) compute(log *logrus.Entry, ghc githubClient, repo approvers.Repo, opts *plugins.Approve, pr *state) error {
+	start := time.Now()
+	var (
+		changes        []github.PullRequestChange
+		reviewComments []github.ReviewComment
+		pushedAt       time.Time
+	)
+	f := newFetcher(pr)
+	f.fetch("PR file changes", func() (interface{}, error) {
+		return ghc.GetPullRequestChanges(pr.org, pr.repo, pr.number)
+	}, func(v interface{}) { changes = v.([]github.PullRequestChange) })
+	f.fetch("review comments", func() (interface{}, error) {
+		return ghc.ListPullRequestComments(pr.org, pr.repo, pr.number)
+	}, func(v interface{}) { reviewComments = v.([]github.ReviewComment) })
+	if opts.RequireApprovalAfterLastPush {
+		f.fetch("head commit time", func() (interface{}, error) {
+			return ghc.GetPRCommitTime(pr.org, pr.repo, pr.number, pr.headSHA)
+		}, func(v interface{}) { pushedAt = v.(time.Time) })
+	}
+	if err := f.wait(); err != nil {
+		return err
+	}
+	var filenames []string
+	for _, change := range changes {
+		filenames = append(filenames, change.Filename)
+	}
+	requirements := pr.requirements
+	if pr.fileRequirements != nil {
+		requirements = append(append([]approvers.Requirement(nil), requirements...), pr.fileRequirements(filenames)...)
+	}
+	botName := e.botName
+	log.WithField("duration", time.Since(start).String()).Debug("Completed github functions in handle")
+
+	start = time.Now()
+	var overrides []string
+	singleApprover := false
+	if policies := activeLabelPolicies(e.labels, opts.LabelPolicies); len(policies) > 0 {
+		filenames, overrides, singleApprover = applyLabelPolicies(filenames, policies)
+		log.WithField("overrides", overrides).Info("Applied label policies")
+	}
+	if len(opts.IgnoredPaths) > 0 {
+		var exemption string
+		if filenames, exemption = applyIgnoredPaths(filenames, opts.IgnoredPaths); exemption != "" {
+			overrides = append(overrides, exemption)
 		}
+	}
+	rn := newRenames(opts.RenamedLogins)
+	if len(rn) > 0 {
+		repo = renamedRepo{Repo: repo, renames: rn}
+	}
+	author := rn.current(pr.author)
 
-		if lastAdded.Actor.Login == "" || lastAdded.Actor.Login == botName || isDeprecatedBot(lastAdded.Actor.Login) {
+	if len(opts.PathRequirements) > 0 {
+		repo = applyPathRequirements(log, repo, opts.PathRequirements)
+	}
+	repo, missing := applyMissingApproversPolicy(log, repo, filenames, opts)
+	owners := approvers.NewOwners(
+		log,
+		filenames,
+		repo,
+		approvers.SuggestionSeed(opts.SuggestionSeed, pr.number, time.Now()),
+	).WithActivity(pr.activity).WithOverloaded(pr.overloaded).
+		WithSuggestion(opts.SuggestionStrategy, pr.suggestionLoad)
+	approversHandler := approvers.NewApprovers(owners)
+	approversHandler.Author = author
+	approversHandler.Overrides = overrides
+	approversHandler.SingleApproverSuffices = singleApprover
+	issue, err := findAssociatedIssue(pr.body, pr.org, opts.AssociatedIssuePattern)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to find associated issue from PR body: %v", err)
+	}
+	approversHandler.AssociatedIssue = issue
+	approversHandler.RequireIssue = opts.IssueRequired
+	approversHandler.Dependencies = pr.dependencies
+	approversHandler.SeriesTopic = pr.seriesTopic
+	approversHandler.Series = pr.series
+	approversHandler.RequireApprovedDependencies = opts.BlockOnDependencies
+	minApprovers, sizeReqs := applySizeRequirements(repo, changedLines(changes), opts.MinApprovers, opts.SizeRequirements)
+	approversHandler.MinApprovers = minApprovers
+	approversHandler.ConditionalLabel = opts.ConditionalApprovedLabel
+	approversHandler.Requirements = append(fileStatusRequirements(changes, opts.FileStatusPolicies), requirements...)
+	approversHandler.Requirements = append(approversHandler.Requirements, missing...)
+	approversHandler.OwnersFileChanges = ownersFileChanges(changes)
+	approversHandler.Requirements = append(
+		approversHandler.Requirements,
+		ownersFileChangeRequirement(approversHandler.OwnersFileChanges, opts.OwnersFileChangeRequires)...,
+	)
+	approversHandler.Requirements = append(approversHandler.Requirements, sizeReqs...)
+	if opts.RequireLgtmBeforeApprove && !e.labels.Has(labels.LGTM) {
+		approversHandler.Requirements = append(approversHandler.Requirements, approvers.Requirement{
+			Description: fmt.Sprintf("The pull request needs the **%s** label before it is approved", labels.LGTM),
+		})
+	}
+	approversHandler.DiffURL = pr.htmlURL + "/files"
+	e.provenance = labelProvenance(ghc, log, pr, botName, e.hasApprovedLabel, opts.SiblingRobots)
+	approversHandler.ManuallyApproved = func() bool {
+		if !e.hasApprovedLabel {
 			return false
 		}
-		return true
+		p := e.provenance()
+		return p != nil && p.Source == ProvenanceHuman
+	}
+
+	// Author implicitly approves their own PR if config allows it
+	if opts.HasSelfApproval() && len(opts.SelfApprovalRequiredPaths) > 0 {
+		// The author is suggested for the files which need the explicit
+		// approval of the author.
+		approversHandler.AddAuthorSelfApproverExcept(author, pr.htmlURL+"#", pathMatcher(opts.SelfApprovalRequiredPaths))
+		approversHandler.AddAssignees(author)
+	} else if opts.HasSelfApproval() {
+		approversHandler.AddAuthorSelfApprover(author, pr.htmlURL+"#", false)
+	} else {
+		// Treat the author as an assignee, and suggest them if possible
+		approversHandler.AddAssignees(author)
 	}
+	log.WithField("duration", time.Since(start).String()).Debug("Completed configuring approversHandler in handle")
 
-	var cache *bool
-	return func() bool {
-		if cache == nil {
-			val := findOut()
-			cache = &val
+	start = time.Now()
+	comments := append(commentsFromReviewComments(reviewComments), e.issueComments...)
+	comments = append(comments, commentsFromReviews(e.reviews)...)
+	if future, outOfOrder := sortComments(comments, time.Now()); future > 0 || outOfOrder > 0 {
+		log.WithFields(logrus.Fields{"future": future, "out_of_order": outOfOrder}).Warn("The timestamps of the comments are skewed.")
+		e.futureComments, e.reorderedComments = future, outOfOrder
+	}
+	for _, c := range comments {
+		c.Author = rn.current(c.Author)
+	}
+	approveComments := filterComments(comments, approvalMatcher(botName, opts.LgtmActsAsApprove, opts.ConsiderReviewState(), opts.CommandAliases))
+	if opts.RequireApprovalAfterLastPush {
+		// The approvals, as well as the cancellations, made before the head
+		// commit was committed don't count.
+		approveComments = filterComments(approveComments, func(c *comment) bool {
+			return c.CreatedAt.After(pushedAt)
+		})
+	}
+	if opts.InvalidateApprovalsOnPush && !pr.lastPushAt.IsZero() {
+		// The approvals made before the latest push are stale.
+		approveComments = filterComments(approveComments, func(c *comment) bool {
+			return c.CreatedAt.After(pr.lastPushAt)
+		})
+	}
+	var expirable approvers.Approvers
+	if opts.ApprovalTTL > 0 {
+		// The approvals made longer ago than the ttl expire, which are told
+		// apart by the approvers counted without the ttl.
+		expirable = approvers.NewApprovers(owners)
+		addApprovers(&expirable, approveComments, author, opts.ConsiderReviewState(), opts.CommandAliases)
+
+		cutoff := time.Now().Add(-opts.ApprovalTTL)
+		approveComments = filterComments(approveComments, func(c *comment) bool {
+			return c.CreatedAt.After(cutoff)
+		})
+		e.approvalTTL = opts.ApprovalTTL
+	}
+	addApprovers(&approversHandler, approveComments, author, opts.ConsiderReviewState(), opts.CommandAliases)
+	log.WithField("duration", time.Since(start).String()).Debug("Completed filering approval comments in handle")
+
+	for _, a := range pr.externalApprovals {
+		approversHandler.AddExternalApprover(rn.current(a.Login), a.URL, "Approved in "+a.Source)
+	}
+
+	if len(e.approvalReactions) > 0 {
+		// Only the approvers in the OWNERS files approve by reactions, as
+		// anyone can react to the notification.
+		ownersApprovers := sets.NewString()
+		for _, v := range owners.GetApprovers() {
+			for login := range v {
+				ownersApprovers.Insert(strings.ToLower(login))
+			}
 		}
-		return *cache
+		for _, r := range e.approvalReactions {
+			if login := rn.current(r.Login); ownersApprovers.Has(strings.ToLower(login)) {
+				approversHandler.AddExternalApprover(login, e.reactionURL, "Approved by reaction")
+			}
+		}
+	}
+
+	for _, user := range pr.assignees {
+		approversHandler.AddAssignees(rn.current(user.Login))
+	}
+
+	if opts.ApprovalTTL > 0 {
+		e.expireApprovals(&approversHandler, approveComments, expirable)
 	}
+
+	e.owners = owners
+	e.approvers = approversHandler
+	e.approveComments = approveComments
+
+	return nil
 }
 
-func approvalMatcher(botName string, lgtmActsAsApprove, reviewActsAsApprove bool) func(*comment) bool {
+func approvalMatcher(botName string, lgtmActsAsApprove, reviewActsAsApprove bool, aliases *plugins.CommandAliases) func(*comment) bool {
 	return func(c *comment) bool {
-		return isApprovalCommand(botName, lgtmActsAsApprove, c) || isApprovalState(botName, reviewActsAsApprove, c)
+		return isApprovalCommand(botName, lgtmActsAsApprove, aliases, c) || isApprovalState(botName, reviewActsAsApprove, c)
+	}
+}
+
+// isQueryArguments reports whether the arguments of "/approve" make one of
+// the commands which don't approve, such as "/approve status".
+func isQueryArguments(args string) bool {
+	switch args {
+	case statusArgument, refreshArgument, configArgument:
+		return true
 	}
+
+	return strings.HasPrefix(args, ownersArgument+" ")
 }
 
-func isApprovalCommand(botName string, lgtmActsAsApprove bool, c *comment) bool {
+// IsCancelArguments reports whether the arguments of "/approve" or "/lgtm"
+// cancel the approval, which is when the first of them is "cancel". A path
+// containing the word, such as "/approve cmd/cancel.go", is not a cancel.
+func IsCancelArguments(args string) bool {
+	v := strings.Fields(args)
+
+	return len(v) > 0 && strings.ToLower(v[0]) == cancelArgument
+}
+
+func isApprovalCommand(botName string, lgtmActsAsApprove bool, aliases *plugins.CommandAliases, c *comment) bool {
 	if c.Author == botName || isDeprecatedBot(c.Author) {
 		return false
 	}
 
-	for _, match := range commandRegex.FindAllStringSubmatch(c.Body, -1) {
+	for _, match := range commandRegex.FindAllStringSubmatch(aliases.Expand(SanitizeCommandText(c.Body)), -1) {
 		cmd := strings.ToUpper(match[1])
+		if arg := strings.ToLower(strings.TrimSpace(match[2])); cmd == approveCommand && isQueryArguments(arg) {
+			continue
+		}
 		if (cmd == lgtmCommand && lgtmActsAsApprove) || cmd == approveCommand {
 			return true
 		}
@@ -324,8 +972,13 @@ func notificationMatcher(botName string) func(*comment) bool {
 	}
 }
 
-func updateNotification(linkURL *url.URL, org, repo, branch, commandURL string, latestNotification *comment, approversHandler approvers.Approvers) *string {
-	message := approvers.GetMessage(approversHandler, linkURL, org, repo, branch, commandURL)
+func instructionsMatcher(botName string) func(*comment) bool {
+	return func(c *comment) bool {
+		return c.Author == botName && instructionsRegex.MatchString(c.Body)
+	}
+}
+
+func updateNotification(latestNotification *comment, message *string) *string {
 	if message == nil || (latestNotification != nil && strings.Contains(latestNotification.Body, *message)) {
 		return nil
 	}
@@ -337,7 +990,7 @@ func updateNotification(linkURL *url.URL, org, repo, branch, commandURL string,
 // them to the Approvers.  The function uses the latest approve or cancel comment
 // to determine the Users intention. A review in requested changes state is
 // considered a cancel.
-func addApprovers(approversHandler *approvers.Approvers, approveComments []*comment, author string, reviewActsAsApprove bool) {
+func addApprovers(approversHandler *approvers.Approvers, approveComments []*comment, author string, reviewActsAsApprove bool, aliases *plugins.CommandAliases) {
 	for _, c := range approveComments {
 		if c.Author == "" {
 			continue
@@ -354,30 +1007,57 @@ func addApprovers(approversHandler *approvers.Approvers, approveComments []*comm
 			approversHandler.RemoveApprover(c.Author)
 		}
 
-		for _, match := range commandRegex.FindAllStringSubmatch(c.Body, -1) {
+		for _, match := range commandRegex.FindAllStringSubmatch(aliases.Expand(SanitizeCommandText(c.Body)), -1) {
 			name := strings.ToUpper(match[1])
 			if name != approveCommand && name != lgtmCommand {
 				continue
 			}
 			args := strings.ToLower(strings.TrimSpace(match[2]))
-			if strings.Contains(args, cancelArgument) {
+			// "/approve set option value" changes the options of the repo.
+			if strings.HasPrefix(args, setArgument+" ") {
+				continue
+			}
+			// "/approve status", "/approve config" and "/approve owners"
+			// only query, and "/approve refresh" only regenerates the
+			// notification.
+			if isQueryArguments(args) {
+				continue
+			}
+			if IsCancelArguments(args) {
 				approversHandler.RemoveApprover(c.Author)
 				continue
 			}
 
-			if c.Author == author {
+			// The other arguments of "/approve" are the paths to approve.
+			noIssue := args == noIssueArgument
+			var paths []string
+			if name == approveCommand {
+				for _, arg := range strings.Fields(match[2]) {
+					if strings.ToLower(arg) == noIssueArgument {
+						noIssue = true
+					} else if len(paths) < maxApprovePaths {
+						paths = append(paths, arg)
+					}
+				}
+			}
+
+			if c.Author == author && len(paths) == 0 {
 				approversHandler.AddAuthorSelfApprover(
 					c.Author,
 					c.HTMLURL,
-					args == noIssueArgument,
+					noIssue,
 				)
 			}
 
 			if name == approveCommand {
+				if len(paths) > 0 {
+					approversHandler.AddPartialApprover(c.Author, c.HTMLURL, noIssue, paths)
+					continue
+				}
 				approversHandler.AddApprover(
 					c.Author,
 					c.HTMLURL,
-					args == noIssueArgument,
+					noIssue,
 				)
 			} else {
 				approversHandler.AddLGTMer(
@@ -388,6 +1068,8 @@ func addApprovers(approversHandler *approvers.Approvers, approveComments []*comm
 			}
 
 		}
+
+		approversHandler.SetCommentID(c.Author, c.HTMLURL, c.ID)
 	}
 }
 
@@ -398,6 +1080,9 @@ type comment struct {
 	HTMLURL     string
 	ID          int
 	ReviewState github.ReviewState
+	// Seq is the sequence number of the comment in the order of creation,
+	// which is its ID for the comments of the PR. It is 0 for the reviews.
+	Seq int
 }
 
 func commentFromIssueComment(ic *github.IssueComment) *comment {
@@ -410,6 +1095,7 @@ func commentFromIssueComment(ic *github.IssueComment) *comment {
 		CreatedAt: ic.CreatedAt,
 		HTMLURL:   ic.HTMLURL,
 		ID:        ic.ID,
+		Seq:       ic.ID,
 	}
 }
 
@@ -431,6 +1117,7 @@ func commentFromReviewComment(rc *github.ReviewComment) *comment {
 		CreatedAt: rc.CreatedAt,
 		HTMLURL:   rc.HTMLURL,
 		ID:        rc.ID,
+		Seq:       rc.ID,
 	}
 }
 
@@ -0,0 +1,55 @@
+package approvers
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+)
+
+// RepoAliases maps the alias groups to their members, which is the content of
+// an OWNERS_ALIASES file.
+type RepoAliases map[string]sets.String
+
+type aliasesFile struct {
+	Aliases map[string][]string `json:"aliases"`
+}
+
+// ParseAliases parses the content of an OWNERS_ALIASES file. The names of
+// groups and their members are case insensitive.
+func ParseAliases(b []byte) (RepoAliases, error) {
+	v := aliasesFile{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+
+	r := make(RepoAliases, len(v.Aliases))
+	for group, members := range v.Aliases {
+		s := sets.NewString()
+		for _, m := range members {
+			s.Insert(strings.ToLower(m))
+		}
+		r[strings.ToLower(group)] = s
+	}
+
+	return r, nil
+}
+
+// Expand replaces the alias groups in logins with their members. The groups
+// are not expanded recursively.
+func (a RepoAliases) Expand(logins sets.String) sets.String {
+	if len(a) == 0 {
+		return logins
+	}
+
+	r := sets.NewString()
+	for login := range logins {
+		if members, ok := a[strings.ToLower(login)]; ok {
+			r = r.Union(members)
+		} else {
+			r.Insert(login)
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,43 @@
+package approvers
+
+import "sort"
+
+// FileCoverage is how a changed file is covered by the OWNERS files.
+type FileCoverage struct {
+	File string
+	// OwnersFile is the directory of the OWNERS file which the file needs the
+	// approval of. It is empty for the root OWNERS file.
+	OwnersFile string
+	// EligibleApprovers can approve the file.
+	EligibleApprovers []string
+	// Approvers are the current approvers of the file.
+	Approvers []string
+	Approved  bool
+}
+
+// Coverage returns the coverage of each changed file, sorted by the file.
+func (ap Approvers) Coverage() []FileCoverage {
+	ownersSet := ap.owners.GetOwnersSet()
+	eligible := ap.owners.GetApprovers()
+	current := ap.GetFilesApprovers()
+
+	r := make([]FileCoverage, 0, len(ap.owners.filenames))
+	for _, fn := range ap.owners.filenames {
+		dir := canonicalDir(ap.owners.repo.FindApproverOwnersForFile(fn))
+		for dir != "" && !ownersSet.Has(dir) {
+			dir = parentDir(dir)
+		}
+
+		r = append(r, FileCoverage{
+			File:              fn,
+			OwnersFile:        dir,
+			EligibleApprovers: eligible[dir].List(),
+			Approvers:         current[dir].List(),
+			Approved:          current[dir].Len() > 0,
+		})
+	}
+
+	sort.Slice(r, func(i, j int) bool { return r[i].File < r[j].File })
+
+	return r
+}
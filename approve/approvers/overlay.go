@@ -0,0 +1,114 @@
+package approvers
+
+import (
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// OwnersEntry is the content of a proposed OWNERS file.
+type OwnersEntry struct {
+	Approvers      []string `json:"approvers,omitempty"`
+	NoParentOwners bool     `json:"no_parent_owners,omitempty"`
+}
+
+// OverlayRepo lays the proposed OWNERS files over a Repo. The keys of overlay
+// are the directories of the OWNERS files. A nil entry means the OWNERS file
+// of the directory is removed.
+type OverlayRepo struct {
+	base    Repo
+	overlay map[string]*OwnersEntry
+}
+
+// NewOverlayRepo constructs a new OverlayRepo.
+func NewOverlayRepo(base Repo, overlay map[string]*OwnersEntry) OverlayRepo {
+	m := make(map[string]*OwnersEntry, len(overlay))
+	for dir, entry := range overlay {
+		m[canonicalDir(dir)] = entry
+	}
+	return OverlayRepo{base: base, overlay: m}
+}
+
+func canonicalDir(dir string) string {
+	dir = filepath.Clean(dir)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
+
+func parentDir(dir string) string {
+	return canonicalDir(filepath.Dir(dir))
+}
+
+// FindApproverOwnersForFile returns the directory of the nearest OWNERS file
+// with approvers for the file.
+func (r OverlayRepo) FindApproverOwnersForFile(file string) string {
+	return r.findOwnersForDir(parentDir(file))
+}
+
+func (r OverlayRepo) findOwnersForDir(dir string) string {
+	baseOwners := canonicalDir(r.base.FindApproverOwnersForFile(filepath.Join(dir, ownersFileName)))
+
+	for d := dir; ; d = parentDir(d) {
+		if entry, ok := r.overlay[d]; ok {
+			if entry != nil && len(entry.Approvers) > 0 {
+				return d
+			}
+		} else if d == baseOwners {
+			return d
+		}
+
+		if d == "" {
+			return ""
+		}
+
+		if d == baseOwners {
+			// The OWNERS file of base is removed, find the next one above it.
+			baseOwners = canonicalDir(r.base.FindApproverOwnersForFile(filepath.Join(parentDir(d), ownersFileName)))
+		}
+	}
+}
+
+// LeafApprovers returns the approvers in the OWNERS file of the path.
+func (r OverlayRepo) LeafApprovers(path string) sets.String {
+	dir := parentDir(path)
+	if entry, ok := r.overlay[dir]; ok {
+		if entry == nil {
+			return sets.NewString()
+		}
+		return sets.NewString(entry.Approvers...)
+	}
+	return r.base.LeafApprovers(path)
+}
+
+// Approvers returns the approvers in the OWNERS file of the path and those of
+// its parent directories unless no_parent_owners is set.
+func (r OverlayRepo) Approvers(path string) sets.String {
+	all := sets.NewString()
+
+	dir := r.findOwnersForDir(parentDir(path))
+	for {
+		p := filepath.Join(dir, ownersFileName)
+		all.Insert(r.LeafApprovers(p).List()...)
+
+		if dir == "" || r.IsNoParentOwners(p) {
+			break
+		}
+		dir = r.findOwnersForDir(parentDir(dir))
+	}
+
+	return all
+}
+
+// IsNoParentOwners reports whether the OWNERS file of the path disables the
+// approvers of the parent directories.
+func (r OverlayRepo) IsNoParentOwners(path string) bool {
+	if entry, ok := r.overlay[canonicalDir(path)]; ok {
+		return entry != nil && entry.NoParentOwners
+	}
+	if entry, ok := r.overlay[parentDir(path)]; ok && filepath.Base(path) == ownersFileName {
+		return entry != nil && entry.NoParentOwners
+	}
+	return r.base.IsNoParentOwners(path)
+}
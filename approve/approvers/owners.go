@@ -18,6 +18,7 @@ package approvers
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"math/rand"
@@ -26,17 +27,65 @@ import (
 	"sort"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 const (
-	ownersFileName = "OWNERS"
+	ownersFileName     = "OWNERS"
+	authorSelfApproved = "Author self-approved"
 	// ApprovalNotificationName defines the name used in the title for the approval notifications.
 	ApprovalNotificationName = "ApprovalNotifier"
+	// ApprovalInstructionsName defines the name used in the title for the approval
+	// instructions which are posted once when the notification is split.
+	ApprovalInstructionsName = "ApprovalInstructions"
 )
 
+// The strategies of ordering the approvers to suggest. The approvers who
+// cover the most files are always suggested first, and the strategy breaks
+// the ties among them.
+const (
+	// SuggestRandom shuffles the approvers, and puts the recently active ones
+	// first if the activity is known. It is the default.
+	SuggestRandom = "random"
+	// SuggestLeastLoaded puts the approvers suggested for the fewest PRs
+	// recently first, and breaks the ties like SuggestRandom.
+	SuggestLeastLoaded = "least-loaded"
+	// SuggestAlphabetical orders the approvers by login, which makes the
+	// suggestion stable.
+	SuggestAlphabetical = "alphabetical"
+)
+
+// The seeds of the shuffle of the approvers to suggest, which decide how
+// often the suggestion of a PR is reshuffled.
+const (
+	// SeedPRNumber seeds the shuffle with the PR number, so the suggestion of
+	// a PR is the same every time unless the approvers change. It is the
+	// default.
+	SeedPRNumber = "pr-number"
+	// SeedDaily seeds the shuffle with the PR number and the day, so the
+	// suggestion of a PR rotates once a day.
+	SeedDaily = "daily"
+	// SeedRandom seeds the shuffle with the time of the handling, so the
+	// suggestion changes every time.
+	SeedRandom = "random"
+)
+
+// SuggestionSeed returns the seed of the shuffle of the approvers to suggest
+// for the PR at the time by the strategy.
+func SuggestionSeed(strategy string, number int, now time.Time) int64 {
+	switch strategy {
+	case SeedDaily:
+		return int64(number)<<32 + now.UTC().Unix()/int64(24*time.Hour/time.Second)
+	case SeedRandom:
+		return now.UnixNano()
+	default:
+		return int64(number)
+	}
+}
+
 // Repo allows querying and interacting with OWNERS information in a repo.
 type Repo interface {
 	Approvers(path string) sets.String
@@ -51,6 +100,18 @@ type Owners struct {
 	repo      Repo
 	seed      int64
 
+	// activity is the time of the latest activity of approvers, keyed by
+	// the lower case login.
+	activity map[string]time.Time
+	// overloaded are the lower case logins of the approvers who have too
+	// many PRs to review.
+	overloaded sets.String
+	// strategy is how the approvers to suggest are ordered, and load is the
+	// number of the PRs which each approver is suggested for recently, keyed
+	// by the lower case login.
+	strategy string
+	load     map[string]int
+
 	log *logrus.Entry
 }
 
@@ -59,17 +120,86 @@ func NewOwners(log *logrus.Entry, filenames []string, r Repo, s int64) Owners {
 	return Owners{filenames: filenames, repo: r, seed: s, log: log}
 }
 
+// GetFilenames returns the files changed.
+func (o Owners) GetFilenames() []string {
+	return append([]string{}, o.filenames...)
+}
+
+// WithActivity returns the Owners which prefers the recently active approvers
+// when suggesting approvers. The keys of activity are lower case logins.
+func (o Owners) WithActivity(activity map[string]time.Time) Owners {
+	o.activity = activity
+	return o
+}
+
+// WithOverloaded returns the Owners which doesn't suggest the overloaded
+// approvers unless only they can approve some of the files. The logins are
+// lower case.
+func (o Owners) WithOverloaded(overloaded sets.String) Owners {
+	o.overloaded = overloaded
+	return o
+}
+
+// WithSuggestion returns the Owners which orders the approvers to suggest by
+// the strategy. load is only used by SuggestLeastLoaded.
+func (o Owners) WithSuggestion(strategy string, load map[string]int) Owners {
+	o.strategy = strategy
+	o.load = load
+	return o
+}
+
+// withoutOverloaded returns the approvers who are not overloaded.
+func (o Owners) withoutOverloaded(approvers []string) []string {
+	if o.overloaded.Len() == 0 {
+		return approvers
+	}
+
+	r := make([]string, 0, len(approvers))
+	for _, a := range approvers {
+		if !o.overloaded.Has(strings.ToLower(a)) {
+			r = append(r, a)
+		}
+	}
+	return r
+}
+
 // GetApprovers returns a map from ownersFiles -> people that are approvers in them
 func (o Owners) GetApprovers() map[string]sets.String {
 	ownersToApprovers := map[string]sets.String{}
 
 	for fn := range o.GetOwnersSet() {
-		ownersToApprovers[fn] = o.repo.Approvers(filepath.Join(fn, ownersFileName))
+		ownersToApprovers[fn] = o.approversOf(fn)
 	}
 
 	return ownersToApprovers
 }
 
+// approversOf returns the approvers of the OWNERS file in the directory and
+// those of its parent directories, up to the first one which sets
+// no_parent_owners. It doesn't depend on the Repo to stop at that option, so
+// the approvers of the parent directories, such as the root approvers, can't
+// approve the subtrees restricting the approval.
+func (o Owners) approversOf(dir string) sets.String {
+	all := sets.NewString()
+
+	for {
+		all.Insert(o.repo.LeafApprovers(filepath.Join(dir, ownersFileName)).List()...)
+
+		if dir == "" || dir == "." || o.repo.IsNoParentOwners(dir) {
+			return all
+		}
+
+		parent := o.repo.FindApproverOwnersForFile(filepath.Join(filepath.Dir(dir), ownersFileName))
+		if parent == "." {
+			parent = ""
+		}
+		if parent == dir {
+			return all
+		}
+		dir = parent
+	}
+}
+
 // GetLeafApprovers returns a map from ownersFiles -> people that are approvers in them (only the leaf)
 func (o Owners) GetLeafApprovers() map[string]sets.String {
 	ownersToApprovers := map[string]sets.String{}
@@ -140,9 +270,11 @@ func (o Owners) KeepCoveringApprovers(reverseMap map[string]sets.String, knownAp
 	if len(potentialApprovers) == 0 {
 		o.log.Debug("No potential approvers exist to filter for relevance. Does this repo have OWNERS files?")
 	}
-	keptApprovers := sets.NewString()
+	return o.keepApproversCovering(reverseMap, o.temporaryUnapprovedFiles(knownApprovers), potentialApprovers)
+}
 
-	unapproved := o.temporaryUnapprovedFiles(knownApprovers)
+func (o Owners) keepApproversCovering(reverseMap map[string]sets.String, unapproved sets.String, potentialApprovers []string) sets.String {
+	keptApprovers := sets.NewString()
 
 	for _, suggestedApprover := range o.GetSuggestedApprovers(reverseMap, potentialApprovers).List() {
 		if reverseMap[suggestedApprover].Intersection(unapproved).Len() != 0 {
@@ -157,8 +289,13 @@ func (o Owners) KeepCoveringApprovers(reverseMap map[string]sets.String, knownAp
 // approving every OWNERS file in the PR
 func (o Owners) GetSuggestedApprovers(reverseMap map[string]sets.String, potentialApprovers []string) sets.String {
 	ap := NewApprovers(o)
+	available := o.withoutOverloaded(potentialApprovers)
 	for !ap.RequirementsMet() {
-		newApprover := findMostCoveringApprover(potentialApprovers, reverseMap, ap.UnapprovedFiles())
+		newApprover := findMostCoveringApprover(available, reverseMap, ap.UnapprovedFiles())
+		if newApprover == "" && len(available) < len(potentialApprovers) {
+			// Only the overloaded approvers can approve the rest of files.
+			newApprover = findMostCoveringApprover(potentialApprovers, reverseMap, ap.UnapprovedFiles())
+		}
 		if newApprover == "" {
 			o.log.Warnf("Couldn't find/suggest approvers for each files. Unapproved: %q", ap.UnapprovedFiles().List())
 			return ap.GetCurrentApproversSet()
@@ -180,14 +317,31 @@ func (o Owners) GetOwnersSet() sets.String {
 }
 
 // GetShuffledApprovers shuffles the potential approvers so that we don't
-// always suggest the same people.
+// always suggest the same people, unless they are ordered alphabetically.
 func (o Owners) GetShuffledApprovers() []string {
 	approversList := o.GetAllPotentialApprovers()
+	if o.strategy == SuggestAlphabetical {
+		sort.SliceStable(approversList, func(i, j int) bool {
+			return strings.ToLower(approversList[i]) < strings.ToLower(approversList[j])
+		})
+		return approversList
+	}
 	order := rand.New(rand.NewSource(o.seed)).Perm(len(approversList))
 	people := make([]string, 0, len(approversList))
 	for _, i := range order {
 		people = append(people, approversList[i])
 	}
+	if len(o.activity) > 0 {
+		// The approvers without activity keep the shuffled order at the end.
+		sort.SliceStable(people, func(i, j int) bool {
+			return o.activity[strings.ToLower(people[i])].After(o.activity[strings.ToLower(people[j])])
+		})
+	}
+	if o.strategy == SuggestLeastLoaded {
+		sort.SliceStable(people, func(i, j int) bool {
+			return o.load[strings.ToLower(people[i])] < o.load[strings.ToLower(people[j])]
+		})
+	}
 	return people
 }
 
@@ -224,16 +378,53 @@ type Approval struct {
 	How       string // How did the approver approved
 	Reference string // Where did the approver approved
 	NoIssue   bool   // Approval also accepts missing associated issue
+	CommentID int    // ID of the comment or review which approved, 0 if implicit
+
+	// Paths limits the approval to the files under them. It approves all the
+	// files if empty.
+	Paths []string
 }
 
 // String creates a link for the approval. Use `Login` if you just want the name.
 func (a Approval) String() string {
-	return fmt.Sprintf(
+	s := fmt.Sprintf(
 		`*<a href="%s" title="%s">%s</a>*`,
 		a.Reference,
 		a.How,
 		a.Login,
 	)
+	if len(a.Paths) > 0 {
+		s += " (" + strings.Join(a.Paths, ", ") + ")"
+	}
+	return s
+}
+
+// covers reports whether the approval approves the path, which is either the
+// directory of an OWNERS file or a file.
+func (a Approval) covers(path string) bool {
+	if len(a.Paths) == 0 {
+		return true
+	}
+
+	for _, p := range a.Paths {
+		p = strings.Trim(p, "/")
+		if p == "" || p == "." || path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Dependency is a PR which the PR depends on
+type Dependency struct {
+	Number   int  `json:"number"`   // Number of the PR depended on
+	Approved bool `json:"approved"` // Whether the PR depended on is approved
+}
+
+// Requirement is an extra requirement to approve the PR besides the OWNERS files
+type Requirement struct {
+	Description string      // Why the requirement exists
+	Approvers   sets.String // Anyone of them can meet it. It can't be met if empty
 }
 
 // Approvers is struct that provide functionality with regard to approvals of a specific
@@ -242,9 +433,48 @@ type Approvers struct {
 	owners          Owners
 	approvers       map[string]Approval // The keys of this map are normalized to lowercase.
 	assignees       sets.String
-	AssociatedIssue int
+	AssociatedIssue string
 	RequireIssue    bool
 
+	Dependencies                []Dependency
+	RequireApprovedDependencies bool
+
+	// SeriesTopic is the topic of the series which the PR is a part of, and
+	// Series are the other PRs of it. They are only shown.
+	SeriesTopic string
+	Series      []Dependency
+
+	Requirements []Requirement
+
+	// MinApprovers is the minimum number of the distinct approvers in the
+	// OWNERS files of the PR who have approved, not counting the author.
+	MinApprovers int
+	// Author is the author of the PR, whose approval, implicit or explicit,
+	// doesn't count towards MinApprovers.
+	Author string
+
+	// SingleApproverSuffices makes the approval of any one approver of the
+	// files enough to approve all the files.
+	SingleApproverSuffices bool
+	// Overrides describes the overrides of the approval requirements.
+	Overrides []string
+
+	// OwnersFileChanges are the OWNERS files changed by the PR. They don't
+	// take effect until the PR is merged.
+	OwnersFileChanges []string
+
+	// ConditionalLabel is the label added besides the approved label when the
+	// PR is approved with conditions, see ApprovalConditions. It is disabled
+	// if empty.
+	ConditionalLabel string
+
+	// DiffURL is the url of the page showing the diff of the PR
+	DiffURL string
+
+	// ExpiredApprovers are those whose approvals have expired, as they were
+	// made longer ago than the ttl of approvals.
+	ExpiredApprovers []string
+
 	ManuallyApproved func() bool
 }
 
@@ -316,6 +546,67 @@ func (ap *Approvers) AddApprover(login, reference string, noIssue bool) {
 	}
 }
 
+// AddExternalApprover adds an approval made outside, such as a vote in
+// another review system, unless the approver has approved already. how
+// describes where it was made.
+func (ap *Approvers) AddExternalApprover(login, reference, how string) {
+	if _, ok := ap.approvers[strings.ToLower(login)]; ok {
+		return
+	}
+	ap.approvers[strings.ToLower(login)] = Approval{
+		Login:     login,
+		How:       how,
+		Reference: reference,
+	}
+}
+
+// AddPartialApprover adds an approval limited to the files under the paths.
+// The paths are added to the partial approval of the same approver if any.
+// The paths matching none of the changed files are ignored, and it is a full
+// approval if none is left, as the arguments used to be ignored.
+func (ap *Approvers) AddPartialApprover(login, reference string, noIssue bool, paths []string) {
+	var matched []string
+	for _, p := range paths {
+		if a := (Approval{Paths: []string{p}}); ap.coversAnyFile(a) {
+			matched = append(matched, strings.Trim(p, "/"))
+		}
+	}
+	if len(matched) == 0 {
+		ap.AddApprover(login, reference, noIssue)
+		return
+	}
+	paths = matched
+
+	if ap.shouldNotOverrideApproval(login, noIssue) {
+		return
+	}
+
+	k := strings.ToLower(login)
+	if a, ok := ap.approvers[k]; ok {
+		if len(a.Paths) == 0 {
+			return
+		}
+		paths = sets.NewString(a.Paths...).Insert(paths...).List()
+	}
+
+	ap.approvers[k] = Approval{
+		Login:     login,
+		How:       "Approved partially",
+		Reference: reference,
+		NoIssue:   noIssue,
+		Paths:     paths,
+	}
+}
+
+func (ap *Approvers) coversAnyFile(a Approval) bool {
+	for _, fn := range ap.owners.filenames {
+		if a.covers(fn) {
+			return true
+		}
+	}
+	return false
+}
+
 // AddAuthorSelfApprover adds the author self approval
 func (ap *Approvers) AddAuthorSelfApprover(login, reference string, noIssue bool) {
 	if ap.shouldNotOverrideApproval(login, noIssue) {
@@ -323,12 +614,59 @@ func (ap *Approvers) AddAuthorSelfApprover(login, reference string, noIssue bool
 	}
 	ap.approvers[strings.ToLower(login)] = Approval{
 		Login:     login,
-		How:       "Author self-approved",
+		How:       authorSelfApproved,
 		Reference: reference,
 		NoIssue:   noIssue,
 	}
 }
 
+// AddAuthorSelfApproverExcept adds the author self approval of the OWNERS
+// files owning none of the files which required reports the author has to
+// approve explicitly. It is a full approval if there is no such file.
+func (ap *Approvers) AddAuthorSelfApproverExcept(login, reference string, required func(string) bool) {
+	owners := ap.owners.GetOwnersSet()
+
+	excluded := sets.NewString()
+	for _, fn := range ap.owners.filenames {
+		if !required(fn) {
+			continue
+		}
+		for dir := range owners {
+			if (Approval{Paths: []string{dir}}).covers(fn) {
+				excluded.Insert(dir)
+			}
+		}
+	}
+
+	if excluded.Len() == 0 {
+		ap.AddAuthorSelfApprover(login, reference, false)
+		return
+	}
+
+	// The OWNERS files are not nested, so each one covers only itself.
+	paths := owners.Difference(excluded).List()
+	if len(paths) == 0 || ap.shouldNotOverrideApproval(login, false) {
+		return
+	}
+
+	ap.approvers[strings.ToLower(login)] = Approval{
+		Login:     login,
+		How:       authorSelfApproved,
+		Reference: reference,
+		Paths:     paths,
+	}
+}
+
+// SetCommentID records the comment which established the approval of login,
+// if the current approval of login is the one made at reference.
+func (ap *Approvers) SetCommentID(login, reference string, id int) {
+	k := strings.ToLower(login)
+	if a, ok := ap.approvers[k]; ok && a.Reference == reference {
+		a.CommentID = id
+		ap.approvers[k] = a
+	}
+}
+
 // RemoveApprover removes an approver from the list.
 func (ap *Approvers) RemoveApprover(login string) {
 	delete(ap.approvers, strings.ToLower(login))
@@ -389,6 +727,23 @@ func (ap Approvers) GetFilesApprovers() map[string]sets.String {
 		// rather than the potential mis-cased username found in
 		// the OWNERS file, that's why it's the first parameter.
 		filesApprovers[fn] = IntersectSetsCase(currentApprovers, potentialApprovers)
+		for login := range filesApprovers[fn] {
+			if !ap.approvers[strings.ToLower(login)].covers(fn) {
+				filesApprovers[fn].Delete(login)
+			}
+		}
+	}
+
+	if ap.SingleApproverSuffices {
+		all := sets.NewString()
+		for _, v := range filesApprovers {
+			all = all.Union(v)
+		}
+		if all.Len() > 0 {
+			for fn := range filesApprovers {
+				filesApprovers[fn] = all
+			}
+		}
 	}
 
 	return filesApprovers
@@ -437,6 +792,7 @@ func (ap Approvers) GetFiles(baseURL *url.URL, branch string) []File {
 				baseURL:  baseURL,
 				filepath: file,
 				branch:   branch,
+				diffLink: ap.diffLink(file),
 			})
 		} else {
 			allOwnersFiles = append(allOwnersFiles, ApprovedFile{
@@ -451,6 +807,24 @@ func (ap Approvers) GetFiles(baseURL *url.URL, branch string) []File {
 	return allOwnersFiles
 }
 
+// diffLink returns the link to the diff of the first changed file owned by
+// the owners file. Gitee anchors the diff of a file by the SHA1 of its path.
+func (ap Approvers) diffLink(ownersFile string) string {
+	if ap.DiffURL == "" {
+		return ""
+	}
+
+	filenames := append([]string{}, ap.owners.filenames...)
+	sort.Strings(filenames)
+	for _, fn := range filenames {
+		dir := ap.owners.repo.FindApproverOwnersForFile(fn)
+		if dir == ownersFile || ownersFile == "" || ownersFile == "." || strings.HasPrefix(dir, ownersFile+"/") {
+			return fmt.Sprintf("%s#%x", ap.DiffURL, sha1.Sum([]byte(fn)))
+		}
+	}
+	return ""
+}
+
 // GetCCs gets the list of suggested approvers for a pull-request.  It
 // now considers current assignees as potential approvers. Here is how
 // it works:
@@ -472,16 +846,55 @@ func (ap Approvers) GetCCs() []string {
 
 	currentApprovers := ap.GetCurrentApproversSet()
 	approversAndAssignees := currentApprovers.Union(ap.assignees)
-	leafReverseMap := ap.owners.GetReverseMap(ap.owners.GetLeafApprovers())
-	suggested := ap.owners.KeepCoveringApprovers(leafReverseMap, approversAndAssignees, randomizedApprovers)
+	if len(randomizedApprovers) == 0 {
+		ap.owners.log.Debug("No potential approvers exist to filter for relevance. Does this repo have OWNERS files?")
+	}
+	leafReverseMap := ap.limitPartialApprovals(ap.owners.GetReverseMap(ap.owners.GetLeafApprovers()))
+	suggested := ap.owners.keepApproversCovering(leafReverseMap, ap.temporaryUnapprovedFiles(approversAndAssignees), randomizedApprovers)
 	approversAndSuggested := currentApprovers.Union(suggested)
 	everyone := approversAndSuggested.Union(ap.assignees)
-	fullReverseMap := ap.owners.GetReverseMap(ap.owners.GetApprovers())
-	keepAssignees := ap.owners.KeepCoveringApprovers(fullReverseMap, approversAndSuggested, everyone.List())
+	fullReverseMap := ap.limitPartialApprovals(ap.owners.GetReverseMap(ap.owners.GetApprovers()))
+	keepAssignees := ap.owners.keepApproversCovering(fullReverseMap, ap.temporaryUnapprovedFiles(approversAndSuggested), everyone.List())
 
 	return suggested.Union(keepAssignees).List()
 }
 
+// temporaryUnapprovedFiles is like Owners.temporaryUnapprovedFiles, but the
+// partial approvers among approvers only approve the paths they gave.
+func (ap Approvers) temporaryUnapprovedFiles(approvers sets.String) sets.String {
+	tmp := NewApprovers(ap.owners)
+	for approver := range approvers {
+		if a, ok := ap.approvers[strings.ToLower(approver)]; ok && len(a.Paths) > 0 {
+			tmp.approvers[strings.ToLower(approver)] = a
+		} else {
+			tmp.AddApprover(approver, "", false)
+		}
+	}
+	return tmp.UnapprovedFiles()
+}
+
+// limitPartialApprovals removes the OWNERS files which the partial approvers
+// haven't approved from the reverse map, so that the other files of theirs
+// are still covered by the suggested approvers.
+func (ap Approvers) limitPartialApprovals(reverseMap map[string]sets.String) map[string]sets.String {
+	for login, files := range reverseMap {
+		approval, ok := ap.approvers[strings.ToLower(login)]
+		if !ok || len(approval.Paths) == 0 {
+			continue
+		}
+
+		covered := sets.NewString()
+		for fn := range files {
+			if approval.covers(fn) {
+				covered.Insert(fn)
+			}
+		}
+		reverseMap[login] = covered
+	}
+
+	return reverseMap
+}
+
 // AreFilesApproved returns a bool indicating whether or not OWNERS files associated with
 // the PR are approved.  A PR with no OWNERS files is not considered approved. If this
 // returns true, the PR may still not be fully approved depending on the associated issue
@@ -490,14 +903,104 @@ func (ap Approvers) AreFilesApproved() bool {
 	return len(ap.owners.filenames) != 0 && ap.UnapprovedFiles().Len() == 0
 }
 
+// AreDependenciesApproved returns a bool indicating whether all the PRs this PR
+// depends on are approved.
+func (ap Approvers) AreDependenciesApproved() bool {
+	for _, d := range ap.Dependencies {
+		if !d.Approved {
+			return false
+		}
+	}
+	return true
+}
+
+// UnmetRequirements returns the extra requirements which are not met by the
+// current approvers. Only the full approvals from the people other than the
+// author count, so neither the self approval of the author, implicit or by a
+// command, nor a partial approval meets a requirement.
+func (ap Approvers) UnmetRequirements() []Requirement {
+	author := strings.ToLower(ap.Author)
+
+	current := sets.NewString()
+	for k, a := range ap.approvers {
+		if k != author && a.How != authorSelfApproved && len(a.Paths) == 0 {
+			current.Insert(k)
+		}
+	}
+
+	var unmet []Requirement
+	for _, r := range ap.Requirements {
+		if IntersectSetsCase(r.Approvers, current).Len() == 0 {
+			unmet = append(unmet, r)
+		}
+	}
+	return unmet
+}
+
+// OwnersApproverCount returns the number of the current approvers who are
+// approvers in the OWNERS files of the PR, not counting the author, whether
+// the author approved implicitly or by a command.
+func (ap Approvers) OwnersApproverCount() int {
+	all := sets.NewString()
+	for _, s := range ap.owners.GetApprovers() {
+		for a := range s {
+			all.Insert(strings.ToLower(a))
+		}
+	}
+
+	author := strings.ToLower(ap.Author)
+
+	n := 0
+	for k := range ap.approvers {
+		if k != author && all.Has(k) {
+			n++
+		}
+	}
+	return n
+}
+
+// MinApproversMet returns a bool indicating whether enough approvers have
+// approved the PR.
+func (ap Approvers) MinApproversMet() bool {
+	return ap.MinApprovers <= 1 || ap.OwnersApproverCount() >= ap.MinApprovers
+}
+
 // RequirementsMet returns a bool indicating whether the PR has met all approval requirements:
 // - all OWNERS files associated with the PR have been approved AND
+// - enough approvers have approved AND
+// - all the PRs it depends on are approved if it is required AND
+// - all the extra requirements are met AND
 // EITHER
 // 	- the munger config is such that an issue is not required to be associated with the PR
 // 	- that there is an associated issue with the PR
 // 	- an OWNER has indicated that the PR is trivial enough that an issue need not be associated with the PR
 func (ap Approvers) RequirementsMet() bool {
-	return ap.AreFilesApproved() && (!ap.RequireIssue || ap.AssociatedIssue != 0 || len(ap.NoIssueApprovers()) != 0)
+	return ap.AreFilesApproved() &&
+		ap.MinApproversMet() &&
+		(!ap.RequireApprovedDependencies || ap.AreDependenciesApproved()) &&
+		len(ap.UnmetRequirements()) == 0 &&
+		(!ap.RequireIssue || ap.AssociatedIssue != "" || len(ap.NoIssueApprovers()) != 0)
+}
+
+// ApprovalConditions returns the conditions under which the PR is approved
+// though it doesn't meet all the requirements normally, which are that the
+// associated issue is waived by "no-issue" approvals, and that the approved
+// label was added manually.
+func (ap Approvers) ApprovalConditions() []string {
+	var r []string
+	if ap.RequireIssue && ap.AssociatedIssue == "" && len(ap.NoIssueApprovers()) != 0 {
+		r = append(r, "the associated issue is waived")
+	}
+	if !ap.RequirementsMet() && ap.ManuallyApproved() {
+		r = append(r, "the approval is added manually")
+	}
+	return r
+}
+
+// ConditionallyApproved returns a bool indicating whether the PR is approved
+// with conditions and should have the ConditionalLabel.
+func (ap Approvers) ConditionallyApproved() bool {
+	return ap.ConditionalLabel != "" && ap.IsApproved() && len(ap.ApprovalConditions()) != 0
 }
 
 // IsApproved returns a bool indicating whether the PR is fully approved.
@@ -551,6 +1054,8 @@ type UnapprovedFile struct {
 	baseURL  *url.URL
 	filepath string
 	branch   string
+	// diffLink is the link to the diff of the first changed file it owns.
+	diffLink string
 }
 
 func (a ApprovedFile) String() string {
@@ -576,9 +1081,63 @@ func (ua UnapprovedFile) String() string {
 		ua.branch,
 		fullOwnersPath,
 	)
+	if ua.diffLink != "" {
+		return fmt.Sprintf("- **[%s](%s)** ([changes](%s))\n", fullOwnersPath, link, ua.diffLink)
+	}
 	return fmt.Sprintf("- **[%s](%s)**\n", fullOwnersPath, link)
 }
 
+// GetCoverableFiles returns the unapproved owners files which can be approved
+// by the given login.
+func (ap Approvers) GetCoverableFiles(login string) []string {
+	reverseMap := ap.owners.GetReverseMap(ap.owners.GetApprovers())
+	return reverseMap[strings.ToLower(login)].Intersection(ap.UnapprovedFiles()).List()
+}
+
+// GetInstructions returns the instructions tailored to the role of viewer:
+// - the author is told how to link an issue and how to request approvers
+// - an approver is told which of the pending owners files they can approve
+// - anyone else gets the generic instructions
+func (ap Approvers) GetInstructions(viewer, author string) string {
+	lines := []string{}
+
+	if viewer != "" && strings.EqualFold(viewer, author) {
+		if ap.RequireIssue && ap.AssociatedIssue == "" && len(ap.NoIssueApprovers()) == 0 {
+			lines = append(lines, "Link an issue by referencing it in the pull-request body.")
+		}
+		if ccs := ap.GetCCs(); !ap.AreFilesApproved() && len(ccs) > 0 {
+			lines = append(lines, fmt.Sprintf(
+				"Request approvers by writing `/assign @%s` in a comment.",
+				strings.Join(ccs, " @"),
+			))
+		}
+		if len(lines) == 0 {
+			lines = append(lines, "Nothing is required from you for approval.")
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	if viewer != "" {
+		if _, ok := ap.approvers[strings.ToLower(viewer)]; ok {
+			return "You have approved this pull-request. You can cancel the approval by writing `/approve cancel` in a comment."
+		}
+
+		if files := ap.GetCoverableFiles(viewer); len(files) > 0 {
+			paths := make([]string, 0, len(files))
+			for _, f := range files {
+				paths = append(paths, filepath.Join(f, ownersFileName))
+			}
+			return fmt.Sprintf(
+				"You can approve %s by writing `/approve` in a comment.",
+				strings.Join(paths, ", "),
+			)
+		}
+	}
+
+	return "Approvers can indicate their approval by writing `/approve` in a comment\n" +
+		"Approvers can cancel approval by writing `/approve cancel` in a comment"
+}
+
 // GenerateTemplate takes a template, name and data, and generates
 // the corresponding string.
 func GenerateTemplate(templ, name string, data interface{}) (string, error) {
@@ -603,6 +1162,23 @@ func GetMessage(ap Approvers, linkURL *url.URL, org, repo, branch, commandURL st
 	message, err := GenerateTemplate(`{{if (and (not .ap.RequirementsMet) (call .ap.ManuallyApproved )) }}
 Approval requirements bypassed by manually added approval.
 
+{{end -}}
+{{if .ap.ConditionallyApproved -}}
+Approved with conditions: {{range $index, $c := .ap.ApprovalConditions}}{{if $index}}, {{end}}{{$c}}{{end}}. It is labeled **{{.ap.ConditionalLabel}}** besides **approved**.
+
+{{end -}}
+{{range .ap.Overrides -}}
+Approval requirements overridden: {{.}}.
+{{end -}}
+{{if .ap.Overrides}}
+{{end -}}
+{{if .ap.OwnersFileChanges -}}
+**Warning**: this pull-request changes the OWNERS files {{range $index, $f := .ap.OwnersFileChanges}}{{if $index}}, {{end}}`+"`{{$f}}`"+`{{end}}. The approval is checked against the OWNERS files of the target branch, and the changes take effect only after merging.
+
+{{end -}}
+{{if .ap.ExpiredApprovers -}}
+The approvals of {{range $index, $a := .ap.ExpiredApprovers}}{{if $index}}, {{end}}**{{$a}}**{{end}} have expired. They need to approve again if they still approve.
+
 {{end -}}
 This pull-request has been approved by:{{range $index, $approval := .ap.ListApprovals}}{{if $index}}, {{else}} {{end}}{{$approval}}{{end}}
 
@@ -626,6 +1202,24 @@ Associated issue requirement bypassed by:{{range $index, $approval := .ap.ListNo
 
 {{ end -}}
 
+{{if .ap.Dependencies -}}
+This pull-request depends on:{{range $index, $dep := .ap.Dependencies}}{{if $index}},{{end}} [!{{$dep.Number}}]({{$.baseURL}}/pulls/{{$dep.Number}}) ({{if $dep.Approved}}approved{{else}}**not approved**{{end}}){{end}}
+{{if (and .ap.RequireApprovedDependencies (not .ap.AreDependenciesApproved)) -}}
+It can not be approved until all of them are approved.
+{{end}}
+{{end -}}
+{{if .ap.Series -}}
+This pull-request is a part of the series **{{.ap.SeriesTopic}}** with:{{range $index, $pr := .ap.Series}}{{if $index}},{{end}} [!{{$pr.Number}}]({{$.baseURL}}/pulls/{{$pr.Number}}) ({{if $pr.Approved}}approved{{else}}**not approved**{{end}}){{end}}
+
+{{end -}}
+{{range .ap.UnmetRequirements -}}
+- {{.Description}}{{if .Approvers}}: needs approval from one of {{range $index, $a := .Approvers.List}}{{if $index}}, {{end}}**{{$a}}**{{end}}{{else}}: it blocks the approval{{end}}
+{{end -}}
+{{if not .ap.MinApproversMet -}}
+- At least {{.ap.MinApprovers}} approvers other than the author are needed, {{.ap.OwnersApproverCount}} so far
+{{end -}}
+{{if (or .ap.UnmetRequirements (not .ap.MinApproversMet))}}
+{{end -}}
 The full list of commands accepted by this bot can be found [here]({{ .commandURL }}?repo={{ .org }}%2F{{ .repo }}).
 
 {{ if (or .ap.AreFilesApproved (call .ap.ManuallyApproved)) -}}
@@ -643,7 +1237,7 @@ Approvers can cancel approval by writing `+"`/approve cancel`"+` in a comment
 		ap.owners.log.WithError(err).Errorf("Error generating message.")
 		return nil
 	}
-	message += getGubernatorMetadata(ap.GetCCs())
+	message += getGubernatorMetadata(ap.GetCCs(), ap.ListApprovals())
 
 	title, err := GenerateTemplate("This PR is **{{if not .IsApproved}}NOT {{end}}APPROVED**", "title", ap)
 	if err != nil {
@@ -654,6 +1248,137 @@ Approvers can cancel approval by writing `+"`/approve cancel`"+` in a comment
 	return notification(ApprovalNotificationName, title, message)
 }
 
+// GetStatusMessage returns the compact status of the approval which is used
+// instead of the message of GetMessage when the notification is split. The
+// static part of the message is in GetInstructionsMessage.
+func GetStatusMessage(ap Approvers, linkURL *url.URL, org, repo, branch string) *string {
+	linkURL.Path = org + "/" + repo
+	message, err := GenerateTemplate(`{{if (and (not .ap.RequirementsMet) (call .ap.ManuallyApproved )) -}}
+Approval requirements bypassed by manually added approval.
+
+{{end -}}
+{{if .ap.ConditionallyApproved -}}
+Approved with conditions, labeled **{{.ap.ConditionalLabel}}**.
+{{end -}}
+{{range .ap.Overrides -}}
+Approval requirements overridden: {{.}}.
+{{end -}}
+{{if .ap.OwnersFileChanges -}}
+**Warning**: OWNERS files changed: {{range $index, $f := .ap.OwnersFileChanges}}{{if $index}}, {{end}}`+"`{{$f}}`"+`{{end}}.
+{{end -}}
+{{if .ap.ExpiredApprovers -}}
+Expired approvals: {{range $index, $a := .ap.ExpiredApprovers}}{{if $index}}, {{end}}**{{$a}}**{{end}}.
+{{end -}}
+Approved by:{{range $index, $approval := .ap.ListApprovals}}{{if $index}}, {{else}} {{end}}{{$approval}}{{end}}
+{{- if (and (not .ap.AreFilesApproved) (not (call .ap.ManuallyApproved))) }}
+Still needs approval from: {{range $index, $cc := .ap.GetCCs}}{{if $index}}, {{end}}**{{$cc}}**{{end}}
+{{- end}}
+{{if (and .ap.RequireIssue (not .ap.AssociatedIssue) (not (len .ap.NoIssueApprovers)) (not (call .ap.ManuallyApproved))) -}}
+*No associated issue*.
+{{end -}}
+{{if .ap.Dependencies -}}
+Depends on:{{range $index, $dep := .ap.Dependencies}}{{if $index}},{{end}} [!{{$dep.Number}}]({{$.baseURL}}/pulls/{{$dep.Number}}) ({{if $dep.Approved}}approved{{else}}**not approved**{{end}}){{end}}
+{{end -}}
+{{if .ap.Series -}}
+Series **{{.ap.SeriesTopic}}**:{{range $index, $pr := .ap.Series}}{{if $index}},{{end}} [!{{$pr.Number}}]({{$.baseURL}}/pulls/{{$pr.Number}}) ({{if $pr.Approved}}approved{{else}}**not approved**{{end}}){{end}}
+{{end -}}
+{{range .ap.UnmetRequirements -}}
+- {{.Description}}{{if .Approvers}}: needs approval from one of {{range $index, $a := .Approvers.List}}{{if $index}}, {{end}}**{{$a}}**{{end}}{{else}}: it blocks the approval{{end}}
+{{end -}}
+{{if not .ap.MinApproversMet -}}
+- At least {{.ap.MinApprovers}} approvers other than the author are needed, {{.ap.OwnersApproverCount}} so far
+{{end -}}
+{{if not .ap.AreFilesApproved}}
+{{range .ap.GetFiles .baseURL .branch}}{{.}}{{end}}
+{{- end}}`, "status", map[string]interface{}{"ap": ap, "baseURL": linkURL, "branch": branch})
+	if err != nil {
+		ap.owners.log.WithError(err).Errorf("Error generating status message.")
+		return nil
+	}
+	message += getGubernatorMetadata(ap.GetCCs(), ap.ListApprovals())
+
+	title, err := GenerateTemplate("This PR is **{{if not .IsApproved}}NOT {{end}}APPROVED**", "title", ap)
+	if err != nil {
+		ap.owners.log.WithError(err).Errorf("Error generating title.")
+		return nil
+	}
+
+	return notification(ApprovalNotificationName, title, message)
+}
+
+// GetMinimalMessage returns the compact status of the approval which is
+// posted in the minimal mode. It doesn't suggest approvers.
+func GetMinimalMessage(ap Approvers) *string {
+	message, err := GenerateTemplate(`{{if (and (not .RequirementsMet) (call .ManuallyApproved )) -}}
+Approval requirements bypassed by manually added approval.
+
+{{end -}}
+{{if .ConditionallyApproved -}}
+Approved with conditions, labeled **{{.ConditionalLabel}}**.
+{{end -}}
+{{if .OwnersFileChanges -}}
+**Warning**: OWNERS files changed: {{range $index, $f := .OwnersFileChanges}}{{if $index}}, {{end}}`+"`{{$f}}`"+`{{end}}.
+{{end -}}
+{{if .ExpiredApprovers -}}
+Expired approvals: {{range $index, $a := .ExpiredApprovers}}{{if $index}}, {{end}}**{{$a}}**{{end}}.
+{{end -}}
+Approved by:{{range $index, $approval := .ListApprovals}}{{if $index}}, {{else}} {{end}}{{$approval}}{{end}}
+{{- if (and (not .AreFilesApproved) (not (call .ManuallyApproved))) }}
+Pending OWNERS files: {{len .UnapprovedFiles}}
+{{- end}}
+{{if (and .RequireIssue (not .AssociatedIssue) (not (len .NoIssueApprovers)) (not (call .ManuallyApproved))) -}}
+*No associated issue*.
+{{end -}}
+{{range .UnmetRequirements -}}
+- {{.Description}}
+{{end -}}
+{{if not .MinApproversMet -}}
+- At least {{.MinApprovers}} approvers other than the author are needed, {{.OwnersApproverCount}} so far
+{{end -}}`, "minimal", ap)
+	if err != nil {
+		ap.owners.log.WithError(err).Errorf("Error generating minimal message.")
+		return nil
+	}
+
+	title, err := GenerateTemplate("This PR is **{{if not .IsApproved}}NOT {{end}}APPROVED**", "title", ap)
+	if err != nil {
+		ap.owners.log.WithError(err).Errorf("Error generating title.")
+		return nil
+	}
+
+	return notification(ApprovalNotificationName, title, message)
+}
+
+// GetTooManyFilesMessage returns the notification of a PR which changes more
+// files than the platform lists, so that it can't be approved.
+func GetTooManyFilesMessage(listed int) *string {
+	message := fmt.Sprintf(
+		"This PR changes more than %d files, beyond what Gitee lists, so the approvers of the files unseen are unknown. Please split it into smaller PRs, or ask an admin to add the label manually.",
+		listed,
+	)
+
+	return notification(ApprovalNotificationName, "This PR is **NOT APPROVED**", message)
+}
+
+// GetInstructionsMessage returns the static guidance on the approval process
+// which is posted once on a PR when the notification is split.
+func GetInstructionsMessage(org, repo, commandURL string) *string {
+	message, err := GenerateTemplate(`The approval status of this pull-request is kept up to date in the comment of the approval notifier.
+
+- To complete the [pull request process](https://git.k8s.io/community/contributors/guide/owners.md#the-code-review-process), an approver in each of the OWNERS files listed there needs to approve it.
+- You can assign the PR to the suggested approvers by writing `+"`/assign @login`"+` in a comment.
+- Approvers can indicate their approval by writing `+"`/approve`"+` in a comment.
+- Approvers can cancel approval by writing `+"`/approve cancel`"+` in a comment.
+
+The full list of commands accepted by this bot can be found [here]({{ .commandURL }}?repo={{ .org }}%2F{{ .repo }}).`, "instructions", map[string]interface{}{"org": org, "repo": repo, "commandURL": commandURL})
+	if err != nil {
+		logrus.WithError(err).Errorf("Error generating instructions.")
+		return nil
+	}
+
+	return notification(ApprovalInstructionsName, "", message)
+}
+
 func notification(name, arguments, context string) *string {
 	str := "[" + strings.ToUpper(name) + "]"
 
@@ -672,8 +1397,21 @@ func notification(name, arguments, context string) *string {
 
 // getGubernatorMetadata returns a JSON string with machine-readable information about approvers.
 // This MUST be kept in sync with gubernator/github/classifier.py, particularly get_approvers.
-func getGubernatorMetadata(toBeAssigned []string) string {
-	bytes, err := json.Marshal(map[string][]string{"approvers": toBeAssigned})
+// The approvals record the comments which established them for audits.
+func getGubernatorMetadata(toBeAssigned []string, approvals []Approval) string {
+	type evidence struct {
+		Login     string `json:"login"`
+		CommentID int    `json:"comment_id,omitempty"`
+		URL       string `json:"url"`
+	}
+
+	evidences := make([]evidence, len(approvals))
+	for i := range approvals {
+		a := &approvals[i]
+		evidences[i] = evidence{Login: a.Login, CommentID: a.CommentID, URL: a.Reference}
+	}
+
+	bytes, err := json.Marshal(map[string]interface{}{"approvers": toBeAssigned, "approvals": evidences})
 	if err == nil {
 		return fmt.Sprintf("\n<!-- META=%s -->", bytes)
 	}
@@ -0,0 +1,201 @@
+package approvers
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+type emptyRepo struct{}
+
+func (emptyRepo) Approvers(string) sets.String            { return sets.NewString() }
+func (emptyRepo) LeafApprovers(string) sets.String        { return sets.NewString() }
+func (emptyRepo) FindApproverOwnersForFile(string) string { return "" }
+func (emptyRepo) IsNoParentOwners(string) bool            { return false }
+
+func TestMinApproversMet(t *testing.T) {
+	repo := NewOverlayRepo(emptyRepo{}, map[string]*OwnersEntry{
+		"": {Approvers: []string{"alice", "bob", "carol"}},
+	})
+	files := []string{"README.md", "pkg/a.go"}
+
+	type approval struct {
+		login    string
+		selfAuto bool
+	}
+
+	cases := []struct {
+		name      string
+		min       int
+		author    string
+		approvals []approval
+		count     int
+		met       bool
+	}{
+		{
+			name:      "not required",
+			min:       0,
+			author:    "alice",
+			approvals: []approval{{login: "alice", selfAuto: true}},
+			count:     0,
+			met:       true,
+		},
+		{
+			name:      "one is enough",
+			min:       1,
+			author:    "eve",
+			approvals: []approval{{login: "bob"}},
+			count:     1,
+			met:       true,
+		},
+		{
+			name:      "author self-approved and one approver",
+			min:       2,
+			author:    "alice",
+			approvals: []approval{{login: "alice", selfAuto: true}, {login: "bob"}},
+			count:     1,
+			met:       false,
+		},
+		{
+			name:   "author approved explicitly and one approver",
+			min:    2,
+			author: "alice",
+			approvals: []approval{
+				{login: "alice", selfAuto: true}, {login: "alice"}, {login: "bob"},
+			},
+			count: 1,
+			met:   false,
+		},
+		{
+			name:      "author approved explicitly in another case",
+			min:       2,
+			author:    "Alice",
+			approvals: []approval{{login: "alice"}, {login: "bob"}},
+			count:     1,
+			met:       false,
+		},
+		{
+			name:      "two approvers besides the author",
+			min:       2,
+			author:    "alice",
+			approvals: []approval{{login: "alice"}, {login: "bob"}, {login: "carol"}},
+			count:     2,
+			met:       true,
+		},
+		{
+			name:      "approver not in the OWNERS files",
+			min:       2,
+			author:    "alice",
+			approvals: []approval{{login: "bob"}, {login: "mallory"}},
+			count:     1,
+			met:       false,
+		},
+	}
+
+	for _, c := range cases {
+		ap := NewApprovers(NewOwners(logrus.NewEntry(logrus.New()), files, repo, 1))
+		ap.MinApprovers = c.min
+		ap.Author = c.author
+
+		for _, a := range c.approvals {
+			if a.selfAuto {
+				ap.AddAuthorSelfApprover(a.login, "", false)
+			} else {
+				ap.AddApprover(a.login, "", false)
+			}
+		}
+
+		if n := ap.OwnersApproverCount(); n != c.count {
+			t.Errorf("%s: expect %d approvers counted, but got %d", c.name, c.count, n)
+		}
+		if v := ap.MinApproversMet(); v != c.met {
+			t.Errorf("%s: expect met %t, but got %t", c.name, c.met, v)
+		}
+	}
+}
+
+func TestUnmetRequirements(t *testing.T) {
+	// The author owns the files, as the approver of the OWNERS file.
+	repo := NewOverlayRepo(emptyRepo{}, map[string]*OwnersEntry{
+		"": {Approvers: []string{"alice", "bob"}},
+	})
+	files := []string{"README.md", "vendor/a.go"}
+
+	type approval struct {
+		login    string
+		selfAuto bool
+		paths    []string
+	}
+
+	cases := []struct {
+		name      string
+		author    string
+		required  []string
+		approvals []approval
+		unmet     int
+	}{
+		{
+			name:     "no approval",
+			author:   "eve",
+			required: []string{"bob"},
+			unmet:    1,
+		},
+		{
+			name:      "approved by the required approver",
+			author:    "eve",
+			required:  []string{"Bob"},
+			approvals: []approval{{login: "bob"}},
+			unmet:     0,
+		},
+		{
+			name:      "author owning the files self-approved",
+			author:    "alice",
+			required:  []string{"alice", "bob"},
+			approvals: []approval{{login: "alice", selfAuto: true}},
+			unmet:     1,
+		},
+		{
+			name:      "author owning the files approved explicitly",
+			author:    "Alice",
+			required:  []string{"alice", "bob"},
+			approvals: []approval{{login: "alice"}},
+			unmet:     1,
+		},
+		{
+			name:      "author owning the files and another approver",
+			author:    "alice",
+			required:  []string{"alice", "bob"},
+			approvals: []approval{{login: "alice", selfAuto: true}, {login: "bob"}},
+			unmet:     0,
+		},
+		{
+			name:      "partial approval",
+			author:    "eve",
+			required:  []string{"bob"},
+			approvals: []approval{{login: "bob", paths: []string{"vendor"}}},
+			unmet:     1,
+		},
+	}
+
+	for _, c := range cases {
+		ap := NewApprovers(NewOwners(logrus.NewEntry(logrus.New()), files, repo, 1))
+		ap.Author = c.author
+		ap.Requirements = []Requirement{{Description: "vendor", Approvers: sets.NewString(c.required...)}}
+
+		for _, a := range c.approvals {
+			switch {
+			case a.selfAuto:
+				ap.AddAuthorSelfApprover(a.login, "", false)
+			case len(a.paths) > 0:
+				ap.AddPartialApprover(a.login, "", false, a.paths)
+			default:
+				ap.AddApprover(a.login, "", false)
+			}
+		}
+
+		if n := len(ap.UnmetRequirements()); n != c.unmet {
+			t.Errorf("%s: expect %d requirements unmet, but got %d", c.name, c.unmet, n)
+		}
+	}
+}
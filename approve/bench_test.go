@@ -0,0 +1,325 @@
+package approve_test
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/github"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+	"github.com/opensourceways/robot-gitee-approve/approve/config"
+	"github.com/opensourceways/robot-gitee-approve/approve/plugins"
+)
+
+// The benchmarks of the full handling of a PR, from listing its data to
+// deciding its approval, at the scales of the large monorepos:
+//
+//	go test ./approve -run XXX -bench Handle -benchmem
+//
+// TestHandleBudget checks them against the budget in CI, so that a
+// regression of the performance fails CI rather than being found in
+// production:
+//
+//	go test ./approve -run HandleBudget -budget         # check the budget
+//	go test ./approve -run HandleBudget -update-budget  # rewrite the budget
+//
+// The budget is on the allocations of a handling, and on its time relative
+// to the smallest scenario measured in the same run, rather than the
+// absolute time which depends on the machine. The client of the platform and
+// the OWNERS files are faked in memory, so only the computation is measured.
+
+var (
+	checkBudget  = flag.Bool("budget", false, "check the handling against the budget of performance.")
+	updateBudget = flag.Bool("update-budget", false, "rewrite the budget of performance with the measured one and the headroom.")
+)
+
+const (
+	benchOrg    = "org"
+	benchRepo   = "repo"
+	benchBranch = "master"
+	benchAuthor = "author"
+	benchBot    = "robot"
+	benchNumber = 1
+	benchPRURL  = "https://gitee.com/org/repo/pulls/1"
+
+	// filesPerDir is the number of the files changed in each directory,
+	// which has its own OWNERS file.
+	filesPerDir = 20
+
+	budgetFile = "bench_budget.json"
+
+	// allocsHeadroom and timeRatioHeadroom are the multiples of the
+	// measured allocations and time ratio which the budget allows when it
+	// is rewritten. The time ratio is noisier.
+	allocsHeadroom    = 1.2
+	timeRatioHeadroom = 2
+)
+
+type scenario struct {
+	files    int
+	comments int
+}
+
+func (s scenario) name() string {
+	return fmt.Sprintf("files=%s/comments=%s", scale(s.files), scale(s.comments))
+}
+
+func scale(n int) string {
+	if n >= 1000 && n%1000 == 0 {
+		return fmt.Sprintf("%dk", n/1000)
+	}
+
+	return fmt.Sprint(n)
+}
+
+// scenarios are measured in order, and the first one is the baseline of the
+// time ratio.
+var scenarios = []scenario{
+	{files: 10, comments: 10},
+	{files: 10, comments: 5000},
+	{files: 1000, comments: 10},
+	{files: 1000, comments: 1000},
+	{files: 10000, comments: 10},
+	{files: 10000, comments: 1000},
+	{files: 10000, comments: 5000},
+}
+
+func BenchmarkHandle(b *testing.B) {
+	for _, s := range scenarios {
+		s := s
+		b.Run(s.name(), func(b *testing.B) {
+			benchmarkHandle(b, s)
+		})
+	}
+}
+
+func benchmarkHandle(b *testing.B, s scenario) {
+	logrus.SetOutput(ioutil.Discard)
+
+	cli := newFakeClient(s)
+	repoOwners := approvers.NewOverlayRepo(emptyRepo{}, cli.owners)
+
+	linkURL, _ := url.Parse("https://gitee.com")
+	gc := config.GitHubOptions{LinkURLFromConfig: "https://gitee.com", LinkURL: linkURL}
+	opts := &plugins.Approve{Repos: []string{benchOrg + "/" + benchRepo}, LgtmActsAsApprove: true}
+	log := logrus.NewEntry(logrus.StandardLogger())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		pr := approve.NewState(benchOrg, benchRepo, benchBranch, "", benchAuthor, benchPRURL, benchNumber, nil)
+		if _, err := approve.Handle(log, cli, repoOwners, gc, opts, pr); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// budget is the max allocations of a handling and the max ratio of its time
+// to the one of the baseline scenario.
+type budget struct {
+	AllocsPerOp int64   `json:"allocs_per_op"`
+	TimeRatio   float64 `json:"time_ratio"`
+}
+
+func TestHandleBudget(t *testing.T) {
+	if !*checkBudget && !*updateBudget {
+		t.Skip("the budget is checked with -budget only")
+	}
+
+	file := filepath.Join("testdata", budgetFile)
+
+	budgets := map[string]budget{}
+	if !*updateBudget {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := json.Unmarshal(data, &budgets); err != nil {
+			t.Fatalf("parse %s: %v", file, err)
+		}
+	}
+
+	var baseline float64
+	for _, s := range scenarios {
+		s := s
+		r := testing.Benchmark(func(b *testing.B) { benchmarkHandle(b, s) })
+		if r.N == 0 {
+			t.Fatalf("%s: the handling failed", s.name())
+		}
+
+		if baseline == 0 {
+			baseline = float64(r.NsPerOp())
+		}
+		ratio := float64(r.NsPerOp()) / baseline
+
+		t.Logf("%-30s %s\t%s\ttime ratio %.1f", s.name(), r.String(), r.MemString(), ratio)
+
+		if *updateBudget {
+			budgets[s.name()] = budget{
+				AllocsPerOp: int64(float64(r.AllocsPerOp()) * allocsHeadroom),
+				TimeRatio:   float64(int64(ratio*timeRatioHeadroom*10)+1) / 10,
+			}
+			continue
+		}
+
+		v, ok := budgets[s.name()]
+		if !ok {
+			t.Errorf("%s: no budget", s.name())
+			continue
+		}
+
+		if r.AllocsPerOp() > v.AllocsPerOp {
+			t.Errorf("%s: over the budget of allocations: %d > %d", s.name(), r.AllocsPerOp(), v.AllocsPerOp)
+		}
+
+		if ratio > v.TimeRatio {
+			t.Errorf("%s: over the budget of time ratio: %.1f > %.1f", s.name(), ratio, v.TimeRatio)
+		}
+	}
+
+	if *updateBudget {
+		data, _ := json.MarshalIndent(budgets, "", "  ")
+		if err := ioutil.WriteFile(file, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fakeClient serves a PR changing the files spread in the directories of
+// their own OWNERS files, on which the approvers of some directories approve
+// among the other comments.
+type fakeClient struct {
+	changes  []github.PullRequestChange
+	comments []github.IssueComment
+	owners   map[string]*approvers.OwnersEntry
+}
+
+func newFakeClient(s scenario) *fakeClient {
+	c := &fakeClient{owners: map[string]*approvers.OwnersEntry{
+		"": {Approvers: []string{"root"}},
+	}}
+
+	dirs := (s.files + filesPerDir - 1) / filesPerDir
+	for i := 0; i < s.files; i++ {
+		dir := fmt.Sprintf("module%d/pkg%d", i/filesPerDir%10, i/filesPerDir)
+		c.changes = append(c.changes, github.PullRequestChange{
+			Filename: fmt.Sprintf("%s/file%d.go", dir, i),
+			Status:   "modified",
+		})
+
+		if _, ok := c.owners[dir]; !ok {
+			c.owners[dir] = &approvers.OwnersEntry{
+				Approvers: []string{dirApprover(i / filesPerDir), fmt.Sprintf("lead%d", i/filesPerDir%10)},
+			}
+		}
+	}
+
+	start := time.Now().Add(-time.Duration(s.comments) * time.Minute)
+	for i := 0; i < s.comments; i++ {
+		login, body := fmt.Sprintf("user%d", i%50), "Looks good to me, some nits inline."
+		switch i % 10 {
+		case 0:
+			login, body = dirApprover(i/10%dirs), "/approve"
+		case 3:
+			login, body = dirApprover(i/10%dirs), "/lgtm"
+		case 7:
+			login, body = dirApprover(i/10%dirs), "/approve cancel"
+		}
+
+		c.comments = append(c.comments, github.IssueComment{
+			ID:        i + 1,
+			Body:      body,
+			User:      github.User{Login: login},
+			HTMLURL:   fmt.Sprintf("%s#note_%d", benchPRURL, i+1),
+			CreatedAt: start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	return c
+}
+
+func dirApprover(dir int) string {
+	return fmt.Sprintf("approver%d", dir)
+}
+
+func (c *fakeClient) GetPullRequest(org, repo string, number int) (*github.PullRequest, error) {
+	return nil, nil
+}
+
+func (c *fakeClient) GetPullRequestChanges(org, repo string, number int) ([]github.PullRequestChange, error) {
+	return c.changes, nil
+}
+
+func (c *fakeClient) GetIssueLabels(org, repo string, number int) ([]github.Label, error) {
+	return nil, nil
+}
+
+func (c *fakeClient) ListIssueComments(org, repo string, number int) ([]github.IssueComment, error) {
+	return append([]github.IssueComment(nil), c.comments...), nil
+}
+
+func (c *fakeClient) GetIssueComment(org, repo string, ID int) (*github.IssueComment, error) {
+	return nil, fmt.Errorf("comment %d is not found", ID)
+}
+
+func (c *fakeClient) ListReviews(org, repo string, number int) ([]github.Review, error) {
+	return nil, nil
+}
+
+func (c *fakeClient) ListPullRequestComments(org, repo string, number int) ([]github.ReviewComment, error) {
+	return nil, nil
+}
+
+func (c *fakeClient) DeleteComment(org, repo string, ID int) error {
+	return nil
+}
+
+func (c *fakeClient) EditComment(org, repo string, ID int, comment string) error {
+	return nil
+}
+
+func (c *fakeClient) CreateComment(org, repo string, number int, comment string) error {
+	return nil
+}
+
+func (c *fakeClient) BotName() (string, error) {
+	return benchBot, nil
+}
+
+func (c *fakeClient) AddLabel(org, repo string, number int, label string) error {
+	return nil
+}
+
+func (c *fakeClient) RemoveLabel(org, repo string, number int, label string) error {
+	return nil
+}
+
+func (c *fakeClient) ListIssueEvents(org, repo string, num int) ([]github.ListedIssueEvent, error) {
+	return nil, nil
+}
+
+func (c *fakeClient) ListCommentReactions(org, repo string, ID int) ([]approve.Reaction, error) {
+	return nil, nil
+}
+
+func (c *fakeClient) GetPRCommitTime(org, repo string, number int, sha string) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+type emptyRepo struct{}
+
+func (emptyRepo) Approvers(path string) sets.String       { return sets.NewString() }
+func (emptyRepo) LeafApprovers(path string) sets.String   { return sets.NewString() }
+func (emptyRepo) FindApproverOwnersForFile(string) string { return "" }
+func (emptyRepo) IsNoParentOwners(path string) bool       { return false }
@@ -0,0 +1,74 @@
+package approve
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/plugins"
+)
+
+// The classes of the comments, by how the parser takes them.
+const (
+	CommentApproval       = "approval_command"
+	CommentCancel         = "cancel"
+	CommentReviewApproval = "review_approval"
+	// CommentOtherCommand is "/approve" with the arguments which don't
+	// approve, such as "/approve status".
+	CommentOtherCommand  = "other_command"
+	CommentNotification  = "notification"
+	CommentIgnoredQuoted = "ignored_quoted"
+	CommentIgnoredBot    = "ignored_bot"
+	// CommentNearMiss looks like "/approve" but isn't taken as it, such as
+	// "/aprove", "／approve" or "/approve" in the middle of a line.
+	CommentNearMiss = "near_miss"
+	CommentOther    = "other"
+)
+
+var (
+	quotedCommandRegex = regexp.MustCompile(`(?mi)^[\t ]*>[\t >]*/(?:approve|lgtm)\b`)
+	nearMissRegex      = regexp.MustCompile(`(?i)[/／][\t ]*ap+r+o+v\w*`)
+)
+
+// ClassifyComment tells how the comment made by the author is taken, which
+// is one of the classes of comments. The command aliases are expanded.
+func ClassifyComment(botName, author, body string, lgtmActsAsApprove bool, aliases *plugins.CommandAliases) string {
+	if author == botName || isDeprecatedBot(author) {
+		if notificationRegex.MatchString(body) {
+			return CommentNotification
+		}
+		return CommentIgnoredBot
+	}
+
+	text := aliases.Expand(SanitizeCommandText(body))
+
+	class := ""
+	for _, match := range commandRegex.FindAllStringSubmatch(text, -1) {
+		name := strings.ToUpper(match[1])
+		if name != approveCommand && !(name == lgtmCommand && lgtmActsAsApprove) {
+			continue
+		}
+
+		args := strings.ToLower(strings.TrimSpace(match[2]))
+		switch {
+		case isQueryArguments(args) || strings.HasPrefix(args, setArgument+" "):
+			if class == "" {
+				class = CommentOtherCommand
+			}
+		case IsCancelArguments(args):
+			class = CommentCancel
+		default:
+			class = CommentApproval
+		}
+	}
+
+	switch {
+	case class != "":
+		return class
+	case quotedCommandRegex.MatchString(text):
+		return CommentIgnoredQuoted
+	case nearMissRegex.MatchString(text):
+		return CommentNearMiss
+	default:
+		return CommentOther
+	}
+}
@@ -0,0 +1,25 @@
+package approve
+
+import "testing"
+
+func TestClassifyCancel(t *testing.T) {
+	cases := []struct {
+		body  string
+		class string
+	}{
+		{body: "/approve cancel", class: CommentCancel},
+		{body: "/approve Cancel", class: CommentCancel},
+		{body: "/lgtm cancel", class: CommentCancel},
+		{body: "/approve cancel the change of docs is wrong", class: CommentCancel},
+		{body: "/approve cmd/cancel.go", class: CommentApproval},
+		{body: "/approve cancellation/", class: CommentApproval},
+		{body: "/approve docs cancel", class: CommentApproval},
+		{body: "/approve", class: CommentApproval},
+	}
+
+	for _, c := range cases {
+		if v := ClassifyComment("robot", "alice", c.body, true, nil); v != c.class {
+			t.Errorf("%q: expect %s, but got %s", c.body, c.class, v)
+		}
+	}
+}
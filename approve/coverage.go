@@ -0,0 +1,19 @@
+package approve
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+	"github.com/opensourceways/robot-gitee-approve/approve/plugins"
+)
+
+// GetCoverage evaluates how each changed file of a PR is covered by the
+// OWNERS files and approved, without changing the PR.
+func GetCoverage(log *logrus.Entry, ghc githubClient, repo approvers.Repo, opts *plugins.Approve, pr *state) ([]approvers.FileCoverage, error) {
+	e, err := evaluate(log, ghc, repo, opts, pr)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.approvers.Coverage(), nil
+}
@@ -0,0 +1,37 @@
+package approve
+
+import (
+	"strings"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+// expireApprovals finds out the approvers whose approvals have expired, who
+// are counted in expirable, which counts the approvals regardless of the
+// ttl, but not in ap, and when the earliest of the approvals counted in ap,
+// by the comments, expires.
+func (e *evaluation) expireApprovals(ap *approvers.Approvers, approveComments []*comment, expirable approvers.Approvers) {
+	current := ap.GetCurrentApproversSet()
+	for _, login := range expirable.GetCurrentApproversSetCased().List() {
+		if !current.Has(strings.ToLower(login)) {
+			ap.ExpiredApprovers = append(ap.ExpiredApprovers, login)
+		}
+	}
+
+	// The approval of an approver is made by their latest comment counted.
+	latest := map[string]*comment{}
+	for _, c := range approveComments {
+		latest[strings.ToLower(c.Author)] = c
+	}
+
+	for login := range current {
+		c, ok := latest[login]
+		if !ok {
+			continue
+		}
+
+		if at := c.CreatedAt.Add(e.approvalTTL); e.approvalsExpireAt.IsZero() || at.Before(e.approvalsExpireAt) {
+			e.approvalsExpireAt = at
+		}
+	}
+}
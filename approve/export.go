@@ -1,8 +1,18 @@
 package approve
 
-import "k8s.io/test-infra/prow/github"
+import (
+	"time"
 
-func NewState(org, repo, branch, body, author, url string, number int, assignees []github.User) *state {
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/github"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+// State is the information of a PR needed to handle its approval.
+type State = state
+
+func NewState(org, repo, branch, body, author, url string, number int, assignees []github.User) *State {
 	return &state{
 		org:       org,
 		repo:      repo,
@@ -15,6 +25,116 @@ func NewState(org, repo, branch, body, author, url string, number int, assignees
 	}
 }
 
+// SetDependencies sets the PRs which the PR depends on.
+func (s *state) SetDependencies(deps []approvers.Dependency) {
+	s.dependencies = deps
+}
+
+// SetSeries sets the topic of the series which the PR is a part of, and the
+// other PRs of the series.
+func (s *state) SetSeries(topic string, prs []approvers.Dependency) {
+	s.seriesTopic = topic
+	s.series = prs
+}
+
+// SetRevisions sets the revisions of the source and target branches.
+func (s *state) SetRevisions(head, base string) {
+	s.headSHA = head
+	s.baseSHA = base
+}
+
+// SetLastPush sets when the source branch was pushed last time, before
+// which the approvals are stale if invalidate_approvals_on_push is set.
+func (s *state) SetLastPush(t time.Time) {
+	s.lastPushAt = t
+}
+
+// SetOwnLabelChange sets when the robot changed the approved label last
+// time, by which its changes are told from those of the other robots sharing
+// its account.
+func (s *state) SetOwnLabelChange(t time.Time) {
+	s.ownLabelAt = t
+}
+
+// SetActivity sets the time of the latest activity of approvers which is
+// used to prefer the active ones when suggesting approvers.
+func (s *state) SetActivity(activity map[string]time.Time) {
+	s.activity = activity
+}
+
+// SetOverloaded sets the approvers who have too many PRs to review, which
+// are not suggested unless only they can approve some files. The logins are
+// lower case.
+func (s *state) SetOverloaded(logins sets.String) {
+	s.overloaded = logins
+}
+
+// SetSuggestionLoad sets the number of the PRs which each approver is
+// suggested for recently, by which the least loaded approvers are suggested
+// first if the strategy says so. The logins are lower case.
+func (s *state) SetSuggestionLoad(load map[string]int) {
+	s.suggestionLoad = load
+}
+
+// SetRequirements sets the extra requirements of approval found outside, such
+// as those from the OWNERS of other orgs.
+func (s *state) SetRequirements(reqs []approvers.Requirement) {
+	s.requirements = reqs
+}
+
+// SetFileRequirements sets the function returning the extra requirements of
+// approval derived from the files changed by the PR, which is called with the
+// changes fetched when handling it, so that they are not fetched again. It
+// must fail closed, returning a requirement which can't be met if it fails.
+func (s *state) SetFileRequirements(f func(files []string) []approvers.Requirement) {
+	s.fileRequirements = f
+}
+
+// SetExternalApprovals sets the approvals made in the review systems outside.
+func (s *state) SetExternalApprovals(approvals []ExternalApproval) {
+	s.externalApprovals = approvals
+}
+
+// SetInputs sets the loader of the inputs of the PR from outside, which are
+// loaded only when needed. fresh means the inputs of the last handling are
+// recent enough to tell the PR unchanged by their digest without loading them.
+func (s *state) SetInputs(load func(s *State) error, fresh bool) {
+	s.inputs = load
+	s.inputsFresh = fresh
+}
+
+// InputsLoaded reports whether the inputs from outside were loaded by the
+// handling.
+func (s *state) InputsLoaded() bool {
+	return s.inputs != nil && s.inputsLoaded
+}
+
+// SetApprovalsExpireAt sets when the earliest of the approvals counted in the
+// last handling expires.
+func (s *state) SetApprovalsExpireAt(t time.Time) {
+	s.approvalsExpireAt = t
+}
+
+// SetLastFingerprint sets the fingerprint of the PR when it was handled last
+// time, so that handling it again is skipped if nothing has changed since.
+func (s *state) SetLastFingerprint(fingerprint string) {
+	s.lastFingerprint = fingerprint
+}
+
+// SetRefresh makes the handling regenerate the notification from the current
+// state even if nothing has changed, such as after the notification was
+// deleted by hand or the templates changed.
+func (s *state) SetRefresh(refresh bool) {
+	s.refresh = refresh
+}
+
+// SetCommentsHint sets what is known of the comments of the PR since they
+// were listed last time, with which handling the PR is skipped by fetching
+// just the notification if nothing has changed.
+func (s *state) SetCommentsHint(h *CommentsHint) {
+	s.commentsHint = h
+}
+
 var (
 	Handle      = handle
 	commandLink = ""
@@ -27,3 +147,13 @@ func GetBotCommandLink(url string) string {
 func SetBotCommandLink(url string) {
 	commandLink = url
 }
+
+// SetFetchTimeout sets the timeout of each fetch from the platform when
+// handling a PR. The default one is kept if it is not positive.
+func SetFetchTimeout(d time.Duration) {
+	if d > 0 {
+		fetchTimeout = d
+	} else {
+		fetchTimeout = defaultFetchTimeout
+	}
+}
@@ -0,0 +1,443 @@
+// Package fakegithub provides an in-memory client of the approve package and
+// the builders of the PRs, for the consumers of the package to test handling
+// the approval of PRs without importing the fake client of prow.
+package fakegithub
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/github"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+const (
+	// DefaultBotName is the login of the robot if BotLogin is empty.
+	DefaultBotName = "approve-bot"
+
+	defaultBranch = "master"
+)
+
+// FakeClient is the client of the approve package keeping everything in
+// memory. The PRs are keyed by their numbers whatever the org and repo are,
+// and the comments it creates are given increasing IDs from 1. It is safe for
+// the concurrent fetches made by the handling.
+type FakeClient struct {
+	lock sync.Mutex
+
+	// BotLogin is the login of the robot.
+	BotLogin string
+
+	PullRequests        map[int]*github.PullRequest
+	PullRequestChanges  map[int][]github.PullRequestChange
+	IssueComments       map[int][]github.IssueComment
+	Reviews             map[int][]github.Review
+	PullRequestComments map[int][]github.ReviewComment
+	IssueEvents         map[int][]github.ListedIssueEvent
+	// Labels are the current labels of the PRs.
+	Labels map[int]sets.String
+	// Reactions are the reactions on the comments keyed by their IDs.
+	Reactions map[int][]approve.Reaction
+	// CommitTimes are the commit times keyed by the SHAs.
+	CommitTimes map[string]time.Time
+
+	// The changes made to the PRs, in the form of "org/repo#number:label"
+	// for the labels.
+	LabelsAdded     []string
+	LabelsRemoved   []string
+	CommentsCreated []string
+	CommentsEdited  []string
+	CommentsDeleted []int
+
+	// Now returns the time of the comments created. time.Now is used if it
+	// is nil.
+	Now func() time.Time
+
+	lastID int
+}
+
+// NewFakeClient returns an empty client.
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		PullRequests:        map[int]*github.PullRequest{},
+		PullRequestChanges:  map[int][]github.PullRequestChange{},
+		IssueComments:       map[int][]github.IssueComment{},
+		Reviews:             map[int][]github.Review{},
+		PullRequestComments: map[int][]github.ReviewComment{},
+		IssueEvents:         map[int][]github.ListedIssueEvent{},
+		Labels:              map[int]sets.String{},
+		Reactions:           map[int][]approve.Reaction{},
+		CommitTimes:         map[string]time.Time{},
+	}
+}
+
+func (f *FakeClient) now() time.Time {
+	if f.Now != nil {
+		return f.Now()
+	}
+	return time.Now()
+}
+
+func (f *FakeClient) nextID() int {
+	f.lastID++
+	return f.lastID
+}
+
+func (f *FakeClient) botName() string {
+	if f.BotLogin == "" {
+		return DefaultBotName
+	}
+	return f.BotLogin
+}
+
+func labelKey(org, repo string, number int, label string) string {
+	return fmt.Sprintf("%s/%s#%d:%s", org, repo, number, label)
+}
+
+func (f *FakeClient) GetPullRequest(org, repo string, number int) (*github.PullRequest, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	pr, ok := f.PullRequests[number]
+	if !ok {
+		return nil, fmt.Errorf("pull request #%d not found", number)
+	}
+
+	v := *pr
+	return &v, nil
+}
+
+func (f *FakeClient) GetPullRequestChanges(org, repo string, number int) ([]github.PullRequestChange, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]github.PullRequestChange{}, f.PullRequestChanges[number]...), nil
+}
+
+func (f *FakeClient) GetIssueLabels(org, repo string, number int) ([]github.Label, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var r []github.Label
+	for _, l := range f.Labels[number].List() {
+		r = append(r, github.Label{Name: l})
+	}
+	return r, nil
+}
+
+func (f *FakeClient) ListIssueComments(org, repo string, number int) ([]github.IssueComment, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]github.IssueComment{}, f.IssueComments[number]...), nil
+}
+
+func (f *FakeClient) GetIssueComment(org, repo string, ID int) (*github.IssueComment, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, cs := range f.IssueComments {
+		for i := range cs {
+			if cs[i].ID == ID {
+				v := cs[i]
+				return &v, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("comment %d not found", ID)
+}
+
+func (f *FakeClient) ListReviews(org, repo string, number int) ([]github.Review, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]github.Review{}, f.Reviews[number]...), nil
+}
+
+func (f *FakeClient) ListPullRequestComments(org, repo string, number int) ([]github.ReviewComment, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]github.ReviewComment{}, f.PullRequestComments[number]...), nil
+}
+
+func (f *FakeClient) DeleteComment(org, repo string, ID int) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for n, cs := range f.IssueComments {
+		for i := range cs {
+			if cs[i].ID == ID {
+				f.IssueComments[n] = append(cs[:i:i], cs[i+1:]...)
+				f.CommentsDeleted = append(f.CommentsDeleted, ID)
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("comment %d not found", ID)
+}
+
+func (f *FakeClient) EditComment(org, repo string, ID int, comment string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, cs := range f.IssueComments {
+		for i := range cs {
+			if cs[i].ID == ID {
+				cs[i].Body = comment
+				cs[i].UpdatedAt = f.now()
+				f.CommentsEdited = append(f.CommentsEdited, comment)
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("comment %d not found", ID)
+}
+
+func (f *FakeClient) CreateComment(org, repo string, number int, comment string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.addComment(number, f.botName(), comment)
+	f.CommentsCreated = append(f.CommentsCreated, comment)
+
+	return nil
+}
+
+func (f *FakeClient) BotName() (string, error) {
+	return f.botName(), nil
+}
+
+func (f *FakeClient) AddLabel(org, repo string, number int, label string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.Labels[number] == nil {
+		f.Labels[number] = sets.NewString()
+	}
+	if f.Labels[number].Has(label) {
+		return fmt.Errorf("label %s already exists on #%d", label, number)
+	}
+
+	f.Labels[number].Insert(label)
+	f.LabelsAdded = append(f.LabelsAdded, labelKey(org, repo, number, label))
+	f.addEvent(number, github.IssueActionLabeled, label)
+
+	return nil
+}
+
+func (f *FakeClient) RemoveLabel(org, repo string, number int, label string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.Labels[number].Has(label) {
+		return fmt.Errorf("label %s doesn't exist on #%d", label, number)
+	}
+
+	f.Labels[number].Delete(label)
+	f.LabelsRemoved = append(f.LabelsRemoved, labelKey(org, repo, number, label))
+	f.addEvent(number, github.IssueActionUnlabeled, label)
+
+	return nil
+}
+
+func (f *FakeClient) ListIssueEvents(org, repo string, num int) ([]github.ListedIssueEvent, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]github.ListedIssueEvent{}, f.IssueEvents[num]...), nil
+}
+
+func (f *FakeClient) ListCommentReactions(org, repo string, ID int) ([]approve.Reaction, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]approve.Reaction{}, f.Reactions[ID]...), nil
+}
+
+func (f *FakeClient) GetPRCommitTime(org, repo string, number int, sha string) (time.Time, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	t, ok := f.CommitTimes[sha]
+	if !ok {
+		return time.Time{}, fmt.Errorf("commit %s not found", sha)
+	}
+	return t, nil
+}
+
+func (f *FakeClient) addComment(number int, login, body string) github.IssueComment {
+	now := f.now()
+	c := github.IssueComment{
+		ID:        f.nextID(),
+		Body:      body,
+		User:      github.User{Login: login},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	f.IssueComments[number] = append(f.IssueComments[number], c)
+
+	return c
+}
+
+func (f *FakeClient) addEvent(number int, event github.IssueEventAction, label string) {
+	f.IssueEvents[number] = append(f.IssueEvents[number], github.ListedIssueEvent{
+		Event:     event,
+		Actor:     github.User{Login: f.botName()},
+		Label:     github.Label{Name: label},
+		CreatedAt: f.now(),
+	})
+}
+
+// PR builds a PR in the client and its state to handle.
+type PR struct {
+	f *FakeClient
+
+	org       string
+	repo      string
+	branch    string
+	number    int
+	author    string
+	body      string
+	assignees []github.User
+}
+
+// NewPR adds the PR made by the author, which changes the files, to the
+// client.
+func (f *FakeClient) NewPR(org, repo string, number int, author string, files ...string) *PR {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.PullRequests[number] = &github.PullRequest{
+		Number:  number,
+		HTMLURL: prURL(org, repo, number),
+		User:    github.User{Login: author},
+		State:   "open",
+	}
+
+	changes := make([]github.PullRequestChange, len(files))
+	for i, fn := range files {
+		changes[i] = github.PullRequestChange{Filename: fn, Status: github.PullRequestFileModified}
+	}
+	f.PullRequestChanges[number] = changes
+
+	return &PR{f: f, org: org, repo: repo, branch: defaultBranch, number: number, author: author}
+}
+
+func prURL(org, repo string, number int) string {
+	return fmt.Sprintf("https://gitee.com/%s/%s/pulls/%d", org, repo, number)
+}
+
+// Branch sets the target branch of the PR.
+func (p *PR) Branch(branch string) *PR {
+	p.branch = branch
+	return p
+}
+
+// Body sets the body of the PR.
+func (p *PR) Body(body string) *PR {
+	p.body = body
+
+	p.f.lock.Lock()
+	p.f.PullRequests[p.number].Body = body
+	p.f.lock.Unlock()
+
+	return p
+}
+
+// Assign assigns the PR to the logins.
+func (p *PR) Assign(logins ...string) *PR {
+	for _, l := range logins {
+		p.assignees = append(p.assignees, github.User{Login: l})
+	}
+	return p
+}
+
+// Label adds the labels to the PR without recording them as the changes.
+func (p *PR) Label(labels ...string) *PR {
+	p.f.lock.Lock()
+	defer p.f.lock.Unlock()
+
+	if p.f.Labels[p.number] == nil {
+		p.f.Labels[p.number] = sets.NewString()
+	}
+	p.f.Labels[p.number].Insert(labels...)
+
+	return p
+}
+
+// Comment adds the comment made by the login to the PR and returns its ID.
+func (p *PR) Comment(login, body string) int {
+	p.f.lock.Lock()
+	defer p.f.lock.Unlock()
+
+	return p.f.addComment(p.number, login, body).ID
+}
+
+// Approve adds "/approve" commented by each login to the PR.
+func (p *PR) Approve(logins ...string) *PR {
+	for _, l := range logins {
+		p.Comment(l, "/approve")
+	}
+	return p
+}
+
+// React adds the reaction made by the login on the comment.
+func (p *PR) React(commentID int, login, content string) *PR {
+	p.f.lock.Lock()
+	defer p.f.lock.Unlock()
+
+	p.f.Reactions[commentID] = append(p.f.Reactions[commentID], approve.Reaction{Login: login, Content: content})
+
+	return p
+}
+
+// State returns the state of the PR to handle.
+func (p *PR) State() *approve.State {
+	return approve.NewState(
+		p.org, p.repo, p.branch, p.body, p.author,
+		prURL(p.org, p.repo, p.number), p.number,
+		append([]github.User{}, p.assignees...),
+	)
+}
+
+// Comments returns the bodies of the comments on the PR, in order.
+func (f *FakeClient) Comments(number int) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	cs := f.IssueComments[number]
+	r := make([]string, len(cs))
+	for i := range cs {
+		r[i] = cs[i].Body
+	}
+	return r
+}
+
+// NewRepo returns the OWNERS files of a repo keyed by their directories,
+// whose root directory is "".
+func NewRepo(owners map[string]*approvers.OwnersEntry) approvers.Repo {
+	return approvers.NewOverlayRepo(emptyRepo{}, owners)
+}
+
+type emptyRepo struct{}
+
+func (emptyRepo) Approvers(path string) sets.String       { return sets.NewString() }
+func (emptyRepo) LeafApprovers(path string) sets.String   { return sets.NewString() }
+func (emptyRepo) FindApproverOwnersForFile(string) string { return "" }
+func (emptyRepo) IsNoParentOwners(path string) bool       { return false }
+
+// CurrentLabels returns the current labels of the PR.
+func (f *FakeClient) CurrentLabels(number int) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.Labels[number].List()
+}
@@ -0,0 +1,70 @@
+package approve
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const defaultFetchTimeout = 20 * time.Second
+
+// fetchTimeout bounds each fetch from the platform made by fetcher.
+var fetchTimeout = defaultFetchTimeout
+
+// fetcher makes the fetches of a PR concurrently. The first failure, or a
+// fetch which doesn't finish within fetchTimeout, fails all of them.
+type fetcher struct {
+	pr  *state
+	g   *errgroup.Group
+	ctx context.Context
+}
+
+func newFetcher(pr *state) *fetcher {
+	g, ctx := errgroup.WithContext(context.Background())
+
+	return &fetcher{pr: pr, g: g, ctx: ctx}
+}
+
+// fetch runs call in background, and passes its result to set once it
+// succeeds in time. set is never called after wait returns, even if call
+// finishes late, since the client can't be interrupted and is left to finish
+// by its own timeout.
+func (f *fetcher) fetch(what string, call func() (interface{}, error), set func(interface{})) {
+	f.g.Go(func() error {
+		type result struct {
+			v   interface{}
+			err error
+		}
+
+		done := make(chan result, 1)
+		go func() {
+			v, err := call()
+			done <- result{v: v, err: err}
+		}()
+
+		timer := time.NewTimer(fetchTimeout)
+		defer timer.Stop()
+
+		select {
+		case r := <-done:
+			if r.err != nil {
+				return fetchErr(f.pr, what, r.err)
+			}
+			set(r.v)
+			return nil
+
+		case <-timer.C:
+			return fetchErr(f.pr, what, fmt.Errorf("timed out after %s", fetchTimeout))
+
+		case <-f.ctx.Done():
+			return f.ctx.Err()
+		}
+	})
+}
+
+// wait waits for the fetches, and returns the first failure.
+func (f *fetcher) wait() error {
+	return f.g.Wait()
+}
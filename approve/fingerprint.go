@@ -0,0 +1,157 @@
+package approve
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"k8s.io/test-infra/prow/labels"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/plugins"
+)
+
+var (
+	fingerprintRegex = regexp.MustCompile(`<!-- STATE=([^\s]+) -->`)
+
+	// inputsDigestRegex matches the digest of the inputs from outside in a
+	// fingerprint.
+	inputsDigestRegex = regexp.MustCompile(`:in=([0-9a-f]+)`)
+)
+
+// fingerprint identifies the state of the PR which the notification depends
+// on: the head revision, the revision of the target branch where the OWNERS
+// files are read from, the latest comment not made by the bot and whether
+// the approved label is present, along with the approval state of the PRs in
+// the same series, the labels of the label policies, the reactions approving,
+// the reviews, the lgtm label if the approval waits for it, and whether the
+// approvals counted last time have expired. The effective config is hashed
+// into it, and so are the inputs from outside. It is empty if the revisions,
+// the config or the inputs are unknown.
+func (e *evaluation) fingerprint(pr *state, opts *plugins.Approve) string {
+	if pr.headSHA == "" || pr.baseSHA == "" {
+		return ""
+	}
+
+	cfg, err := configHash(opts)
+	if err != nil {
+		return ""
+	}
+
+	inputs := pr.inputsDigest()
+	if inputs == "" {
+		return ""
+	}
+
+	deps := ""
+	for _, d := range pr.series {
+		deps += fmt.Sprintf(":%d=%t", d.Number, d.Approved)
+	}
+
+	for _, p := range activeLabelPolicies(e.labels, opts.LabelPolicies) {
+		deps += ":" + p.Label
+	}
+
+	if opts.RequireLgtmBeforeApprove {
+		deps += fmt.Sprintf(":%s=%t", labels.LGTM, e.labels.Has(labels.LGTM))
+	}
+
+	for _, r := range e.approvalReactions {
+		deps += ":+1=" + r.Login
+	}
+
+	for i := range e.reviews {
+		r := &e.reviews[i]
+		deps += fmt.Sprintf(":review%d=%s", r.ID, r.State)
+	}
+
+	// The approvals expire as the time goes by without any change to the PR.
+	if t := pr.approvalsExpireAt; !t.IsZero() && !time.Now().Before(t) {
+		deps += ":expired"
+	}
+
+	return fmt.Sprintf(
+		"%s:%s:%d:%t:cfg=%s:in=%s%s",
+		pr.headSHA, pr.baseSHA, e.lastComment, e.hasApprovedLabel, cfg, inputs, deps,
+	)
+}
+
+// inputsDigest returns the digest of the inputs from outside: the approval
+// state of the PRs depended on, the requirements and the approvals made
+// outside. It is the digest in the last fingerprint if the inputs are not
+// loaded but those of the last handling are fresh, or empty if it is unknown.
+func (pr *state) inputsDigest() string {
+	if pr.inputs != nil && !pr.inputsLoaded {
+		if !pr.inputsFresh {
+			return ""
+		}
+
+		if m := inputsDigestRegex.FindStringSubmatch(pr.lastFingerprint); m != nil {
+			return m[1]
+		}
+		return ""
+	}
+
+	h := sha256.New()
+
+	for _, d := range pr.dependencies {
+		fmt.Fprintf(h, "dep:%d=%t\n", d.Number, d.Approved)
+	}
+
+	for _, r := range pr.requirements {
+		fmt.Fprintf(h, "req:%q=%s\n", r.Description, strings.Join(r.Approvers.List(), ","))
+	}
+
+	for _, a := range pr.externalApprovals {
+		fmt.Fprintf(h, "ext:%s=%s\n", a.Source, a.Login)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// loadInputs loads the inputs from outside unless they are loaded.
+func (pr *state) loadInputs() error {
+	if pr.inputs == nil || pr.inputsLoaded {
+		return nil
+	}
+
+	if err := pr.inputs(pr); err != nil {
+		return err
+	}
+
+	pr.inputsLoaded = true
+
+	return nil
+}
+
+// configHash returns the hash of the config in json along with the command
+// aliases, which are not a part of the json of the config.
+func configHash(opts *plugins.Approve) (string, error) {
+	h := sha256.New()
+
+	for _, v := range []interface{}{opts, opts.CommandAliases} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		h.Write(b)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:8]), nil
+}
+
+// fingerprintMetadata returns the hidden metadata recording the fingerprint
+// in the notification.
+func fingerprintMetadata(fingerprint string) string {
+	return fmt.Sprintf("\n<!-- STATE=%s -->", fingerprint)
+}
+
+func parseFingerprint(body string) string {
+	if m := fingerprintRegex.FindStringSubmatch(body); len(m) > 1 {
+		return m[1]
+	}
+	return ""
+}
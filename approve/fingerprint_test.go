@@ -0,0 +1,172 @@
+package approve
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+	"github.com/opensourceways/robot-gitee-approve/approve/plugins"
+)
+
+func TestFingerprintConfig(t *testing.T) {
+	pr := &state{headSHA: "head", baseSHA: "base"}
+	e := &evaluation{}
+
+	base := func() *plugins.Approve {
+		return &plugins.Approve{Repos: []string{"org"}, MinApprovers: 1}
+	}
+	origin := e.fingerprint(pr, base())
+
+	cases := []struct {
+		name    string
+		change  func(*plugins.Approve)
+		changed bool
+	}{
+		{
+			name:   "same config",
+			change: func(*plugins.Approve) {},
+		},
+		{
+			name:    "option changed",
+			change:  func(o *plugins.Approve) { o.MinApprovers = 2 },
+			changed: true,
+		},
+		{
+			name:    "option enabled",
+			change:  func(o *plugins.Approve) { o.LgtmActsAsApprove = true },
+			changed: true,
+		},
+		{
+			name: "command aliases added",
+			change: func(o *plugins.Approve) {
+				o.CommandAliases = plugins.NewCommandAliases(map[string]string{"同意": "approve"})
+			},
+			changed: true,
+		},
+	}
+
+	for _, c := range cases {
+		opts := base()
+		c.change(opts)
+
+		v := e.fingerprint(pr, opts)
+		if v == "" {
+			t.Errorf("%s: the fingerprint is empty", c.name)
+		}
+		if (v != origin) != c.changed {
+			t.Errorf("%s: expect changed %t, but got %s and %s", c.name, c.changed, origin, v)
+		}
+		if parseFingerprint(fingerprintMetadata(v)) != v {
+			t.Errorf("%s: the fingerprint %s is not parsed back", c.name, v)
+		}
+	}
+}
+
+func TestFingerprintState(t *testing.T) {
+	opts := &plugins.Approve{Repos: []string{"org"}}
+	e := &evaluation{}
+
+	reqs := func(logins ...string) []approvers.Requirement {
+		return []approvers.Requirement{{Description: "vendor is owned by upstream", Approvers: sets.NewString(logins...)}}
+	}
+	last := e.fingerprint(&state{headSHA: "head", baseSHA: "base", requirements: reqs("alice")}, opts)
+
+	cases := []struct {
+		name  string
+		pr    *state
+		empty bool
+		// changed is whether the fingerprint differs from the last one.
+		changed bool
+		loaded  bool
+	}{
+		{
+			name: "same requirements",
+			pr:   &state{requirements: reqs("alice")},
+		},
+		{
+			name:    "requirements changed",
+			pr:      &state{requirements: reqs("bob")},
+			changed: true,
+		},
+		{
+			name:    "requirements gone",
+			pr:      &state{},
+			changed: true,
+		},
+		{
+			name: "approvals not expired yet",
+			pr: &state{
+				requirements:      reqs("alice"),
+				approvalsExpireAt: time.Now().Add(time.Hour),
+			},
+		},
+		{
+			name: "approvals expired",
+			pr: &state{
+				requirements:      reqs("alice"),
+				approvalsExpireAt: time.Now().Add(-time.Second),
+			},
+			changed: true,
+		},
+		{
+			name: "inputs of the last handling are fresh",
+			pr: &state{
+				inputs:          func(*State) error { return fmt.Errorf("loaded") },
+				inputsFresh:     true,
+				lastFingerprint: last,
+			},
+		},
+		{
+			name: "inputs of the last handling are stale",
+			pr: &state{
+				inputs:          func(*State) error { return fmt.Errorf("loaded") },
+				lastFingerprint: last,
+			},
+			empty: true,
+		},
+		{
+			name: "inputs loaded",
+			pr: &state{
+				inputs: func(s *State) error {
+					s.SetRequirements(reqs("bob"))
+					return nil
+				},
+				lastFingerprint: last,
+			},
+			changed: true,
+			loaded:  true,
+		},
+	}
+
+	for _, c := range cases {
+		c.pr.headSHA, c.pr.baseSHA = "head", "base"
+
+		if c.loaded {
+			if err := c.pr.loadInputs(); err != nil {
+				t.Errorf("%s: unexpected error: %v", c.name, err)
+			}
+		}
+
+		v := e.fingerprint(c.pr, opts)
+		if (v == "") != c.empty {
+			t.Errorf("%s: expect empty %t, but got %q", c.name, c.empty, v)
+		}
+		if !c.empty && (v != last) != c.changed {
+			t.Errorf("%s: expect changed %t, but got %s and %s", c.name, c.changed, last, v)
+		}
+	}
+}
+
+func TestLoadInputsFails(t *testing.T) {
+	pr := &state{inputs: func(*State) error { return fmt.Errorf("federation unavailable") }}
+
+	if err := pr.loadInputs(); err == nil {
+		t.Error("expect the error of loading the inputs")
+	}
+	if pr.InputsLoaded() {
+		t.Error("expect the inputs not loaded")
+	}
+}
@@ -0,0 +1,121 @@
+//go:build gofuzz
+// +build gofuzz
+
+package approve
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+	"github.com/opensourceways/robot-gitee-approve/approve/plugins"
+)
+
+// The fuzzing targets of the parsing of the commands and the associated
+// issues, which are built with the gofuzz build tag only. They panic if the
+// parsing breaks its invariants, and return 1 for the inputs which are
+// interesting to go-fuzz. They are built and run with the seed corpus as
+//
+//	go-fuzz-build -func FuzzCommands github.com/opensourceways/robot-gitee-approve/approve
+//	go-fuzz -bin approve-fuzz.zip -workdir approve/testdata/fuzz/commands
+//
+// and the same for FuzzAssociatedIssue with the workdir of
+// testdata/fuzz/issue. The tests run them on the seed corpus as
+//
+//	go test -tags gofuzz -run TestFuzzCorpus ./approve
+
+var (
+	fuzzLog     = logrus.NewEntry(logrus.New())
+	fuzzAliases = plugins.NewCommandAliases(map[string]string{"同意": "approve", "撤销同意": "approve cancel"})
+	fuzzIssueID = regexp.MustCompile(`^(?:I[0-9A-Z]+|\d+)$`)
+)
+
+// Fuzz fuzzes both the commands and the associated issues.
+func Fuzz(data []byte) int {
+	return FuzzCommands(data) + FuzzAssociatedIssue(data)
+}
+
+// FuzzCommands fuzzes the parsing of the commands of the comments.
+func FuzzCommands(data []byte) int {
+	return fuzzCommands(data)
+}
+
+// FuzzAssociatedIssue fuzzes finding the associated issue in the PR body,
+// whose first line is the org.
+func FuzzAssociatedIssue(data []byte) int {
+	return fuzzAssociatedIssue(data)
+}
+
+// fuzzRepo is a repo whose root OWNERS file has the approvers.
+type fuzzRepo struct{}
+
+func (fuzzRepo) Approvers(string) sets.String            { return sets.NewString("root") }
+func (fuzzRepo) LeafApprovers(string) sets.String        { return sets.NewString("root") }
+func (fuzzRepo) FindApproverOwnersForFile(string) string { return "" }
+func (fuzzRepo) IsNoParentOwners(string) bool            { return false }
+
+// fuzzCommands parses data as a comment, which must be classified the same
+// way as it is taken by the approval matcher.
+func fuzzCommands(data []byte) int {
+	body := string(data)
+
+	text := SanitizeCommandText(body)
+	if len(text) > maxCommandTextLength || !utf8.ValidString(text) || strings.Contains(text, "\x00") {
+		panic(fmt.Sprintf("invalid sanitized text of %q", body))
+	}
+
+	for _, aliases := range []*plugins.CommandAliases{nil, fuzzAliases} {
+		c := &comment{Body: body, Author: "root", HTMLURL: "https://gitee.com/org/repo/pulls/1#note_1", ID: 1}
+
+		ap := approvers.NewApprovers(approvers.NewOwners(fuzzLog, []string{"a/b.go", "c.go"}, fuzzRepo{}, 1))
+		addApprovers(&ap, []*comment{c}, "author", true, aliases)
+
+		matched := isApprovalCommand("bot", true, aliases, c)
+		switch class := ClassifyComment("bot", "root", body, true, aliases); class {
+		case CommentApproval, CommentCancel:
+			if !matched {
+				panic(fmt.Sprintf("%q is classified as %s but not matched", body, class))
+			}
+		case CommentOtherCommand:
+		default:
+			if matched {
+				panic(fmt.Sprintf("%q is matched but classified as %s", body, class))
+			}
+		}
+
+		if matched {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// fuzzAssociatedIssue takes the first line of data as the org, whose regex
+// metacharacters must be escaped, and the rest as the PR body.
+func fuzzAssociatedIssue(data []byte) int {
+	org, body := string(data), ""
+	if i := strings.IndexByte(org, '\n'); i >= 0 {
+		org, body = org[:i], org[i+1:]
+	}
+
+	issue, err := findAssociatedIssue(body, org, "")
+	if err != nil {
+		panic(fmt.Sprintf("find the associated issue of the org %q: %v", org, err))
+	}
+
+	if issue == "" {
+		return 0
+	}
+
+	if !fuzzIssueID.MatchString(issue) {
+		panic(fmt.Sprintf("invalid issue %q found in %q of the org %q", issue, body, org))
+	}
+
+	return 1
+}
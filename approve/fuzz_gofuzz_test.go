@@ -0,0 +1,46 @@
+//go:build gofuzz
+// +build gofuzz
+
+package approve
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestFuzzCorpus runs the fuzzing targets on the seed corpus, along with the
+// crashers go-fuzz has found and added to it.
+func TestFuzzCorpus(t *testing.T) {
+	targets := map[string]func([]byte) int{
+		"commands": fuzzCommands,
+		"issue":    fuzzAssociatedIssue,
+	}
+
+	for name, fuzz := range targets {
+		files, err := filepath.Glob(filepath.Join("testdata", "fuzz", name, "corpus", "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 {
+			t.Errorf("no seed corpus of %s", name)
+		}
+
+		for _, f := range files {
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%s: %v", f, r)
+					}
+				}()
+
+				fuzz(data)
+			}()
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package approve
+
+import (
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/github"
+	"k8s.io/test-infra/prow/labels"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/plugins"
+)
+
+// CommentsHint is what the caller knows of the comments of a PR without
+// listing them, which is learned from the last handling and kept up to date
+// by the comment events since.
+type CommentsHint struct {
+	// NotificationID is the ID of the latest notification.
+	NotificationID int
+	// LastCommentID is the ID of the latest comment not made by the bot.
+	LastCommentID int
+}
+
+// unchangedByHint tells whether the PR has not changed since the latest
+// notification by fetching just the notification, rather than listing all
+// the comments which may be many. It is false if that can't be told, such
+// as when there is no hint or the notification is gone, and the comments
+// have to be listed.
+func unchangedByHint(log *logrus.Entry, ghc githubClient, opts *plugins.Approve, pr *state) bool {
+	h := pr.commentsHint
+	if h == nil || h.NotificationID == 0 || pr.lastFingerprint == "" {
+		return false
+	}
+
+	var (
+		notification *github.IssueComment
+		issueLabels  []github.Label
+		reviews      []github.Review
+	)
+	f := newFetcher(pr)
+	f.fetch("notification", func() (interface{}, error) {
+		return ghc.GetIssueComment(pr.org, pr.repo, h.NotificationID)
+	}, func(v interface{}) { notification = v.(*github.IssueComment) })
+	f.fetch("issue labels", func() (interface{}, error) {
+		return ghc.GetIssueLabels(pr.org, pr.repo, pr.number)
+	}, func(v interface{}) { issueLabels = v.([]github.Label) })
+	if opts.ConsiderReviewState() {
+		f.fetch("reviews", func() (interface{}, error) {
+			return ghc.ListReviews(pr.org, pr.repo, pr.number)
+		}, func(v interface{}) { reviews = v.([]github.Review) })
+	}
+	if err := f.wait(); err != nil {
+		log.WithError(err).Debug("Can't tell the change by the hint of comments.")
+		return false
+	}
+
+	botName, err := ghc.BotName()
+	if err != nil {
+		return false
+	}
+
+	c := commentFromIssueComment(notification)
+	if !notificationMatcher(botName)(c) {
+		return false
+	}
+
+	labelSet := sets.NewString()
+	for _, label := range issueLabels {
+		labelSet.Insert(label.Name)
+	}
+	e := &evaluation{
+		botName:          botName,
+		hasApprovedLabel: labelSet.Has(labels.Approved),
+		labels:           labelSet,
+		lastComment:      h.LastCommentID,
+		reviews:          reviews,
+	}
+	if opts.ReactionActsAsApprove {
+		e.fetchApprovalReactions(log, ghc, pr, c)
+	}
+
+	fingerprint := e.fingerprint(pr, opts)
+
+	return fingerprint != "" &&
+		(fingerprint == pr.lastFingerprint || fingerprint == parseFingerprint(c.Body))
+}
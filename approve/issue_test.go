@@ -0,0 +1,28 @@
+package approve
+
+import "testing"
+
+func TestFindAssociatedIssueEscapesOrg(t *testing.T) {
+	cases := []struct {
+		org   string
+		body  string
+		issue string
+	}{
+		{org: "open.euler", body: "https://gitee.com/openXeuler/repo/issues/I1", issue: ""},
+		{org: "open.euler", body: "https://gitee.com/open.euler/repo/issues/I2", issue: "I2"},
+		{org: "org|.*", body: "https://gitee.com/anything/repo/issues/I3", issue: ""},
+		{org: "(org", body: "https://gitee.com/(org/repo/issues/I4", issue: "I4"},
+		{org: "org{2,}", body: "https://gitee.com/orgg/repo/issues/I5", issue: ""},
+		{org: "a+b", body: "see #6", issue: "6"},
+	}
+
+	for _, c := range cases {
+		issue, err := findAssociatedIssue(c.body, c.org, "")
+		if err != nil {
+			t.Errorf("%s: %v", c.org, err)
+		}
+		if issue != c.issue {
+			t.Errorf("%s: expect %q in %q, but got %q", c.org, c.issue, c.body, issue)
+		}
+	}
+}
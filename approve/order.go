@@ -0,0 +1,56 @@
+package approve
+
+import (
+	"sort"
+	"time"
+)
+
+// futureCommentTolerance is how far in the future a comment may be created
+// at before its timestamp is regarded as skewed, which allows for the small
+// difference between the clocks of the robot and the platform.
+const futureCommentTolerance = time.Minute
+
+// sortComments sorts the comments in the order they were made. Gitee numbers
+// the comments of a PR in the order they are created, which is trusted over
+// their timestamps that occasionally disagree with it, so those comments keep
+// the order of their sequence IDs among themselves, while the reviews, which
+// have no sequence ID, are placed among them by time. The comments created in
+// the future are regarded as created now.
+//
+// It returns the number of the comments created in the future and the number
+// of those whose timestamps disagree with their sequence IDs.
+func sortComments(comments []*comment, now time.Time) (future, outOfOrder int) {
+	limit := now.Add(futureCommentTolerance)
+	for _, c := range comments {
+		if c.CreatedAt.After(limit) {
+			c.CreatedAt = now
+			future++
+		}
+	}
+
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].CreatedAt.Before(comments[j].CreatedAt)
+	})
+
+	var pos []int
+	var seq []*comment
+	for i, c := range comments {
+		if c.Seq > 0 {
+			pos = append(pos, i)
+			seq = append(seq, c)
+		}
+	}
+
+	sort.SliceStable(seq, func(i, j int) bool {
+		return seq[i].Seq < seq[j].Seq
+	})
+
+	for i, p := range pos {
+		if comments[p] != seq[i] {
+			comments[p] = seq[i]
+			outOfOrder++
+		}
+	}
+
+	return future, outOfOrder
+}
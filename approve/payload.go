@@ -0,0 +1,90 @@
+package approve
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// notificationPayloadVersion is the version of NotificationPayload, which is
+// increased when a change of it breaks the parsers.
+const notificationPayloadVersion = 1
+
+var notificationPayloadRegex = regexp.MustCompile(`<!-- APPROVAL-REPORT=(\{.*?\}) -->`)
+
+// NotificationPayload is the approval state of the PR embedded in the
+// notification as a hidden comment, for the other robots to read the state
+// exactly as the notification is made on.
+type NotificationPayload struct {
+	Version  int  `json:"version"`
+	Approved bool `json:"approved"`
+	// Approvers are the logins whose approval is counted.
+	Approvers []string `json:"approvers"`
+	// Owners maps the OWNERS paths of the changed files to their approvers.
+	Owners map[string]OwnersPayload `json:"owners"`
+	// UnapprovedFiles are the OWNERS paths which still need approval.
+	UnapprovedFiles []string `json:"unapproved_files"`
+}
+
+// OwnersPayload is the approval state of an OWNERS path.
+type OwnersPayload struct {
+	// Approvers are those who can approve it.
+	Approvers []string `json:"approvers"`
+	// ApprovedBy are the approvers whose approval on it is counted.
+	ApprovedBy []string `json:"approved_by,omitempty"`
+}
+
+func (e *evaluation) notificationPayload() NotificationPayload {
+	ap := e.approvers
+
+	owners := map[string]OwnersPayload{}
+	for fn, v := range e.owners.GetApprovers() {
+		owners[fn] = OwnersPayload{Approvers: v.List()}
+	}
+	for fn, v := range ap.GetFilesApprovers() {
+		p := owners[fn]
+		p.ApprovedBy = v.List()
+		owners[fn] = p
+	}
+
+	return NotificationPayload{
+		Version:         notificationPayloadVersion,
+		Approved:        ap.IsApproved(),
+		Approvers:       ap.GetCurrentApproversSetCased().List(),
+		Owners:          owners,
+		UnapprovedFiles: ap.UnapprovedFiles().List(),
+	}
+}
+
+// payloadMetadata returns the hidden comment embedding the payload in the
+// notification. The payload can't end the comment early, since json escapes
+// the > in it.
+func payloadMetadata(p NotificationPayload) (string, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("\n<!-- APPROVAL-REPORT=%s -->", b), nil
+}
+
+// ParseNotificationPayload parses the approval state embedded in the body of
+// the notification. It returns nil if there is none, such as when the
+// notification was made before the payload was embedded.
+func ParseNotificationPayload(body string) (*NotificationPayload, error) {
+	m := notificationPayloadRegex.FindStringSubmatch(body)
+	if len(m) < 2 {
+		return nil, nil
+	}
+
+	p := new(NotificationPayload)
+	if err := json.Unmarshal([]byte(m[1]), p); err != nil {
+		return nil, fmt.Errorf("invalid notification payload: %v", err)
+	}
+
+	if p.Version > notificationPayloadVersion {
+		return nil, fmt.Errorf("unsupported version of notification payload: %d", p.Version)
+	}
+
+	return p, nil
+}
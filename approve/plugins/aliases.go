@@ -0,0 +1,72 @@
+package plugins
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// CommandAliases rewrites the aliases of commands, such as "/同意", into the
+// commands they stand for, such as "/approve". The aliases are case
+// insensitive.
+type CommandAliases struct {
+	reg      *regexp.Regexp
+	commands map[string]string
+}
+
+// NewCommandAliases compiles the aliases which map the aliases to the commands
+// with the arguments, such as "撤销同意" to "approve cancel". The leading slashes
+// of both are optional. It returns nil if there is no alias.
+func NewCommandAliases(aliases map[string]string) *CommandAliases {
+	if len(aliases) == 0 {
+		return nil
+	}
+
+	commands := make(map[string]string, len(aliases))
+	names := make([]string, 0, len(aliases))
+	for alias, cmd := range aliases {
+		alias = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(alias), "/"))
+		commands[alias] = strings.TrimPrefix(strings.TrimSpace(cmd), "/")
+		names = append(names, regexp.QuoteMeta(alias))
+	}
+
+	// The longer aliases come first, so that "/撤销同意" is not taken as
+	// "/撤销" if both are aliases.
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+
+	return &CommandAliases{
+		reg:      regexp.MustCompile(`(?mi)^/(` + strings.Join(names, "|") + `)([\t ]|$)`),
+		commands: commands,
+	}
+}
+
+// Expand returns the text with the aliases at the beginning of lines replaced
+// by their commands. The arguments following an alias are kept after the
+// ones of its command.
+func (a *CommandAliases) Expand(text string) string {
+	if a == nil {
+		return text
+	}
+
+	return a.reg.ReplaceAllStringFunc(text, func(m string) string {
+		v := a.reg.FindStringSubmatch(m)
+
+		return "/" + a.commands[strings.ToLower(v[1])] + v[2]
+	})
+}
+
+// MarshalJSON writes the aliases as the map of the aliases to the commands,
+// which is what they are compiled from.
+func (a *CommandAliases) MarshalJSON() ([]byte, error) {
+	if a == nil {
+		return []byte("null"), nil
+	}
+
+	return json.Marshal(a.commands)
+}
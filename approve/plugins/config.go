@@ -17,6 +17,7 @@ limitations under the License.
 package plugins
 
 import (
+	"strings"
 	"sync"
 	"time"
 
@@ -32,6 +33,10 @@ type Approve struct {
 	// IssueRequired indicates if an associated issue is required for approval in
 	// the specified repos.
 	IssueRequired bool `json:"issue_required,omitempty"`
+	// AssociatedIssuePattern is the regular expression matching the issue
+	// associated in the PR body, whose first group is the ID of the issue.
+	// The issues of the org linked or referenced by ID are matched if empty.
+	AssociatedIssuePattern string `json:"associated_issue_pattern,omitempty"`
 
 	// TODO(fejta): delete in June 2019
 	DeprecatedImplicitSelfApprove *bool `json:"implicit_self_approve,omitempty"`
@@ -43,6 +48,33 @@ type Approve struct {
 	// indicate approval
 	LgtmActsAsApprove bool `json:"lgtm_acts_as_approve,omitempty"`
 
+	// ReactionActsAsApprove makes a thumbs-up reaction on the notification
+	// by an approver the same as "/approve".
+	ReactionActsAsApprove bool `json:"reaction_acts_as_approve,omitempty"`
+
+	// SuggestionStrategy orders the approvers to suggest, which is one of
+	// the strategies of the approvers package. The default is random.
+	SuggestionStrategy string `json:"suggestion_strategy,omitempty"`
+
+	// SuggestionSeed seeds the shuffle of the approvers to suggest, which is
+	// one of the seeds of the approvers package. The default is pr-number.
+	SuggestionSeed string `json:"suggestion_seed,omitempty"`
+
+	// RequireApprovalAfterLastPush only counts the approvals made after the
+	// head commit of the PR was committed.
+	RequireApprovalAfterLastPush bool `json:"require_approval_after_last_push,omitempty"`
+
+	// InvalidateApprovalsOnPush only counts the approvals made after the
+	// source branch of the PR was pushed last time.
+	InvalidateApprovalsOnPush bool `json:"invalidate_approvals_on_push,omitempty"`
+
+	// ApprovalTTL expires the approvals made longer ago than it. Disabled if 0.
+	ApprovalTTL time.Duration `json:"approval_ttl,omitempty"`
+
+	// SiblingRobots are the accounts of the other robots, whose changes to
+	// the approved label are not regarded as made by humans.
+	SiblingRobots []string `json:"sibling_robots,omitempty"`
+
 	// ReviewActsAsApprove should be replaced with its non-deprecated inverse: ignore_review_state.
 	// TODO(fejta): delete in June 2019
 	DeprecatedReviewActsAsApprove *bool `json:"review_acts_as_approve,omitempty"`
@@ -50,6 +82,135 @@ type Approve struct {
 	// * an APPROVE github review is equivalent to leaving an "/approve" message.
 	// * A REQUEST_CHANGES github review is equivalent to leaving an /approve cancel" message.
 	IgnoreReviewState *bool `json:"ignore_review_state,omitempty"`
+
+	// BlockOnDependencies prevents a PR from being approved until all the PRs
+	// it depends on are approved.
+	BlockOnDependencies bool `json:"block_on_dependencies,omitempty"`
+
+	// FileStatusPolicies requires extra approval for, or blocks, the changes to files
+	// with specific statuses under the protected paths.
+	FileStatusPolicies []FileStatusPolicy `json:"file_status_policies,omitempty"`
+
+	// SplitNotification splits the notification into a compact status comment
+	// which is rewritten in place and an instructions comment posted once.
+	SplitNotification bool `json:"split_notification,omitempty"`
+
+	// LabelPolicies relax the approval requirements of the PRs with specific labels.
+	LabelPolicies []LabelPolicy `json:"label_policies,omitempty"`
+
+	// SizeRequirements require more approval for the PRs changing more lines.
+	SizeRequirements []SizeRequirement `json:"size_requirements,omitempty"`
+
+	// IgnoredPaths are the files which need no approval, unless all the files
+	// of the PR are ignored.
+	IgnoredPaths []string `json:"ignored_paths,omitempty"`
+
+	// SelfApprovalRequiredPaths are the files which the author has to
+	// approve explicitly, while the others are approved by the author
+	// implicitly. It applies only if the self approval is not required for
+	// all the files.
+	SelfApprovalRequiredPaths []string `json:"self_approval_required_paths,omitempty"`
+
+	// RequireLgtmBeforeApprove keeps the PR unapproved until it has the lgtm
+	// label.
+	RequireLgtmBeforeApprove bool `json:"require_lgtm_before_approve,omitempty"`
+
+	// MinApprovers is the minimum number of the distinct approvers in the
+	// OWNERS files who must approve the PR, not counting the author.
+	MinApprovers int `json:"min_approvers,omitempty"`
+
+	// PathRequirements declare the approvers of the directories without
+	// OWNERS files.
+	PathRequirements []PathRequirement `json:"path_requirements,omitempty"`
+
+	// OwnersFileChangeRequires are the approvers one of whom must approve
+	// the PRs changing OWNERS files.
+	OwnersFileChangeRequires []string `json:"owners_file_change_requires,omitempty"`
+
+	// ConditionalApprovedLabel is added besides the approved label when the
+	// PR is approved with the issue waived or the approval added manually.
+	ConditionalApprovedLabel string `json:"conditional_approved_label,omitempty"`
+
+	// CommandAliases are the aliases of the commands, such as "/同意" for
+	// "/approve".
+	CommandAliases *CommandAliases `json:"-"`
+
+	// RenamedLogins maps the previous logins of the renamed accounts to their
+	// current ones.
+	RenamedLogins map[string]string `json:"renamed_logins,omitempty"`
+
+	// MinimalMode only keeps a compact status comment, rewritten in place, and
+	// the approved label, without suggesting approvers or posting instructions.
+	MinimalMode bool `json:"minimal_mode,omitempty"`
+
+	// MissingApproversPolicy decides how to handle the PRs whose files have
+	// no approvers at all, which means the OWNERS files of the repo are
+	// missing or broken. It is fail-closed by default.
+	MissingApproversPolicy string `json:"missing_approvers_policy,omitempty"`
+	// FallbackApprovers are the approvers of all the files in the fail-open
+	// policy.
+	FallbackApprovers []string `json:"fallback_approvers,omitempty"`
+}
+
+const (
+	// MissingApproversFailClosed makes such PRs unable to be approved.
+	MissingApproversFailClosed = "fail-closed"
+	// MissingApproversFailOpen lets any of the fallback approvers approve such PRs.
+	MissingApproversFailOpen = "fail-open"
+)
+
+// LabelPolicy specifies the overrides of the approval requirements for the PRs
+// with a label.
+type LabelPolicy struct {
+	// Label is the label of PR which the policy applies to.
+	Label string `json:"label,omitempty"`
+	// IgnoreFiles are the files which need no approval. Each one is a directory
+	// or a glob pattern. They still need approval if all the files of the PR
+	// are ignored.
+	IgnoreFiles []string `json:"ignore_files,omitempty"`
+	// SingleApprover makes the approval of any one approver of the files
+	// enough to approve all the files.
+	SingleApprover bool `json:"single_approver,omitempty"`
+}
+
+// SizeRequirement specifies the approval required for the PRs changing more
+// lines than Lines, counting both the additions and the deletions.
+type SizeRequirement struct {
+	Lines int `json:"lines,omitempty"`
+	// MinApprovers is the minimum number of the approvers in the OWNERS
+	// files of the PR who must approve it, not counting the author.
+	MinApprovers int `json:"min_approvers,omitempty"`
+	// RequireRootApprover requires one of the approvers of the root OWNERS
+	// file to approve the PR.
+	RequireRootApprover bool `json:"require_root_approver,omitempty"`
+}
+
+// FileStatusPolicy specifies the policy for the changes to files with specific statuses.
+type FileStatusPolicy struct {
+	// Paths are the protected paths. Each one is a directory or a glob pattern.
+	Paths []string `json:"paths,omitempty"`
+	// Statuses are the file statuses reported by the platform, such as deleted or renamed.
+	Statuses []string `json:"statuses,omitempty"`
+	// Approvers is the list of people one of whom must approve the PR.
+	// The PR can't be approved if it is empty.
+	Approvers []string `json:"approvers,omitempty"`
+}
+
+// PathRequirement declares the approvers of a directory which has no OWNERS
+// file, as if it had one.
+type PathRequirement struct {
+	// Path is the directory, such as "ci" or "ci/**".
+	Path string `json:"path,omitempty"`
+	// Approvers can approve the files under the directory.
+	Approvers []string `json:"approvers,omitempty"`
+	// NoParentOwners makes the approvers of the parent directories unable to
+	// approve the files under the directory.
+	NoParentOwners bool `json:"no_parent_owners,omitempty"`
+}
+
+// Dir returns the directory of the requirement.
+func (p PathRequirement) Dir() string {
+	return strings.Trim(strings.TrimSuffix(strings.Trim(p.Path, "/"), "/**"), "/")
 }
 
 var (
@@ -0,0 +1,316 @@
+package approve
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/github"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+	"github.com/opensourceways/robot-gitee-approve/approve/plugins"
+)
+
+// matchPath reports whether the file is matched by the pattern which is either
+// a glob pattern or a directory containing the file.
+func matchPath(pattern, file string) bool {
+	pattern = strings.Trim(pattern, "/")
+	if pattern == "" {
+		return false
+	}
+
+	if ok, _ := path.Match(pattern, file); ok {
+		return true
+	}
+
+	return strings.HasPrefix(file, pattern+"/")
+}
+
+func matchAnyPath(patterns []string, file string) bool {
+	for _, p := range patterns {
+		if matchPath(p, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// regexPathPrefix marks the path patterns which are regular expressions.
+const regexPathPrefix = "regex:"
+
+// maxIgnoredFilesShown is the maximum number of the ignored files listed in
+// the notification.
+const maxIgnoredFilesShown = 10
+
+// pathMatcher returns the function reporting whether a file is matched by the
+// patterns, such as the ignored paths. Each one is a regular expression of
+// the file after "regex:", a glob pattern of the name of the file or of any
+// directory of it if it has no "/", such as "*.md" and "vendor", or else a
+// directory or a glob pattern of the file. The invalid ones match nothing,
+// which are rejected when the config is loaded.
+func pathMatcher(patterns []string) func(string) bool {
+	var regs []*regexp.Regexp
+	var globs []string
+	for _, p := range patterns {
+		if strings.HasPrefix(p, regexPathPrefix) {
+			if r, err := regexp.Compile(strings.TrimPrefix(p, regexPathPrefix)); err == nil {
+				regs = append(regs, r)
+			}
+		} else {
+			globs = append(globs, p)
+		}
+	}
+
+	return func(file string) bool {
+		for _, r := range regs {
+			if r.MatchString(file) {
+				return true
+			}
+		}
+
+		for _, p := range globs {
+			if !strings.Contains(p, "/") {
+				for _, name := range strings.Split(file, "/") {
+					if ok, _ := path.Match(p, name); ok {
+						return true
+					}
+				}
+			} else if matchPath(p, file) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// applyIgnoredPaths returns the files which need approval and the description
+// of the exemption made by the ignored paths. All the files still need
+// approval if they are all ignored, so that every PR is approved by someone.
+func applyIgnoredPaths(filenames, patterns []string) ([]string, string) {
+	match := pathMatcher(patterns)
+
+	var kept, ignored []string
+	for _, fn := range filenames {
+		if match(fn) {
+			ignored = append(ignored, fn)
+		} else {
+			kept = append(kept, fn)
+		}
+	}
+
+	if len(ignored) == 0 || len(kept) == 0 {
+		return filenames, ""
+	}
+
+	shown := ignored
+	if len(shown) > maxIgnoredFilesShown {
+		shown = shown[:maxIgnoredFilesShown]
+	}
+
+	desc := fmt.Sprintf("the ignored paths exempt %s from approval", strings.Join(shown, ", "))
+	if n := len(ignored) - len(shown); n > 0 {
+		desc += fmt.Sprintf(" and %d more files", n)
+	}
+
+	return kept, desc
+}
+
+// fileStatusRequirements returns the requirements derived from the file status
+// policies which apply to the changes.
+func fileStatusRequirements(changes []github.PullRequestChange, policies []plugins.FileStatusPolicy) []approvers.Requirement {
+	var reqs []approvers.Requirement
+
+	for i := range policies {
+		p := &policies[i]
+		statuses := sets.NewString()
+		for _, s := range p.Statuses {
+			statuses.Insert(strings.ToLower(s))
+		}
+
+		var files []string
+		for _, c := range changes {
+			if statuses.Has(strings.ToLower(c.Status)) && matchAnyPath(p.Paths, c.Filename) {
+				files = append(files, c.Filename)
+			}
+		}
+
+		if len(files) == 0 {
+			continue
+		}
+
+		reqs = append(reqs, approvers.Requirement{
+			Description: fmt.Sprintf(
+				"%s of protected files (%s)",
+				strings.Join(p.Statuses, "/"), strings.Join(files, ", "),
+			),
+			Approvers: sets.NewString(p.Approvers...),
+		})
+	}
+
+	return reqs
+}
+
+// ownersFileChanges returns the OWNERS and OWNERS_ALIASES files changed by
+// the PR.
+func ownersFileChanges(changes []github.PullRequestChange) []string {
+	var files []string
+	for _, c := range changes {
+		if name := path.Base(c.Filename); name == "OWNERS" || name == "OWNERS_ALIASES" {
+			files = append(files, c.Filename)
+		}
+	}
+
+	return files
+}
+
+// ownersFileChangeRequirement returns the requirement of the approval for
+// the changes of OWNERS files, or nil if there is none.
+func ownersFileChangeRequirement(files []string, required []string) []approvers.Requirement {
+	if len(files) == 0 || len(required) == 0 {
+		return nil
+	}
+
+	return []approvers.Requirement{{
+		Description: fmt.Sprintf("changes of OWNERS files (%s)", strings.Join(files, ", ")),
+		Approvers:   sets.NewString(required...),
+	}}
+}
+
+// activeLabelPolicies returns the label policies which apply to the PR with
+// the labels.
+func activeLabelPolicies(labels sets.String, policies []plugins.LabelPolicy) []plugins.LabelPolicy {
+	var r []plugins.LabelPolicy
+	for i := range policies {
+		if labels.Has(policies[i].Label) {
+			r = append(r, policies[i])
+		}
+	}
+	return r
+}
+
+// applyLabelPolicies returns the files which still need approval under the
+// label policies, the descriptions of the overrides made by the policies and
+// whether one approver is enough.
+func applyLabelPolicies(filenames []string, policies []plugins.LabelPolicy) ([]string, []string, bool) {
+	kept := filenames
+	var overrides []string
+	single := false
+
+	for i := range policies {
+		p := &policies[i]
+
+		if len(p.IgnoreFiles) > 0 {
+			var v []string
+			for _, fn := range kept {
+				if !matchAnyPath(p.IgnoreFiles, fn) {
+					v = append(v, fn)
+				}
+			}
+
+			if len(v) > 0 {
+				kept = v
+				overrides = append(overrides, fmt.Sprintf(
+					"label **%s** exempts the files under %s from approval",
+					p.Label, strings.Join(p.IgnoreFiles, ", "),
+				))
+			}
+		}
+
+		if p.SingleApprover {
+			single = true
+			overrides = append(overrides, fmt.Sprintf(
+				"label **%s** makes one approver enough to approve all the files", p.Label,
+			))
+		}
+	}
+
+	return kept, overrides, single
+}
+
+// applyPathRequirements lays the path requirements over the repo as the
+// OWNERS files of their directories. The OWNERS files which exist take
+// precedence over them.
+func applyPathRequirements(log *logrus.Entry, repo approvers.Repo, reqs []plugins.PathRequirement) approvers.Repo {
+	overlay := map[string]*approvers.OwnersEntry{}
+	for i := range reqs {
+		r := &reqs[i]
+		dir := r.Dir()
+
+		if owners := path.Clean(repo.FindApproverOwnersForFile(path.Join(dir, "OWNERS"))); owners == dir {
+			log.Debugf("Ignore the path requirement of %s which has an OWNERS file", dir)
+			continue
+		}
+
+		overlay[dir] = &approvers.OwnersEntry{Approvers: r.Approvers, NoParentOwners: r.NoParentOwners}
+	}
+
+	if len(overlay) == 0 {
+		return repo
+	}
+
+	return approvers.NewOverlayRepo(repo, overlay)
+}
+
+// applyMissingApproversPolicy handles the PR whose files have no approvers
+// at all. In the fail-open policy, the fallback approvers become the root
+// approvers of the repo. Otherwise, a requirement which can't be met explains
+// why the PR can't be approved.
+func applyMissingApproversPolicy(log *logrus.Entry, repo approvers.Repo, filenames []string, opts *plugins.Approve) (approvers.Repo, []approvers.Requirement) {
+	if len(filenames) == 0 || len(approvers.NewOwners(log, filenames, repo, 0).GetAllPotentialApprovers()) > 0 {
+		return repo, nil
+	}
+
+	if opts.MissingApproversPolicy == plugins.MissingApproversFailOpen {
+		log.Warn("No approvers are found for the files, falling back to the fallback approvers")
+
+		return approvers.NewOverlayRepo(repo, map[string]*approvers.OwnersEntry{
+			"": {Approvers: opts.FallbackApprovers},
+		}), nil
+	}
+
+	return repo, []approvers.Requirement{{
+		Description: "No approvers are found in the OWNERS files for the changed files, please ask the maintainers to fix them",
+	}}
+}
+
+// changedLines returns the number of the lines changed by the PR.
+func changedLines(changes []github.PullRequestChange) int {
+	n := 0
+	for i := range changes {
+		n += changes[i].Additions + changes[i].Deletions
+	}
+	return n
+}
+
+// applySizeRequirements returns the minimum number of approvers and the
+// requirements of the size requirements which apply to the PR changing the
+// lines. The minimum is min if none of them raises it.
+func applySizeRequirements(repo approvers.Repo, lines, min int, reqs []plugins.SizeRequirement) (int, []approvers.Requirement) {
+	var r []approvers.Requirement
+	root := false
+
+	for i := range reqs {
+		v := &reqs[i]
+		if lines <= v.Lines {
+			continue
+		}
+
+		if v.MinApprovers > min {
+			min = v.MinApprovers
+		}
+
+		if v.RequireRootApprover && !root {
+			root = true
+			r = append(r, approvers.Requirement{
+				Description: fmt.Sprintf("The pull request changes %d lines, more than %d", lines, v.Lines),
+				Approvers:   repo.Approvers("OWNERS"),
+			})
+		}
+	}
+
+	return min, r
+}
@@ -0,0 +1,117 @@
+package approve
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/github"
+	"k8s.io/test-infra/prow/labels"
+)
+
+const (
+	LabelAdded   = "added"
+	LabelRemoved = "removed"
+
+	// ProvenanceRobot is this robot, ProvenanceOtherRobot is another robot,
+	// either a sibling robot or one sharing the account of this robot, and
+	// ProvenanceHuman is anyone else.
+	ProvenanceRobot      = "robot"
+	ProvenanceOtherRobot = "other_robot"
+	ProvenanceHuman      = "human"
+
+	// ownLabelChangeTolerance is how far the time of a change to the label
+	// logged by the platform may be from when the robot made it, for it to be
+	// regarded as made by the robot.
+	ownLabelChangeTolerance = 2 * time.Minute
+)
+
+// LabelProvenance is who made the approved label as it is now, which is the
+// last change to it.
+type LabelProvenance struct {
+	// Action is LabelAdded or LabelRemoved.
+	Action string    `json:"action"`
+	Actor  string    `json:"actor"`
+	At     time.Time `json:"at"`
+	// Source is one of ProvenanceRobot, ProvenanceOtherRobot and
+	// ProvenanceHuman.
+	Source string `json:"source"`
+}
+
+// labelProvenance returns the function finding out the provenance of the
+// approved label, which lists the events of the PR once on the first call.
+// It returns nil if it can't be found out.
+func labelProvenance(ghc githubClient, log *logrus.Entry, pr *state, botName string, hasLabel bool, siblings []string) func() *LabelProvenance {
+	action, event := LabelAdded, github.IssueActionLabeled
+	if !hasLabel {
+		action, event = LabelRemoved, github.IssueActionUnlabeled
+	}
+
+	findOut := func() *LabelProvenance {
+		events, err := ghc.ListIssueEvents(pr.org, pr.repo, pr.number)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to list issue events for %s/%s#%d.", pr.org, pr.repo, pr.number)
+			return nil
+		}
+
+		var last *github.ListedIssueEvent
+		for i := range events {
+			if events[i].Event == event && events[i].Label.Name == labels.Approved {
+				last = &events[i]
+			}
+		}
+
+		if last == nil || last.Actor.Login == "" {
+			return nil
+		}
+
+		p := &LabelProvenance{Action: action, Actor: last.Actor.Login, At: last.CreatedAt}
+		switch {
+		case p.Actor == botName:
+			p.Source = ProvenanceRobot
+			// The robots sharing the account can only be told apart by the
+			// changes the robot remembers making itself.
+			if !pr.ownLabelAt.IsZero() && p.At.After(pr.ownLabelAt.Add(ownLabelChangeTolerance)) {
+				p.Source = ProvenanceOtherRobot
+			}
+
+		case isDeprecatedBot(p.Actor) || sets.NewString(siblings...).Has(p.Actor):
+			p.Source = ProvenanceOtherRobot
+
+		default:
+			p.Source = ProvenanceHuman
+		}
+
+		return p
+	}
+
+	var cache *LabelProvenance
+	done := false
+
+	return func() *LabelProvenance {
+		if !done {
+			cache = findOut()
+			done = true
+		}
+
+		return cache
+	}
+}
+
+// labelConflict reports whether the robot is going to revert the change to
+// the approved label made by another robot, which the robots would keep
+// doing to each other.
+func (e *evaluation) labelConflict(add, remove []string) bool {
+	if e.provenance == nil {
+		return false
+	}
+
+	changes := sets.NewString(add...).Union(sets.NewString(remove...))
+	if !changes.Has(labels.Approved) {
+		return false
+	}
+
+	p := e.provenance()
+
+	return p != nil && p.Source == ProvenanceOtherRobot
+}
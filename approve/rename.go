@@ -0,0 +1,58 @@
+package approve
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+// renames maps the previous logins of the renamed accounts to their current
+// ones. The keys are in lower case.
+type renames map[string]string
+
+func newRenames(m map[string]string) renames {
+	r := make(renames, len(m))
+	for k, v := range m {
+		r[strings.ToLower(k)] = v
+	}
+	return r
+}
+
+// current returns the current login of the account. The account may have
+// been renamed several times.
+func (r renames) current(login string) string {
+	for i := 0; i < len(r); i++ {
+		v, ok := r[strings.ToLower(login)]
+		if !ok || strings.EqualFold(v, login) {
+			break
+		}
+		login = v
+	}
+	return login
+}
+
+func (r renames) currentSet(logins sets.String) sets.String {
+	s := sets.NewString()
+	for login := range logins {
+		s.Insert(r.current(login))
+	}
+	return s
+}
+
+// renamedRepo replaces the previous logins in the OWNERS files with the
+// current ones, so that the approvals of renamed approvers are counted.
+type renamedRepo struct {
+	approvers.Repo
+
+	renames renames
+}
+
+func (r renamedRepo) Approvers(path string) sets.String {
+	return r.renames.currentSet(r.Repo.Approvers(path))
+}
+
+func (r renamedRepo) LeafApprovers(path string) sets.String {
+	return r.renames.currentSet(r.Repo.LeafApprovers(path))
+}
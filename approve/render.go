@@ -0,0 +1,113 @@
+package approve
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+	"github.com/opensourceways/robot-gitee-approve/approve/plugins"
+)
+
+const (
+	// NotificationFull is the notification with the approvers suggested
+	// and the instructions, which is posted by default.
+	NotificationFull = "full"
+	// NotificationStatus is the status part of the split notification.
+	NotificationStatus = "status"
+	// NotificationMinimal is the notification of the minimal mode.
+	NotificationMinimal = "minimal"
+)
+
+// ApprovalState is the approval state of a PR which the notification is
+// rendered of.
+type ApprovalState struct {
+	Approvers approvers.Approvers
+
+	Org    string
+	Repo   string
+	Branch string
+	// PRURL is the link to the PR, by which the link to the commands is made
+	// if RenderOptions.CommandURL is not set.
+	PRURL string
+}
+
+// RenderOptions are the options of rendering the notification.
+type RenderOptions struct {
+	// Style is one of NotificationFull, NotificationStatus and
+	// NotificationMinimal. NotificationFull is used if it is empty.
+	Style string
+	// LinkURL is the url of the platform, by which the links to the OWNERS
+	// files are made. It is not changed by the rendering.
+	LinkURL *url.URL
+	// CommandURL is the link to the usage of the commands. The one set by
+	// SetBotCommandLink is used if it is empty.
+	CommandURL string
+}
+
+// NotificationStyle returns the style of the notification posted with the
+// options.
+func NotificationStyle(opts *plugins.Approve) string {
+	switch {
+	case opts.MinimalMode:
+		return NotificationMinimal
+	case opts.SplitNotification:
+		return NotificationStatus
+	default:
+		return NotificationFull
+	}
+}
+
+// RenderNotification renders the notification of the approval state as it
+// is posted on the PR, without the fingerprint of the state.
+func RenderNotification(state ApprovalState, opts RenderOptions) (string, error) {
+	var linkURL url.URL
+	if opts.LinkURL != nil {
+		linkURL = *opts.LinkURL
+	}
+
+	commandURL := opts.CommandURL
+	if commandURL == "" {
+		commandURL = GetBotCommandLink(state.PRURL)
+	}
+
+	var message *string
+	switch opts.Style {
+	case NotificationMinimal:
+		message = approvers.GetMinimalMessage(state.Approvers)
+	case NotificationStatus:
+		message = approvers.GetStatusMessage(state.Approvers, &linkURL, state.Org, state.Repo, state.Branch)
+	case "", NotificationFull:
+		message = approvers.GetMessage(state.Approvers, &linkURL, state.Org, state.Repo, state.Branch, commandURL)
+	default:
+		return "", fmt.Errorf("unknown notification style: %s", opts.Style)
+	}
+
+	if message == nil {
+		return "", fmt.Errorf("failed to render the notification")
+	}
+
+	return *message, nil
+}
+
+// EvaluateState evaluates the approval state of a PR without changing it,
+// for rendering its notification.
+func EvaluateState(log *logrus.Entry, ghc githubClient, repo approvers.Repo, opts *plugins.Approve, pr *state) (ApprovalState, error) {
+	e, err := evaluate(log, ghc, repo, opts, pr)
+	if err != nil {
+		return ApprovalState{}, err
+	}
+
+	return e.approvalState(pr), nil
+}
+
+func (e *evaluation) approvalState(pr *state) ApprovalState {
+	return ApprovalState{
+		Approvers: e.approvers,
+		Org:       pr.org,
+		Repo:      pr.repo,
+		Branch:    pr.branch,
+		PRURL:     pr.htmlURL,
+	}
+}
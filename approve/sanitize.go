@@ -0,0 +1,27 @@
+package approve
+
+import (
+	"strings"
+)
+
+const (
+	// maxCommandTextLength is the maximum length of the comments and PR
+	// bodies scanned for commands and associated issues. Anything longer
+	// is cut, so that huge inputs can't make the robot slow.
+	maxCommandTextLength = 64 * 1024
+
+	// maxApprovePaths is the maximum number of paths of a "/approve"
+	// command. The extra paths are ignored.
+	maxApprovePaths = 64
+)
+
+// SanitizeCommandText returns the part of s which is scanned for commands.
+// It cuts s to the maximum length and replaces the invalid UTF-8 sequences
+// and NUL characters.
+func SanitizeCommandText(s string) string {
+	if len(s) > maxCommandTextLength {
+		s = s[:maxCommandTextLength]
+	}
+
+	return strings.ReplaceAll(strings.ToValidUTF8(s, "\uFFFD"), "\x00", "")
+}
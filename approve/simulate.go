@@ -0,0 +1,53 @@
+package approve
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+	"github.com/opensourceways/robot-gitee-approve/approve/plugins"
+)
+
+// Requirements are the approval requirements of a PR.
+type Requirements struct {
+	Approved        bool     `json:"approved"`
+	OwnersFiles     []string `json:"owners_files"`
+	UnapprovedFiles []string `json:"unapproved_files"`
+	// SuggestedApprovers are the approvers still needed for the unapproved files.
+	SuggestedApprovers []string `json:"suggested_approvers"`
+}
+
+// Simulation reports how the approval requirements of a PR would change with
+// the proposed OWNERS files.
+type Simulation struct {
+	Current  Requirements `json:"current"`
+	Proposed Requirements `json:"proposed"`
+}
+
+// Simulate evaluates the approval requirements of a PR twice, once against
+// the current OWNERS files and once against those overlaid by the proposed
+// ones, without changing the PR. The keys of proposed are the directories
+// of the OWNERS files, and a nil value removes the OWNERS file.
+func Simulate(log *logrus.Entry, ghc githubClient, repo approvers.Repo, proposed map[string]*approvers.OwnersEntry, opts *plugins.Approve, pr *state) (Simulation, error) {
+	e, err := evaluate(log, ghc, repo, opts, pr)
+	if err != nil {
+		return Simulation{}, err
+	}
+	current := e.requirements()
+
+	if err := e.compute(log, ghc, approvers.NewOverlayRepo(repo, proposed), opts, pr); err != nil {
+		return Simulation{}, err
+	}
+
+	return Simulation{Current: current, Proposed: e.requirements()}, nil
+}
+
+func (e *evaluation) requirements() Requirements {
+	ap := e.approvers
+
+	return Requirements{
+		Approved:           ap.IsApproved(),
+		OwnersFiles:        e.owners.GetOwnersSet().List(),
+		UnapprovedFiles:    ap.UnapprovedFiles().List(),
+		SuggestedApprovers: ap.GetCCs(),
+	}
+}
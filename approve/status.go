@@ -0,0 +1,72 @@
+package approve
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+	"github.com/opensourceways/robot-gitee-approve/approve/plugins"
+)
+
+// Status is the approval status of a PR.
+type Status struct {
+	// Approved reports whether the PR is approved, on which the approved
+	// label is applied.
+	Approved           bool     `json:"approved"`
+	Approvers          []string `json:"approvers"`
+	UnapprovedFiles    []string `json:"unapproved_files"`
+	SuggestedApprovers []string `json:"suggested_approvers"`
+
+	// PendingFiles groups the changed files which still need approval by the
+	// directories of the OWNERS files owning them.
+	PendingFiles map[string][]string `json:"pending_files,omitempty"`
+
+	// Approvals links each current approval to the comment establishing it.
+	Approvals []ApprovalRecord `json:"approvals"`
+
+	// Instructions tells the viewer what they can do for the approval.
+	Instructions string `json:"instructions"`
+
+	// LabelsToAdd and LabelsToRemove are the labels of approval, the
+	// approved label and the conditional one, which handling the PR would
+	// add and remove now.
+	LabelsToAdd    []string `json:"labels_to_add,omitempty"`
+	LabelsToRemove []string `json:"labels_to_remove,omitempty"`
+
+	// SeriesTopic is the topic of the series which the PR is a part of, and
+	// Series are the approval state of the other PRs of it.
+	SeriesTopic string                 `json:"series_topic,omitempty"`
+	Series      []approvers.Dependency `json:"series,omitempty"`
+}
+
+// GetStatus evaluates the approval status of a PR without changing it.
+// The instructions are tailored to the role of viewer on the PR.
+func GetStatus(log *logrus.Entry, ghc githubClient, repo approvers.Repo, opts *plugins.Approve, pr *state, viewer string) (Status, error) {
+	e, err := evaluate(log, ghc, repo, opts, pr)
+	if err != nil {
+		return Status{}, err
+	}
+
+	ap := e.approvers
+	add, remove := e.labelChanges(opts)
+
+	pending := map[string][]string{}
+	for _, v := range ap.Coverage() {
+		if !v.Approved {
+			pending[v.OwnersFile] = append(pending[v.OwnersFile], v.File)
+		}
+	}
+
+	return Status{
+		Approved:           ap.IsApproved(),
+		Approvers:          ap.GetCurrentApproversSetCased().List(),
+		Approvals:          approvalRecords(ap),
+		UnapprovedFiles:    ap.UnapprovedFiles().List(),
+		SuggestedApprovers: ap.GetCCs(),
+		PendingFiles:       pending,
+		Instructions:       ap.GetInstructions(viewer, pr.author),
+		LabelsToAdd:        add,
+		LabelsToRemove:     remove,
+		SeriesTopic:        pr.seriesTopic,
+		Series:             pr.series,
+	}, nil
+}
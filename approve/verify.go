@@ -0,0 +1,37 @@
+package approve
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+	"github.com/opensourceways/robot-gitee-approve/approve/plugins"
+)
+
+// Verification is the approval of a PR evaluated from the comments and the
+// OWNERS files only.
+type Verification struct {
+	Approved        bool
+	Approvers       []string
+	UnapprovedFiles []string
+}
+
+// Verify evaluates the approval of a PR from the live data without trusting
+// any label, so neither an approved label added manually nor the labels of
+// the label policies relaxing the requirements count.
+func Verify(log *logrus.Entry, ghc githubClient, repo approvers.Repo, opts *plugins.Approve, pr *state) (Verification, error) {
+	strict := *opts
+	strict.LabelPolicies = nil
+
+	e, err := evaluate(log, ghc, repo, &strict, pr)
+	if err != nil {
+		return Verification{}, err
+	}
+
+	ap := e.approvers
+
+	return Verification{
+		Approved:        ap.RequirementsMet(),
+		Approvers:       ap.GetCurrentApproversSetCased().List(),
+		UnapprovedFiles: ap.UnapprovedFiles().List(),
+	}, nil
+}
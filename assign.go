@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+var assignApproversReg = regexp.MustCompile(`(?mi)^/assign-approvers[\t ]*$`)
+
+// handleAssignApprovers handles "/assign-approvers" which assigns the
+// suggested approvers to the PR.
+func (bot *robot) handleAssignApprovers(org, repo string, pr prInfo, commenter string, cfg *botConfig, log *logrus.Entry) error {
+	assigned, err := bot.assignApprovers(org, repo, pr, cfg, log)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("@%s There are no more approvers to assign.", commenter)
+	if len(assigned) > 0 {
+		msg = fmt.Sprintf("@%s Assigned the suggested approvers: @%s", commenter, strings.Join(assigned, ", @"))
+	}
+
+	return bot.cli.withDryRun(cfg.DryRun).CreateComment(org, repo, pr.number, msg)
+}
+
+// assignApprovers assigns the suggested approvers who are not the author or
+// assigned yet to the PR, and returns them.
+func (bot *robot) assignApprovers(org, repo string, pr prInfo, cfg *botConfig, log *logrus.Entry) ([]string, error) {
+	s, err := bot.statusOf(org, repo, pr, cfg, "", log)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude := sets.NewString(strings.ToLower(pr.author))
+	for _, a := range pr.assignees {
+		exclude.Insert(strings.ToLower(a))
+	}
+
+	var logins []string
+	for _, v := range s.SuggestedApprovers {
+		if !exclude.Has(strings.ToLower(v)) {
+			logins = append(logins, v)
+		}
+	}
+
+	if len(logins) == 0 {
+		return nil, nil
+	}
+
+	if err := bot.cli.withDryRun(cfg.DryRun).AssignPR(org, repo, pr.number, logins); err != nil {
+		return nil, err
+	}
+
+	return logins, nil
+}
+
+// ccApprovers mentions the suggested approvers other than the author in a
+// comment, so that they are notified by Gitee.
+func (bot *robot) ccApprovers(org, repo string, pr prInfo, cfg *botConfig, log *logrus.Entry) error {
+	s, err := bot.statusOf(org, repo, pr, cfg, "", log)
+	if err != nil {
+		return err
+	}
+
+	var logins []string
+	for _, v := range s.SuggestedApprovers {
+		if !strings.EqualFold(v, pr.author) {
+			logins = append(logins, v)
+		}
+	}
+
+	if len(logins) == 0 {
+		return nil
+	}
+
+	return bot.cli.withDryRun(cfg.DryRun).CreateComment(org, repo, pr.number, fmt.Sprintf(
+		"@%s You are suggested to approve this pull-request according to the OWNERS files. "+
+			"Please review it and comment `/approve` if it looks good to you.",
+		strings.Join(logins, " @"),
+	))
+}
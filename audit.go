@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+)
+
+const (
+	auditAddLabel    = "add_label"
+	auditRemoveLabel = "remove_label"
+)
+
+var auditClient = http.Client{Timeout: 10 * time.Second}
+
+// auditRecord is the change of a label of a PR together with the evidence of
+// the approval it was decided on, which is enough to tell afterwards why the
+// PR was approved or not.
+type auditRecord struct {
+	Time   time.Time `json:"time"`
+	Org    string    `json:"org"`
+	Repo   string    `json:"repo"`
+	Number int       `json:"number"`
+	Head   string    `json:"head_sha,omitempty"`
+	Action string    `json:"action"`
+	Label  string    `json:"label"`
+	DryRun bool      `json:"dry_run,omitempty"`
+
+	Approved        bool                     `json:"approved"`
+	Approvers       []string                 `json:"approvers"`
+	Approvals       []approve.ApprovalRecord `json:"approvals"`
+	Files           []string                 `json:"files"`
+	OwnersApprovers map[string][]string      `json:"owners_approvers"`
+	UnapprovedFiles []string                 `json:"unapproved_files"`
+}
+
+// auditLog writes the audit records as lines of json to the sink, which is
+// one of stdout, a file appended to, or a webhook which every record is
+// posted to.
+type auditLog struct {
+	lock sync.Mutex
+	w    io.Writer
+
+	// url is the webhook, which is posted to instead of writing to w.
+	url string
+
+	// scrub scrubs the personal data and the credentials from the records.
+	scrub bool
+}
+
+// newAuditLog opens the sink, which is stdout, file:<path> or an http(s)
+// url of the webhook.
+func newAuditLog(sink string, scrub bool) (*auditLog, error) {
+	a, err := openAuditSink(sink)
+	if err != nil {
+		return nil, err
+	}
+
+	a.scrub = scrub
+
+	return a, nil
+}
+
+func openAuditSink(sink string) (*auditLog, error) {
+	switch {
+	case sink == "stdout":
+		return &auditLog{w: os.Stdout}, nil
+
+	case strings.HasPrefix(sink, "file:"):
+		f, err := os.OpenFile(strings.TrimPrefix(sink, "file:"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		return &auditLog{w: f}, nil
+
+	case strings.HasPrefix(sink, "http://") || strings.HasPrefix(sink, "https://"):
+		if _, err := url.Parse(sink); err != nil {
+			return nil, fmt.Errorf("invalid url of the audit sink")
+		}
+
+		return &auditLog{url: sink}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown audit sink: %s", sink)
+	}
+}
+
+// record writes a record for each of the labels changed by the handling.
+func (a *auditLog) record(org, repo string, pr prInfo, r *approve.Result, dryRun bool) {
+	if a == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, v := range []struct {
+		action string
+		labels []string
+	}{
+		{auditRemoveLabel, r.LabelsRemoved},
+		{auditAddLabel, r.LabelsAdded},
+	} {
+		for _, label := range v.labels {
+			a.write(&auditRecord{
+				Time:            now,
+				Org:             org,
+				Repo:            repo,
+				Number:          pr.number,
+				Head:            pr.headSHA,
+				Action:          v.action,
+				Label:           label,
+				DryRun:          dryRun,
+				Approved:        r.Approved,
+				Approvers:       r.Approvers,
+				Approvals:       r.Approvals,
+				Files:           r.Files,
+				OwnersApprovers: r.OwnersApprovers,
+				UnapprovedFiles: r.UnapprovedFiles,
+			})
+		}
+	}
+}
+
+func (a *auditLog) write(rec *auditRecord) {
+	log := logrus.WithFields(logrus.Fields{
+		"org": rec.Org, "repo": rec.Repo, "number": rec.Number, "label": rec.Label,
+	})
+
+	b, err := json.Marshal(rec)
+	if err == nil && a.scrub {
+		b, err = scrubJSON(b)
+	}
+	if err != nil {
+		log.WithError(err).Error("marshal the audit record")
+		return
+	}
+
+	if a.url != "" {
+		go func() {
+			if err := postJSON(a.url, b); err != nil {
+				log.WithError(err).Error("post the audit record")
+			}
+		}()
+
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if _, err := a.w.Write(append(b, '\n')); err != nil {
+		log.WithError(err).Error("write the audit record")
+	}
+}
+
+func postJSON(endpoint string, b []byte) error {
+	resp, err := auditClient.Post(endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		v, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(v)))
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/opensourceways/robot-gitee-approve/metrics"
+)
+
+const (
+	defaultBreakerWindow   = 10 * time.Minute
+	defaultBreakerCooldown = 30 * time.Minute
+)
+
+type circuitBreaker struct {
+	// MaxFailures is the number of consecutive failures of handling the
+	// events of the repo within the window which trips the breaker. The
+	// events of the repo are skipped until the cool-down ends. 0 disables it.
+	MaxFailures int `json:"max_failures,omitempty"`
+
+	// WindowMinutes is the window of the consecutive failures in minutes.
+	// The default is 10.
+	WindowMinutes int `json:"window_minutes,omitempty"`
+
+	// CooldownMinutes is the time the breaker stays tripped in minutes. The
+	// default is 30.
+	CooldownMinutes int `json:"cooldown_minutes,omitempty"`
+}
+
+func (b *circuitBreaker) window() time.Duration {
+	if b.WindowMinutes > 0 {
+		return time.Duration(b.WindowMinutes) * time.Minute
+	}
+	return defaultBreakerWindow
+}
+
+func (b *circuitBreaker) cooldown() time.Duration {
+	if b.CooldownMinutes > 0 {
+		return time.Duration(b.CooldownMinutes) * time.Minute
+	}
+	return defaultBreakerCooldown
+}
+
+// breakerState is the state of the circuit breaker of a repo.
+type breakerState struct {
+	Failures     int       `json:"consecutive_failures"`
+	FirstFailure time.Time `json:"first_failure_at,omitempty"`
+	OpenUntil    time.Time `json:"open_until,omitempty"`
+
+	// halfOpen is true after the cool-down, when one more failure trips
+	// the breaker again.
+	halfOpen bool
+}
+
+// repoBreakers are the circuit breakers of repos keyed by org/repo.
+type repoBreakers struct {
+	lock  sync.Mutex
+	items map[string]*breakerState
+}
+
+func newRepoBreakers() *repoBreakers {
+	return &repoBreakers{items: map[string]*breakerState{}}
+}
+
+// allow reports whether the events of the repo can be handled.
+func (b *repoBreakers) allow(org, repo string, now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	v, ok := b.items[org+"/"+repo]
+	if !ok || v.OpenUntil.IsZero() {
+		return true
+	}
+
+	if now.Before(v.OpenUntil) {
+		return false
+	}
+
+	v.OpenUntil = time.Time{}
+	v.halfOpen = true
+	metrics.SetCircuitOpen(org, repo, false)
+
+	return true
+}
+
+// record records the result of handling an event of the repo.
+func (b *repoBreakers) record(org, repo string, cfg *circuitBreaker, err error, now time.Time) {
+	if cfg.MaxFailures <= 0 {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	k := org + "/" + repo
+	if err == nil {
+		delete(b.items, k)
+		return
+	}
+
+	v, ok := b.items[k]
+	if !ok || (!v.halfOpen && now.Sub(v.FirstFailure) > cfg.window()) {
+		v = &breakerState{FirstFailure: now}
+		b.items[k] = v
+	}
+	v.Failures++
+
+	if v.halfOpen || v.Failures >= cfg.MaxFailures {
+		v.OpenUntil = now.Add(cfg.cooldown())
+		v.halfOpen = false
+		metrics.SetCircuitOpen(org, repo, true)
+	}
+}
+
+func (b *repoBreakers) list() map[string]breakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	r := make(map[string]breakerState, len(b.items))
+	for k, v := range b.items {
+		r[k] = *v
+	}
+
+	return r
+}
+
+// debugStatePath serves the debug state, which tells the repos and the
+// approvers of all the repos, so it is an admin endpoint.
+const debugStatePath = "/debug/state"
+
+// debugState is the runtime state of the robot for debugging.
+type debugState struct {
+	CircuitBreakers map[string]breakerState `json:"circuit_breakers"`
+	QueuedPRs       int                     `json:"queued_prs"`
+	// PendingCommentWrites is the number of the writes of comments which
+	// are queued or being made asynchronously.
+	PendingCommentWrites int `json:"pending_comment_writes"`
+	// ApproverCapacity is the latest capacity decisions of the approvers of
+	// each repo.
+	ApproverCapacity map[string]map[string]approverLoad `json:"approver_capacity,omitempty"`
+}
+
+func (bot *robot) debugStateHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, debugState{
+		CircuitBreakers: bot.breakers.list(),
+		QueuedPRs:       bot.queue.pending(),
+
+		PendingCommentWrites: bot.writer.pendingWrites(),
+		ApproverCapacity:     bot.capacity.list(),
+	})
+}
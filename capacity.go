@@ -0,0 +1,174 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensourceways/community-robot-lib/giteeclient"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const defaultCapacityRefreshInterval = 30 * time.Minute
+
+// approverCapacity limits the number of the open PRs of the repo which an
+// approver is assigned to, beyond which the approver is overloaded and not
+// suggested unless nobody else can approve the files.
+type approverCapacity struct {
+	// MaxOpenPRs is the cap of every approver. The capacity is not limited
+	// if it is 0, unless the approver has a cap in Caps.
+	MaxOpenPRs int `json:"max_open_prs,omitempty"`
+
+	// Caps overrides MaxOpenPRs for specific approvers, such as a lower one
+	// for those who are part-time.
+	Caps map[string]int `json:"caps,omitempty"`
+
+	// RefreshMinutes is the interval of refreshing the open PRs assigned to
+	// the approvers in minutes. The default is 30.
+	RefreshMinutes int `json:"refresh_minutes,omitempty"`
+}
+
+func (c *approverCapacity) enabled() bool {
+	return c.MaxOpenPRs > 0 || len(c.Caps) > 0
+}
+
+// capOf returns the cap of the approver, or 0 if it is not limited.
+func (c *approverCapacity) capOf(login string) int {
+	for k, v := range c.Caps {
+		if strings.EqualFold(k, login) {
+			return v
+		}
+	}
+
+	return c.MaxOpenPRs
+}
+
+func (c *approverCapacity) refreshInterval() time.Duration {
+	if c.RefreshMinutes > 0 {
+		return time.Duration(c.RefreshMinutes) * time.Minute
+	}
+	return defaultCapacityRefreshInterval
+}
+
+// approverLoad is the capacity decision of an approver.
+type approverLoad struct {
+	OpenPRs    int  `json:"open_prs"`
+	Cap        int  `json:"cap"`
+	Overloaded bool `json:"overloaded"`
+}
+
+type repoLoad struct {
+	// open is the number of the open PRs assigned to each approver, keyed by
+	// the lower case login.
+	open        map[string]int
+	decisions   map[string]approverLoad
+	refreshedAt time.Time
+	refreshing  bool
+}
+
+// capacityCache caches the number of the open PRs of repos assigned to each
+// user.
+type capacityCache struct {
+	cli   iClient
+	lock  sync.Mutex
+	items map[string]*repoLoad
+}
+
+func newCapacityCache(cli iClient) *capacityCache {
+	return &capacityCache{cli: cli, items: map[string]*repoLoad{}}
+}
+
+// overloaded returns the approvers of the repo who are overloaded as lower
+// case logins, and refreshes the load in background if it is stale. None is
+// overloaded before the first refresh is done.
+func (c *capacityCache) overloaded(org, repo string, cfg *approverCapacity) sets.String {
+	r := sets.NewString()
+	if !cfg.enabled() {
+		return r
+	}
+
+	k := org + "/" + repo
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	v, ok := c.items[k]
+	if !ok {
+		v = &repoLoad{}
+		c.items[k] = v
+	}
+
+	if !v.refreshing && time.Since(v.refreshedAt) > cfg.refreshInterval() {
+		v.refreshing = true
+		go c.refresh(org, repo)
+	}
+
+	v.decisions = map[string]approverLoad{}
+	for login, n := range v.open {
+		d := approverLoad{OpenPRs: n, Cap: cfg.capOf(login)}
+		d.Overloaded = d.Cap > 0 && n >= d.Cap
+		v.decisions[login] = d
+
+		if d.Overloaded {
+			r.Insert(login)
+		}
+	}
+
+	return r
+}
+
+func (c *capacityCache) refresh(org, repo string) {
+	open, err := c.fetch(org, repo)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	v := c.items[org+"/"+repo]
+	v.refreshing = false
+
+	if err != nil {
+		logrus.WithError(err).Errorf("refresh the load of approvers of %s/%s", org, repo)
+		return
+	}
+
+	v.open = open
+	v.refreshedAt = time.Now()
+}
+
+func (c *capacityCache) fetch(org, repo string) (map[string]int, error) {
+	prs, err := c.cli.GetPullRequests(org, repo, giteeclient.ListPullRequestOpt{State: "open"})
+	if err != nil {
+		return nil, err
+	}
+
+	open := map[string]int{}
+	for i := range prs {
+		for _, a := range prs[i].Assignees {
+			open[strings.ToLower(a.Login)]++
+		}
+	}
+
+	return open, nil
+}
+
+// list returns the latest capacity decisions of the approvers of each repo.
+func (c *capacityCache) list() map[string]map[string]approverLoad {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	r := make(map[string]map[string]approverLoad, len(c.items))
+	for k, v := range c.items {
+		if len(v.decisions) == 0 {
+			continue
+		}
+
+		m := make(map[string]approverLoad, len(v.decisions))
+		for login, d := range v.decisions {
+			m[login] = d
+		}
+		r[k] = m
+	}
+
+	return r
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/opensourceways/repo-owners-cache/repoowners"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	chatSlack  = "slack"
+	chatWeCom  = "wecom"
+	chatFeishu = "feishu"
+)
+
+var chatClient = http.Client{Timeout: 10 * time.Second}
+
+// chatRoute routes the PRs pending approval on the OWNERS files of a subtree
+// to the chat channel of the team owning it.
+type chatRoute struct {
+	// Path is the directory of the subtree, such as docs. The OWNERS files
+	// in it and its sub directories are routed. Empty means the whole repo.
+	Path string `json:"path,omitempty"`
+
+	// WebhookURL is the incoming webhook of the chat channel.
+	WebhookURL string `json:"webhook_url" required:"true"`
+
+	// Kind is the kind of the chat, which is one of slack, which also fits
+	// Mattermost and Rocket.Chat, wecom and feishu. The default is slack.
+	Kind string `json:"kind,omitempty"`
+}
+
+func (r *chatRoute) validate() error {
+	if r.WebhookURL == "" {
+		return fmt.Errorf("webhook_url of chat route must be set")
+	}
+
+	// The url is not shown, since it has the key of the channel.
+	if u, err := url.Parse(r.WebhookURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid webhook_url of chat route %q", r.Path)
+	}
+
+	switch r.Kind {
+	case "", chatSlack, chatWeCom, chatFeishu:
+	default:
+		return fmt.Errorf("unknown kind of chat route: %s", r.Kind)
+	}
+
+	return nil
+}
+
+// covers reports whether the OWNERS file of the directory is in the subtree.
+func (r *chatRoute) covers(dir string) bool {
+	p := strings.Trim(r.Path, "/")
+	dir = strings.Trim(dir, "/")
+	if dir == "." {
+		dir = ""
+	}
+
+	return p == "" || dir == p || strings.HasPrefix(dir, p+"/")
+}
+
+// payload returns the message in the format of the chat.
+func (r *chatRoute) payload(text string) interface{} {
+	switch r.Kind {
+	case chatWeCom:
+		return map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": text},
+		}
+
+	case chatFeishu:
+		return map[string]interface{}{
+			"msg_type": "text",
+			"content":  map[string]string{"text": text},
+		}
+
+	default:
+		return map[string]string{"text": text}
+	}
+}
+
+// notifyPending tells the channels of the subtrees which the PR has become
+// pending approval on since it was handled last time, which are the OWNERS
+// files in pending but not in before. Each channel is told once with all
+// the OWNERS files routed to it and their approvers. The messages are posted
+// in background, and only logged in the dry-run mode.
+func notifyPending(
+	org, repo string, pr prInfo, routes []chatRoute, oc repoowners.RepoOwner,
+	before, pending []string, dryRun bool, log *logrus.Entry,
+) {
+	dirs := sets.NewString(pending...).Difference(sets.NewString(before...)).List()
+	if len(dirs) == 0 {
+		return
+	}
+
+	for i := range routes {
+		route := &routes[i]
+
+		var lines []string
+		for _, dir := range dirs {
+			if !route.covers(dir) {
+				continue
+			}
+
+			approvers := oc.LeafApprovers(filepath.Join(dir, "OWNERS")).List()
+
+			name := dir
+			if name == "" || name == "." {
+				name = "/"
+			}
+			lines = append(lines, fmt.Sprintf("- %s: %s", name, strings.Join(approvers, ", ")))
+		}
+
+		if len(lines) == 0 {
+			continue
+		}
+
+		text := fmt.Sprintf(
+			"%s/%s#%d is pending approval on the paths below, which can be approved by any one of the approvers listed.\n%s\n%s",
+			org, repo, pr.number, pr.htmlURL, strings.Join(lines, "\n"),
+		)
+
+		l := log.WithField("chat", route.Path)
+		if dryRun {
+			l.Infof("Would post to the chat: %q", text)
+			continue
+		}
+
+		go func(route chatRoute) {
+			if err := postChat(&route, text); err != nil {
+				l.WithError(err).Error("post to the chat")
+			}
+		}(*route)
+	}
+}
+
+func postChat(route *chatRoute, text string) error {
+	body, err := json.Marshal(route.payload(text))
+	if err != nil {
+		return err
+	}
+
+	resp, err := chatClient.Post(route.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// Drop the url, which has the key of the channel, from the error.
+		if ue, ok := err.(*url.Error); ok {
+			return ue.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("post to the chat: %s %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/opensourceways/community-robot-lib/giteeclient"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+)
+
+const (
+	giteeAPIEndpoint = "https://gitee.com/api/v5"
+
+	checkRunName = "approve"
+
+	checkRunInProgress = "in_progress"
+	checkRunCompleted  = "completed"
+	checkRunSuccess    = "success"
+)
+
+// checkRun is a check run on a commit.
+type checkRun struct {
+	Name       string         `json:"name"`
+	HeadSHA    string         `json:"head_sha"`
+	Status     string         `json:"status"`
+	Conclusion string         `json:"conclusion,omitempty"`
+	Output     checkRunOutput `json:"output"`
+}
+
+type checkRunOutput struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+}
+
+// giteeClient adds the check runs, the reactions, the trees and getting a
+// single comment, which the client of the library doesn't support, to it.
+type giteeClient struct {
+	giteeclient.Client
+
+	token func() []byte
+	hc    *http.Client
+}
+
+func newGiteeClient(token func() []byte) iClient {
+	return giteeClient{
+		Client: giteeclient.NewClient(token),
+		token:  token,
+		hc:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends the request to the API of Gitee with the token in the
+// Authorization header rather than in the query, so that the token is not in
+// the url which the errors of the transport carry.
+func (c giteeClient) do(method, u string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+string(c.token()))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return c.hc.Do(req)
+}
+
+// CreateCheckRun creates a check run on the commit of run.HeadSHA. Creating
+// one with the same name again replaces it on the commit.
+func (c giteeClient) CreateCheckRun(org, repo string, run checkRun) error {
+	body, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf(
+		"%s/repos/%s/%s/check-runs",
+		giteeAPIEndpoint, url.PathEscape(org), url.PathEscape(repo),
+	)
+
+	resp, err := c.do(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("create check run: %s %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	return nil
+}
+
+// reportCheckRun reports the progress of the approval as a check run on the
+// head commit of the PR.
+func (bot *robot) reportCheckRun(cli *ghclient, org, repo string, pr prInfo, r approve.Result) error {
+	if pr.headSHA == "" {
+		return nil
+	}
+
+	approved := r.OwnersFiles - len(r.UnapprovedFiles)
+	run := checkRun{
+		Name:    checkRunName,
+		HeadSHA: pr.headSHA,
+		Status:  checkRunInProgress,
+		Output: checkRunOutput{
+			Title: fmt.Sprintf("%s: %d/%d paths approved", checkRunName, approved, r.OwnersFiles),
+		},
+	}
+
+	if r.Approved {
+		run.Status = checkRunCompleted
+		run.Conclusion = checkRunSuccess
+		run.Output.Summary = "Approved by " + strings.Join(r.Approvers, ", ")
+	} else if len(r.UnapprovedFiles) > 0 {
+		run.Output.Summary = "Pending: " + strings.Join(r.UnapprovedFiles, ", ")
+	} else {
+		run.Output.Summary = "All the paths are approved, but some requirements are not met yet."
+	}
+
+	return cli.CreateCheckRun(org, repo, run)
+}
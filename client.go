@@ -1,18 +1,156 @@
 package main
 
-import "k8s.io/test-infra/prow/github"
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensourceways/community-robot-lib/giteeclient"
+	sdk "github.com/opensourceways/go-gitee/gitee"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/github"
+	"k8s.io/test-infra/prow/labels"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+)
+
+const (
+	botFetchRetries  = 3
+	botFetchInterval = time.Second
+
+	changesPerPage = 100
+	// maxChangesPages bounds the pages of the files changed by a PR.
+	maxChangesPages = 30
+)
 
 type ghclient struct {
 	cli iClient
+	bot *botCache
+
+	// retries is the budget of the retries of the changes to PRs.
+	retries *retryBudget
+
+	// dryRun logs the changes to PRs instead of making them.
+	dryRun bool
+}
+
+func newGHClient(cli iClient) ghclient {
+	return ghclient{cli: cli, bot: &botCache{}, retries: &retryBudget{}}
+}
+
+// withDryRun returns a copy of the client which is in the dry-run mode if
+// either it or dryRun is.
+func (c ghclient) withDryRun(dryRun bool) *ghclient {
+	c.dryRun = c.dryRun || dryRun
+	return &c
+}
+
+// skipWrite logs the change instead if the client is in the dry-run mode,
+// and reports whether it is.
+func (c *ghclient) skipWrite(org, repo string, format string, args ...interface{}) bool {
+	if !c.dryRun {
+		return false
+	}
+
+	logrus.WithFields(logrus.Fields{"org": org, "repo": repo, "dry_run": true}).Infof(format, args...)
+
+	return true
+}
+
+// botCache caches the account of the robot. The id is used to detect that
+// the account was renamed, because Gitee shows the current login of the
+// author on every comment, including the ones created before the rename.
+type botCache struct {
+	lock  sync.RWMutex
+	login string
+	id    int
+}
+
+func (b *botCache) get() (string, int) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.login, b.id
+}
+
+func (b *botCache) set(login string, id int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.login = login
+	b.id = id
 }
 
+// GetPullRequestChanges lists the files changed by the PR page by page. It
+// returns approve.TooManyFilesError if not all of them can be listed, either
+// because there are too many pages or because Gitee stops paging, which is
+// known when a full page lists no file not seen before.
 func (c *ghclient) GetPullRequestChanges(org, repo string, number int) ([]github.PullRequestChange, error) {
-	cs, err := c.cli.GetPullRequestChanges(org, repo, int32(number))
+	var all []sdk.PullRequestFiles
+	seen := sets.NewString()
+
+	for page := 1; ; page++ {
+		cs, err := c.cli.GetPullRequestChangesPage(org, repo, int32(number), page, changesPerPage)
+		if err != nil {
+			return nil, err
+		}
+
+		added := 0
+		for i := range cs {
+			if !seen.Has(cs[i].Filename) {
+				seen.Insert(cs[i].Filename)
+				all = append(all, cs[i])
+				added++
+			}
+		}
+
+		if len(cs) < changesPerPage {
+			return transformPRChanges(all), nil
+		}
+
+		if added == 0 || page == maxChangesPages {
+			return nil, &approve.TooManyFilesError{Listed: len(all)}
+		}
+	}
+}
+
+// GetPullRequestChangesPage lists a page of the files changed by the PR, which
+// the client of the library doesn't support.
+func (c giteeClient) GetPullRequestChangesPage(org, repo string, number int32, page, perPage int) ([]sdk.PullRequestFiles, error) {
+	u := fmt.Sprintf(
+		"%s/repos/%s/%s/pulls/%d/files?page=%d&per_page=%d",
+		giteeAPIEndpoint, url.PathEscape(org), url.PathEscape(repo), number, page, perPage,
+	)
+
+	resp, err := c.do(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	return transformPRChanges(cs), nil
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("list PR files: %s %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	var r []sdk.PullRequestFiles
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("list PR files: %v", err)
+	}
+
+	return r, nil
 }
 
 func (c *ghclient) GetIssueLabels(org, repo string, number int) ([]github.Label, error) {
@@ -30,45 +168,401 @@ func (c *ghclient) ListIssueComments(org, repo string, number int) ([]github.Iss
 		return nil, err
 	}
 
-	return transformComments(comments), nil
+	r := transformComments(comments)
+	c.refreshRenamedBot(r)
+
+	return r, nil
+}
+
+// refreshRenamedBot updates the cached login of robot if one of the
+// comments was made by the robot account under another login.
+func (c *ghclient) refreshRenamedBot(comments []github.IssueComment) {
+	login, id := c.bot.get()
+	if id == 0 {
+		return
+	}
+
+	for i := range comments {
+		u := &comments[i].User
+		if u.ID == id && u.Login != login && u.Login != "" {
+			logrus.Infof("bot account is renamed from %s to %s", login, u.Login)
+
+			c.bot.set(u.Login, id)
+
+			return
+		}
+	}
+}
+
+func (c *ghclient) GetIssueComment(org, repo string, ID int) (*github.IssueComment, error) {
+	v, err := c.cli.GetPRComment(org, repo, int32(ID))
+	if err != nil {
+		return nil, err
+	}
+
+	r := transformComments([]sdk.PullRequestComments{v})
+	c.refreshRenamedBot(r)
+
+	return &r[0], nil
 }
 
 func (c *ghclient) DeleteComment(org, repo string, ID int) error {
-	return c.cli.DeletePRComment(org, repo, int32(ID))
+	if c.skipWrite(org, repo, "Would delete comment %d", ID) {
+		return nil
+	}
+	return c.mutate(org, repo, "delete comment", func() error {
+		return c.cli.DeletePRComment(org, repo, int32(ID))
+	})
+}
+
+func (c *ghclient) EditComment(org, repo string, ID int, comment string) error {
+	if c.skipWrite(org, repo, "Would edit comment %d: %q", ID, comment) {
+		return nil
+	}
+	return c.mutate(org, repo, "edit comment", func() error {
+		return c.cli.UpdatePRComment(org, repo, int32(ID), comment)
+	})
 }
 
 func (c *ghclient) CreateComment(org, repo string, number int, comment string) error {
-	return c.cli.CreatePRComment(org, repo, int32(number), comment)
+	if c.skipWrite(org, repo, "Would comment on #%d: %q", number, comment) {
+		return nil
+	}
+	return c.mutate(org, repo, "create comment", func() error {
+		return c.cli.CreatePRComment(org, repo, int32(number), comment)
+	})
 }
 
+// BotName returns the cached login of robot and fetches it on the first
+// call.
 func (c *ghclient) BotName() (string, error) {
-	bot, err := c.cli.GetBot()
-	if err != nil {
-		return "", err
+	if login, _ := c.bot.get(); login != "" {
+		return login, nil
 	}
-	return bot.Login, nil
+
+	var err error
+	for i := 0; i < botFetchRetries; i++ {
+		if i > 0 {
+			time.Sleep(botFetchInterval * time.Duration(i))
+		}
+
+		var bot sdk.User
+		if bot, err = c.cli.GetBot(); err == nil {
+			if bot.Login == "" {
+				return "", fmt.Errorf("the login of bot is empty")
+			}
+
+			c.bot.set(bot.Login, int(bot.Id))
+
+			return bot.Login, nil
+		}
+	}
+
+	return "", err
 }
 
 func (c *ghclient) AddLabel(org, repo string, number int, label string) error {
-	return c.cli.AddPRLabel(org, repo, int32(number), label)
+	if c.skipWrite(org, repo, "Would add label %q to #%d", label, number) {
+		return nil
+	}
+	return c.mutate(org, repo, "add label", func() error {
+		return c.cli.AddPRLabel(org, repo, int32(number), label)
+	})
 }
 
 func (c *ghclient) RemoveLabel(org, repo string, number int, label string) error {
-	return c.cli.RemovePRLabel(org, repo, int32(number), label)
+	if c.skipWrite(org, repo, "Would remove label %q from #%d", label, number) {
+		return nil
+	}
+	return c.mutate(org, repo, "remove label", func() error {
+		return c.cli.RemovePRLabel(org, repo, int32(number), label)
+	})
+}
+
+func (c *ghclient) AssignPR(org, repo string, number int, logins []string) error {
+	if c.skipWrite(org, repo, "Would assign %v to #%d", logins, number) {
+		return nil
+	}
+	return c.cli.AssignPR(org, repo, int32(number), logins)
 }
 
+func (c *ghclient) CreateCheckRun(org, repo string, run checkRun) error {
+	if c.skipWrite(org, repo, "Would create check run on %s: %s", run.HeadSHA, run.Output.Title) {
+		return nil
+	}
+	return c.cli.CreateCheckRun(org, repo, run)
+}
+
+// ListIssueEvents returns the events of the approved label which are found
+// from the operation logs of PR. Gitee records a label change as a log whose
+// content mentions the label, such as "add label approved", in English or in
+// Chinese depending on the site.
 func (c *ghclient) ListIssueEvents(org, repo string, num int) ([]github.ListedIssueEvent, error) {
-	return []github.ListedIssueEvent{}, nil
+	logs, err := c.cli.ListPROperationLogs(org, repo, int32(num))
+	if err != nil {
+		return nil, err
+	}
+
+	var events []github.ListedIssueEvent
+	for i := range logs {
+		l := &logs[i]
+		if !strings.Contains(strings.ToLower(l.ActionType), "label") || !strings.Contains(l.Content, labels.Approved) {
+			continue
+		}
+
+		e := github.ListedIssueEvent{
+			Event: github.IssueActionLabeled,
+			Label: github.Label{Name: labels.Approved},
+		}
+
+		content := strings.ToLower(l.Content)
+		for _, w := range []string{"remove", "delete", "删除", "移除"} {
+			if strings.Contains(content, w) {
+				e.Event = github.IssueActionUnlabeled
+				break
+			}
+		}
+
+		if l.User != nil {
+			e.Actor = github.User{Login: l.User.Login}
+		}
+		e.CreatedAt, _ = time.Parse(time.RFC3339, l.CreatedAt)
+
+		events = append(events, e)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].CreatedAt.Before(events[j].CreatedAt)
+	})
+
+	return events, nil
+}
+
+func (c *ghclient) ListCommentReactions(org, repo string, ID int) ([]approve.Reaction, error) {
+	v, err := c.cli.ListCommentReactions(org, repo, int32(ID))
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]approve.Reaction, 0, len(v))
+	for i := range v {
+		r = append(r, approve.Reaction{Login: v[i].User.Login, Content: v[i].Content})
+	}
+
+	return r, nil
+}
+
+// GetPRCommitTime returns the committed time of the commit of the PR.
+func (c *ghclient) GetPRCommitTime(org, repo string, number int, sha string) (time.Time, error) {
+	commits, err := c.cli.GetPRCommits(org, repo, int32(number))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for i := range commits {
+		if v := &commits[i]; v.Sha == sha && v.Commit != nil && v.Commit.Committer != nil {
+			return v.Commit.Committer.Date, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("commit %s is not found in the PR", sha)
 }
 
 func (c *ghclient) GetPullRequest(org, repo string, number int) (*github.PullRequest, error) {
 	return nil, nil
 }
 
+// reviewLogStates maps the content of the operation logs which Gitee writes
+// when an assignee reviews the PR, in lower case without the html tags and
+// the spaces around, to the states of the review. Gitee writes "审查通过"
+// when the review is passed, and "审查不通过" or "审查未通过" when it is
+// rejected, or the English ones when the locale is English. The content is
+// matched exactly, so that a rejection can never be taken as an approval by
+// containing the words of approval, such as "不通过" containing "通过". The
+// other logs, such as resetting the review, are not reviews.
+var reviewLogStates = map[string]github.ReviewState{
+	"审查通过":              github.ReviewStateApproved,
+	"review passed":     github.ReviewStateApproved,
+	"审查不通过":             github.ReviewStateChangesRequested,
+	"审查未通过":             github.ReviewStateChangesRequested,
+	"review not passed": github.ReviewStateChangesRequested,
+	"review rejected":   github.ReviewStateChangesRequested,
+}
+
+var htmlTagRegex = regexp.MustCompile(`<[^>]*>`)
+
+// ListReviews returns the reviews found from the operation logs of PR. Gitee
+// records the review of an assignee as a log rather than providing a review
+// API, see reviewLogStates for the logs taken as reviews. Passing the review
+// is regarded as an approved review, and rejecting it as a review requesting
+// changes.
 func (c *ghclient) ListReviews(org, repo string, number int) ([]github.Review, error) {
-	return []github.Review{}, nil
+	logs, err := c.cli.ListPROperationLogs(org, repo, int32(number))
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("https://gitee.com/%s/%s/pulls/%d", org, repo, number)
+
+	var reviews []github.Review
+	for i := range logs {
+		l := &logs[i]
+		if l.User == nil {
+			continue
+		}
+
+		state := reviewState(l.Content)
+		if state == "" {
+			continue
+		}
+
+		r := github.Review{
+			ID:      int(l.Id),
+			User:    github.User{Login: l.User.Login},
+			State:   state,
+			HTMLURL: url,
+		}
+		r.SubmittedAt, _ = time.Parse(time.RFC3339, l.CreatedAt)
+
+		reviews = append(reviews, r)
+	}
+
+	sort.SliceStable(reviews, func(i, j int) bool {
+		return reviews[i].SubmittedAt.Before(reviews[j].SubmittedAt)
+	})
+
+	return reviews, nil
+}
+
+// reviewState returns the state of the review which the operation log is, or
+// empty if it is not a review.
+func reviewState(content string) github.ReviewState {
+	content = strings.ToLower(strings.TrimSpace(htmlTagRegex.ReplaceAllString(content, "")))
+
+	return reviewLogStates[content]
 }
 
 func (c *ghclient) ListPullRequestComments(org, repo string, number int) ([]github.ReviewComment, error) {
 	return []github.ReviewComment{}, nil
 }
+
+// swappableClient delegates to a client which can be replaced at any time.
+// It is used to switch to the client with the refreshed token of OAuth app.
+type swappableClient struct {
+	lock sync.RWMutex
+	cli  iClient
+}
+
+func newSwappableClient(cli iClient) *swappableClient {
+	return &swappableClient{cli: cli}
+}
+
+func (c *swappableClient) set(cli iClient) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.cli = cli
+}
+
+func (c *swappableClient) get() iClient {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.cli
+}
+
+func (c *swappableClient) GetPullRequestChangesPage(org, repo string, number int32, page, perPage int) ([]sdk.PullRequestFiles, error) {
+	return c.get().GetPullRequestChangesPage(org, repo, number, page, perPage)
+}
+
+func (c *swappableClient) GetPRLabels(org, repo string, number int32) ([]sdk.Label, error) {
+	return c.get().GetPRLabels(org, repo, number)
+}
+
+func (c *swappableClient) ListPRComments(org, repo string, number int32) ([]sdk.PullRequestComments, error) {
+	return c.get().ListPRComments(org, repo, number)
+}
+
+func (c *swappableClient) DeletePRComment(org, repo string, ID int32) error {
+	return c.get().DeletePRComment(org, repo, ID)
+}
+
+func (c *swappableClient) UpdatePRComment(org, repo string, commentID int32, comment string) error {
+	return c.get().UpdatePRComment(org, repo, commentID, comment)
+}
+
+func (c *swappableClient) CreatePRComment(org, repo string, number int32, comment string) error {
+	return c.get().CreatePRComment(org, repo, number, comment)
+}
+
+func (c *swappableClient) GetBot() (sdk.User, error) {
+	return c.get().GetBot()
+}
+
+func (c *swappableClient) GetGiteePullRequest(org, repo string, number int32) (sdk.PullRequest, error) {
+	return c.get().GetGiteePullRequest(org, repo, number)
+}
+
+func (c *swappableClient) AddPRLabel(org, repo string, number int32, label string) error {
+	return c.get().AddPRLabel(org, repo, number, label)
+}
+
+func (c *swappableClient) RemovePRLabel(org, repo string, number int32, label string) error {
+	return c.get().RemovePRLabel(org, repo, number, label)
+}
+
+func (c *swappableClient) GetUserPermissionsOfRepo(org, repo, login string) (sdk.ProjectMemberPermission, error) {
+	return c.get().GetUserPermissionsOfRepo(org, repo, login)
+}
+
+func (c *swappableClient) GetPullRequests(org, repo string, opts giteeclient.ListPullRequestOpt) ([]sdk.PullRequest, error) {
+	return c.get().GetPullRequests(org, repo, opts)
+}
+
+func (c *swappableClient) GetPRCommits(org, repo string, number int32) ([]sdk.PullRequestCommits, error) {
+	return c.get().GetPRCommits(org, repo, number)
+}
+
+func (c *swappableClient) ListPROperationLogs(org, repo string, number int32) ([]sdk.OperateLog, error) {
+	return c.get().ListPROperationLogs(org, repo, number)
+}
+
+func (c *swappableClient) GetPathContent(org, repo, path, ref string) (sdk.Content, error) {
+	return c.get().GetPathContent(org, repo, path, ref)
+}
+
+func (c *swappableClient) AssignPR(owner, repo string, number int32, logins []string) error {
+	return c.get().AssignPR(owner, repo, number, logins)
+}
+
+func (c *swappableClient) CreateCheckRun(org, repo string, run checkRun) error {
+	return c.get().CreateCheckRun(org, repo, run)
+}
+
+func (c *swappableClient) GetPRComment(org, repo string, commentID int32) (sdk.PullRequestComments, error) {
+	return c.get().GetPRComment(org, repo, commentID)
+}
+
+func (c *swappableClient) GetRepoTree(org, repo, ref string) ([]treeEntry, error) {
+	return c.get().GetRepoTree(org, repo, ref)
+}
+
+func (c *swappableClient) GetRepos(org string) ([]sdk.Project, error) {
+	return c.get().GetRepos(org)
+}
+
+func (c *swappableClient) GetRepoLabels(owner, repo string) ([]sdk.Label, error) {
+	return c.get().GetRepoLabels(owner, repo)
+}
+
+func (c *swappableClient) CreateRepoLabel(org, repo, label, color string) error {
+	return c.get().CreateRepoLabel(org, repo, label, color)
+}
+
+func (c *swappableClient) CreateIssue(org, repo, title, body string) (sdk.Issue, error) {
+	return c.get().CreateIssue(org, repo, title, body)
+}
+
+func (c *swappableClient) ListCommentReactions(org, repo string, commentID int32) ([]commentReaction, error) {
+	return c.get().ListCommentReactions(org, repo, commentID)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"k8s.io/test-infra/prow/github"
+)
+
+func TestReviewState(t *testing.T) {
+	cases := []struct {
+		content string
+		state   github.ReviewState
+	}{
+		{content: "审查通过", state: github.ReviewStateApproved},
+		{content: " <b>审查通过</b>\n", state: github.ReviewStateApproved},
+		{content: "Review Passed", state: github.ReviewStateApproved},
+		{content: "审查不通过", state: github.ReviewStateChangesRequested},
+		{content: "审查未通过", state: github.ReviewStateChangesRequested},
+		{content: "review not passed", state: github.ReviewStateChangesRequested},
+		{content: "review rejected", state: github.ReviewStateChangesRequested},
+		{content: "测试通过", state: ""},
+		{content: "重置了审查", state: ""},
+		{content: "passed", state: ""},
+		{content: "审查通过 审查不通过", state: ""},
+		{content: "", state: ""},
+	}
+
+	for _, c := range cases {
+		if v := reviewState(c.content); v != c.state {
+			t.Errorf("%q: expect %q, but got %q", c.content, c.state, v)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestGiteeClientToken(t *testing.T) {
+	const token = "s3cret-token"
+
+	var req *http.Request
+	c := giteeClient{
+		token: func() []byte { return []byte(token) },
+		hc: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			req = r
+			return nil, fmt.Errorf("dial tcp: lookup gitee.com: no such host")
+		})},
+	}
+
+	cases := []struct {
+		name string
+		call func() error
+	}{
+		{
+			name: "tree",
+			call: func() error { _, err := c.GetRepoTree("org", "repo", "master"); return err },
+		},
+		{
+			name: "PR files",
+			call: func() error { _, err := c.GetPullRequestChangesPage("org", "repo", 1, 1, 100); return err },
+		},
+		{
+			name: "reactions",
+			call: func() error { _, err := c.ListCommentReactions("org", "repo", 1); return err },
+		},
+		{
+			name: "comment",
+			call: func() error { _, err := c.GetPRComment("org", "repo", 1); return err },
+		},
+		{
+			name: "check run",
+			call: func() error { return c.CreateCheckRun("org", "repo", checkRun{Name: checkRunName}) },
+		},
+	}
+
+	for _, c := range cases {
+		req = nil
+
+		err := c.call()
+		if err == nil {
+			t.Errorf("%s: expect the error of the transport", c.name)
+		} else if strings.Contains(err.Error(), token) {
+			t.Errorf("%s: the error carries the token: %v", c.name, err)
+		}
+
+		if req == nil {
+			t.Errorf("%s: no request is sent", c.name)
+			continue
+		}
+
+		if strings.Contains(req.URL.String(), token) {
+			t.Errorf("%s: the url carries the token: %s", c.name, req.URL)
+		}
+		if v := req.Header.Get("Authorization"); v != "Bearer "+token {
+			t.Errorf("%s: expect the token in the Authorization header, but got %q", c.name, v)
+		}
+	}
+}
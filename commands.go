@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+)
+
+// statusCommandReg matches "/approve status" which replies with a summary of
+// the approval status.
+var statusCommandReg = regexp.MustCompile(`(?mi)^/approve[\t ]+status[\t ]*$`)
+
+// mentionReg matches the subcommands issued by mentioning the bot, such as
+// "@approve-bot status".
+var mentionReg = regexp.MustCompile(`(?mi)^@([^\s]+)[\t ]+(status|help|reevaluate)[\t ]*$`)
+
+// noteCommand is the context of a command in a comment on a PR.
+type noteCommand struct {
+	org       string
+	repo      string
+	pr        prInfo
+	cfg       *botConfig
+	botName   string
+	commenter string
+	log       *logrus.Entry
+}
+
+func (c *noteCommand) reply(bot *robot, msg string) error {
+	return bot.cli.withDryRun(c.cfg.DryRun).CreateComment(c.org, c.repo, c.pr.number, fmt.Sprintf("@%s %s", c.commenter, msg))
+}
+
+// noteRoute handles the comments matching its pattern. The match is the
+// submatches of the first match accepted.
+type noteRoute struct {
+	reg    *regexp.Regexp
+	accept func(c *noteCommand, match []string) bool
+	handle func(bot *robot, c *noteCommand, match []string) error
+}
+
+// noteRoutes are the commands other than the approve commands, in order of
+// precedence. Only the first one matching a comment is handled.
+var noteRoutes = []noteRoute{
+	{
+		reg: setCommandReg,
+		handle: func(bot *robot, c *noteCommand, m []string) error {
+			return bot.handleSetCommand(c, m[1], m[2])
+		},
+	},
+	{
+		reg: assignApproversReg,
+		handle: func(bot *robot, c *noteCommand, m []string) error {
+			return bot.handleAssignApprovers(c.org, c.repo, c.pr, c.commenter, c.cfg, c.log)
+		},
+	},
+	{
+		reg: statusCommandReg,
+		handle: func(bot *robot, c *noteCommand, m []string) error {
+			return bot.postStatusSummary(c)
+		},
+	},
+	{
+		reg: configCommandReg,
+		handle: func(bot *robot, c *noteCommand, m []string) error {
+			return bot.handleConfigCommand(c)
+		},
+	},
+	{
+		reg: refreshCommandReg,
+		handle: func(bot *robot, c *noteCommand, m []string) error {
+			return bot.handleRefreshCommand(c)
+		},
+	},
+	{
+		reg: ownersCommandReg,
+		handle: func(bot *robot, c *noteCommand, m []string) error {
+			return bot.handleOwnersCommand(c, m[1])
+		},
+	},
+	{
+		reg: mentionReg,
+		accept: func(c *noteCommand, m []string) bool {
+			return strings.EqualFold(m[1], c.botName)
+		},
+		handle: (*robot).handleMention,
+	},
+}
+
+// routeNoteCommand handles the first command of noteRoutes matching the
+// comment, and reports whether there is one.
+func (bot *robot) routeNoteCommand(c *noteCommand, body string) (bool, error) {
+	for i := range noteRoutes {
+		r := &noteRoutes[i]
+		for _, m := range r.reg.FindAllStringSubmatch(body, -1) {
+			if r.accept == nil || r.accept(c, m) {
+				return true, r.handle(bot, c, m)
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// handleMention handles the subcommands issued by mentioning the bot, which
+// work even if another plugin shadows the slash commands.
+func (bot *robot) handleMention(c *noteCommand, m []string) error {
+	switch strings.ToLower(m[2]) {
+	case "status":
+		return bot.postStatusSummary(c)
+
+	case "reevaluate":
+		key := fmt.Sprintf("%s/%s/%d/%s", c.org, c.repo, c.pr.number, c.commenter)
+		if allowed, _ := bot.throttle.allow(key, c.cfg.MaxCommandsPerHour, time.Now()); !allowed {
+			return nil
+		}
+
+		return bot.handleEvent(c.org, c.repo, c.pr, c.cfg, c.log)
+
+	default:
+		return c.reply(bot, mentionHelp(c.botName, c.pr.htmlURL))
+	}
+}
+
+// statusSummaryMark marks the status summary of the PR, which is edited
+// instead of posting another one when it is asked for again.
+const statusSummaryMark = "<!-- approve-status -->"
+
+// postStatusSummary posts the status summary of the PR, or updates the
+// previous one. It changes neither the labels nor the notification.
+func (bot *robot) postStatusSummary(c *noteCommand) error {
+	s, err := bot.statusOf(c.org, c.repo, c.pr, c.cfg, c.commenter, c.log)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("@%s %s", c.commenter, statusSummary(&s))
+	cli := bot.cli.withDryRun(c.cfg.DryRun)
+
+	comments, err := cli.ListIssueComments(c.org, c.repo, c.pr.number)
+	if err != nil {
+		return err
+	}
+
+	for i := len(comments) - 1; i >= 0; i-- {
+		v := &comments[i]
+		if v.User.Login == c.botName && strings.Contains(v.Body, statusSummaryMark) {
+			return cli.EditComment(c.org, c.repo, v.ID, msg)
+		}
+	}
+
+	return cli.CreateComment(c.org, c.repo, c.pr.number, msg)
+}
+
+func statusSummary(s *approve.Status) string {
+	b := new(strings.Builder)
+
+	if s.Approved {
+		b.WriteString("This pull-request is **APPROVED**.\n\n")
+	} else {
+		b.WriteString("This pull-request is **NOT APPROVED** yet.\n\n")
+	}
+
+	if len(s.Approvers) > 0 {
+		fmt.Fprintf(b, "- Approved by: %s\n", strings.Join(s.Approvers, ", "))
+	} else {
+		b.WriteString("- Approved by: nobody yet\n")
+	}
+
+	if len(s.PendingFiles) > 0 {
+		b.WriteString("- Pending OWNERS files:\n")
+
+		dirs := make([]string, 0, len(s.PendingFiles))
+		for dir := range s.PendingFiles {
+			dirs = append(dirs, dir)
+		}
+		sort.Strings(dirs)
+
+		for _, dir := range dirs {
+			fmt.Fprintf(b, "  - `%s`: %s\n", filepath.Join(dir, "OWNERS"), strings.Join(s.PendingFiles[dir], ", "))
+		}
+	}
+
+	if !s.Approved && len(s.SuggestedApprovers) > 0 {
+		fmt.Fprintf(b, "- Suggested approvers: %s\n", strings.Join(s.SuggestedApprovers, ", "))
+	}
+
+	if s.Instructions != "" {
+		b.WriteString("\n" + s.Instructions + "\n")
+	}
+
+	b.WriteString(statusSummaryMark)
+
+	return b.String()
+}
+
+func mentionHelp(botName, prURL string) string {
+	return fmt.Sprintf(`The commands of approval:
+- `+"`/approve`"+`, `+"`/approve no-issue`"+` and `+"`/approve cancel`"+` approve the pull-request or cancel the approval.
+- `+"`/approve status`"+` shows a summary of the approval status.
+- `+"`/approve config`"+` shows the events triggering the robot on this repository.
+- `+"`/approve owners <path>`"+` shows the approvers of the OWNERS files owning the path.
+- `+"`/approve refresh`"+` regenerates the notification, which only the members of the OWNERS files can do.
+- `+"`/assign-approvers`"+` assigns the suggested approvers.
+- `+"`@%[1]s status`"+` is the same as `+"`/approve status`"+`.
+- `+"`@%[1]s reevaluate`"+` evaluates the approval again.
+
+The full list of commands accepted by this bot can be found [here](%[2]s).`,
+		botName, approve.GetBotCommandLink(prURL),
+	)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	sdk "github.com/opensourceways/go-gitee/gitee"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+)
+
+// commentHintTTL bounds how long a hint is trusted without listing the
+// comments, since a comment event may be missed, such as when the robot is
+// down.
+const commentHintTTL = time.Hour
+
+type commentHint struct {
+	approve.CommentsHint
+
+	listedAt time.Time
+}
+
+// commentHints remembers the notification of every PR and its latest comment
+// not made by the robot, which are learned when the comments are listed and
+// kept up to date by the comment events. They let a PR which hasn't changed
+// be told by fetching just the notification rather than all the comments.
+// They are kept in memory only, and the comments are listed again after a
+// restart.
+type commentHints struct {
+	lock  sync.Mutex
+	items map[string]*commentHint
+}
+
+func newCommentHints() *commentHints {
+	return &commentHints{items: map[string]*commentHint{}}
+}
+
+// get returns the hint of the PR, or nil if there is none or it is stale.
+func (h *commentHints) get(org, repo string, number int) *approve.CommentsHint {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	k := snapshotKey(org, repo, number)
+	v, ok := h.items[k]
+	if !ok {
+		return nil
+	}
+
+	if time.Since(v.listedAt) > commentHintTTL {
+		delete(h.items, k)
+		return nil
+	}
+
+	r := v.CommentsHint
+	return &r
+}
+
+// update records the hint learned by the handling. The hint is dropped if
+// the handling failed or didn't learn one, such as when it posted a new
+// notification whose ID is unknown, unless it was skipped by the hint.
+func (h *commentHints) update(org, repo string, number int, r approve.Result, err error) {
+	if err == nil && r.Skipped && r.Comments == nil {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	k := snapshotKey(org, repo, number)
+	if err != nil || r.Comments == nil || r.Comments.NotificationID == 0 {
+		delete(h.items, k)
+		return
+	}
+
+	h.items[k] = &commentHint{CommentsHint: *r.Comments, listedAt: time.Now()}
+}
+
+// observe records a comment made on the PR by someone other than the robot.
+func (h *commentHints) observe(org, repo string, number, commentID int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if v, ok := h.items[snapshotKey(org, repo, number)]; ok && commentID > v.LastCommentID {
+		v.LastCommentID = commentID
+	}
+}
+
+func (h *commentHints) forget(org, repo string, number int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.items, snapshotKey(org, repo, number))
+}
+
+// GetPRComment gets a comment of the PRs, which the client of the library
+// doesn't support.
+func (c giteeClient) GetPRComment(org, repo string, commentID int32) (sdk.PullRequestComments, error) {
+	var r sdk.PullRequestComments
+
+	u := fmt.Sprintf(
+		"%s/repos/%s/%s/pulls/comments/%d",
+		giteeAPIEndpoint, url.PathEscape(org), url.PathEscape(repo), commentID,
+	)
+
+	resp, err := c.do(http.MethodGet, u, nil)
+	if err != nil {
+		return r, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return r, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return r, fmt.Errorf("get comment: %s %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	if err := json.Unmarshal(b, &r); err != nil {
+		return r, fmt.Errorf("get comment: %v", err)
+	}
+
+	return r, nil
+}
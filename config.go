@@ -1,14 +1,73 @@
 package main
 
-import "github.com/opensourceways/community-robot-lib/config"
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/opensourceways/community-robot-lib/config"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/labels"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+	"github.com/opensourceways/robot-gitee-approve/approve/plugins"
+)
+
+const (
+	unconfiguredRepoIgnore        = "ignore"
+	unconfiguredRepoDefaultPolicy = "default-policy"
+	unconfiguredRepoError         = "error"
+)
 
 type configuration struct {
 	ConfigItems []botConfig `json:"config_items,omitempty"`
+
+	// UnconfiguredRepoAction is how to handle the events of repos matching
+	// none of the config items. It is one of ignore, default-policy and
+	// error, and defaults to ignore. Error reports the events as failed.
+	UnconfiguredRepoAction string `json:"unconfigured_repo_action,omitempty"`
+
+	// RenamedLogins maps the previous logins of the renamed accounts to their
+	// current ones, so that the approvals and the OWNERS files referring to
+	// either login are matched. It applies to all the repos.
+	RenamedLogins map[string]string `json:"renamed_logins,omitempty"`
+
+	// WebhookSecretFiles maps the orgs to the files of the secrets of their
+	// webhooks which are delivered to /v1/webhook, where the signature of
+	// every delivery is verified and the replayed ones are rejected. The
+	// key * applies to the other orgs, which can forge the deliveries of
+	// each other since they share the secret, so give each org its own
+	// secret unless they trust each other. The verification is opt-in: the
+	// deliveries of the orgs without a secret are handled unverified by
+	// either endpoint as before, while those of the orgs with one are
+	// ignored by the webhook endpoint of the framework. The deliveries of the
+	// orgs not configured for the robot are rejected by /v1/webhook.
+	WebhookSecretFiles map[string]string `json:"webhook_secret_files,omitempty"`
+
+	// Default is the options applying to all the repos, which are the same as
+	// those of a config item without the repo filter. A config item sets the
+	// options it overrides only, which are merged deeply onto the default. The
+	// item configuring a repo on its own is further merged onto the item
+	// configuring its org, so that the options can be set once for an org and
+	// overridden for some repos. The default policy is merged onto it too.
+	Default map[string]interface{} `json:"default,omitempty"`
+
+	// DefaultPolicy applies to the unconfigured repos when the action is
+	// default-policy. Its repo filter is not used.
+	DefaultPolicy botConfig `json:"default_policy,omitempty"`
+
+	layers *configLayers
 }
 
-func (c *configuration) configFor(org, repo string) *botConfig {
+// configFor returns the config of the repo merged with the defaults, or nil
+// if the repo is not configured. It fails if the config can't be merged,
+// rather than falling back to the item without the defaults, which may be
+// less strict than intended.
+func (c *configuration) configFor(org, repo string) (*botConfig, error) {
 	if c == nil {
-		return nil
+		return nil, nil
 	}
 
 	items := c.ConfigItems
@@ -17,11 +76,32 @@ func (c *configuration) configFor(org, repo string) *botConfig {
 		v[i] = &items[i]
 	}
 
-	if i := config.Find(org, repo, v); i >= 0 {
-		return &items[i]
+	i := config.Find(org, repo, v)
+	if i < 0 {
+		return nil, nil
 	}
 
-	return nil
+	name := org + "/" + repo
+	if !sets.NewString(items[i].Repos...).Has(name) {
+		name = org
+	}
+
+	// It is validated when the config is loaded, so it fails only if the
+	// config is broken somehow.
+	bc, err := c.resolve(i, name)
+	if err != nil {
+		return nil, fmt.Errorf("resolve the config of %s/%s: %v", org, repo, err)
+	}
+
+	return bc, nil
+}
+
+func (c *configuration) unconfiguredRepoAction() string {
+	if c.UnconfiguredRepoAction == "" {
+		return unconfiguredRepoIgnore
+	}
+
+	return c.UnconfiguredRepoAction
 }
 
 func (c *configuration) Validate() error {
@@ -29,10 +109,51 @@ func (c *configuration) Validate() error {
 		return nil
 	}
 
+	switch c.unconfiguredRepoAction() {
+	case unconfiguredRepoIgnore, unconfiguredRepoError:
+	case unconfiguredRepoDefaultPolicy:
+		if err := c.DefaultPolicy.validateOptions(); err != nil {
+			return fmt.Errorf("invalid default policy: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown unconfigured_repo_action: %s", c.UnconfiguredRepoAction)
+	}
+
+	for old, current := range c.RenamedLogins {
+		if old == "" || current == "" || strings.EqualFold(old, current) {
+			return fmt.Errorf("invalid renamed login %q to %q", old, current)
+		}
+	}
+
+	// A repo in more than one config item is configured by the first one
+	// only, which is likely a mistake.
+	seen := map[string]int{}
 	items := c.ConfigItems
 	for i := range items {
-		if err := items[i].validate(); err != nil {
-			return err
+		if err := items[i].validateFilter(); err != nil {
+			return fmt.Errorf("invalid config item %d: %v", i, err)
+		}
+
+		for _, r := range items[i].Repos {
+			if j, ok := seen[r]; ok {
+				return fmt.Errorf("repo %s is in both config item %d and %d", r, j, i)
+			}
+			seen[r] = i
+		}
+	}
+
+	// The options are validated after merged, since an item may rely on the
+	// options set by the default or the item of its org.
+	for i := range items {
+		for _, r := range items[i].Repos {
+			bc, err := c.resolve(i, r)
+			if err == nil {
+				err = bc.validateOptions()
+			}
+
+			if err != nil {
+				return fmt.Errorf("invalid config item %d for %s: %v", i, r, err)
+			}
 		}
 	}
 
@@ -48,22 +169,725 @@ func (c *configuration) SetDefault() {
 	for i := range Items {
 		Items[i].setDefault()
 	}
+
+	c.DefaultPolicy.setDefault()
 }
 
 type botConfig struct {
 	config.RepoFilter
 
+	// IssueRequired indicates if an associated issue is required for approval.
+	IssueRequired bool `json:"issue_required,omitempty"`
+
+	// AssociatedIssuePattern is the regular expression matching the issue
+	// associated in the PR body, whose first group is the ID of the issue,
+	// for the issues outside Gitee. By default, the issues of the org are
+	// matched, either linked such as https://gitee.com/org/repo/issues/I5ABCD
+	// or referenced by ID such as #I5ABCD.
+	AssociatedIssuePattern string `json:"associated_issue_pattern,omitempty"`
+
 	// RequireSelfApproval requires PR authors to explicitly approve their PRs.
 	// Otherwise the plugin assumes the author of the PR approves the changes in the PR.
-	RequireSelfApproval bool `json:"require_self_approval,omitempty"`
+	// It is either a boolean, or a list of the path patterns of the files
+	// which the author has to approve explicitly, such as ["pkg"], in the
+	// same forms as ignored_paths, while the others are approved by the
+	// author implicitly.
+	RequireSelfApproval selfApproval `json:"require_self_approval,omitempty"`
+
+	// StackedPRs enables the stacked PR mode. The PRs referenced by the lines of
+	// "Depends-on: #number" in the PR body are regarded as the dependencies of
+	// the PR and their approval state is shown in the notification.
+	StackedPRs bool `json:"stacked_prs,omitempty"`
+
+	// BlockOnDependencies prevents the approved label from being added until all
+	// the dependencies are approved. It only works when StackedPRs is true.
+	BlockOnDependencies bool `json:"block_on_dependencies,omitempty"`
+
+	// FileStatusPolicies requires extra approval for, or blocks, the deletions,
+	// renames or other kinds of changes to files under the protected paths.
+	FileStatusPolicies []plugins.FileStatusPolicy `json:"file_status_policies,omitempty"`
+
+	// MinApprovers is the minimum number of the distinct approvers in the
+	// OWNERS files who must approve the PR even if one of them can approve
+	// all the files, not counting the author. It enforces the review by two
+	// persons if it is 2.
+	MinApprovers int `json:"min_approvers,omitempty"`
+
+	// ConditionalApprovedLabel, such as approved-with-conditions, is added
+	// besides the approved label when the PR is approved under conditions,
+	// which are the associated issue waived by "/approve no-issue" or the
+	// approved label added manually. So the merge automation can gate such
+	// PRs differently.
+	ConditionalApprovedLabel string `json:"conditional_approved_label,omitempty"`
+
+	// TeamAssignees maps the accounts which stand for teams to the directories
+	// of their OWNERS files. When such an account is assigned to a PR, the
+	// approvers of that OWNERS file are treated as the assignees instead, so
+	// that they can be suggested as approvers.
+	TeamAssignees map[string]string `json:"team_assignees,omitempty"`
+
+	// MaxCommandsPerHour is the maximum number of approve commands of a user
+	// on a PR within an hour which trigger the re-evaluation of approval.
+	// The user is told once when it is exceeded. 0 means no limit.
+	MaxCommandsPerHour int `json:"max_commands_per_hour,omitempty"`
+
+	// LgtmActsAsApprove indicates that the lgtm command should be used to
+	// indicate approval.
+	LgtmActsAsApprove bool `json:"lgtm_acts_as_approve,omitempty"`
+
+	// CommandAliases maps the aliases to the commands they stand for, with
+	// the arguments if any, such as "同意" to "approve" and "撤销同意" to
+	// "approve cancel", for the communities which prefer the commands in
+	// their own languages. The aliases are case insensitive.
+	CommandAliases map[string]string `json:"command_aliases,omitempty"`
+
+	// ReviewActsAsApprove makes the review of an assignee act as a command.
+	// Passing the review is the same as "/approve" and rejecting it is the
+	// same as "/approve cancel".
+	ReviewActsAsApprove bool `json:"review_acts_as_approve,omitempty"`
+
+	// ReactionActsAsApprove makes a 👍 reaction on the notification by an
+	// approver in the OWNERS files the same as "/approve". The notification
+	// is rewritten in place instead of being posted again, so that the
+	// reactions are kept. Removing the reaction withdraws the approval.
+	ReactionActsAsApprove bool `json:"reaction_acts_as_approve,omitempty"`
+
+	// RequireApprovalAfterLastPush only counts the approvals, by commands or
+	// reviews, made after the committed time of the head commit, so that an
+	// approval never covers the commits pushed after it. It is looked up
+	// from the commits of the PR on every handling. It can't be used with
+	// reaction_acts_as_approve, as when a reaction is made is unknown.
+	RequireApprovalAfterLastPush bool `json:"require_approval_after_last_push,omitempty"`
+
+	// InvalidateApprovalsOnPush makes the approvals stale once new commits
+	// are pushed to the source branch of the PR, so that they, as well as
+	// the approved label, are removed until the PR is approved again. Unlike
+	// require_approval_after_last_push, it goes by when the push is
+	// notified by the update event of the PR rather than by the committed
+	// time, which may be older than the push. It can't be used with
+	// reaction_acts_as_approve either.
+	InvalidateApprovalsOnPush bool `json:"invalidate_approvals_on_push,omitempty"`
+
+	// ApprovalTTLHours expires the approvals made longer ago than it in
+	// hours, so that the approvals of long-lived PRs reflect the current
+	// opinions of the approvers. The approvers are asked to approve again
+	// once their approvals expire, and the open PRs are handled again when
+	// their approvals expire without any event. The approvals by reactions
+	// and those made outside don't expire. Disabled if 0.
+	ApprovalTTLHours int `json:"approval_ttl_hours,omitempty"`
+
+	// SiblingRobots are the accounts of the other robots working on the PRs,
+	// such as the merge robot. The approved label added by them doesn't
+	// count as a manual approval as the one added by a human does, and the
+	// robot reports it when it reverts their changes to the label.
+	SiblingRobots []string `json:"sibling_robots,omitempty"`
+
+	// SplitNotification splits the notification into a compact status comment,
+	// which is the only one rewritten on changes, and an instructions comment
+	// which is posted once.
+	SplitNotification bool `json:"split_notification,omitempty"`
+
+	// MinimalMode reduces the writes to the PRs of high-traffic repos. Only
+	// the approved label and a compact status comment without suggestions or
+	// instructions are maintained. It takes precedence over SplitNotification.
+	MinimalMode bool `json:"minimal_mode,omitempty"`
+
+	// DryRun logs the changes to the PRs, such as the labels and comments,
+	// instead of making them, so the decisions can be observed before the
+	// robot is enabled for the repo.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// ApprovalCheckRun reports the progress of the approval, such as
+	// "3/5 paths approved", as a check run on the head commit of the PRs, so
+	// that it is visible without reading the notification.
+	ApprovalCheckRun bool `json:"approval_check_run,omitempty"`
+
+	// Triggers switches the events which trigger the handling of the PRs.
+	// "/approve config" shows those in effect on the repo.
+	Triggers eventTriggers `json:"triggers,omitempty"`
+
+	// AutoAssignApprovers assigns the suggested approvers to the PRs when
+	// they are opened. Anyone can ask for it later by "/assign-approvers".
+	AutoAssignApprovers bool `json:"auto_assign_approvers,omitempty"`
+
+	// AutoCCApprovers mentions the suggested approvers in a comment when the
+	// PRs are opened, so that they are notified by Gitee rather than having
+	// to find themselves in the notification.
+	AutoCCApprovers bool `json:"auto_cc_approvers,omitempty"`
+
+	// LintOwnersOnPR lints the OWNERS files and the aliases file changed by
+	// the PRs, and comments the problems found, such as invalid YAML and
+	// unknown users, on them.
+	LintOwnersOnPR bool `json:"lint_owners_on_pr,omitempty"`
+
+	// MissingApproversPolicy is how to handle the PRs whose files have no
+	// approvers at all because the OWNERS files are missing or broken. It is
+	// fail-closed by default, in which such PRs can't be approved and the
+	// notification explains why, or fail-open, in which any one of the
+	// FallbackApprovers can approve them.
+	MissingApproversPolicy string   `json:"missing_approvers_policy,omitempty"`
+	FallbackApprovers      []string `json:"fallback_approvers,omitempty"`
+
+	// PathRequirements declare the approvers of the directories without
+	// OWNERS files, such as requiring infra-admins for ci/**, for the repos
+	// where the OWNERS files are impractical. They take part in the approval
+	// as if they were OWNERS files, so the OWNERS files of the parent
+	// directories still apply unless no_parent_owners is set.
+	PathRequirements []plugins.PathRequirement `json:"path_requirements,omitempty"`
+
+	// OwnersFileChangeRequires are the approvers, such as the maintainers
+	// of the repo, one of whom must approve the PRs which change OWNERS or
+	// OWNERS_ALIASES files. The approval is always computed against the
+	// OWNERS files of the target branch, and the notification warns about
+	// such changes anyway.
+	OwnersFileChangeRequires []string `json:"owners_file_change_requires,omitempty"`
+
+	// LabelPolicies relax the approval requirements of the PRs with specific
+	// labels, such as exempting docs from approval or making one approver
+	// enough. The overrides applied are shown in the notification.
+	LabelPolicies []plugins.LabelPolicy `json:"label_policies,omitempty"`
+
+	// SizeRequirements require more approval for the bigger PRs, such as two
+	// approvers for the PRs changing more than 500 lines and an approver of
+	// the root OWNERS file for those changing more than 2000 lines. The lines
+	// are the additions plus the deletions, and all the requirements which
+	// apply are applied.
+	SizeRequirements []plugins.SizeRequirement `json:"size_requirements,omitempty"`
+
+	// IgnoredPaths are the files which need no approval, such as the docs,
+	// the generated code and vendor. Each one is a regular expression of the
+	// path after "regex:", such as "regex:_generated\.go$", a glob pattern of
+	// the name of the file or of any directory of it if it has no "/", such
+	// as "*.md" and "vendor", or else a directory or a glob pattern of the
+	// path. The files ignored are shown in the notification, and all the
+	// files need approval if they are all ignored.
+	IgnoredPaths []string `json:"ignored_paths,omitempty"`
+
+	// RequireLgtmBeforeApprove adds the approved label only when the PR has
+	// the lgtm label too, and removes it once the lgtm label is removed, so
+	// that the PRs are not merged with the approval only.
+	RequireLgtmBeforeApprove bool `json:"require_lgtm_before_approve,omitempty"`
+
+	// OwnersFallback fetches the OWNERS files from Gitee directly when the
+	// owners cache is still unavailable after retrying, so the PRs are
+	// handled in the meantime. The labels of the OWNERS files are not
+	// supported then.
+	OwnersFallback bool `json:"owners_fallback,omitempty"`
+
+	// ActivityRanking suggests the approvers who commented on the recently
+	// merged PRs before the inactive ones.
+	ActivityRanking activityRanking `json:"activity_ranking,omitempty"`
+
+	// ApproverCapacity skips the approvers assigned to too many open PRs of
+	// the repo when suggesting approvers, so that the load of review is
+	// spread. The decisions are shown in /debug/state.
+	ApproverCapacity approverCapacity `json:"approver_capacity,omitempty"`
+
+	// SuggestionStrategy breaks the ties among the approvers to suggest, who
+	// cover the same files. It is one of random, which is the default,
+	// least-loaded which prefers those suggested for the fewest PRs of the
+	// repo in the last week so that the same leaf owners are not suggested
+	// on every PR, and alphabetical.
+	SuggestionStrategy string `json:"suggestion_strategy,omitempty"`
+
+	// SuggestionSeed decides how often the approvers suggested on a PR are
+	// reshuffled. It is one of pr-number, which is the default and keeps the
+	// suggestion of a PR the same on every handling, daily which rotates it
+	// once a day, and random which reshuffles it every time.
+	SuggestionSeed string `json:"suggestion_seed,omitempty"`
+
+	// OwnersAliasesFile is the path of the file in the repo which defines the
+	// alias groups, such as OWNERS_ALIASES. The approvers in the OWNERS files
+	// which name a group are expanded to its members. The file must exist on
+	// the target branch when it is set.
+	OwnersAliasesFile string `json:"owners_aliases_file,omitempty"`
+
+	// Series regards the PRs of the same topic, extracted from the body or
+	// the source branch, as a series such as the parts of a split change.
+	// The notification links the other PRs of the series, and their
+	// combined approval state is served by /v1/series/{org}/{repo}/{topic}.
+	Series prSeries `json:"series,omitempty"`
+
+	// Federation declares the files owned by the OWNERS of other orgs.
+	Federation []federatedPaths `json:"federation,omitempty"`
+
+	// ExternalApprovals are the review systems outside whose approvals of
+	// the PRs count as "/approve", such as the Code-Review+2 votes in Gerrit
+	// for the communities bridging Gerrit and Gitee during the migration.
+	// They are read whenever the PR is handled, which "@robot reevaluate"
+	// triggers after voting.
+	ExternalApprovals externalApprovals `json:"external_approvals,omitempty"`
+
+	// CircuitBreaker stops handling the events of the repo for a while after
+	// handling them fails consecutively.
+	CircuitBreaker circuitBreaker `json:"circuit_breaker,omitempty"`
+
+	// ChatRoutes route the PRs pending approval on the OWNERS files of the
+	// subtrees to the chat channels of the teams owning them. When a PR
+	// becomes pending on a subtree, its channel is told of the PR and the
+	// approvers required, rather than all the PRs going to one channel.
+	ChatRoutes []chatRoute `json:"chat_routes,omitempty"`
+
+	// BranchOverrides overrides the options above for specific target branches,
+	// such as requiring issues only on the release branches. The first one
+	// matching the branch applies, after the options set by comments.
+	BranchOverrides []branchOverride `json:"branch_overrides,omitempty"`
+
+	// AutomationAuthors applies a distinct policy to the PRs created by
+	// automation accounts, such as the bots updating dependencies and the
+	// robots syncing repos. It applies after the branch overrides.
+	AutomationAuthors automationAuthors `json:"automation_authors,omitempty"`
 
 	ignoreReviewState bool
+	renamedLogins     map[string]string
+	commandAliases    *plugins.CommandAliases
+
+	// soleApprovers replace the approvers of all the OWNERS files, which is
+	// set by the policy of automation authors.
+	soleApprovers []string
+}
+
+// selfApproval is the value of require_self_approval. Paths are set if it is
+// a list.
+type selfApproval struct {
+	Required bool
+	Paths    []string
+}
+
+func (s *selfApproval) UnmarshalJSON(b []byte) error {
+	var required bool
+	if err := json.Unmarshal(b, &required); err == nil {
+		*s = selfApproval{Required: required}
+		return nil
+	}
+
+	var paths []string
+	if err := json.Unmarshal(b, &paths); err != nil {
+		return fmt.Errorf("require_self_approval must be a boolean or a list of paths")
+	}
+	*s = selfApproval{Paths: paths}
+
+	return nil
+}
+
+func (s selfApproval) MarshalJSON() ([]byte, error) {
+	if len(s.Paths) > 0 {
+		return json.Marshal(s.Paths)
+	}
+
+	return json.Marshal(s.Required)
+}
+
+type automationAuthors struct {
+	// Logins are the accounts of automation.
+	Logins []string `json:"logins,omitempty"`
+
+	// Approvers, if set, replace the approvers of the OWNERS files, so that
+	// the approval of any one of them approves all the files. They can name
+	// the alias groups if owners_aliases_file is set.
+	Approvers []string `json:"approvers,omitempty"`
+
+	IssueRequired       *bool `json:"issue_required,omitempty"`
+	RequireSelfApproval *bool `json:"require_self_approval,omitempty"`
+	LgtmActsAsApprove   *bool `json:"lgtm_acts_as_approve,omitempty"`
+	MinApprovers        *int  `json:"min_approvers,omitempty"`
+}
+
+func (a *automationAuthors) match(author string) bool {
+	for _, v := range a.Logins {
+		if strings.EqualFold(v, author) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *automationAuthors) validate() error {
+	if a.MinApprovers != nil && *a.MinApprovers < 0 {
+		return fmt.Errorf("min_approvers of automation authors can't be negative")
+	}
+
+	return nil
+}
+
+type branchOverride struct {
+	// Names are the exact names of the target branches.
+	Names []string `json:"names,omitempty"`
+
+	// Branches are the regular expressions matching the target branches.
+	Branches []string `json:"branches,omitempty"`
+
+	IssueRequired       *bool `json:"issue_required,omitempty"`
+	RequireSelfApproval *bool `json:"require_self_approval,omitempty"`
+	LgtmActsAsApprove   *bool `json:"lgtm_acts_as_approve,omitempty"`
+	BlockOnDependencies *bool `json:"block_on_dependencies,omitempty"`
+	MinimalMode         *bool `json:"minimal_mode,omitempty"`
+
+	// branchRegs are the compiled Branches, which must match the whole
+	// branch.
+	branchRegs []*regexp.Regexp
+}
+
+func (o *branchOverride) match(branch string) bool {
+	for _, n := range o.Names {
+		if n == branch {
+			return true
+		}
+	}
+
+	for _, r := range o.branchRegs {
+		if r.MatchString(branch) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compile compiles the Branches, which is done once the config is loaded.
+func (o *branchOverride) compile() error {
+	regs := make([]*regexp.Regexp, 0, len(o.Branches))
+	for _, b := range o.Branches {
+		// The pattern must be valid on its own, so that it can't break out
+		// of the group anchoring it, such as a)|(b.
+		if _, err := regexp.Compile(b); err != nil {
+			return fmt.Errorf("invalid branch %s: %v", b, err)
+		}
+
+		r, err := regexp.Compile("^(?:" + b + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid branch %s: %v", b, err)
+		}
+		regs = append(regs, r)
+	}
+
+	o.branchRegs = regs
+
+	return nil
+}
+
+func (o *branchOverride) validate() error {
+	if len(o.Names) == 0 && len(o.Branches) == 0 {
+		return fmt.Errorf("names or branches of branch override must be set")
+	}
+
+	if err := o.compile(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *botConfig) branchOverride(branch string) *branchOverride {
+	for i := range c.BranchOverrides {
+		if c.BranchOverrides[i].match(branch) {
+			return &c.BranchOverrides[i]
+		}
+	}
+
+	return nil
+}
+
+// forBranch returns the config with the override of the branch applied.
+func (c *botConfig) forBranch(branch string) *botConfig {
+	o := c.branchOverride(branch)
+	if o == nil {
+		return c
+	}
+
+	v := *c
+	for _, f := range []struct {
+		override *bool
+		field    *bool
+	}{
+		{o.IssueRequired, &v.IssueRequired},
+		{o.LgtmActsAsApprove, &v.LgtmActsAsApprove},
+		{o.BlockOnDependencies, &v.BlockOnDependencies},
+		{o.MinimalMode, &v.MinimalMode},
+	} {
+		if f.override != nil {
+			*f.field = *f.override
+		}
+	}
+
+	if o.RequireSelfApproval != nil {
+		v.RequireSelfApproval = selfApproval{Required: *o.RequireSelfApproval}
+	}
+
+	return &v
+}
+
+// forAuthor returns the config with the policy of automation authors applied
+// if the PR is created by one of them.
+func (c *botConfig) forAuthor(author string) *botConfig {
+	a := &c.AutomationAuthors
+	if !a.match(author) {
+		return c
+	}
+
+	v := *c
+	for _, f := range []struct {
+		override *bool
+		field    *bool
+	}{
+		{a.IssueRequired, &v.IssueRequired},
+		{a.LgtmActsAsApprove, &v.LgtmActsAsApprove},
+	} {
+		if f.override != nil {
+			*f.field = *f.override
+		}
+	}
+
+	if a.RequireSelfApproval != nil {
+		v.RequireSelfApproval = selfApproval{Required: *a.RequireSelfApproval}
+	}
+
+	if a.MinApprovers != nil {
+		v.MinApprovers = *a.MinApprovers
+	}
+
+	if len(a.Approvers) > 0 {
+		v.soleApprovers = a.Approvers
+	}
+
+	return &v
 }
 
 func (c *botConfig) setDefault() {
-	c.ignoreReviewState = true
+	c.ignoreReviewState = !c.ReviewActsAsApprove
+	c.commandAliases = plugins.NewCommandAliases(c.CommandAliases)
+
+	// They are validated when the config is loaded.
+	for i := range c.BranchOverrides {
+		_ = c.BranchOverrides[i].compile()
+	}
 }
 
-func (c *botConfig) validate() error {
+// validateFilter validates the repo filter of the config item.
+func (c *botConfig) validateFilter() error {
+	if len(c.Repos) == 0 {
+		return fmt.Errorf("repos must be set")
+	}
+
+	for _, v := range [][]string{c.Repos, c.ExcludedRepos} {
+		for _, r := range v {
+			if err := validateRepoPattern(r); err != nil {
+				return err
+			}
+		}
+	}
+
 	return c.RepoFilter.Validate()
 }
+
+// validateRepoPattern checks that the repo of a repo filter is either an org
+// or a repo of it in the form of org/repo.
+func validateRepoPattern(r string) error {
+	v := strings.Split(r, "/")
+	for _, s := range v {
+		if s == "" || strings.TrimSpace(s) != s {
+			return fmt.Errorf("invalid repo %q, which must be org or org/repo", r)
+		}
+	}
+
+	if len(v) > 2 {
+		return fmt.Errorf("invalid repo %q, which must be org or org/repo", r)
+	}
+
+	return nil
+}
+
+// validatePathPatterns checks the patterns of the files, which are matched
+// by path.Match.
+func validatePathPatterns(name string, patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q of %s: %v", p, name, err)
+		}
+	}
+
+	return nil
+}
+
+// validatePathMatchers validates the patterns in the forms of ignored_paths,
+// which are regular expressions after "regex:" or else glob patterns.
+func validatePathMatchers(name string, patterns []string) error {
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "regex:") {
+			if _, err := regexp.Compile(strings.TrimPrefix(p, "regex:")); err != nil {
+				return fmt.Errorf("invalid regular expression %q of %s: %v", p, name, err)
+			}
+		} else if err := validatePathPatterns(name, []string{p}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *botConfig) validateOptions() error {
+	if c.MaxCommandsPerHour < 0 {
+		return fmt.Errorf("max_commands_per_hour can't be negative")
+	}
+
+	if c.MinApprovers < 0 {
+		return fmt.Errorf("min_approvers can't be negative")
+	}
+
+	if c.ApprovalTTLHours < 0 {
+		return fmt.Errorf("approval_ttl_hours can't be negative")
+	}
+
+	if r := &c.ActivityRanking; r.RecentPRs < 0 || r.RefreshHours < 0 {
+		return fmt.Errorf("recent_prs and refresh_hours of activity ranking can't be negative")
+	}
+
+	if r := &c.ApproverCapacity; r.MaxOpenPRs < 0 || r.RefreshMinutes < 0 {
+		return fmt.Errorf("max_open_prs and refresh_minutes of approver capacity can't be negative")
+	}
+
+	if p := c.AssociatedIssuePattern; p != "" {
+		reg, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("invalid associated_issue_pattern %s: %v", p, err)
+		}
+
+		if reg.NumSubexp() < 1 {
+			return fmt.Errorf("associated_issue_pattern %s must have a group of the issue", p)
+		}
+	}
+
+	if c.BlockOnDependencies && !c.StackedPRs {
+		return fmt.Errorf("block_on_dependencies only works with stacked_prs")
+	}
+
+	if c.ConditionalApprovedLabel == labels.Approved {
+		return fmt.Errorf("conditional_approved_label can't be the %s label", labels.Approved)
+	}
+
+	if c.RequireApprovalAfterLastPush && c.ReactionActsAsApprove {
+		return fmt.Errorf("require_approval_after_last_push can't be used with reaction_acts_as_approve")
+	}
+
+	if c.InvalidateApprovalsOnPush && c.ReactionActsAsApprove {
+		return fmt.Errorf("invalidate_approvals_on_push can't be used with reaction_acts_as_approve")
+	}
+
+	switch c.SuggestionStrategy {
+	case "", approvers.SuggestRandom, approvers.SuggestLeastLoaded, approvers.SuggestAlphabetical:
+	default:
+		return fmt.Errorf("unknown suggestion_strategy %s", c.SuggestionStrategy)
+	}
+
+	switch c.SuggestionSeed {
+	case "", approvers.SeedPRNumber, approvers.SeedDaily, approvers.SeedRandom:
+	default:
+		return fmt.Errorf("unknown suggestion_seed %s", c.SuggestionSeed)
+	}
+
+	if b := &c.CircuitBreaker; b.MaxFailures < 0 || b.WindowMinutes < 0 || b.CooldownMinutes < 0 {
+		return fmt.Errorf("max_failures, window_minutes and cooldown_minutes of circuit breaker can't be negative")
+	}
+
+	for i := range c.BranchOverrides {
+		if err := c.BranchOverrides[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.FileStatusPolicies {
+		p := &c.FileStatusPolicies[i]
+		if len(p.Paths) == 0 || len(p.Statuses) == 0 {
+			return fmt.Errorf("paths and statuses of file status policy must be set")
+		}
+		if err := validatePathPatterns("file status policy", p.Paths); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.Federation {
+		if err := c.Federation[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.ChatRoutes {
+		if err := c.ChatRoutes[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.AutomationAuthors.validate(); err != nil {
+		return err
+	}
+
+	if err := c.ExternalApprovals.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Series.validate(); err != nil {
+		return err
+	}
+
+	switch c.MissingApproversPolicy {
+	case "", plugins.MissingApproversFailClosed:
+	case plugins.MissingApproversFailOpen:
+		if len(c.FallbackApprovers) == 0 {
+			return fmt.Errorf("fallback_approvers must be set for the fail-open policy of missing approvers")
+		}
+	default:
+		return fmt.Errorf("unknown missing_approvers_policy: %s", c.MissingApproversPolicy)
+	}
+
+	for i := range c.PathRequirements {
+		p := &c.PathRequirements[i]
+		if p.Dir() == "" || strings.ContainsAny(p.Dir(), "*?[") {
+			return fmt.Errorf("path of path requirement must be a directory, such as ci or ci/**: %q", p.Path)
+		}
+		if len(p.Approvers) == 0 {
+			return fmt.Errorf("approvers of path requirement %s must be set", p.Path)
+		}
+	}
+
+	for alias, cmd := range c.CommandAliases {
+		if a := strings.TrimPrefix(alias, "/"); a == "" || strings.ContainsAny(a, " \t\r\n") {
+			return fmt.Errorf("alias of command must be a word: %q", alias)
+		}
+		if strings.TrimPrefix(strings.TrimSpace(cmd), "/") == "" {
+			return fmt.Errorf("command of alias %s must be set", alias)
+		}
+	}
+
+	for i := range c.LabelPolicies {
+		if c.LabelPolicies[i].Label == "" {
+			return fmt.Errorf("label of label policy must be set")
+		}
+		if err := validatePathPatterns("label policy", c.LabelPolicies[i].IgnoreFiles); err != nil {
+			return err
+		}
+	}
+
+	if err := validatePathMatchers("ignored_paths", c.IgnoredPaths); err != nil {
+		return err
+	}
+
+	if err := validatePathMatchers("require_self_approval", c.RequireSelfApproval.Paths); err != nil {
+		return err
+	}
+
+	for i := range c.SizeRequirements {
+		r := &c.SizeRequirements[i]
+		if r.Lines <= 0 {
+			return fmt.Errorf("lines of size requirement must be positive")
+		}
+		if r.MinApprovers < 0 {
+			return fmt.Errorf("min_approvers of size requirement can't be negative")
+		}
+		if r.MinApprovers == 0 && !r.RequireRootApprover {
+			return fmt.Errorf("size requirement of %d lines requires nothing", r.Lines)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConfigFor(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   string
+		broken   map[string]interface{}
+		repo     string
+		min      int
+		notFound bool
+		err      bool
+	}{
+		{
+			name:   "merged onto the default",
+			config: `{"default": {"min_approvers": 2}, "config_items": [{"repos": ["org"]}]}`,
+			repo:   "a",
+			min:    2,
+		},
+		{
+			name:     "not configured",
+			config:   `{"config_items": [{"repos": ["org/b"]}]}`,
+			repo:     "a",
+			notFound: true,
+		},
+		{
+			name:   "default broken after loading",
+			config: `{"config_items": [{"repos": ["org"], "min_approvers": 1}]}`,
+			broken: map[string]interface{}{"issue_required": "yes"},
+			repo:   "a",
+			err:    true,
+		},
+	}
+
+	for _, c := range cases {
+		cfg := &configuration{}
+		if err := json.Unmarshal([]byte(c.config), cfg); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if c.broken != nil {
+			cfg.Default = c.broken
+		}
+
+		bc, err := cfg.configFor("org", c.repo)
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if err != nil {
+			if bc != nil {
+				t.Errorf("%s: expect no config on the error, but got %+v", c.name, bc)
+			}
+			continue
+		}
+
+		if (bc == nil) != c.notFound {
+			t.Errorf("%s: expect not found %t, but got %+v", c.name, c.notFound, bc)
+			continue
+		}
+		if bc != nil && bc.MinApprovers != c.min {
+			t.Errorf("%s: expect min approvers %d, but got %d", c.name, c.min, bc.MinApprovers)
+		}
+	}
+}
+
+func TestBranchOverride(t *testing.T) {
+	cases := []struct {
+		name     string
+		override branchOverride
+		branch   string
+		match    bool
+		invalid  bool
+	}{
+		{
+			name:     "name",
+			override: branchOverride{Names: []string{"master"}},
+			branch:   "master",
+			match:    true,
+		},
+		{
+			name:     "pattern",
+			override: branchOverride{Branches: []string{`release-\d+`}},
+			branch:   "release-1",
+			match:    true,
+		},
+		{
+			name:     "pattern matching part of the branch",
+			override: branchOverride{Branches: []string{`release-\d+`}},
+			branch:   "release-1-dev",
+		},
+		{
+			name:     "alternation anchored as a whole",
+			override: branchOverride{Branches: []string{`a|b`}},
+			branch:   "ab",
+		},
+		{
+			name:     "invalid pattern",
+			override: branchOverride{Branches: []string{`release-(`}},
+			invalid:  true,
+		},
+		{
+			name:     "pattern breaking out of the anchors",
+			override: branchOverride{Branches: []string{`a)|(b`}},
+			invalid:  true,
+		},
+		{
+			name:    "nothing to match",
+			invalid: true,
+		},
+	}
+
+	for _, c := range cases {
+		o := c.override
+
+		err := o.validate()
+		if (err != nil) != c.invalid {
+			t.Errorf("%s: expect invalid %t, but got %v", c.name, c.invalid, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if v := o.match(c.branch); v != c.match {
+			t.Errorf("%s: expect match %t for %s, but got %t", c.name, c.match, c.branch, v)
+		}
+	}
+}
+
+func TestBranchOverrideLoaded(t *testing.T) {
+	cfg := &configuration{}
+	b := `{"config_items": [{"repos": ["org"], "branch_overrides": [{"branches": ["stable-.*"], "issue_required": true}]}]}`
+	if err := json.Unmarshal([]byte(b), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.SetDefault()
+
+	bc, err := cfg.configFor("org", "repo")
+	if err != nil || bc == nil {
+		t.Fatalf("expect the config, but got %v", err)
+	}
+
+	if !bc.forBranch("stable-1").IssueRequired || bc.forBranch("master").IssueRequired {
+		t.Error("expect the override applied to the matched branch only")
+	}
+}
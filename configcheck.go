@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"sigs.k8s.io/yaml"
+)
+
+// validateConfigFile validates the config file for --validate-config. It is
+// stricter than loading it by the config agent, which ignores the unknown
+// fields, so that a misspelled option, which would silently leave the
+// feature disabled, is reported too.
+func validateConfigFile(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	// The config items are decoded by the configuration itself to be merged
+	// onto the default, which is not strict, so check them beforehand.
+	if err := yaml.UnmarshalStrict(b, new(plainConfiguration)); err != nil {
+		return fmt.Errorf("parse %s: %v", file, err)
+	}
+
+	c := new(configuration)
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return fmt.Errorf("parse %s: %v", file, err)
+	}
+
+	if len(c.Default) > 0 {
+		d, err := json.Marshal(c.Default)
+		if err == nil {
+			err = yaml.UnmarshalStrict(d, new(botConfig))
+		}
+
+		if err != nil {
+			return fmt.Errorf("parse default of %s: %v", file, err)
+		}
+	}
+
+	c.SetDefault()
+
+	return c.Validate()
+}
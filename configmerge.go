@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// plainConfiguration is configuration without its own json decoding, which
+// is for decoding the options into it.
+type plainConfiguration configuration
+
+// configLayers keeps the config items as they are written, which set only
+// the options they override, to merge them onto the default and the item of
+// the org.
+type configLayers struct {
+	items  []map[string]interface{}
+	policy map[string]interface{}
+
+	lock     sync.Mutex
+	resolved map[string]*botConfig
+}
+
+func (c *configuration) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, (*plainConfiguration)(c)); err != nil {
+		return err
+	}
+
+	var raw struct {
+		ConfigItems   []map[string]interface{} `json:"config_items"`
+		DefaultPolicy map[string]interface{}   `json:"default_policy"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	c.layers = &configLayers{
+		items:    raw.ConfigItems,
+		policy:   raw.DefaultPolicy,
+		resolved: map[string]*botConfig{},
+	}
+
+	if len(c.Default) == 0 {
+		return nil
+	}
+
+	// The default policy has no repo of its own, so it is merged now.
+	v, err := decodeLayers(c.Default, raw.DefaultPolicy)
+	if err != nil {
+		return fmt.Errorf("merge default_policy: %v", err)
+	}
+	c.DefaultPolicy = *v
+
+	return nil
+}
+
+// resolve returns the config of the repo, or of the org if it is an org,
+// which is configured by the item. The item is merged onto the item of the
+// org, if the repo is configured by an item on its own, and the default, in
+// which the later ones override the options set by the earlier ones.
+func (c *configuration) resolve(i int, repo string) (*botConfig, error) {
+	l := c.layers
+	if l == nil || len(l.items) != len(c.ConfigItems) {
+		return &c.ConfigItems[i], nil
+	}
+
+	key := fmt.Sprintf("%d:%s", i, repo)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if v, ok := l.resolved[key]; ok {
+		return v, nil
+	}
+
+	layers := []map[string]interface{}{c.Default}
+	if j := c.orgItem(i, repo); j >= 0 {
+		layers = append(layers, l.items[j])
+	}
+	layers = append(layers, l.items[i])
+
+	v, err := decodeLayers(layers...)
+	if err != nil {
+		return nil, err
+	}
+
+	v.RepoFilter = c.ConfigItems[i].RepoFilter
+	v.setDefault()
+
+	l.resolved[key] = v
+
+	return v, nil
+}
+
+// orgItem returns the index of the item configuring the org of the repo
+// other than the item i, or -1 if there is none or repo is an org.
+func (c *configuration) orgItem(i int, repo string) int {
+	v := strings.Split(repo, "/")
+	if len(v) != 2 {
+		return -1
+	}
+
+	for j := range c.ConfigItems {
+		if j == i {
+			continue
+		}
+
+		item := &c.ConfigItems[j]
+		if sets.NewString(item.Repos...).Has(v[0]) && !sets.NewString(item.ExcludedRepos...).Has(repo) {
+			return j
+		}
+	}
+
+	return -1
+}
+
+// decodeLayers merges the layers in order and decodes the options merged.
+// The repo filters of the layers are not merged.
+func decodeLayers(layers ...map[string]interface{}) (*botConfig, error) {
+	merged := map[string]interface{}{}
+	for _, l := range layers {
+		mergeOptions(merged, l)
+	}
+
+	delete(merged, "repos")
+	delete(merged, "excluded_repos")
+
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	v := new(botConfig)
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// mergeOptions merges src into dst deeply. The objects are merged by their
+// keys, while the other values, including the lists, are replaced.
+func mergeOptions(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sv, ok := v.(map[string]interface{})
+		if !ok {
+			dst[k] = v
+			continue
+		}
+
+		dv, ok := dst[k].(map[string]interface{})
+		if !ok {
+			dv = map[string]interface{}{}
+		} else {
+			// Copy it, since it may be a part of a layer shared by others.
+			cp := make(map[string]interface{}, len(dv))
+			for dk, dvv := range dv {
+				cp[dk] = dvv
+			}
+			dv = cp
+		}
+
+		mergeOptions(dv, sv)
+		dst[k] = dv
+	}
+}
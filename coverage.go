@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+const coveragePathPrefix = "/v1/coverage/"
+
+// coverageHandler serves GET /v1/coverage/{org}/{repo}/{number} which exports
+// in CSV how each changed file of the PR is covered by the OWNERS files and
+// approved, for audits and for debugging the coverage.
+func (bot *robot) coverageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, repo, number, err := parsePRPath(coveragePathPrefix, r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cs, err := bot.coverage(org, repo, number)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	if err := writeCoverageCSV(w, cs); err != nil {
+		logrus.WithError(err).Error("write coverage csv")
+	}
+}
+
+func (bot *robot) coverage(org, repo string, number int) ([]approvers.FileCoverage, error) {
+	log := logrus.WithFields(logrus.Fields{"org": org, "repo": repo, "number": number})
+
+	v, err := bot.cli.cli.GetGiteePullRequest(org, repo, int32(number))
+	if err != nil {
+		return nil, err
+	}
+	pr := prInfoFromPR(&v)
+
+	_, c := bot.cfgAgent.GetConfig()
+	cfg, err := bot.getConfig(c, org, repo, pr.base)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg = cfg.forAuthor(pr.author)
+	oc, err := bot.loadOwners(org, repo, pr.base, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	state := bot.newState(org, repo, pr, cfg, oc, log)
+	opts := transformConfig(org, cfg)
+
+	return approve.GetCoverage(log, &bot.cli, oc, &opts, state)
+}
+
+func writeCoverageCSV(w io.Writer, cs []approvers.FileCoverage) error {
+	cw := csv.NewWriter(w)
+
+	rows := [][]string{{"file", "owners_file", "eligible_approvers", "approved_by", "approved"}}
+	for i := range cs {
+		v := &cs[i]
+		rows = append(rows, []string{
+			v.File,
+			filepath.Join(v.OwnersFile, "OWNERS"),
+			strings.Join(v.EligibleApprovers, " "),
+			strings.Join(v.Approvers, " "),
+			strconv.FormatBool(v.Approved),
+		})
+	}
+
+	return cw.WriteAll(rows)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dashboardPath serves the dashboard, which lists the PRs of all the repos,
+// including the private ones, so it is an admin endpoint.
+const dashboardPath = "/dashboard"
+
+var dashboardTmpl = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Approval dashboard</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.approved { color: #2c7a2c; }
+.pending { color: #b35c00; }
+</style>
+</head>
+<body>
+<h1>Open pull requests</h1>
+{{range .}}
+<h2>{{.Repo}}</h2>
+<table>
+<tr><th>PR</th><th>State</th><th>Series</th><th>Pending OWNERS paths</th><th>Age</th></tr>
+{{range .PRs}}
+<tr>
+<td><a href="{{.URL}}">!{{.Number}}</a></td>
+<td>{{if .Approved}}<span class="approved">approved</span>{{else}}<span class="pending">pending</span>{{end}}</td>
+<td>{{if .Topic}}<a href="/v1/series/{{.Org}}/{{.Repo}}/{{.Topic}}">{{.Topic}}</a>{{end}}</td>
+<td>{{range $i, $p := .PendingPaths}}{{if $i}}, {{end}}{{$p}}{{end}}</td>
+<td>{{.Age}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>No open pull requests.</p>
+{{end}}
+</body>
+</html>
+`))
+
+type dashboardPR struct {
+	prSnapshot
+
+	Age string
+}
+
+type dashboardRepo struct {
+	Repo string
+	PRs  []dashboardPR
+}
+
+// dashboardHandler serves an HTML page listing the open PRs of each repo.
+func (s *snapshotStore) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	repos := map[string]*dashboardRepo{}
+
+	for _, v := range s.list() {
+		if v.Closed {
+			continue
+		}
+
+		k := v.Org + "/" + v.Repo
+		item, ok := repos[k]
+		if !ok {
+			item = &dashboardRepo{Repo: k}
+			repos[k] = item
+		}
+
+		age := ""
+		if !v.OpenedAt.IsZero() {
+			age = now.Sub(v.OpenedAt).Truncate(time.Hour).String()
+		}
+
+		item.PRs = append(item.PRs, dashboardPR{prSnapshot: v, Age: age})
+	}
+
+	data := make([]dashboardRepo, 0, len(repos))
+	for _, item := range repos {
+		prs := item.PRs
+		sort.Slice(prs, func(i, j int) bool {
+			return prs[i].OpenedAt.Before(prs[j].OpenedAt)
+		})
+
+		data = append(data, *item)
+	}
+
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].Repo < data[j].Repo
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTmpl.Execute(w, data); err != nil {
+		logrus.WithError(err).Error("render dashboard")
+	}
+}
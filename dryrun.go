@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/opensourceways/community-robot-lib/config"
+	"github.com/opensourceways/community-robot-lib/giteeclient"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	dryRunPath = "/v1/dry-run"
+
+	defaultDryRunRecentPRs = 10
+)
+
+// dryRunRequest is the proposed config and the PRs to evaluate.
+type dryRunRequest struct {
+	// Config is the content of the proposed config file.
+	Config string `json:"config"`
+
+	// PRs are the PRs to evaluate in the form of org/repo/number.
+	PRs []string `json:"prs,omitempty"`
+
+	// Repos are the repos in the form of org/repo whose latest open PRs are
+	// evaluated too.
+	Repos []string `json:"repos,omitempty"`
+
+	// Recent is the number of the latest open PRs of each repo to evaluate.
+	// The default is 10.
+	Recent int `json:"recent,omitempty"`
+}
+
+type dryRunDecision struct {
+	Approved        bool     `json:"approved"`
+	UnapprovedFiles []string `json:"unapproved_files"`
+	Error           string   `json:"error,omitempty"`
+}
+
+type dryRunResult struct {
+	PR       string         `json:"pr"`
+	URL      string         `json:"url"`
+	Current  dryRunDecision `json:"current"`
+	Proposed dryRunDecision `json:"proposed"`
+	Changed  bool           `json:"changed"`
+}
+
+// dryRunHandler serves POST /v1/dry-run which reports the PRs whose approval
+// decision would change under the proposed config, for reviewing the changes
+// of config in CI. The report is in markdown if format=markdown is given.
+// It is an admin endpoint, and only the repos configured for the robot are
+// evaluated, so that it can't be used to read the other repos by the token of
+// the robot.
+func (bot *robot) dryRunHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req dryRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	proposed := &configuration{}
+	if err := yaml.Unmarshal([]byte(req.Config), proposed); err != nil {
+		http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	proposed.SetDefault()
+	if err := proposed.Validate(); err != nil {
+		http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, current := bot.cfgAgent.GetConfig()
+
+	prs, err := bot.dryRunPRs(current, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := bot.dryRun(current, proposed, prs)
+
+	if r.URL.Query().Get("format") == "markdown" {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write([]byte(dryRunMarkdown(results)))
+		return
+	}
+
+	writeJSON(w, results)
+}
+
+type prRef struct {
+	org    string
+	repo   string
+	number int
+}
+
+func (p prRef) String() string {
+	return fmt.Sprintf("%s/%s/%d", p.org, p.repo, p.number)
+}
+
+// dryRunPRs returns the PRs to evaluate, which must be of the repos
+// configured by the current config.
+func (bot *robot) dryRunPRs(current config.Config, req *dryRunRequest) ([]prRef, error) {
+	c, ok := current.(*configuration)
+	if !ok {
+		return nil, fmt.Errorf("can't convert to configuration")
+	}
+
+	configured := func(org, repo string) error {
+		bc, err := c.configFor(org, repo)
+		if err != nil {
+			return err
+		}
+		if bc == nil {
+			return fmt.Errorf("%s/%s is not configured for the robot", org, repo)
+		}
+		return nil
+	}
+
+	var prs []prRef
+
+	for _, s := range req.PRs {
+		v := strings.Split(s, "/")
+		if len(v) != 3 {
+			return nil, fmt.Errorf("invalid PR %s, it should be org/repo/number", s)
+		}
+
+		if err := configured(v[0], v[1]); err != nil {
+			return nil, err
+		}
+
+		n, err := strconv.Atoi(v[2])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid PR number of %s", s)
+		}
+
+		prs = append(prs, prRef{org: v[0], repo: v[1], number: n})
+	}
+
+	recent := req.Recent
+	if recent <= 0 {
+		recent = defaultDryRunRecentPRs
+	}
+
+	for _, s := range req.Repos {
+		v := strings.Split(s, "/")
+		if len(v) != 2 {
+			return nil, fmt.Errorf("invalid repo %s, it should be org/repo", s)
+		}
+
+		if err := configured(v[0], v[1]); err != nil {
+			return nil, err
+		}
+
+		open, err := bot.cli.cli.GetPullRequests(v[0], v[1], giteeclient.ListPullRequestOpt{State: "open"})
+		if err != nil {
+			return nil, fmt.Errorf("list the PRs of %s: %v", s, err)
+		}
+
+		if len(open) > recent {
+			open = open[:recent]
+		}
+		for i := range open {
+			prs = append(prs, prRef{org: v[0], repo: v[1], number: int(open[i].Number)})
+		}
+	}
+
+	return prs, nil
+}
+
+func (bot *robot) dryRun(current config.Config, proposed *configuration, prs []prRef) []dryRunResult {
+	results := make([]dryRunResult, 0, len(prs))
+	for _, p := range prs {
+		r := dryRunResult{PR: p.String()}
+
+		v, err := bot.cli.cli.GetGiteePullRequest(p.org, p.repo, int32(p.number))
+		if err != nil {
+			r.Current.Error = err.Error()
+			r.Proposed.Error = err.Error()
+			results = append(results, r)
+			continue
+		}
+		pr := prInfoFromPR(&v)
+		r.URL = pr.htmlURL
+
+		r.Current = bot.dryRunDecision(current, p, pr)
+		r.Proposed = bot.dryRunDecision(proposed, p, pr)
+		r.Changed = r.Current.Approved != r.Proposed.Approved || r.Current.Error != r.Proposed.Error
+
+		results = append(results, r)
+	}
+
+	return results
+}
+
+func (bot *robot) dryRunDecision(c config.Config, p prRef, pr prInfo) dryRunDecision {
+	s, err := bot.evaluateStatus(c, p.org, p.repo, pr, "")
+	if err != nil {
+		return dryRunDecision{Error: err.Error()}
+	}
+
+	return dryRunDecision{Approved: s.Approved, UnapprovedFiles: s.UnapprovedFiles}
+}
+
+func dryRunMarkdown(results []dryRunResult) string {
+	var changed []dryRunResult
+	for _, r := range results {
+		if r.Changed {
+			changed = append(changed, r)
+		}
+	}
+
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "The approval decisions of %d of the %d evaluated PRs would change.\n", len(changed), len(results))
+	if len(changed) == 0 {
+		return b.String()
+	}
+
+	b.WriteString("\n| PR | Current | Proposed |\n| --- | --- | --- |\n")
+	for _, r := range changed {
+		fmt.Fprintf(b, "| [%s](%s) | %s | %s |\n", r.PR, r.URL, r.Current, r.Proposed)
+	}
+
+	return b.String()
+}
+
+func (d dryRunDecision) String() string {
+	switch {
+	case d.Error != "":
+		return "error: " + d.Error
+	case d.Approved:
+		return "approved"
+	default:
+		return fmt.Sprintf("not approved, %d files pending", len(d.UnapprovedFiles))
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	libconfig "github.com/opensourceways/community-robot-lib/config"
+)
+
+func TestAdminOnly(t *testing.T) {
+	cases := []struct {
+		name   string
+		token  string
+		header string
+		status int
+	}{
+		{name: "admin token", token: "s3cret", header: "Bearer s3cret", status: http.StatusOK},
+		{name: "no token", token: "s3cret", status: http.StatusUnauthorized},
+		{name: "wrong token", token: "s3cret", header: "Bearer guess", status: http.StatusUnauthorized},
+		{name: "token not as bearer", token: "s3cret", header: "s3cret", status: http.StatusUnauthorized},
+		{name: "no admin token configured", header: "Bearer ", status: http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		bot := &robot{adminToken: []byte(c.token)}
+		h := bot.adminOnly(func(w http.ResponseWriter, r *http.Request) {})
+
+		r := httptest.NewRequest(http.MethodPost, dryRunPath, nil)
+		if c.header != "" {
+			r.Header.Set("Authorization", c.header)
+		}
+
+		w := httptest.NewRecorder()
+		h(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("%s: expect status %d, but got %d", c.name, c.status, w.Code)
+		}
+	}
+}
+
+func TestDryRunPRs(t *testing.T) {
+	current := &configuration{
+		ConfigItems: []botConfig{
+			{RepoFilter: libconfig.RepoFilter{Repos: []string{"org"}}},
+			{RepoFilter: libconfig.RepoFilter{Repos: []string{"other/repo"}}},
+		},
+	}
+
+	cases := []struct {
+		name string
+		req  dryRunRequest
+		prs  []string
+		err  string
+	}{
+		{
+			name: "PRs of the configured repos",
+			req:  dryRunRequest{PRs: []string{"org/a/1", "other/repo/2"}},
+			prs:  []string{"org/a/1", "other/repo/2"},
+		},
+		{
+			name: "PR of an unconfigured org",
+			req:  dryRunRequest{PRs: []string{"org/a/1", "private/repo/1"}},
+			err:  "not configured",
+		},
+		{
+			name: "PR of an unconfigured repo of a configured org",
+			req:  dryRunRequest{PRs: []string{"other/private/1"}},
+			err:  "not configured",
+		},
+		{
+			name: "repo not configured",
+			req:  dryRunRequest{Repos: []string{"private/repo"}},
+			err:  "not configured",
+		},
+		{
+			name: "invalid PR",
+			req:  dryRunRequest{PRs: []string{"org/a"}},
+			err:  "invalid PR",
+		},
+	}
+
+	bot := &robot{}
+
+	for _, c := range cases {
+		prs, err := bot.dryRunPRs(current, &c.req)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: expect the error of %q, but got %v", c.name, c.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+
+		var v []string
+		for _, p := range prs {
+			v = append(v, p.String())
+		}
+		if strings.Join(v, ",") != strings.Join(c.prs, ",") {
+			t.Errorf("%s: expect %v, but got %v", c.name, c.prs, v)
+		}
+	}
+}
+
+func TestDryRunMarkdown(t *testing.T) {
+	cases := []struct {
+		name     string
+		results  []dryRunResult
+		contains []string
+		excludes []string
+	}{
+		{
+			name: "nothing changed",
+			results: []dryRunResult{
+				{PR: "org/a/1", Current: dryRunDecision{Approved: true}, Proposed: dryRunDecision{Approved: true}},
+			},
+			contains: []string{"0 of the 1 evaluated PRs"},
+			excludes: []string{"| PR |"},
+		},
+		{
+			name: "approval revoked and error",
+			results: []dryRunResult{
+				{
+					PR: "org/a/1", URL: "https://gitee.com/org/a/pulls/1", Changed: true,
+					Current:  dryRunDecision{Approved: true},
+					Proposed: dryRunDecision{UnapprovedFiles: []string{"a.go", "b.go"}},
+				},
+				{PR: "org/a/2", Current: dryRunDecision{}, Proposed: dryRunDecision{}},
+				{
+					PR: "org/a/3", Changed: true,
+					Current:  dryRunDecision{},
+					Proposed: dryRunDecision{Error: "invalid OWNERS"},
+				},
+			},
+			contains: []string{
+				"2 of the 3 evaluated PRs",
+				"| [org/a/1](https://gitee.com/org/a/pulls/1) | approved | not approved, 2 files pending |",
+				"| error: invalid OWNERS |",
+			},
+			excludes: []string{"org/a/2"},
+		},
+	}
+
+	for _, c := range cases {
+		v := dryRunMarkdown(c.results)
+
+		for _, s := range c.contains {
+			if !strings.Contains(v, s) {
+				t.Errorf("%s: expect %q in\n%s", c.name, s, v)
+			}
+		}
+		for _, s := range c.excludes {
+			if strings.Contains(v, s) {
+				t.Errorf("%s: expect no %q in\n%s", c.name, s, v)
+			}
+		}
+	}
+}
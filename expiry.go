@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// approvalExpiryInterval is how often the PRs are checked for the approvals
+// expired since they were handled last time.
+const approvalExpiryInterval = 10 * time.Minute
+
+// pingExpiredApprovers asks the approvers whose approvals have expired since
+// the PR was handled last time to approve again.
+func (bot *robot) pingExpiredApprovers(cli *ghclient, org, repo string, pr prInfo, before, expired []string, log *logrus.Entry) {
+	logins := sets.NewString(expired...).Difference(sets.NewString(before...)).List()
+	if len(logins) == 0 {
+		return
+	}
+
+	for i := range logins {
+		logins[i] = "@" + logins[i]
+	}
+
+	msg := fmt.Sprintf(
+		"%s your approval on this pull request has expired. Please comment `/approve` again if you still approve.",
+		strings.Join(logins, " "),
+	)
+	if err := cli.CreateComment(org, repo, pr.number, msg); err != nil {
+		log.WithError(err).Error("ping the approvers whose approvals expired")
+	}
+}
+
+// expirySweeper handles the open PRs again once their approvals expire, as
+// nothing else triggers the handling of an idle PR.
+type expirySweeper struct {
+	bot  *robot
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newExpirySweeper(bot *robot) *expirySweeper {
+	return &expirySweeper{bot: bot, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+func (s *expirySweeper) start() {
+	go func() {
+		defer close(s.done)
+
+		t := time.NewTicker(approvalExpiryInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+				s.sweep(time.Now())
+			}
+		}
+	}()
+}
+
+func (s *expirySweeper) shutdown() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *expirySweeper) sweep(now time.Time) {
+	bot := s.bot
+	_, c := bot.cfgAgent.GetConfig()
+
+	for _, v := range bot.snapshots.list() {
+		if v.Closed || v.ApprovalsExpireAt.IsZero() || v.ApprovalsExpireAt.After(now) {
+			continue
+		}
+
+		log := logrus.WithFields(logrus.Fields{"org": v.Org, "repo": v.Repo, "number": v.Number})
+
+		pr, err := bot.cli.cli.GetGiteePullRequest(v.Org, v.Repo, int32(v.Number))
+		if err != nil {
+			log.WithError(err).Error("get the PR whose approvals expired")
+			continue
+		}
+
+		info := prInfoFromPR(&pr)
+		if pr.State != "open" {
+			bot.snapshots.close(v.Org, v.Repo, v.Number)
+			continue
+		}
+
+		cfg, err := bot.getEventConfig(c, v.Org, v.Repo, info.base)
+		if err != nil || cfg == nil {
+			if err != nil {
+				log.WithError(err).Error("get the config of the PR whose approvals expired")
+			}
+			continue
+		}
+
+		if err := bot.refresh(v.Org, v.Repo, info, cfg, log); err != nil {
+			log.WithError(err).Error("handle the PR whose approvals expired")
+		}
+	}
+}
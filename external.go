@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+)
+
+const (
+	gerritSourceName = "Gerrit"
+
+	// gerritApprovalScore is the Code-Review vote which counts as "/approve".
+	gerritApprovalScore = 2
+)
+
+var (
+	changeIDReg = regexp.MustCompile(`(?m)^Change-Id:[\t ]*(I[0-9a-f]{40})[\t \r]*$`)
+
+	// gerritMagicPrefix prefixes the JSON responses of Gerrit to prevent
+	// XSSI.
+	gerritMagicPrefix = []byte(")]}'")
+
+	gerritClient = http.Client{Timeout: 10 * time.Second}
+)
+
+// approvalSource is a review system outside Gitee whose approvals of the PRs
+// count as "/approve".
+type approvalSource interface {
+	name() string
+	approvals(org, repo string, pr prInfo) ([]approve.ExternalApproval, error)
+}
+
+// externalApprovals configures the review systems outside whose approvals of
+// the PRs count, such as the Gerrit which the community is migrating from.
+type externalApprovals struct {
+	Gerrit *gerritSource `json:"gerrit,omitempty"`
+}
+
+func (e *externalApprovals) validate() error {
+	if e.Gerrit != nil {
+		return e.Gerrit.validate()
+	}
+
+	return nil
+}
+
+func (e *externalApprovals) sources() []approvalSource {
+	var r []approvalSource
+	if e.Gerrit != nil {
+		r = append(r, e.Gerrit)
+	}
+
+	return r
+}
+
+// gerritSource gets the approvals from the change in Gerrit which is linked to
+// the PR by the "Change-Id: I..." line of the PR body. A Code-Review+2 vote on
+// the change counts as "/approve" of the voter.
+//
+// The PR body is written by the author, so the change only counts if it is of
+// the project of the repo and the target branch of the PR, and its current
+// revision is the head commit of the PR.
+type gerritSource struct {
+	// URL is the base url of Gerrit, such as https://gerrit.example.com.
+	URL string `json:"url" required:"true"`
+
+	// Project is the project of the changes in Gerrit mirroring the repo,
+	// in which {org} and {repo} are replaced with those of the repo, such as
+	// "{org}/{repo}".
+	Project string `json:"project" required:"true"`
+
+	// Accounts maps the usernames in Gerrit to the logins in Gitee. The
+	// votes of the usernames not mapped don't count.
+	Accounts map[string]string `json:"accounts,omitempty"`
+}
+
+func (g *gerritSource) validate() error {
+	if g.URL == "" {
+		return fmt.Errorf("url of gerrit must be set")
+	}
+
+	if _, err := url.Parse(g.URL); err != nil {
+		return fmt.Errorf("invalid url of gerrit: %v", err)
+	}
+
+	if g.Project == "" {
+		return fmt.Errorf("project of gerrit must be set")
+	}
+
+	return nil
+}
+
+// project returns the project in Gerrit mirroring the repo.
+func (g *gerritSource) project(org, repo string) string {
+	return strings.NewReplacer("{org}", org, "{repo}", repo).Replace(g.Project)
+}
+
+func (g *gerritSource) name() string {
+	return gerritSourceName
+}
+
+// gerritChange is the part of the ChangeInfo of Gerrit used.
+type gerritChange struct {
+	Number          int    `json:"_number"`
+	Project         string `json:"project"`
+	Branch          string `json:"branch"`
+	CurrentRevision string `json:"current_revision"`
+	Labels          map[string]struct {
+		All []struct {
+			Value    int    `json:"value"`
+			Username string `json:"username"`
+		} `json:"all"`
+	} `json:"labels"`
+}
+
+func (g *gerritSource) approvals(org, repo string, pr prInfo) ([]approve.ExternalApproval, error) {
+	m := changeIDReg.FindStringSubmatch(pr.body)
+	if m == nil || pr.headSHA == "" {
+		return nil, nil
+	}
+
+	project := g.project(org, repo)
+
+	changes, err := g.queryChanges(m[1], project, pr.base)
+	if err != nil {
+		return nil, err
+	}
+
+	// The change is checked again rather than trusting the query, and it
+	// must be the same commit as the PR, so that the votes on another code
+	// don't count.
+	var c *gerritChange
+	for i := range changes {
+		v := &changes[i]
+		if v.Project == project && v.Branch == pr.base && v.CurrentRevision == pr.headSHA {
+			c = v
+			break
+		}
+	}
+	if c == nil {
+		return nil, nil
+	}
+
+	link := fmt.Sprintf("%s/c/%s/+/%d", strings.TrimSuffix(g.URL, "/"), c.Project, c.Number)
+
+	var r []approve.ExternalApproval
+	for _, v := range c.Labels["Code-Review"].All {
+		if v.Value < gerritApprovalScore {
+			continue
+		}
+
+		login, ok := g.Accounts[v.Username]
+		if !ok || login == "" {
+			continue
+		}
+
+		r = append(r, approve.ExternalApproval{Login: login, URL: link, Source: gerritSourceName})
+	}
+
+	return r, nil
+}
+
+func (g *gerritSource) queryChanges(changeID, project, branch string) ([]gerritChange, error) {
+	q := fmt.Sprintf("change:%s project:%s branch:%s", changeID, project, branch)
+
+	u := fmt.Sprintf(
+		"%s/changes/?q=%s&o=DETAILED_LABELS&o=DETAILED_ACCOUNTS&o=CURRENT_REVISION",
+		strings.TrimSuffix(g.URL, "/"), url.QueryEscape(q),
+	)
+
+	resp, err := gerritClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("query %s: status code %d", u, resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %v", u, err)
+	}
+
+	var r []gerritChange
+	if err := json.Unmarshal(bytes.TrimPrefix(b, gerritMagicPrefix), &r); err != nil {
+		return nil, fmt.Errorf("query %s: %v", u, err)
+	}
+
+	return r, nil
+}
+
+// externalApprovals returns the approvals of the PR made in the review systems
+// outside. The systems which fail are ignored, so their approvals don't count
+// until they recover.
+func (bot *robot) externalApprovals(org, repo string, pr prInfo, cfg *botConfig, log *logrus.Entry) []approve.ExternalApproval {
+	var r []approve.ExternalApproval
+
+	for _, s := range cfg.ExternalApprovals.sources() {
+		v, err := s.approvals(org, repo, pr)
+		if err != nil {
+			log.WithError(err).Errorf("get the approvals from %s", s.name())
+			continue
+		}
+
+		r = append(r, v...)
+	}
+
+	return r
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGerritApprovals(t *testing.T) {
+	const (
+		changeID = "I0123456789abcdef0123456789abcdef01234567"
+		head     = "1111111111111111111111111111111111111111"
+	)
+
+	type vote struct {
+		Value    int    `json:"value"`
+		Username string `json:"username"`
+	}
+	change := func(project, branch, revision string, votes ...vote) map[string]interface{} {
+		return map[string]interface{}{
+			"_number":          1,
+			"project":          project,
+			"branch":           branch,
+			"current_revision": revision,
+			"labels": map[string]interface{}{
+				"Code-Review": map[string]interface{}{"all": votes},
+			},
+		}
+	}
+
+	cases := []struct {
+		name    string
+		body    string
+		head    string
+		changes []map[string]interface{}
+		status  int
+		logins  []string
+		err     bool
+	}{
+		{
+			name:    "mapped voter of the change of the PR",
+			body:    "fix\n\nChange-Id: " + changeID,
+			head:    head,
+			changes: []map[string]interface{}{change("org/repo", "master", head, vote{2, "alice"})},
+			logins:  []string{"alice-gitee"},
+		},
+		{
+			name: "unmapped voter and vote not enough",
+			body: "Change-Id: " + changeID,
+			head: head,
+			changes: []map[string]interface{}{
+				change("org/repo", "master", head, vote{2, "mallory"}, vote{1, "alice"}),
+			},
+		},
+		{
+			name:    "change of another project",
+			body:    "Change-Id: " + changeID,
+			head:    head,
+			changes: []map[string]interface{}{change("other/repo", "master", head, vote{2, "alice"})},
+		},
+		{
+			name:    "change of another branch",
+			body:    "Change-Id: " + changeID,
+			head:    head,
+			changes: []map[string]interface{}{change("org/repo", "stable", head, vote{2, "alice"})},
+		},
+		{
+			name:    "change of another revision",
+			body:    "Change-Id: " + changeID,
+			head:    head,
+			changes: []map[string]interface{}{change("org/repo", "master", "2222", vote{2, "alice"})},
+		},
+		{
+			name:    "head of the PR unknown",
+			body:    "Change-Id: " + changeID,
+			changes: []map[string]interface{}{change("org/repo", "master", "", vote{2, "alice"})},
+		},
+		{
+			name: "no Change-Id",
+			body: "fix",
+			head: head,
+		},
+		{
+			name:   "gerrit unavailable",
+			body:   "Change-Id: " + changeID,
+			head:   head,
+			status: http.StatusServiceUnavailable,
+			err:    true,
+		},
+	}
+
+	for _, c := range cases {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.status != 0 {
+				w.WriteHeader(c.status)
+				return
+			}
+
+			b, _ := json.Marshal(c.changes)
+			w.Write(append(append([]byte(nil), gerritMagicPrefix...), b...))
+		}))
+
+		g := &gerritSource{
+			URL:      s.URL,
+			Project:  "{org}/{repo}",
+			Accounts: map[string]string{"alice": "alice-gitee"},
+		}
+
+		r, err := g.approvals("org", "repo", prInfo{body: c.body, base: "master", headSHA: c.head})
+		s.Close()
+
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		}
+
+		var logins []string
+		for _, v := range r {
+			logins = append(logins, v.Login)
+		}
+		if len(logins) != len(c.logins) || (len(logins) > 0 && logins[0] != c.logins[0]) {
+			t.Errorf("%s: expect the approvals of %v, but got %v", c.name, c.logins, logins)
+		}
+	}
+}
+
+func TestGerritSourceValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		g    gerritSource
+		err  bool
+	}{
+		{name: "valid", g: gerritSource{URL: "https://gerrit.example.com", Project: "{org}/{repo}"}},
+		{name: "no project", g: gerritSource{URL: "https://gerrit.example.com"}, err: true},
+		{name: "no url", g: gerritSource{Project: "p"}, err: true},
+	}
+
+	for _, c := range cases {
+		if err := c.g.validate(); (err != nil) != c.err {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		}
+	}
+}
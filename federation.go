@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+const ownersPathPrefix = "/v1/owners/"
+
+// federatedPaths declares the files of the repo which are owned by the OWNERS
+// of another org, such as vendored code. The approval of one of the approvers
+// of the owning repo, got from the approve robot of that org, is required.
+type federatedPaths struct {
+	// Paths are the owned files. Each one is a directory or a glob pattern.
+	Paths []string `json:"paths" required:"true"`
+
+	// Endpoint is the base url of the approve robot of the owning org.
+	Endpoint string `json:"endpoint" required:"true"`
+
+	Org    string `json:"org" required:"true"`
+	Repo   string `json:"repo" required:"true"`
+	Branch string `json:"branch" required:"true"`
+
+	// OwnersPath is the path in the owning repo whose approvers own the files.
+	OwnersPath string `json:"owners_path,omitempty"`
+
+	// TokenFile is the file of the token which the robot of the owning org
+	// requires the queries to carry as the bearer token.
+	TokenFile string `json:"token_file,omitempty"`
+}
+
+func (f *federatedPaths) validate() error {
+	if len(f.Paths) == 0 || f.Endpoint == "" || f.Org == "" || f.Repo == "" || f.Branch == "" {
+		return fmt.Errorf("paths, endpoint, org, repo and branch of federated paths must be set")
+	}
+
+	if _, err := url.Parse(f.Endpoint); err != nil {
+		return fmt.Errorf("invalid endpoint of federated paths: %v", err)
+	}
+
+	if err := validatePathPatterns("federated paths", f.Paths); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (f *federatedPaths) matchAny(files []string) bool {
+	for _, file := range files {
+		for _, p := range f.Paths {
+			p = strings.Trim(p, "/")
+			if ok, _ := path.Match(p, file); ok || strings.HasPrefix(file, p+"/") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ownersResponse is the response of the owners API.
+type ownersResponse struct {
+	Approvers []string `json:"approvers"`
+}
+
+var federationClient = http.Client{Timeout: 10 * time.Second}
+
+func queryFederatedApprovers(f *federatedPaths) ([]string, error) {
+	u := fmt.Sprintf(
+		"%s%s%s/%s?branch=%s&path=%s",
+		strings.TrimSuffix(f.Endpoint, "/"), ownersPathPrefix,
+		url.PathEscape(f.Org), url.PathEscape(f.Repo),
+		url.QueryEscape(f.Branch), url.QueryEscape(f.OwnersPath),
+	)
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.TokenFile != "" {
+		b, err := ioutil.ReadFile(f.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("read the token of %s: %v", f.Endpoint, err)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(b)))
+	}
+
+	resp, err := federationClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("query %s: status code %d", u, resp.StatusCode)
+	}
+
+	var r ownersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("query %s: %v", u, err)
+	}
+
+	return r.Approvers, nil
+}
+
+// federatedRequirements returns the requirements of approval by the owning
+// orgs for the files of the PR. A requirement blocks the approval if the
+// approvers can't be got from the owning org.
+func (bot *robot) federatedRequirements(org, repo string, number int, cfg *botConfig, log *logrus.Entry) ([]approvers.Requirement, error) {
+	if len(cfg.Federation) == 0 {
+		return nil, nil
+	}
+
+	changes, err := bot.cli.GetPullRequestChanges(org, repo, number)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, len(changes))
+	for i := range changes {
+		files[i] = changes[i].Filename
+	}
+
+	var reqs []approvers.Requirement
+	for i := range cfg.Federation {
+		f := &cfg.Federation[i]
+		if !f.matchAny(files) {
+			continue
+		}
+
+		owner := fmt.Sprintf("%s/%s", f.Org, f.Repo)
+		if f.OwnersPath != "" {
+			owner += "/" + strings.Trim(f.OwnersPath, "/")
+		}
+
+		v, err := queryFederatedApprovers(f)
+		if err != nil {
+			log.WithError(err).Errorf("query the approvers of %s", owner)
+
+			reqs = append(reqs, approvers.Requirement{
+				Description: fmt.Sprintf("The approvers of %s which owns %s are unavailable now", owner, strings.Join(f.Paths, ", ")),
+			})
+
+			continue
+		}
+
+		reqs = append(reqs, approvers.Requirement{
+			Description: fmt.Sprintf("%s is owned by %s", strings.Join(f.Paths, ", "), owner),
+			Approvers:   sets.NewString(v...),
+		})
+	}
+
+	return reqs, nil
+}
+
+// ownersHandler serves GET /v1/owners/{org}/{repo}?branch={branch}&path={path}
+// which returns the approvers of the path for the robots of other orgs. It
+// reads the OWNERS files of any repo the robot can access, so the requests
+// must carry the owners token or the admin token.
+func (bot *robot) ownersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	v := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, ownersPathPrefix), "/"), "/")
+	if len(v) != 2 {
+		http.Error(w, fmt.Sprintf("the path should be %s{org}/{repo}", ownersPathPrefix), http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	branch := q.Get("branch")
+	if branch == "" {
+		http.Error(w, "missing branch", http.StatusBadRequest)
+		return
+	}
+
+	// The aliases of the repo are unknown if it is not configured.
+	_, c := bot.cfgAgent.GetConfig()
+	cfg, err := bot.getConfig(c, v[0], v[1], branch)
+	if err != nil {
+		cfg = &botConfig{}
+	}
+
+	oc, err := bot.loadOwners(v[0], v[1], branch, cfg)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// Regard the path as a file in the directory so that the OWNERS of the
+	// directory itself is found.
+	dir := oc.FindApproverOwnersForFile(filepath.Join(strings.Trim(q.Get("path"), "/"), "OWNERS"))
+	writeJSON(w, ownersResponse{Approvers: oc.Approvers(filepath.Join(dir, "OWNERS")).List()})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sdk "github.com/opensourceways/go-gitee/gitee"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+// fakeChangesClient serves the files changed by a PR.
+type fakeChangesClient struct {
+	iClient
+
+	files []string
+	err   error
+}
+
+func (c *fakeChangesClient) GetPullRequestChangesPage(org, repo string, number int32, page, perPage int) ([]sdk.PullRequestFiles, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	if page > 1 {
+		return nil, nil
+	}
+
+	r := make([]sdk.PullRequestFiles, len(c.files))
+	for i, f := range c.files {
+		r[i] = sdk.PullRequestFiles{Filename: f}
+	}
+	return r, nil
+}
+
+func TestFederatedRequirements(t *testing.T) {
+	dir, err := ioutil.TempDir("", "federation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	remote := httptest.NewServer(bearerOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("branch") != "master" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(ownersResponse{Approvers: []string{"vendor-owner"}})
+	}, []byte("s3cret")))
+	defer remote.Close()
+
+	federationWithToken := func(branch, tokenFile string) []federatedPaths {
+		return []federatedPaths{{
+			Paths:     []string{"vendor/lib"},
+			Endpoint:  remote.URL,
+			Org:       "upstream",
+			Repo:      "lib",
+			Branch:    branch,
+			TokenFile: tokenFile,
+		}}
+	}
+	federation := func(branch string) []federatedPaths {
+		return federationWithToken(branch, tokenFile)
+	}
+
+	cases := []struct {
+		name       string
+		federation []federatedPaths
+		files      []string
+		changesErr error
+
+		// required are the approvers of the requirements, in which an empty
+		// one can't be met.
+		required [][]string
+		err      bool
+	}{
+		{
+			name:  "no federation",
+			files: []string{"vendor/lib/a.go"},
+		},
+		{
+			name:       "files not owned",
+			federation: federation("master"),
+			files:      []string{"README.md"},
+		},
+		{
+			name:       "files owned by the other org",
+			federation: federation("master"),
+			files:      []string{"README.md", "vendor/lib/a.go"},
+			required:   [][]string{{"vendor-owner"}},
+		},
+		{
+			name:       "owning org unavailable",
+			federation: federation("stable"),
+			files:      []string{"vendor/lib/a.go"},
+			required:   [][]string{{}},
+		},
+		{
+			name:       "no token for the owning org",
+			federation: federationWithToken("master", ""),
+			files:      []string{"vendor/lib/a.go"},
+			required:   [][]string{{}},
+		},
+		{
+			name:       "token file missing",
+			federation: federationWithToken("master", filepath.Join(dir, "missing")),
+			files:      []string{"vendor/lib/a.go"},
+			required:   [][]string{{}},
+		},
+		{
+			name:       "files of the PR unavailable",
+			federation: federation("master"),
+			changesErr: fmt.Errorf("timeout"),
+			err:        true,
+		},
+	}
+
+	log := logrus.NewEntry(logrus.New())
+
+	for _, c := range cases {
+		bot := &robot{cli: newGHClient(&fakeChangesClient{files: c.files, err: c.changesErr})}
+		cfg := &botConfig{Federation: c.federation}
+
+		reqs, err := bot.federatedRequirements("org", "repo", 1, cfg, log)
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+
+		if len(reqs) != len(c.required) {
+			t.Errorf("%s: expect %d requirements, but got %v", c.name, len(c.required), reqs)
+			continue
+		}
+
+		for i, r := range reqs {
+			if !r.Approvers.Equal(sets.NewString(c.required[i]...)) {
+				t.Errorf("%s: expect the approvers %v, but got %v", c.name, c.required[i], r.Approvers.List())
+			}
+		}
+
+		// The requirements are not met by the other approvers.
+		ap := approvers.NewApprovers(approvers.NewOwners(log, c.files, emptyOwnersRepo{}, 1))
+		ap.Requirements = reqs
+		ap.AddApprover("root", "", false)
+		if n := len(ap.UnmetRequirements()); n != len(reqs) {
+			t.Errorf("%s: expect %d unmet requirements, but got %d", c.name, len(reqs), n)
+		}
+	}
+}
+
+func TestOwnersHandlerAuth(t *testing.T) {
+	cases := []struct {
+		name   string
+		owners string
+		admin  string
+		header string
+		status int
+	}{
+		{name: "owners token", owners: "o", admin: "a", header: "Bearer o", status: http.StatusOK},
+		{name: "admin token", owners: "o", admin: "a", header: "Bearer a", status: http.StatusOK},
+		{name: "admin token only configured", admin: "a", header: "Bearer a", status: http.StatusOK},
+		{name: "wrong token", owners: "o", admin: "a", header: "Bearer x", status: http.StatusUnauthorized},
+		{name: "no token", owners: "o", status: http.StatusUnauthorized},
+		{name: "empty token", owners: "o", header: "Bearer ", status: http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		bot := &robot{ownersToken: []byte(c.owners), adminToken: []byte(c.admin)}
+		h := bearerOnly(func(w http.ResponseWriter, r *http.Request) {}, bot.ownersToken, bot.adminToken)
+
+		r := httptest.NewRequest(http.MethodGet, ownersPathPrefix+"org/repo?branch=master", nil)
+		if c.header != "" {
+			r.Header.Set("Authorization", c.header)
+		}
+
+		w := httptest.NewRecorder()
+		h(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("%s: expect status %d, but got %d", c.name, c.status, w.Code)
+		}
+	}
+}
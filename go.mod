@@ -15,7 +15,13 @@ require (
 	github.com/opensourceways/community-robot-lib v0.0.0-20220118064921-28924d0a1246
 	github.com/opensourceways/go-gitee v0.0.0-20220120022149-6d34985edf4f
 	github.com/opensourceways/repo-owners-cache v0.0.0-20211230083539-49b1f537c8cd
+	github.com/prometheus/client_golang v1.7.0
 	github.com/sirupsen/logrus v1.8.1
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	go.uber.org/zap v1.15.0
+	golang.org/x/sync v0.0.0-20201020160332-67f06af15bc9
 	k8s.io/apimachinery v0.23.1
 	k8s.io/test-infra v0.0.0-20200522021239-7ab687ff3213
+	sigs.k8s.io/yaml v1.3.0
 )
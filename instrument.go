@@ -0,0 +1,194 @@
+package main
+
+import (
+	"time"
+
+	"github.com/opensourceways/community-robot-lib/giteeclient"
+	sdk "github.com/opensourceways/go-gitee/gitee"
+
+	"github.com/opensourceways/robot-gitee-approve/metrics"
+)
+
+// instrumentedClient counts the calls to the Gitee API and their duration,
+// and traces them if the tracer is set.
+type instrumentedClient struct {
+	iClient
+
+	t *tracer
+}
+
+func (c instrumentedClient) observe(method, org, repo string, number int32, start time.Time, err error) {
+	metrics.ObserveAPICall(method, start, err)
+
+	if org != "" {
+		c.t.record("gitee."+method, org, repo, int(number), start, err)
+	}
+}
+
+func (c instrumentedClient) GetPullRequestChangesPage(org, repo string, number int32, page, perPage int) ([]sdk.PullRequestFiles, error) {
+	start := time.Now()
+	v, err := c.iClient.GetPullRequestChangesPage(org, repo, number, page, perPage)
+	c.observe("GetPullRequestChangesPage", org, repo, number, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) GetPRLabels(org, repo string, number int32) ([]sdk.Label, error) {
+	start := time.Now()
+	v, err := c.iClient.GetPRLabels(org, repo, number)
+	c.observe("GetPRLabels", org, repo, number, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) ListPRComments(org, repo string, number int32) ([]sdk.PullRequestComments, error) {
+	start := time.Now()
+	v, err := c.iClient.ListPRComments(org, repo, number)
+	c.observe("ListPRComments", org, repo, number, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) DeletePRComment(org, repo string, ID int32) error {
+	start := time.Now()
+	err := c.iClient.DeletePRComment(org, repo, ID)
+	c.observe("DeletePRComment", org, repo, 0, start, err)
+	return err
+}
+
+func (c instrumentedClient) UpdatePRComment(org, repo string, commentID int32, comment string) error {
+	start := time.Now()
+	err := c.iClient.UpdatePRComment(org, repo, commentID, comment)
+	c.observe("UpdatePRComment", org, repo, 0, start, err)
+	return err
+}
+
+func (c instrumentedClient) CreatePRComment(org, repo string, number int32, comment string) error {
+	start := time.Now()
+	err := c.iClient.CreatePRComment(org, repo, number, comment)
+	c.observe("CreatePRComment", org, repo, number, start, err)
+	return err
+}
+
+func (c instrumentedClient) GetBot() (sdk.User, error) {
+	start := time.Now()
+	v, err := c.iClient.GetBot()
+	c.observe("GetBot", "", "", 0, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) GetGiteePullRequest(org, repo string, number int32) (sdk.PullRequest, error) {
+	start := time.Now()
+	v, err := c.iClient.GetGiteePullRequest(org, repo, number)
+	c.observe("GetGiteePullRequest", org, repo, number, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) AddPRLabel(org, repo string, number int32, label string) error {
+	start := time.Now()
+	err := c.iClient.AddPRLabel(org, repo, number, label)
+	c.observe("AddPRLabel", org, repo, number, start, err)
+	return err
+}
+
+func (c instrumentedClient) RemovePRLabel(org, repo string, number int32, label string) error {
+	start := time.Now()
+	err := c.iClient.RemovePRLabel(org, repo, number, label)
+	c.observe("RemovePRLabel", org, repo, number, start, err)
+	return err
+}
+
+func (c instrumentedClient) GetUserPermissionsOfRepo(org, repo, login string) (sdk.ProjectMemberPermission, error) {
+	start := time.Now()
+	v, err := c.iClient.GetUserPermissionsOfRepo(org, repo, login)
+	c.observe("GetUserPermissionsOfRepo", org, repo, 0, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) GetPullRequests(org, repo string, opts giteeclient.ListPullRequestOpt) ([]sdk.PullRequest, error) {
+	start := time.Now()
+	v, err := c.iClient.GetPullRequests(org, repo, opts)
+	c.observe("GetPullRequests", org, repo, 0, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) GetPRCommits(org, repo string, number int32) ([]sdk.PullRequestCommits, error) {
+	start := time.Now()
+	v, err := c.iClient.GetPRCommits(org, repo, number)
+	c.observe("GetPRCommits", org, repo, number, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) ListPROperationLogs(org, repo string, number int32) ([]sdk.OperateLog, error) {
+	start := time.Now()
+	v, err := c.iClient.ListPROperationLogs(org, repo, number)
+	c.observe("ListPROperationLogs", org, repo, number, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) GetPathContent(org, repo, path, ref string) (sdk.Content, error) {
+	start := time.Now()
+	v, err := c.iClient.GetPathContent(org, repo, path, ref)
+	c.observe("GetPathContent", org, repo, 0, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) AssignPR(owner, repo string, number int32, logins []string) error {
+	start := time.Now()
+	err := c.iClient.AssignPR(owner, repo, number, logins)
+	c.observe("AssignPR", owner, repo, number, start, err)
+	return err
+}
+
+func (c instrumentedClient) GetPRComment(org, repo string, commentID int32) (sdk.PullRequestComments, error) {
+	start := time.Now()
+	v, err := c.iClient.GetPRComment(org, repo, commentID)
+	c.observe("GetPRComment", org, repo, 0, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) GetRepoTree(org, repo, ref string) ([]treeEntry, error) {
+	start := time.Now()
+	v, err := c.iClient.GetRepoTree(org, repo, ref)
+	c.observe("GetRepoTree", org, repo, 0, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) GetRepos(org string) ([]sdk.Project, error) {
+	start := time.Now()
+	v, err := c.iClient.GetRepos(org)
+	c.observe("GetRepos", "", "", 0, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) GetRepoLabels(owner, repo string) ([]sdk.Label, error) {
+	start := time.Now()
+	v, err := c.iClient.GetRepoLabels(owner, repo)
+	c.observe("GetRepoLabels", owner, repo, 0, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) CreateRepoLabel(org, repo, label, color string) error {
+	start := time.Now()
+	err := c.iClient.CreateRepoLabel(org, repo, label, color)
+	c.observe("CreateRepoLabel", org, repo, 0, start, err)
+	return err
+}
+
+func (c instrumentedClient) CreateIssue(org, repo, title, body string) (sdk.Issue, error) {
+	start := time.Now()
+	v, err := c.iClient.CreateIssue(org, repo, title, body)
+	c.observe("CreateIssue", org, repo, 0, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) ListCommentReactions(org, repo string, commentID int32) ([]commentReaction, error) {
+	start := time.Now()
+	v, err := c.iClient.ListCommentReactions(org, repo, commentID)
+	c.observe("ListCommentReactions", org, repo, 0, start, err)
+	return v, err
+}
+
+func (c instrumentedClient) CreateCheckRun(org, repo string, run checkRun) error {
+	start := time.Now()
+	err := c.iClient.CreateCheckRun(org, repo, run)
+	c.observe("CreateCheckRun", org, repo, 0, start, err)
+	return err
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	logBackendLogrus = "logrus"
+	logBackendZap    = "zap"
+
+	// logModuleField is the field naming the module which logs an entry.
+	logModuleField = "module"
+)
+
+type logOptions struct {
+	backend  string
+	level    string
+	format   string
+	sampling string
+}
+
+func (o *logOptions) validate() error {
+	if o.level != "" {
+		if _, err := logrus.ParseLevel(o.level); err != nil {
+			return err
+		}
+	}
+
+	switch o.backend {
+	case "", logBackendLogrus, logBackendZap:
+	default:
+		return fmt.Errorf("unknown log backend: %s", o.backend)
+	}
+
+	switch o.format {
+	case "", logFormatText, logFormatJSON:
+	default:
+		return fmt.Errorf("unknown log format: %s", o.format)
+	}
+
+	_, err := parseLogSampling(o.sampling)
+	return err
+}
+
+// parseLogSampling parses the sampling rates of the form "module=N,...",
+// which means one in every N debug lines of the module is kept.
+func parseLogSampling(s string) (map[string]uint64, error) {
+	rates := map[string]uint64{}
+	if s == "" {
+		return rates, nil
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		v := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(v) != 2 || v[0] == "" {
+			return nil, fmt.Errorf("invalid log sampling: %s", item)
+		}
+
+		n, err := strconv.ParseUint(v[1], 10, 64)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid log sampling rate: %s", item)
+		}
+
+		rates[v[0]] = n
+	}
+
+	return rates, nil
+}
+
+// setupLogging applies the options to the standard logger. The formatter
+// set by the component initialization is kept if no format is specified.
+//
+// The code logs by logrus whichever the backend is. The zap backend takes
+// over the output of the entries of logrus, so that the level and the
+// sampling apply to both backends alike.
+func setupLogging(o *logOptions) error {
+	if o.level != "" {
+		level, err := logrus.ParseLevel(o.level)
+		if err != nil {
+			return err
+		}
+		logrus.SetLevel(level)
+	}
+
+	var f logrus.Formatter
+	switch {
+	case o.backend == logBackendZap:
+		zf := newZapFormatter(o.format, logrus.StandardLogger().Out)
+		logrus.RegisterExitHandler(zf.sync)
+		logrus.SetOutput(ioutil.Discard)
+		f = zf
+	case o.format == logFormatText:
+		f = &logrus.TextFormatter{FullTimestamp: true}
+	case o.format == logFormatJSON:
+		f = &logrus.JSONFormatter{}
+	default:
+		f = logrus.StandardLogger().Formatter
+	}
+
+	rates, err := parseLogSampling(o.sampling)
+	if err != nil {
+		return err
+	}
+
+	if len(rates) > 0 {
+		f = &samplingFormatter{Formatter: f, rates: rates, counts: map[string]uint64{}}
+	}
+
+	logrus.SetFormatter(f)
+
+	return nil
+}
+
+// samplingFormatter drops the debug lines of the modules with sampling rates
+// except one in every N lines of the same message. A formatter is used
+// because the hooks of logrus can't drop an entry.
+type samplingFormatter struct {
+	logrus.Formatter
+
+	rates  map[string]uint64
+	lock   sync.Mutex
+	counts map[string]uint64
+}
+
+func (f *samplingFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	if e.Level < logrus.DebugLevel {
+		return f.Formatter.Format(e)
+	}
+
+	module, _ := e.Data[logModuleField].(string)
+	rate, ok := f.rates[module]
+	if !ok || rate == 1 {
+		return f.Formatter.Format(e)
+	}
+
+	key := module + "/" + e.Message
+
+	f.lock.Lock()
+	n := f.counts[key]
+	f.counts[key] = n + 1
+	f.lock.Unlock()
+
+	if n%rate != 0 {
+		return nil, nil
+	}
+
+	return f.Formatter.Format(e)
+}
+
+// zapFormatter writes the entries of logrus by zap instead of formatting
+// them, and returns nothing for logrus to write. It is called by logrus with
+// the lock of the logger held, so the entries are written in order.
+type zapFormatter struct {
+	core zapcore.Core
+}
+
+// newZapFormatter writes the entries in json, or in the console format of
+// zap if the format is text. The level of zap accepts all the entries because
+// logrus has filtered them by its level.
+func newZapFormatter(format string, out io.Writer) *zapFormatter {
+	ec := zap.NewProductionEncoderConfig()
+	ec.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	enc := zapcore.NewJSONEncoder(ec)
+	if format == logFormatText {
+		enc = zapcore.NewConsoleEncoder(ec)
+	}
+
+	return &zapFormatter{
+		core: zapcore.NewCore(enc, zapcore.Lock(zapcore.AddSync(out)), zapcore.DebugLevel),
+	}
+}
+
+func (f *zapFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	ce := f.core.Check(zapcore.Entry{
+		Level:   zapLevel(e.Level),
+		Time:    e.Time,
+		Message: e.Message,
+	}, nil)
+	if ce == nil {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(e.Data))
+	for k := range e.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// The component is set as a default field of the formatter by the
+	// component initialization, which this formatter replaces.
+	fields := make([]zapcore.Field, 0, len(keys)+1)
+	fields = append(fields, zap.Any("component", botName))
+	for _, k := range keys {
+		fields = append(fields, zap.Any(k, e.Data[k]))
+	}
+
+	// The core is checked directly rather than by a logger of zap, which
+	// exits or panics on the fatal or panic entries itself, while logrus
+	// does it after writing them.
+	ce.Write(fields...)
+
+	return nil, nil
+}
+
+// sync flushes the entries before logrus exits on a fatal entry.
+func (f *zapFormatter) sync() {
+	_ = f.core.Sync()
+}
+
+func zapLevel(l logrus.Level) zapcore.Level {
+	switch l {
+	case logrus.PanicLevel:
+		return zapcore.PanicLevel
+	case logrus.FatalLevel:
+		return zapcore.FatalLevel
+	case logrus.ErrorLevel:
+		return zapcore.ErrorLevel
+	case logrus.WarnLevel:
+		return zapcore.WarnLevel
+	case logrus.InfoLevel:
+		return zapcore.InfoLevel
+	default:
+		return zapcore.DebugLevel
+	}
+}
@@ -1,26 +1,59 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"time"
 
-	"github.com/opensourceways/community-robot-lib/giteeclient"
+	"github.com/opensourceways/community-robot-lib/config"
 	"github.com/opensourceways/community-robot-lib/logrusutil"
 	liboptions "github.com/opensourceways/community-robot-lib/options"
 	"github.com/opensourceways/community-robot-lib/robot-gitee-framework"
 	"github.com/opensourceways/community-robot-lib/secret"
 	"github.com/opensourceways/repo-owners-cache/grpc/client"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 
 	"github.com/opensourceways/robot-gitee-approve/approve"
 )
 
 type options struct {
-	service     liboptions.ServiceOptions
-	gitee       liboptions.GiteeOptions
-	cacheServer string
-	commandLink string
+	service      liboptions.ServiceOptions
+	gitee        liboptions.GiteeOptions
+	cacheServer  string
+	commandLink  string
+	snapshotFile string
+	oauthApp     string
+	settingFile  string
+	loadFile     string
+	recordEvents string
+	replayEvents string
+	lintOwners   string
+	onboard      string
+	checkConfig  string
+	role         string
+	auditSink    string
+	traceSink    string
+	spoolDir     string
+	spoolPoll    time.Duration
+	stallTimeout time.Duration
+	fetchTimeout time.Duration
+	debounce     time.Duration
+	maxRetries   int
+	writers      int
+	writeSpacing time.Duration
+	dryRun       bool
+	verifySecret string
+	refreshToken string
+	adminToken   string
+	ownersToken  string
+	scrubPersist bool
+	persistKey   string
+	log          logOptions
 }
 
 func (o *options) Validate() error {
@@ -36,6 +69,71 @@ func (o *options) Validate() error {
 		return fmt.Errorf("missing command-link")
 	}
 
+	if err := o.log.validate(); err != nil {
+		return err
+	}
+
+	if o.stallTimeout < 0 {
+		return fmt.Errorf("stall-timeout can't be negative")
+	}
+
+	if o.fetchTimeout < 0 {
+		return fmt.Errorf("fetch-timeout can't be negative")
+	}
+
+	if o.debounce < 0 {
+		return fmt.Errorf("debounce-window can't be negative")
+	}
+
+	if o.maxRetries < 0 {
+		return fmt.Errorf("max-retries can't be negative")
+	}
+
+	if o.writers < 0 {
+		return fmt.Errorf("comment-writers can't be negative")
+	}
+
+	if o.writeSpacing < 0 {
+		return fmt.Errorf("comment-write-interval can't be negative")
+	}
+
+	switch o.role {
+	case roleAll:
+	case roleFrontend, roleWorker:
+		if o.spoolDir == "" {
+			return fmt.Errorf("spool-dir must be set for the role of %s", o.role)
+		}
+
+		if o.replayEvents != "" {
+			return fmt.Errorf("replay-events can't be set for the role of %s", o.role)
+		}
+
+	default:
+		return fmt.Errorf("unknown role: %s", o.role)
+	}
+
+	if o.spoolPoll <= 0 {
+		return fmt.Errorf("spool-poll-interval must be positive")
+	}
+
+	if o.recordEvents != "" && o.replayEvents != "" {
+		return fmt.Errorf("record-events and replay-events can't be set at the same time")
+	}
+
+	if o.lintOwners != "" {
+		if _, _, _, err := parseLintTarget(o.lintOwners); err != nil {
+			return err
+		}
+	}
+
+	if o.onboard != "" && o.replayEvents != "" {
+		return fmt.Errorf("onboard and replay-events can't be set at the same time")
+	}
+
+	if o.oauthApp != "" || o.replayEvents != "" {
+		return nil
+	}
+
 	return o.gitee.Validate()
 }
 
@@ -46,6 +144,37 @@ func gatherOptions(fs *flag.FlagSet, args ...string) options {
 	o.service.AddFlags(fs)
 	fs.StringVar(&o.cacheServer, "cache-server", "", "the cache server address.")
 	fs.StringVar(&o.commandLink, "command-link", "", "the link to command usage.")
+	fs.StringVar(&o.snapshotFile, "snapshot-file", "", "the file to persist the approval snapshots of PRs. Keep them in memory only if empty.")
+	fs.StringVar(&o.settingFile, "setting-file", "", "the file to persist the repo options set by comments. Keep them in memory only if empty.")
+	fs.StringVar(&o.loadFile, "suggestion-load-file", "", "the file to persist the approvers suggested for PRs recently, by which the least-loaded suggestion strategy orders approvers. Keep them in memory only if empty.")
+	fs.StringVar(&o.log.backend, "log-backend", logBackendLogrus, "the logging backend, logrus or zap. The level, the format and the sampling of the logs apply to both.")
+	fs.StringVar(&o.log.level, "log-level", "", "the log level. Keep the default one if empty.")
+	fs.StringVar(&o.log.format, "log-format", "", "the log format, text or json. Keep the default one if empty.")
+	fs.StringVar(&o.log.sampling, "log-sampling", "", "the sampling rates of debug logs in the form of module=N,... which keeps one in every N lines, such as approve=10.")
+	fs.StringVar(&o.oauthApp, "oauth-app", "", "the file of the OAuth app credential in json. Authenticate as the OAuth app instead of by the token if set.")
+	fs.StringVar(&o.recordEvents, "record-events", "", "the directory to record the webhook events and the responses of Gitee API in.")
+	fs.DurationVar(&o.stallTimeout, "stall-timeout", 0, "the time after which the robot is reported as not ready if events are received but none is processed successfully. Disabled if 0.")
+	fs.DurationVar(&o.fetchTimeout, "fetch-timeout", 0, "the timeout of each of the concurrent fetches of a PR from Gitee when handling it. The default 20s is used if 0.")
+	fs.DurationVar(&o.debounce, "debounce-window", 0, "the window in which the events of a PR are coalesced into one handling, and each PR is handled serially. Disabled if 0.")
+	fs.IntVar(&o.maxRetries, "max-retries", 0, "the max number of the retries, with exponential backoff, of handling a PR which failed with a transient error. Enables the queue of PRs as the debounce-window does. Disabled if 0.")
+	fs.IntVar(&o.writers, "comment-writers", 0, "the number of the workers making the writes of comments asynchronously. The writes of a repo are made in order by the same worker. Comments are written synchronously if 0.")
+	fs.DurationVar(&o.writeSpacing, "comment-write-interval", 0, "the interval between the asynchronous writes of comments made by a worker, to smooth the bursts under the rate limits.")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "log the changes to PRs, such as the labels and comments, instead of making them for all the repos.")
+	fs.StringVar(&o.verifySecret, "verify-secret-file", "", "the file of the secret to sign the verdicts of "+verifyPath+" with. The endpoint is disabled if empty.")
+	fs.StringVar(&o.refreshToken, "refresh-token-file", "", "the file of the token which the requests of "+refreshPathPrefix+" must carry in the Authorization header as Bearer <token>. The endpoint is disabled if empty.")
+	fs.StringVar(&o.adminToken, "admin-token-file", "", "the file of the token which the requests of the admin endpoints, "+dryRunPath+", "+dashboardPath+", "+debugStatePath+" and "+ownersLintPathPrefix+", must carry in the Authorization header as Bearer <token>. The admin endpoints are disabled if empty.")
+	fs.StringVar(&o.ownersToken, "owners-token-file", "", "the file of the token which the robots of the other orgs must carry as Bearer <token> to query "+ownersPathPrefix+" for the federation, which accepts the admin token too. The endpoint is disabled if neither is set.")
+	fs.StringVar(&o.lintOwners, "lint-owners", "", "lint the OWNERS files of the branch of the repo in the form of org/repo:branch, write the findings in json to stdout and exit.")
+	fs.StringVar(&o.onboard, "onboard", "", "onboard all the repos of the org configured for the robot: check their root OWNERS files, create the labels, post an issue describing the approval policy to the repos whose approved label is created, handle their open PRs, write the report in json to stdout and exit.")
+	fs.StringVar(&o.checkConfig, "validate-config", "", "validate the config file strictly, in which the unknown options are errors too, and exit with the status of 1 if it is invalid or 0 otherwise. The other options are not required.")
+	fs.BoolVar(&o.scrubPersist, "scrub-persisted", false, "scrub the emails and the credentials, such as the tokens pasted in comments, from the recorded events and the audit log before writing them. The data which the robot works on, such as the snapshots and the spooled events, is never scrubbed.")
+	fs.StringVar(&o.persistKey, "persist-key-file", "", "the file of the 32-byte key in hex or base64 to encrypt the snapshots, the settings, the suggestion load, the spooled events and the recorded events with AES-GCM. They are not encrypted if empty, and those not encrypted are still read if set.")
+	fs.StringVar(&o.auditSink, "audit-sink", "", "where to write the audit log of the changes of the labels with the evidence of the approvals, which is stdout, file:<path> or the url of a webhook receiving each record in json. Disabled if empty.")
+	fs.StringVar(&o.traceSink, "trace-sink", "", "where to export the traces of the handling of the webhook deliveries, with a span for each Gitee API call and OWNERS lookup, which is stdout, file:<path> writing the spans in json lines, or the url of an OpenTelemetry collector receiving OTLP/HTTP in json, such as http://collector:4318/v1/traces. The logs carry the trace-id of the delivery. Disabled if empty.")
+	fs.StringVar(&o.role, "role", roleAll, "the role to run as, which is one of all, frontend and worker. The frontend verifies the webhook events and writes them to spool-dir only, and the worker handles the events in spool-dir and serves the other endpoints, so that they can be deployed and scaled separately. All does both without the spool.")
+	fs.StringVar(&o.spoolDir, "spool-dir", "", "the directory shared by the frontend and the worker to pass the events by. Required for the roles of frontend and worker.")
+	fs.DurationVar(&o.spoolPoll, "spool-poll-interval", time.Second, "the interval at which the worker polls spool-dir for the new events.")
+	fs.StringVar(&o.replayEvents, "replay-events", "", "the directory of the recorded events to replay with a fake client which only logs the changes. Exit after replaying.")
 
 	fs.Parse(args)
 	return o
@@ -55,18 +184,100 @@ func main() {
 	logrusutil.ComponentInit(botName)
 
 	o := gatherOptions(flag.NewFlagSet(os.Args[0], flag.ExitOnError), os.Args[1:]...)
+	if o.checkConfig != "" {
+		if err := validateConfigFile(o.checkConfig); err != nil {
+			logrus.WithError(err).Fatal("Invalid config.")
+		}
+
+		logrus.Info("The config is valid.")
+
+		return
+	}
+
 	if err := o.Validate(); err != nil {
 		logrus.WithError(err).Fatal("Invalid options")
 	}
 
+	if err := setupLogging(&o.log); err != nil {
+		logrus.WithError(err).Fatal("Error setting up logging.")
+	}
+
 	approve.SetBotCommandLink(o.commandLink)
+	approve.SetFetchTimeout(o.fetchTimeout)
+
+	persist, err := newPersistProtection(o.scrubPersist, o.persistKey)
+	if err != nil {
+		logrus.WithError(err).Fatal("Error loading persist key.")
+	}
+
+	var c iClient
+	if o.replayEvents != "" {
+		rc, err := newReplayClient(o.replayEvents, persist)
+		if err != nil {
+			logrus.WithError(err).Fatal("Error loading recorded responses.")
+		}
+
+		c = rc
+	} else if o.oauthApp != "" {
+		ts, err := newOAuthTokenSource(o.oauthApp)
+		if err != nil {
+			logrus.WithError(err).Fatal("Error getting oauth token.")
+		}
+
+		sc := newSwappableClient(newGiteeClient(ts.token))
+		ts.start(func(token []byte) {
+			sc.set(newGiteeClient(func() []byte { return token }))
+		})
+
+		defer ts.stop()
+
+		c = sc
+	} else {
+		secretAgent := new(secret.Agent)
+		if err := secretAgent.Start([]string{o.gitee.TokenPath}); err != nil {
+			logrus.WithError(err).Fatal("Error starting secret agent.")
+		}
+
+		defer secretAgent.Stop()
+
+		c = newGiteeClient(secretAgent.GetTokenGenerator(o.gitee.TokenPath))
+	}
+
+	var tr *tracer
+	if o.traceSink != "" && o.replayEvents == "" {
+		if tr, err = newTracer(o.traceSink); err != nil {
+			logrus.WithError(err).Fatal("Error opening trace sink.")
+		}
 
-	secretAgent := new(secret.Agent)
-	if err := secretAgent.Start([]string{o.gitee.TokenPath}); err != nil {
-		logrus.WithError(err).Fatal("Error starting secret agent.")
+		tr.start()
+
+		defer tr.shutdown()
+	}
+
+	if o.replayEvents == "" {
+		c = instrumentedClient{iClient: c, t: tr}
 	}
 
-	defer secretAgent.Stop()
+	var recorder *eventRecorder
+	if o.recordEvents != "" {
+		v, err := newEventRecorder(o.recordEvents, persist)
+		if err != nil {
+			logrus.WithError(err).Fatal("Error starting event recorder.")
+		}
+
+		recorder = v
+		c = recordingClient{iClient: c, r: v}
+	}
+
+	var writer *commentWriter
+	if o.writers > 0 && o.replayEvents == "" && o.role != roleFrontend {
+		writer = newCommentWriter(c, o.writers, o.writeSpacing)
+		writer.start()
+
+		defer writer.stop()
+
+		c = writer
+	}
 
 	cacheClient, err := client.NewClient(o.cacheServer)
 	if err != nil {
@@ -79,14 +290,212 @@ func main() {
 		}
 	}()
 
-	c := giteeclient.NewClient(secretAgent.GetTokenGenerator(o.gitee.TokenPath))
+	snapshots, err := newSnapshotStore(o.snapshotFile, persist.withoutScrub())
+	if err != nil {
+		logrus.WithError(err).Fatal("init snapshot store fail")
+	}
+
+	snapshots.start()
+
+	defer snapshots.shutdown()
 
-	v, err := c.GetBot()
+	settings, err := newSettingStore(o.settingFile, persist.withoutScrub())
 	if err != nil {
-		logrus.WithError(err).Error("Error get bot name")
+		logrus.WithError(err).Fatal("init setting store fail")
+	}
+
+	suggestions, err := newSuggestionLoadStore(o.loadFile, persist.withoutScrub())
+	if err != nil {
+		logrus.WithError(err).Fatal("init suggestion load store fail")
+	}
+
+	// The config agent of framework is not accessible, so start another one
+	// for the requests which are not triggered by webhook events.
+	cfgAgent := config.NewConfigAgent(func() config.Config { return new(configuration) })
+	if err := cfgAgent.Start(o.service.ConfigFile); err != nil {
+		logrus.WithError(err).Fatal("Error starting config agent.")
+	}
+
+	defer cfgAgent.Stop()
+
+	r := newRobot(c, cacheClient, &cfgAgent, snapshots, settings)
+	r.recorder = recorder
+	r.persist = persist
+	r.suggested = suggestions
+	r.writer = writer
+	r.tracer = tr
+
+	if o.auditSink != "" {
+		if r.audit, err = newAuditLog(o.auditSink, o.scrubPersist); err != nil {
+			logrus.WithError(err).Fatal("Error opening audit sink.")
+		}
+	}
+	r.cli.dryRun = o.dryRun
+
+	if o.lintOwners != "" {
+		if err := r.lintOwnersTo(o.lintOwners, os.Stdout); err != nil {
+			logrus.WithError(err).Fatal("Error linting OWNERS files.")
+		}
+
+		return
 	}
 
-	r := newRobot(c, cacheClient, v.Login)
+	if o.onboard != "" {
+		if err := r.onboardTo(o.onboard, os.Stdout); err != nil {
+			logrus.WithError(err).Fatal("Error onboarding the org.")
+		}
+
+		return
+	}
+
+	var spool *eventSpool
+	if o.role != roleAll {
+		if spool, err = newEventSpool(o.spoolDir, persist.withoutScrub()); err != nil {
+			logrus.WithError(err).Fatal("Error opening the spool.")
+		}
+	}
+
+	if o.role == roleFrontend {
+		runFrontend(r, spool, o.service)
+
+		return
+	}
+
+	if o.verifySecret != "" {
+		b, err := ioutil.ReadFile(o.verifySecret)
+		if err != nil {
+			logrus.WithError(err).Fatal("Error reading verify secret.")
+		}
+
+		r.verifySecret = bytes.TrimSpace(b)
+		if len(r.verifySecret) == 0 {
+			logrus.Fatal("The verify secret is empty.")
+		}
+
+		http.HandleFunc(verifyPath, r.verifyHandler)
+	}
+
+	if o.refreshToken != "" {
+		b, err := ioutil.ReadFile(o.refreshToken)
+		if err != nil {
+			logrus.WithError(err).Fatal("Error reading refresh token.")
+		}
+
+		r.refreshToken = bytes.TrimSpace(b)
+		if len(r.refreshToken) == 0 {
+			logrus.Fatal("The refresh token is empty.")
+		}
+
+		http.HandleFunc(refreshPathPrefix, r.refreshHandler)
+	}
+
+	if o.adminToken != "" {
+		b, err := ioutil.ReadFile(o.adminToken)
+		if err != nil {
+			logrus.WithError(err).Fatal("Error reading admin token.")
+		}
+
+		r.adminToken = bytes.TrimSpace(b)
+		if len(r.adminToken) == 0 {
+			logrus.Fatal("The admin token is empty.")
+		}
+
+		http.HandleFunc(dryRunPath, r.adminOnly(r.dryRunHandler))
+		http.HandleFunc(dashboardPath, r.adminOnly(snapshots.dashboardHandler))
+		http.HandleFunc(debugStatePath, r.adminOnly(r.debugStateHandler))
+		http.HandleFunc(ownersLintPathPrefix, r.adminOnly(r.ownersLintHandler))
+	}
 
+	if o.ownersToken != "" {
+		b, err := ioutil.ReadFile(o.ownersToken)
+		if err != nil {
+			logrus.WithError(err).Fatal("Error reading owners token.")
+		}
+
+		r.ownersToken = bytes.TrimSpace(b)
+		if len(r.ownersToken) == 0 {
+			logrus.Fatal("The owners token is empty.")
+		}
+	}
+
+	if len(r.ownersToken) > 0 || len(r.adminToken) > 0 {
+		http.HandleFunc(ownersPathPrefix, bearerOnly(r.ownersHandler, r.ownersToken, r.adminToken))
+	}
+
+	if o.stallTimeout > 0 && o.replayEvents == "" {
+		r.watchdog = newWatchdog(o.stallTimeout)
+		r.watchdog.start()
+
+		defer r.watchdog.stop()
+	}
+
+	if (o.debounce > 0 || o.maxRetries > 0) && o.replayEvents == "" {
+		r.queue = newPRQueue(o.debounce, o.maxRetries, r.runJob)
+
+		defer r.queue.wait()
+	}
+
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/funnel", snapshots.funnelHandler)
+	http.HandleFunc(statusPathPrefix, r.statusHandler)
+	http.HandleFunc(simulatePathPrefix, r.simulateHandler)
+	http.HandleFunc(coveragePathPrefix, r.coverageHandler)
+	http.HandleFunc(seriesPathPrefix, r.seriesHandler)
+
+	if o.role == roleAll {
+		hook := newWebhookGuard(r)
+		http.Handle(webhookPath, hook)
+
+		defer hook.wait()
+	}
+
+	http.HandleFunc("/readyz", r.watchdog.readyHandler)
+
+	if _, err := r.cli.BotName(); err != nil {
+		logrus.WithError(err).Fatal("Error get bot name")
+	}
+
+	if r.queue != nil {
+		go r.resumeQueued()
+	}
+
+	if o.replayEvents == "" {
+		sweeper := newExpirySweeper(r)
+		sweeper.start()
+
+		defer sweeper.shutdown()
+	}
+
+	if spool != nil {
+		w := newSpoolWorker(r, spool, o.spoolPoll)
+		w.start()
+
+		defer w.stop()
+	}
+
+	if o.replayEvents != "" {
+		if err := r.replayEvents(o.replayEvents); err != nil {
+			logrus.WithError(err).Fatal("Error replaying events.")
+		}
+
+		return
+	}
+
+	// The framework serves the endpoints registered above. Its own webhook
+	// endpoint handles the orgs without a webhook secret only, see
+	// RegisterEventHandler.
 	framework.Run(r, o.service)
 }
+
+// runFrontend serves the webhooks only, writing the events to the spool for
+// the worker, until the robot is stopped.
+func runFrontend(r *robot, spool *eventSpool, service liboptions.ServiceOptions) {
+	r.spool = spool
+
+	hook := newWebhookGuard(r)
+	http.Handle(webhookPath, hook)
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/readyz", r.watchdog.readyHandler)
+
+	framework.Run(r, service)
+}
@@ -0,0 +1,229 @@
+// Package metrics defines the Prometheus metrics of the robot.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	resultSuccess = "success"
+	resultError   = "error"
+)
+
+var (
+	unconfiguredRepoEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "approve_unconfigured_repo_events_total",
+			Help: "The number of events of the repos matching none of the config items.",
+		},
+		[]string{"org", "repo", "action"},
+	)
+
+	oauthTokenRefreshedAt = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "approve_oauth_token_refreshed_timestamp_seconds",
+		Help: "The time when the OAuth access token was refreshed last. The age of the token is the time since then.",
+	})
+
+	oauthRefreshFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "approve_oauth_token_refresh_failures_total",
+		Help: "The number of failures refreshing the OAuth access token.",
+	})
+
+	handleDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "approve_handle_duration_seconds",
+			Help:    "The time spent evaluating the approval of a PR and updating it.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		},
+		[]string{"org", "repo", "result"},
+	)
+
+	apiCalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "approve_gitee_api_calls_total",
+			Help: "The number of calls to the Gitee API.",
+		},
+		[]string{"method", "result"},
+	)
+
+	apiCallDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "approve_gitee_api_call_duration_seconds",
+			Help:    "The time spent calling the Gitee API.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"method"},
+	)
+
+	circuitOpen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "approve_circuit_breaker_open",
+			Help: "Whether the circuit breaker of the repo is tripped, during which its events are skipped.",
+		},
+		[]string{"org", "repo"},
+	)
+
+	eventProcessingStalled = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "approve_event_processing_stalled",
+		Help: "Whether the events keep being received but none has been processed successfully for the stall timeout.",
+	})
+
+	ownersLookupDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "approve_owners_lookup_duration_seconds",
+			Help:    "The time spent loading the OWNERS of a branch from the owners cache.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"result"},
+	)
+
+	prRequeues = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "approve_pr_requeues_total",
+			Help: "The number of PRs of the repo queued again to retry after handling them failed with a transient error.",
+		},
+		[]string{"org", "repo"},
+	)
+
+	commentWriteFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "approve_comment_write_failures_total",
+			Help: "The number of the asynchronous writes of comments of the repo which failed, by the kind of the write.",
+		},
+		[]string{"org", "repo", "kind"},
+	)
+
+	commentClockSkew = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "approve_comment_clock_skew_total",
+			Help: "The number of the comments of the repo with skewed timestamps found when handling PRs, by whether they are in the future or out of order.",
+		},
+		[]string{"org", "repo", "kind"},
+	)
+
+	approvedLabelConflicts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "approve_label_conflicts_total",
+			Help: "The number of the changes to the approved label made by other robots which the robot reverted, by the action of the change.",
+		},
+		[]string{"org", "repo", "action"},
+	)
+
+	commentClassifications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "approve_comment_classifications_total",
+			Help: "The number of the comments of the repo by how the parser takes them, such as approval_command and near_miss, to tune the aliases and the parser.",
+		},
+		[]string{"org", "repo", "class"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		unconfiguredRepoEvents,
+		oauthTokenRefreshedAt,
+		oauthRefreshFailures,
+		handleDuration,
+		apiCalls,
+		apiCallDuration,
+		ownersLookupDuration,
+		circuitOpen,
+		eventProcessingStalled,
+		prRequeues,
+		commentWriteFailures,
+		commentClockSkew,
+		approvedLabelConflicts,
+		commentClassifications,
+	)
+}
+
+func result(err error) string {
+	if err != nil {
+		return resultError
+	}
+	return resultSuccess
+}
+
+// UnconfiguredRepoEvent counts an event of a repo matching none of the config
+// items, and the action taken.
+func UnconfiguredRepoEvent(org, repo, action string) {
+	unconfiguredRepoEvents.WithLabelValues(org, repo, action).Inc()
+}
+
+// OAuthTokenRefreshed records that the OAuth access token is refreshed now.
+func OAuthTokenRefreshed() {
+	oauthTokenRefreshedAt.SetToCurrentTime()
+}
+
+// OAuthRefreshFailed counts a failure refreshing the OAuth access token.
+func OAuthRefreshFailed() {
+	oauthRefreshFailures.Inc()
+}
+
+// ObserveHandle records the duration of handling a PR of the repo, which
+// started at start.
+func ObserveHandle(org, repo string, start time.Time, err error) {
+	handleDuration.WithLabelValues(org, repo, result(err)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveAPICall records a call to the Gitee API which started at start.
+func ObserveAPICall(method string, start time.Time, err error) {
+	apiCalls.WithLabelValues(method, result(err)).Inc()
+	apiCallDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// SetCircuitOpen sets whether the circuit breaker of the repo is tripped.
+func SetCircuitOpen(org, repo string, open bool) {
+	if open {
+		circuitOpen.WithLabelValues(org, repo).Set(1)
+	} else {
+		circuitOpen.DeleteLabelValues(org, repo)
+	}
+}
+
+// SetEventProcessingStalled sets whether the processing of events stalls.
+func SetEventProcessingStalled(stalled bool) {
+	if stalled {
+		eventProcessingStalled.Set(1)
+	} else {
+		eventProcessingStalled.Set(0)
+	}
+}
+
+// ObserveOwnersLookup records a lookup of the OWNERS which started at start.
+func ObserveOwnersLookup(start time.Time, err error) {
+	ownersLookupDuration.WithLabelValues(result(err)).Observe(time.Since(start).Seconds())
+}
+
+// PRRequeued counts a PR of the repo queued again to retry.
+func PRRequeued(org, repo string) {
+	prRequeues.WithLabelValues(org, repo).Inc()
+}
+
+// CommentWriteFailed counts an asynchronous write of comments of the repo
+// which failed.
+func CommentWriteFailed(org, repo, kind string) {
+	commentWriteFailures.WithLabelValues(org, repo, kind).Inc()
+}
+
+// CommentClockSkew counts the comments of the repo with skewed timestamps,
+// which are of the kind, future or out_of_order.
+func CommentClockSkew(org, repo, kind string, n int) {
+	if n > 0 {
+		commentClockSkew.WithLabelValues(org, repo, kind).Add(float64(n))
+	}
+}
+
+// ApprovedLabelConflict counts a change to the approved label of a PR of the
+// repo made by another robot, added or removed, which the robot reverted.
+func ApprovedLabelConflict(org, repo, action string) {
+	approvedLabelConflicts.WithLabelValues(org, repo, action).Inc()
+}
+
+// CommentClassified counts a comment of the repo of the class. It doesn't
+// tell who made the comment or what it says.
+func CommentClassified(org, repo, class string) {
+	commentClassifications.WithLabelValues(org, repo, class).Inc()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// mutationRetries is the max number of the retries of a change to a PR
+	// failing with a transient error.
+	mutationRetries = 3
+
+	mutationRetryBaseDelay = 500 * time.Millisecond
+	mutationRetryMaxDelay  = 8 * time.Second
+
+	// mutationRetryBudget bounds the retries of all the changes made within
+	// mutationRetryWindow, so that the retries don't pile up on Gitee when it
+	// is down rather than failing transiently.
+	mutationRetryBudget = 60
+	mutationRetryWindow = time.Minute
+)
+
+// retryBudget is the number of the retries left in the current window.
+type retryBudget struct {
+	lock    sync.Mutex
+	left    int
+	resetAt time.Time
+}
+
+// take reports whether a retry is allowed, and consumes it if so.
+func (b *retryBudget) take(now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if now.After(b.resetAt) {
+		b.left = mutationRetryBudget
+		b.resetAt = now.Add(mutationRetryWindow)
+	}
+
+	if b.left <= 0 {
+		return false
+	}
+
+	b.left--
+
+	return true
+}
+
+// mutationRetryDelay returns the delay before the attempt-th retry, which
+// doubles on each attempt up to mutationRetryMaxDelay, and is jittered by up
+// to half of it so that the retries of the PRs failing together are spread.
+func mutationRetryDelay(attempt int) time.Duration {
+	d := mutationRetryBaseDelay
+	for i := 1; i < attempt && d < mutationRetryMaxDelay; i++ {
+		d *= 2
+	}
+
+	if d > mutationRetryMaxDelay {
+		d = mutationRetryMaxDelay
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// mutate makes the change to the PR, retrying it if it fails with a
+// transient error while the budget of retries lasts. The last error is
+// returned if it still fails.
+func (c *ghclient) mutate(org, repo, what string, change func() error) error {
+	err := change()
+	for attempt := 1; err != nil && attempt <= mutationRetries && isTransient(err); attempt++ {
+		if !c.retries.take(time.Now()) {
+			logrus.WithFields(logrus.Fields{"org": org, "repo": repo}).Warnf(
+				"Give up retrying to %s as the budget of retries is used up.", what,
+			)
+
+			break
+		}
+
+		time.Sleep(mutationRetryDelay(attempt))
+
+		err = change()
+	}
+
+	return err
+}
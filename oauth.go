@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/metrics"
+)
+
+const (
+	giteeTokenURL = "https://gitee.com/oauth/token"
+
+	// tokenRetryInterval is the interval to retry a failed refresh.
+	tokenRetryInterval = time.Minute
+)
+
+// oauthApp is the credential of a Gitee OAuth app authorized by the robot
+// account. The refresh token is the one got when authorizing the app.
+type oauthApp struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type oauthToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// oauthTokenSource keeps the access token of an OAuth app fresh by refreshing
+// it before it expires.
+type oauthTokenSource struct {
+	app oauthApp
+	hc  http.Client
+
+	lock         sync.RWMutex
+	accessToken  string
+	refreshToken string
+	expiry       time.Time
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newOAuthTokenSource(file string) (*oauthTokenSource, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var app oauthApp
+	if err := json.Unmarshal(b, &app); err != nil {
+		return nil, fmt.Errorf("load oauth app from %s: %v", file, err)
+	}
+
+	if app.ClientID == "" || app.ClientSecret == "" || app.RefreshToken == "" {
+		return nil, fmt.Errorf("client_id, client_secret and refresh_token of oauth app must be set")
+	}
+
+	s := &oauthTokenSource{
+		app:          app,
+		hc:           http.Client{Timeout: 30 * time.Second},
+		refreshToken: app.RefreshToken,
+		stopCh:       make(chan struct{}),
+	}
+
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// token returns the current access token.
+func (s *oauthTokenSource) token() []byte {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return []byte(s.accessToken)
+}
+
+func (s *oauthTokenSource) refresh() error {
+	s.lock.RLock()
+	v := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.refreshToken},
+		"client_id":     {s.app.ClientID},
+		"client_secret": {s.app.ClientSecret},
+	}
+	s.lock.RUnlock()
+
+	resp, err := s.hc.PostForm(giteeTokenURL, v)
+	if err != nil {
+		metrics.OAuthRefreshFailed()
+		return fmt.Errorf("refresh oauth token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var t oauthToken
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("refresh oauth token: status code %d", resp.StatusCode)
+	} else if err = json.NewDecoder(resp.Body).Decode(&t); err == nil && t.AccessToken == "" {
+		err = fmt.Errorf("refresh oauth token: empty access token")
+	}
+
+	if err != nil {
+		metrics.OAuthRefreshFailed()
+		return err
+	}
+
+	s.lock.Lock()
+	s.accessToken = t.AccessToken
+	if t.RefreshToken != "" {
+		s.refreshToken = t.RefreshToken
+	}
+	s.expiry = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+	s.lock.Unlock()
+
+	metrics.OAuthTokenRefreshed()
+
+	return nil
+}
+
+// nextRefresh returns the time to wait before the next refresh, which is
+// when 80% of the lifetime of the token has passed.
+func (s *oauthTokenSource) nextRefresh() time.Duration {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	d := time.Until(s.expiry) * 4 / 5
+	if d < tokenRetryInterval {
+		d = tokenRetryInterval
+	}
+
+	return d
+}
+
+// start refreshes the token in background and calls onRefresh with the new
+// token after each successful refresh.
+func (s *oauthTokenSource) start(onRefresh func(token []byte)) {
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		wait := s.nextRefresh()
+		for {
+			select {
+			case <-s.stopCh:
+				return
+			case <-time.After(wait):
+			}
+
+			if err := s.refresh(); err != nil {
+				logrus.WithError(err).Error("refresh oauth token")
+				wait = tokenRetryInterval
+				continue
+			}
+
+			onRefresh(s.token())
+			wait = s.nextRefresh()
+		}
+	}()
+}
+
+func (s *oauthTokenSource) stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/opensourceways/community-robot-lib/config"
+	"github.com/opensourceways/community-robot-lib/giteeclient"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/test-infra/prow/labels"
+)
+
+const (
+	onboardIssueTitle = "The pull requests of this repository are approved by the approve robot"
+
+	// approvedLabelColor is the color of the labels created for onboarding.
+	approvedLabelColor = "0e8a16"
+)
+
+// onboardedRepo is the result of onboarding a repo.
+type onboardedRepo struct {
+	Repo string `json:"repo"`
+	// Skipped tells why the repo is skipped, such as it is not configured.
+	Skipped       string   `json:"skipped,omitempty"`
+	MissingOwners bool     `json:"missing_owners,omitempty"`
+	LabelsCreated []string `json:"labels_created,omitempty"`
+	Issue         string   `json:"issue,omitempty"`
+	// PRs is the number of the open PRs handled.
+	PRs    int      `json:"prs"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// onboardReport is the result of onboarding the repos of an org.
+type onboardReport struct {
+	Org   string          `json:"org"`
+	Repos []onboardedRepo `json:"repos"`
+}
+
+// onboardTo onboards the repos of the org of --onboard and writes the report
+// in JSON.
+func (bot *robot) onboardTo(org string, w io.Writer) error {
+	report, err := bot.onboard(org)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(report)
+}
+
+// onboard prepares each repo of the org configured for the robot: checks it
+// has the root OWNERS file, creates the labels, posts an issue describing the
+// approval policy and handles its open PRs. The issue is posted only when the
+// approved label is created, so running it again doesn't post the issue again.
+func (bot *robot) onboard(org string) (onboardReport, error) {
+	report := onboardReport{Org: org}
+
+	repos, err := bot.cli.cli.GetRepos(org)
+	if err != nil {
+		return report, err
+	}
+
+	_, c := bot.cfgAgent.GetConfig()
+
+	for i := range repos {
+		p := &repos[i]
+		if p.Archived {
+			continue
+		}
+
+		log := logrus.WithFields(logrus.Fields{"org": org, "repo": p.Path})
+		report.Repos = append(report.Repos, bot.onboardRepo(org, p.Path, p.DefaultBranch, c, log))
+	}
+
+	return report, nil
+}
+
+func (bot *robot) onboardRepo(org, repo, branch string, c config.Config, log *logrus.Entry) onboardedRepo {
+	r := onboardedRepo{Repo: repo}
+	fail := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		log.Error(msg)
+		r.Errors = append(r.Errors, msg)
+	}
+
+	if branch == "" {
+		branch = "master"
+	}
+
+	cfg, err := bot.getEventConfig(c, org, repo, branch)
+	if err != nil {
+		fail("get the config: %v", err)
+		return r
+	}
+	if cfg == nil {
+		r.Skipped = "not configured"
+		return r
+	}
+
+	cli := bot.cli.withDryRun(cfg.DryRun)
+
+	if tree, err := bot.cli.cli.GetRepoTree(org, repo, branch); err != nil {
+		fail("list the files of %s: %v", branch, err)
+	} else {
+		r.MissingOwners = true
+		for _, e := range tree {
+			if e.Type == "blob" && e.Path == "OWNERS" {
+				r.MissingOwners = false
+				break
+			}
+		}
+	}
+
+	created, err := cli.ensureRepoLabels(org, repo, cfg)
+	if err != nil {
+		fail("create the labels: %v", err)
+	}
+	r.LabelsCreated = created
+
+	if sets.NewString(created...).Has(labels.Approved) {
+		issue, err := cli.CreateIssue(org, repo, onboardIssueTitle, approvalPolicyMessage(cfg, r.MissingOwners))
+		if err != nil {
+			fail("post the issue of the approval policy: %v", err)
+		}
+		r.Issue = issue
+	}
+
+	prs, err := bot.cli.cli.GetPullRequests(org, repo, giteeclient.ListPullRequestOpt{State: "open"})
+	if err != nil {
+		fail("list the open PRs: %v", err)
+		return r
+	}
+
+	for i := range prs {
+		info := prInfoFromPR(&prs[i])
+		if err := bot.refresh(org, repo, info, cfg, log.WithField("number", info.number)); err != nil {
+			fail("handle #%d: %v", info.number, err)
+			continue
+		}
+		r.PRs++
+	}
+
+	return r
+}
+
+// ensureRepoLabels creates the labels added by the robot which the repo
+// doesn't have, and returns those created.
+func (c *ghclient) ensureRepoLabels(org, repo string, cfg *botConfig) ([]string, error) {
+	existing, err := c.cli.GetRepoLabels(org, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	have := sets.NewString()
+	for i := range existing {
+		have.Insert(existing[i].Name)
+	}
+
+	var created []string
+	for _, l := range []string{labels.Approved, cfg.ConditionalApprovedLabel} {
+		if l == "" || have.Has(l) {
+			continue
+		}
+
+		if !c.skipWrite(org, repo, "create label %s", l) {
+			if err := c.cli.CreateRepoLabel(org, repo, l, approvedLabelColor); err != nil {
+				return created, err
+			}
+		}
+
+		have.Insert(l)
+		created = append(created, l)
+	}
+
+	return created, nil
+}
+
+// CreateIssue creates the issue and returns its link.
+func (c *ghclient) CreateIssue(org, repo, title, body string) (string, error) {
+	if c.skipWrite(org, repo, "create issue %q", title) {
+		return "", nil
+	}
+
+	v, err := c.cli.CreateIssue(org, repo, title, body)
+	if err != nil {
+		return "", err
+	}
+
+	return v.HtmlUrl, nil
+}
+
+// approvalPolicyMessage describes how the PRs of the repo are approved under
+// the config.
+func approvalPolicyMessage(cfg *botConfig, missingOwners bool) string {
+	var b strings.Builder
+
+	b.WriteString("From now on, the pull requests of this repository are approved by the approvers in the OWNERS files.\n\n")
+	b.WriteString(fmt.Sprintf(
+		"A pull request is labeled **%s** once the approvers of all the files it changes have commented `/approve`. ",
+		labels.Approved,
+	))
+	b.WriteString("Comment `/approve cancel` to withdraw the approval, and `/approve status` to show the state of it.\n\n")
+
+	var rules []string
+	if cfg.RequireSelfApproval.Required {
+		rules = append(rules, "the author has to approve explicitly, even if the author is an approver")
+	} else if paths := cfg.RequireSelfApproval.Paths; len(paths) > 0 {
+		rules = append(rules, fmt.Sprintf(
+			"the author has to approve the changes of %s explicitly, even if the author is an approver",
+			strings.Join(paths, ", "),
+		))
+	}
+	if cfg.MinApprovers > 0 {
+		rules = append(rules, fmt.Sprintf("at least %d approvers other than the author have to approve", cfg.MinApprovers))
+	}
+	if cfg.IssueRequired {
+		rules = append(rules, "the pull request has to reference an issue, or be approved with `/approve no-issue`")
+	}
+	if cfg.LgtmActsAsApprove {
+		rules = append(rules, "`/lgtm` of an approver counts as the approval")
+	}
+	if cfg.InvalidateApprovalsOnPush || cfg.RequireApprovalAfterLastPush {
+		rules = append(rules, "the approvals made before the last push don't count")
+	}
+	if cfg.ApprovalTTLHours > 0 {
+		rules = append(rules, fmt.Sprintf("an approval expires %d hours after it is made", cfg.ApprovalTTLHours))
+	}
+	if len(cfg.OwnersFileChangeRequires) > 0 {
+		rules = append(rules, fmt.Sprintf(
+			"the changes of OWNERS files have to be approved by one of %s",
+			strings.Join(cfg.OwnersFileChangeRequires, ", "),
+		))
+	}
+
+	if len(rules) > 0 {
+		b.WriteString("Besides:\n\n")
+		for _, v := range rules {
+			b.WriteString("- " + v + "\n")
+		}
+		b.WriteString("\n")
+	}
+
+	if missingOwners {
+		b.WriteString("**This repository has no OWNERS file in its root directory yet**, please add one, or no pull request can be approved.\n\n")
+	}
+
+	b.WriteString("Comment `/approve config` on a pull request to show the events triggering the robot.")
+
+	return b.String()
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensourceways/repo-owners-cache/repoowners"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+const (
+	// ownersLookupAttempts is the number of the attempts to look up the
+	// owners cache when it fails with a transient error, and
+	// ownersLookupDelay is the delay before the first retry, which doubles
+	// on each retry.
+	ownersLookupAttempts = 3
+	ownersLookupDelay    = time.Second
+
+	// fetchedOwnersTTL is how long the OWNERS files fetched from Gitee are
+	// used, while the owners cache is unavailable.
+	fetchedOwnersTTL = 5 * time.Minute
+
+	// ownersUnavailableMarker marks the comment telling that the OWNERS files
+	// are unavailable.
+	ownersUnavailableMarker = "<!-- OWNERS-UNAVAILABLE -->"
+)
+
+// lookupRepoOwners looks up the owners cache, retrying with backoff if it
+// fails with a transient error.
+func (bot *robot) lookupRepoOwners(org, repo, base string) (repoowners.RepoOwner, error) {
+	delay := ownersLookupDelay
+
+	for attempt := 1; ; attempt++ {
+		oc, err := bot.loadRepoOwners(org, repo, base)
+		if err == nil || attempt >= ownersLookupAttempts || !isTransient(err) {
+			return oc, err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// fetchedOwners is the OWNERS files of a branch fetched from Gitee directly,
+// which stands in for the owners cache while it is unavailable. The logins
+// are lower case as those of the cache.
+type fetchedOwners struct {
+	approvers.OverlayRepo
+
+	reviewers map[string]sets.String
+	fetchedAt time.Time
+}
+
+func (o *fetchedOwners) TopLevelApprovers() sets.String {
+	return o.LeafApprovers("OWNERS")
+}
+
+func (o *fetchedOwners) FindReviewersOwnersForFile(file string) string {
+	for dir := ownersDir(file); ; dir = ownersDir(dir) {
+		if o.reviewers[dir].Len() > 0 || dir == "" {
+			return dir
+		}
+	}
+}
+
+func (o *fetchedOwners) LeafReviewers(file string) sets.String {
+	return sets.NewString(o.reviewers[ownersDir(file)].UnsortedList()...)
+}
+
+func (o *fetchedOwners) Reviewers(file string) sets.String {
+	all := sets.NewString()
+	for dir := ownersDir(file); ; dir = ownersDir(dir) {
+		all.Insert(o.reviewers[dir].UnsortedList()...)
+		if dir == "" || o.IsNoParentOwners(path.Join(dir, "OWNERS")) {
+			return all
+		}
+	}
+}
+
+func (o *fetchedOwners) AllReviewers() sets.String {
+	all := sets.NewString()
+	for _, v := range o.reviewers {
+		all.Insert(v.UnsortedList()...)
+	}
+	return all
+}
+
+func (o *fetchedOwners) FindLabelsForFile(file string) sets.String {
+	return sets.NewString()
+}
+
+// noOwners is a repo without OWNERS files, over which the fetched ones are
+// laid.
+type noOwners struct{}
+
+func (noOwners) Approvers(string) sets.String            { return sets.NewString() }
+func (noOwners) LeafApprovers(string) sets.String        { return sets.NewString() }
+func (noOwners) FindApproverOwnersForFile(string) string { return "" }
+func (noOwners) IsNoParentOwners(string) bool            { return false }
+
+// fetchedOwnersCache caches the OWNERS files fetched from Gitee for a while,
+// so that they are not fetched on every event while the owners cache is
+// unavailable.
+type fetchedOwnersCache struct {
+	lock  sync.Mutex
+	items map[string]*fetchedOwners
+}
+
+func newFetchedOwnersCache() *fetchedOwnersCache {
+	return &fetchedOwnersCache{items: map[string]*fetchedOwners{}}
+}
+
+func (c *fetchedOwnersCache) get(k string) *fetchedOwners {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if v, ok := c.items[k]; ok && time.Since(v.fetchedAt) < fetchedOwnersTTL {
+		return v
+	}
+
+	return nil
+}
+
+func (c *fetchedOwnersCache) set(k string, v *fetchedOwners) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key, item := range c.items {
+		if time.Since(item.fetchedAt) >= fetchedOwnersTTL {
+			delete(c.items, key)
+		}
+	}
+
+	c.items[k] = v
+}
+
+// fetchOwners fetches all the OWNERS files of the branch from Gitee.
+func (bot *robot) fetchOwners(org, repo, base string) (*fetchedOwners, error) {
+	k := fmt.Sprintf("%s/%s:%s", org, repo, base)
+	if v := bot.fetched.get(k); v != nil {
+		return v, nil
+	}
+
+	tree, err := bot.cli.cli.GetRepoTree(org, repo, base)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]*approvers.OwnersEntry{}
+	reviewers := map[string]sets.String{}
+
+	for _, e := range tree {
+		if e.Type != "blob" || !isOwnersFile(e.Path) {
+			continue
+		}
+
+		c, err := bot.cli.cli.GetPathContent(org, repo, e.Path, base)
+		if err != nil {
+			return nil, fmt.Errorf("get %s: %v", e.Path, err)
+		}
+
+		b, err := base64.StdEncoding.DecodeString(c.Content)
+		if err != nil {
+			return nil, fmt.Errorf("decode %s: %v", e.Path, err)
+		}
+
+		v := new(ownersConfig)
+		if err := yaml.Unmarshal(b, v); err != nil {
+			return nil, fmt.Errorf("parse %s: %v", e.Path, err)
+		}
+
+		dir := ownersDir(e.Path)
+		entries[dir] = &approvers.OwnersEntry{
+			Approvers:      lowerLogins(v.Approvers),
+			NoParentOwners: v.Options.NoParentOwners,
+		}
+		reviewers[dir] = sets.NewString(lowerLogins(v.Reviewers)...)
+	}
+
+	v := &fetchedOwners{
+		OverlayRepo: approvers.NewOverlayRepo(noOwners{}, entries),
+		reviewers:   reviewers,
+		fetchedAt:   time.Now(),
+	}
+	bot.fetched.set(k, v)
+
+	return v, nil
+}
+
+func lowerLogins(logins []string) []string {
+	r := make([]string, len(logins))
+	for i, v := range logins {
+		r[i] = strings.ToLower(v)
+	}
+	return r
+}
+
+// ownersUnavailableNotices remembers the PRs told that the OWNERS files are
+// unavailable, so that each one is told once until they are available again.
+type ownersUnavailableNotices struct {
+	lock sync.Mutex
+	prs  sets.String
+}
+
+func newOwnersUnavailableNotices() *ownersUnavailableNotices {
+	return &ownersUnavailableNotices{prs: sets.NewString()}
+}
+
+// mark reports whether the PR has not been told yet, and marks it told.
+func (n *ownersUnavailableNotices) mark(org, repo string, number int) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	k := fmt.Sprintf("%s/%s/%d", org, repo, number)
+	if n.prs.Has(k) {
+		return false
+	}
+
+	n.prs.Insert(k)
+
+	return true
+}
+
+func (n *ownersUnavailableNotices) clear(org, repo string, number int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.prs.Delete(fmt.Sprintf("%s/%s/%d", org, repo, number))
+}
+
+// noticeOwnersUnavailable tells the PR once that its approvals are not
+// processed because the OWNERS files are unavailable. The cause is logged
+// only, which may reveal the deployment.
+func (bot *robot) noticeOwnersUnavailable(cli *ghclient, org, repo string, pr prInfo, cause error, log *logrus.Entry) {
+	log.WithError(cause).Error("The OWNERS files are unavailable.")
+
+	if !bot.ownersNotices.mark(org, repo, pr.number) {
+		return
+	}
+
+	msg := "The approvals of this pull request can't be processed now, because the OWNERS files of the target branch are unavailable. " +
+		"They will be processed on the next event of it after the OWNERS files are available again.\n" +
+		ownersUnavailableMarker
+	if err := cli.CreateComment(org, repo, pr.number, msg); err != nil {
+		log.WithError(err).Error("tell the PR that the OWNERS files are unavailable")
+	}
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+const (
+	ownersLintPathPrefix = "/v1/owners-lint/"
+
+	lintError   = "error"
+	lintWarning = "warning"
+
+	// ownersLintMarker marks the comment of the findings on a PR, so that it
+	// is updated rather than posted again.
+	ownersLintMarker = "<!-- OWNERS-LINT -->"
+)
+
+// ownersFinding is a problem found in an OWNERS file or the aliases file.
+type ownersFinding struct {
+	Path     string `json:"path"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// ownersLintReport is the findings of the OWNERS files of a repo at a ref.
+type ownersLintReport struct {
+	Org      string          `json:"org"`
+	Repo     string          `json:"repo"`
+	Ref      string          `json:"ref"`
+	Findings []ownersFinding `json:"findings"`
+}
+
+// ownersConfig is the content of an OWNERS file.
+type ownersConfig struct {
+	Approvers []string `json:"approvers"`
+	Reviewers []string `json:"reviewers"`
+	requiredOwners
+	Options struct {
+		NoParentOwners bool `json:"no_parent_owners"`
+	} `json:"options"`
+}
+
+// treeEntry is a file or directory of the tree of a commit.
+type treeEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+// GetRepoTree lists the files and directories of the repo at the ref
+// recursively, which the client of the library doesn't support.
+func (c giteeClient) GetRepoTree(org, repo, ref string) ([]treeEntry, error) {
+	u := fmt.Sprintf(
+		"%s/repos/%s/%s/git/trees/%s?recursive=1",
+		giteeAPIEndpoint, url.PathEscape(org), url.PathEscape(repo), url.PathEscape(ref),
+	)
+
+	resp, err := c.do(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("get tree: %s %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	var r struct {
+		Tree      []treeEntry `json:"tree"`
+		Truncated bool        `json:"truncated"`
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("get tree: %v", err)
+	}
+
+	if r.Truncated {
+		return nil, fmt.Errorf("the tree of %s/%s at %s is too large to list", org, repo, ref)
+	}
+
+	return r.Tree, nil
+}
+
+// ownersLinter lints the OWNERS files of a repo at a ref.
+type ownersLinter struct {
+	cli       iClient
+	org       string
+	repo      string
+	ref       string
+	aliases   approvers.RepoAliases
+	findings  []ownersFinding
+	reference map[string]sets.String
+}
+
+func newOwnersLinter(cli iClient, org, repo, ref string) *ownersLinter {
+	return &ownersLinter{
+		cli:       cli,
+		org:       org,
+		repo:      repo,
+		ref:       ref,
+		reference: map[string]sets.String{},
+	}
+}
+
+func (l *ownersLinter) add(file, rule, severity, format string, args ...interface{}) {
+	l.findings = append(l.findings, ownersFinding{
+		Path:     file,
+		Rule:     rule,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *ownersLinter) read(file string) ([]byte, error) {
+	c, err := l.cli.GetPathContent(l.org, l.repo, file, l.ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(c.Content)
+}
+
+// loadAliases loads the alias groups, which are not users, and lints the
+// aliases file if report is true.
+func (l *ownersLinter) loadAliases(file string, report bool) {
+	file = strings.Trim(file, "/")
+
+	b, err := l.read(file)
+	if err != nil {
+		if report {
+			l.add(file, "unreadable", lintError, "can't read the aliases file: %v", err)
+		}
+		return
+	}
+
+	aliases, err := approvers.ParseAliases(b)
+	if err != nil {
+		if report {
+			l.add(file, "invalid-yaml", lintError, "%v", err)
+		}
+		return
+	}
+
+	l.aliases = aliases
+	if !report {
+		return
+	}
+
+	groups := make([]string, 0, len(aliases))
+	for g := range aliases {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	// The edges of the cycles are reported as the cycles rather than the
+	// nested aliases.
+	seen := sets.NewString()
+	cycleEdges := sets.NewString()
+	for _, g := range groups {
+		cycle := l.findCycle(g, []string{g})
+		if cycle == nil {
+			continue
+		}
+
+		for i := 1; i < len(cycle); i++ {
+			cycleEdges.Insert(cycle[i-1] + "/" + cycle[i])
+		}
+
+		if key := strings.Join(sets.NewString(cycle...).List(), ","); !seen.Has(key) {
+			seen.Insert(key)
+			l.add(file, "alias-cycle", lintError, "the aliases are in a cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	for _, g := range groups {
+		if aliases[g].Len() == 0 {
+			l.add(file, "empty-alias", lintWarning, "the alias %s has no members", g)
+		}
+
+		for _, m := range aliases[g].List() {
+			if _, ok := aliases[m]; !ok {
+				l.refer(m, file)
+			} else if !cycleEdges.Has(g + "/" + m) {
+				l.add(file, "nested-alias", lintWarning, "the alias %s has the alias %s as a member, which is not expanded", g, m)
+			}
+		}
+	}
+}
+
+// findCycle returns the cycle from the last alias of trail back to its first
+// one, or nil if there is none.
+func (l *ownersLinter) findCycle(g string, trail []string) []string {
+	for _, m := range l.aliases[g].List() {
+		if _, ok := l.aliases[m]; !ok {
+			continue
+		}
+
+		if m == trail[0] {
+			return append(trail, m)
+		}
+
+		if sets.NewString(trail...).Has(m) {
+			continue
+		}
+
+		if r := l.findCycle(m, append(append([]string{}, trail...), m)); r != nil {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// refer records that the user is referred to by the file.
+func (l *ownersLinter) refer(login, file string) {
+	k := strings.ToLower(login)
+	if l.reference[k] == nil {
+		l.reference[k] = sets.NewString()
+	}
+	l.reference[k].Insert(file)
+}
+
+// lintOwners lints the OWNERS file and returns the parsed content, or nil if
+// it is invalid.
+func (l *ownersLinter) lintOwners(file string) *ownersConfig {
+	b, err := l.read(file)
+	if err != nil {
+		l.add(file, "unreadable", lintError, "can't read the file: %v", err)
+		return nil
+	}
+
+	v := new(ownersConfig)
+	if err := yaml.Unmarshal(b, v); err != nil {
+		l.add(file, "invalid-yaml", lintError, "%v", err)
+		return nil
+	}
+
+	logins := sets.NewString(v.Approvers...)
+	if l.aliases.Expand(logins).Len() == 0 {
+		if v.Options.NoParentOwners {
+			l.add(file, "no-approvers", lintError, "it sets no_parent_owners without approvers, so nobody can approve the files under it")
+		} else {
+			l.add(file, "empty-approvers", lintWarning, "it has no approvers, so only the approvers of the parent directories can approve")
+		}
+	}
+
+	for _, login := range append(append(v.Approvers, v.Reviewers...), v.logins()...) {
+		if _, ok := l.aliases[strings.ToLower(login)]; !ok {
+			l.refer(login, file)
+		}
+	}
+
+	return v
+}
+
+// lintUsers checks that the users in the OWNERS files exist, by querying
+// their permissions of the repo.
+func (l *ownersLinter) lintUsers() {
+	for _, login := range sets.StringKeySet(l.reference).List() {
+		if _, err := l.cli.GetUserPermissionsOfRepo(l.org, l.repo, login); err == nil {
+			continue
+		}
+
+		for _, file := range l.reference[login].List() {
+			l.add(file, "unknown-user", lintError, "the user %s is not found", login)
+		}
+	}
+}
+
+func (l *ownersLinter) result() []ownersFinding {
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		return l.findings[i].Path < l.findings[j].Path
+	})
+
+	return l.findings
+}
+
+func isOwnersFile(file string) bool {
+	return path.Base(file) == "OWNERS"
+}
+
+// lintOwnersOfBranch lints all the OWNERS files of the branch and the aliases
+// file, and checks with the owners cache that the files under every OWNERS
+// file can be approved by someone.
+func (bot *robot) lintOwnersOfBranch(org, repo, branch string, cfg *botConfig) ([]ownersFinding, error) {
+	tree, err := bot.cli.cli.GetRepoTree(org, repo, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	oc, err := bot.loadOwners(org, repo, branch, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	l := newOwnersLinter(bot.cli.cli, org, repo, branch)
+	if cfg.OwnersAliasesFile != "" {
+		l.loadAliases(cfg.OwnersAliasesFile, true)
+	}
+
+	for _, e := range tree {
+		if e.Type != "blob" || !isOwnersFile(e.Path) {
+			continue
+		}
+
+		v := l.lintOwners(e.Path)
+		if v != nil && !v.Options.NoParentOwners && oc.Approvers(e.Path).Len() == 0 {
+			l.add(e.Path, "no-approvers", lintError, "nobody in the OWNERS tree can approve the files under it")
+		}
+	}
+
+	l.lintUsers()
+
+	return l.result(), nil
+}
+
+// lintOwnersOfPR lints the OWNERS files and the aliases file changed by the
+// PR at its head commit.
+func (bot *robot) lintOwnersOfPR(org, repo string, pr prInfo, cfg *botConfig) ([]ownersFinding, error) {
+	changes, err := bot.cli.GetPullRequestChanges(org, repo, pr.number)
+	if err != nil {
+		return nil, err
+	}
+
+	aliasesFile := strings.Trim(cfg.OwnersAliasesFile, "/")
+
+	var files []string
+	aliasesChanged := false
+	for _, c := range changes {
+		if c.Status == "removed" {
+			continue
+		}
+
+		if aliasesFile != "" && c.Filename == aliasesFile {
+			aliasesChanged = true
+		} else if isOwnersFile(c.Filename) {
+			files = append(files, c.Filename)
+		}
+	}
+
+	if len(files) == 0 && !aliasesChanged {
+		return nil, nil
+	}
+
+	l := newOwnersLinter(bot.cli.cli, org, repo, pr.headSHA)
+	if aliasesFile != "" {
+		l.loadAliases(aliasesFile, aliasesChanged)
+	}
+
+	for _, f := range files {
+		l.lintOwners(f)
+	}
+
+	l.lintUsers()
+
+	return l.result(), nil
+}
+
+// commentOwnersLint comments the findings of the OWNERS files changed by the
+// PR. The comment is updated as the PR changes, and removed once they are
+// fixed.
+func (bot *robot) commentOwnersLint(org, repo string, pr prInfo, cfg *botConfig, log *logrus.Entry) error {
+	findings, err := bot.lintOwnersOfPR(org, repo, pr, cfg)
+	if err != nil {
+		return err
+	}
+
+	comments, err := bot.cli.ListIssueComments(org, repo, pr.number)
+	if err != nil {
+		return err
+	}
+
+	botName, err := bot.cli.BotName()
+	if err != nil {
+		return err
+	}
+
+	var previous *int
+	previousBody := ""
+	for i := range comments {
+		if c := &comments[i]; c.User.Login == botName && strings.Contains(c.Body, ownersLintMarker) {
+			previous = &c.ID
+			previousBody = c.Body
+		}
+	}
+
+	cli := bot.cli.withDryRun(cfg.DryRun)
+
+	if len(findings) == 0 {
+		if previous == nil {
+			return nil
+		}
+
+		log.Info("The findings of the OWNERS files are fixed.")
+
+		return cli.DeleteComment(org, repo, *previous)
+	}
+
+	msg := ownersLintMessage(findings)
+	if previous == nil {
+		return cli.CreateComment(org, repo, pr.number, msg)
+	}
+
+	if previousBody == msg {
+		return nil
+	}
+
+	return cli.EditComment(org, repo, *previous, msg)
+}
+
+func ownersLintMessage(findings []ownersFinding) string {
+	var b strings.Builder
+
+	b.WriteString(ownersLintMarker + "\n")
+	b.WriteString("**Problems are found in the OWNERS files changed by this PR:**\n\n")
+	b.WriteString("| Severity | File | Problem |\n| --- | --- | --- |\n")
+
+	for _, f := range findings {
+		// The messages of YAML errors may break the table.
+		msg := strings.NewReplacer("|", "\\|", "\n", " ").Replace(f.Message)
+		fmt.Fprintf(&b, "| %s | `%s` | %s |\n", f.Severity, f.Path, msg)
+	}
+
+	return b.String()
+}
+
+// lintOwners lints the OWNERS files of the branch of the repo, and falls back
+// to the default config if the repo is not configured.
+func (bot *robot) lintOwners(org, repo, branch string) (ownersLintReport, error) {
+	// The aliases of the repo are unknown if it is not configured.
+	_, c := bot.cfgAgent.GetConfig()
+	cfg, err := bot.getConfig(c, org, repo, branch)
+	if err != nil {
+		cfg = &botConfig{}
+	}
+
+	findings, err := bot.lintOwnersOfBranch(org, repo, branch, cfg)
+	if err != nil {
+		return ownersLintReport{}, err
+	}
+
+	if findings == nil {
+		findings = []ownersFinding{}
+	}
+
+	return ownersLintReport{Org: org, Repo: repo, Ref: branch, Findings: findings}, nil
+}
+
+// ownersLintHandler serves GET /v1/owners-lint/{org}/{repo}?branch={branch}
+// which returns the findings of the OWNERS files of the branch. It reads any
+// repo the robot can access and makes API calls on every request, so it is an
+// admin endpoint.
+func (bot *robot) ownersLintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	v := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, ownersLintPathPrefix), "/"), "/")
+	if len(v) != 2 {
+		http.Error(w, fmt.Sprintf("the path should be %s{org}/{repo}", ownersLintPathPrefix), http.StatusBadRequest)
+		return
+	}
+
+	branch := r.URL.Query().Get("branch")
+	if branch == "" {
+		http.Error(w, "missing branch", http.StatusBadRequest)
+		return
+	}
+
+	report, err := bot.lintOwners(v[0], v[1], branch)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, report)
+}
+
+// parseLintTarget parses the target of --lint-owners which is of the form
+// org/repo:branch.
+func parseLintTarget(s string) (string, string, string, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return "", "", "", fmt.Errorf("lint-owners should be org/repo:branch")
+	}
+
+	v := strings.Split(s[:i], "/")
+	if len(v) != 2 || v[0] == "" || v[1] == "" || s[i+1:] == "" {
+		return "", "", "", fmt.Errorf("lint-owners should be org/repo:branch")
+	}
+
+	return v[0], v[1], s[i+1:], nil
+}
+
+// lintOwnersTo writes the findings of the target of --lint-owners in JSON.
+func (bot *robot) lintOwnersTo(target string, w io.Writer) error {
+	org, repo, branch, err := parseLintTarget(target)
+	if err != nil {
+		return err
+	}
+
+	report, err := bot.lintOwners(org, repo, branch)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(report)
+}
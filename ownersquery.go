@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/opensourceways/repo-owners-cache/repoowners"
+)
+
+// ownersCommandReg matches "/approve owners <path>" which replies with the
+// approvers of the path.
+var ownersCommandReg = regexp.MustCompile(`(?mi)^/approve[\t ]+owners[\t ]+([^\s]+)[\t ]*$`)
+
+// owningDir is an OWNERS file whose approvers can approve a path.
+type owningDir struct {
+	dir       string
+	approvers []string
+	// noParentOwners stops the approvers of the parent directories.
+	noParentOwners bool
+}
+
+// owningDirs returns the OWNERS files whose approvers can approve the file,
+// from the nearest one up to the root or the first one setting
+// no_parent_owners.
+func owningDirs(oc repoowners.RepoOwner, file string) []owningDir {
+	var r []owningDir
+
+	dir := canonicalOwnersDir(oc.FindApproverOwnersForFile(file))
+	for {
+		v := owningDir{
+			dir:            dir,
+			approvers:      oc.LeafApprovers(filepath.Join(dir, "OWNERS")).List(),
+			noParentOwners: dir != "" && oc.IsNoParentOwners(dir),
+		}
+		r = append(r, v)
+
+		if dir == "" || v.noParentOwners {
+			return r
+		}
+
+		parent := canonicalOwnersDir(oc.FindApproverOwnersForFile(filepath.Join(filepath.Dir(dir), "OWNERS")))
+		if parent == dir {
+			return r
+		}
+		dir = parent
+	}
+}
+
+func canonicalOwnersDir(dir string) string {
+	if dir == "." || dir == "/" {
+		return ""
+	}
+
+	return dir
+}
+
+// handleOwnersCommand replies with the approvers of the OWNERS files owning
+// the path at the target branch, which answers who can approve it.
+func (bot *robot) handleOwnersCommand(c *noteCommand, path string) error {
+	file := filepath.Clean(strings.Trim(strings.Trim(path, "`"), "/"))
+	if file == "." || file == ".." || strings.HasPrefix(file, "../") {
+		return c.reply(bot, fmt.Sprintf("`%s` is not a path of this repository.", path))
+	}
+
+	oc, err := bot.loadOwners(c.org, c.repo, c.pr.base, c.cfg.forAuthor(c.pr.author))
+	if err != nil {
+		return err
+	}
+
+	return c.reply(bot, ownersReply(file, c.pr.base, owningDirs(oc, file)))
+}
+
+func ownersReply(file, branch string, dirs []owningDir) string {
+	b := new(strings.Builder)
+
+	fmt.Fprintf(b, "The approvers of `%s` on the branch `%s`, from the nearest OWNERS file:\n\n", file, branch)
+
+	found := false
+	for _, v := range dirs {
+		approvers := "no approvers"
+		if len(v.approvers) > 0 {
+			approvers = strings.Join(v.approvers, ", ")
+			found = true
+		}
+
+		fmt.Fprintf(b, "- `%s`: %s", filepath.Join(v.dir, "OWNERS"), approvers)
+		if v.noParentOwners {
+			b.WriteString(" (no_parent_owners, so the approvers of the parent directories can't approve it)")
+		}
+		b.WriteString("\n")
+	}
+
+	if !found {
+		b.WriteString("\nNo OWNERS file has approvers for it, so it can't be approved.")
+	} else {
+		b.WriteString("\nAny one of them can approve it.")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,178 @@
+// Package approveclient is the client of the status API of the approve robot,
+// for the services which need the approval state of PRs, such as the robot
+// merging PRs.
+package approveclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	statusPath = "/v1/approve/"
+
+	defaultRetries = 3
+	defaultBackoff = time.Second
+)
+
+// Approval is an approval of a PR.
+type Approval struct {
+	Login string `json:"login"`
+	How   string `json:"how"`
+	// CommentID is the ID of the comment which approved. It is 0 if the
+	// approval is implied, such as the one of the author.
+	CommentID int    `json:"comment_id,omitempty"`
+	URL       string `json:"url"`
+}
+
+// Status is the approval status of a PR.
+type Status struct {
+	Approved           bool       `json:"approved"`
+	Approvers          []string   `json:"approvers"`
+	UnapprovedFiles    []string   `json:"unapproved_files"`
+	SuggestedApprovers []string   `json:"suggested_approvers"`
+	Approvals          []Approval `json:"approvals"`
+	// Instructions tells the viewer what they can do for the approval.
+	Instructions string `json:"instructions"`
+	// LabelsToAdd and LabelsToRemove are the labels of approval which the
+	// robot would add to and remove from the PR when handling it now.
+	LabelsToAdd    []string `json:"labels_to_add,omitempty"`
+	LabelsToRemove []string `json:"labels_to_remove,omitempty"`
+}
+
+// APIError is the error responded by the robot.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("approve robot responded %d: %s", e.StatusCode, e.Message)
+}
+
+// Client queries the approval status of PRs from the approve robot.
+type Client struct {
+	endpoint string
+	hc       *http.Client
+	retries  int
+	backoff  time.Duration
+}
+
+// Option customizes the client.
+type Option func(*Client)
+
+// WithHTTPClient makes the client send the requests by hc.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.hc = hc
+	}
+}
+
+// WithRetries sets how many times a request is retried on network errors and
+// server errors, and the backoff before the first retry which doubles on each
+// retry.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// New constructs a client of the robot served at endpoint, such as
+// http://robot-gitee-approve:8888.
+func New(endpoint string, opts ...Option) *Client {
+	c := &Client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		hc:       &http.Client{Timeout: 30 * time.Second},
+		retries:  defaultRetries,
+		backoff:  defaultBackoff,
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+// GetStatus returns the approval status of the PR. The instructions of the
+// status are tailored to viewer if it is not empty.
+func (c *Client) GetStatus(ctx context.Context, org, repo string, number int, viewer string) (Status, error) {
+	u := fmt.Sprintf(
+		"%s%s%s/%s/%d", c.endpoint, statusPath,
+		url.PathEscape(org), url.PathEscape(repo), number,
+	)
+	if viewer != "" {
+		u += "?viewer=" + url.QueryEscape(viewer)
+	}
+
+	var s Status
+	err := c.get(ctx, u, &s)
+
+	return s, err
+}
+
+func (c *Client) get(ctx context.Context, u string, v interface{}) error {
+	backoff := c.backoff
+
+	for i := 0; ; i++ {
+		err := c.getOnce(ctx, u, v)
+		if err == nil || i >= c.retries || !retryable(ctx, err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) getOnce(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(b))}
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// retryable reports whether the request may succeed if retried, which is
+// the case for the network errors and the server errors.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	switch e := err.(type) {
+	case *APIError:
+		return e.StatusCode >= http.StatusInternalServerError
+	case *url.Error:
+		return true
+	}
+
+	return false
+}
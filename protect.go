@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+const redacted = "[REDACTED]"
+
+// sealedMagic prefixes the persisted data encrypted, so that the data written
+// before the encryption is enabled can still be read.
+var sealedMagic = []byte("approve-sealed-v1\n")
+
+// secretPatterns match the personal data and the credentials which may be
+// pasted into the comments or the PR bodies by accident.
+var secretPatterns = []struct {
+	reg  *regexp.Regexp
+	repl string
+}{
+	{
+		regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+		redacted,
+	},
+	{
+		regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+		redacted,
+	},
+	{
+		regexp.MustCompile(`(?i)\b(access_token|private_token|token|password|passwd|secret|api_?key)(["']?[\t ]*[=:][\t ]*["']?)[^\s"'&,;]+`),
+		"${1}${2}" + redacted,
+	},
+	{
+		regexp.MustCompile(`(?i)\b(bearer|basic)[\t ]+[A-Za-z0-9._~+/-]+=*`),
+		"${1} " + redacted,
+	},
+	{
+		// The tokens of GitHub and GitLab, and the personal access tokens
+		// of Gitee which are 32 hex characters.
+		regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|glpat-[A-Za-z0-9_-]{20,}|[0-9a-f]{32})\b`),
+		redacted,
+	},
+}
+
+// scrubText replaces the personal data and the credentials in the text.
+func scrubText(s string) string {
+	for _, p := range secretPatterns {
+		s = p.reg.ReplaceAllString(s, p.repl)
+	}
+
+	return s
+}
+
+// scrubValue scrubs the strings in the value decoded from JSON.
+func scrubValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case string:
+		return scrubText(x)
+
+	case []interface{}:
+		for i := range x {
+			x[i] = scrubValue(x[i])
+		}
+
+	case map[string]interface{}:
+		for k := range x {
+			x[k] = scrubValue(x[k])
+		}
+	}
+
+	return v
+}
+
+// scrubJSON scrubs the strings of the JSON document. The keys are kept, as
+// they are written by the robot rather than by users.
+func scrubJSON(b []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(scrubValue(v))
+}
+
+// persistProtection protects the data written to the disk, which are the
+// snapshots, the settings, the suggestion load, the spooled events and the
+// recorded events, by encrypting them, and scrubs the recorded events. The
+// zero value, as well as nil, writes the data as it is.
+type persistProtection struct {
+	// scrub scrubs the personal data and the credentials before the data
+	// is written. It is meant for the records kept for the inspection only,
+	// since it alters the data, such as the comments of the events, which
+	// the robot still works on.
+	scrub bool
+
+	// aead encrypts the data with AES-GCM if it is set.
+	aead cipher.AEAD
+}
+
+// newPersistProtection reads the key of the encryption from the file, which
+// holds 32 bytes encoded in hex or base64. Nothing is encrypted if the file
+// is empty.
+func newPersistProtection(scrub bool, keyFile string) (*persistProtection, error) {
+	p := &persistProtection{scrub: scrub}
+	if keyFile == "" {
+		return p, nil
+	}
+
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read the persist key: %v", err)
+	}
+
+	key, err := decodeKey(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// withoutScrub returns the protection encrypting the data as p does without
+// scrubbing them, for the data which the robot still works on.
+func (p *persistProtection) withoutScrub() *persistProtection {
+	if p == nil {
+		return nil
+	}
+
+	return &persistProtection{aead: p.aead}
+}
+
+func decodeKey(s string) ([]byte, error) {
+	if b, err := hex.DecodeString(s); err == nil && len(b) == 32 {
+		return b, nil
+	}
+
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == 32 {
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("the persist key must be 32 bytes encoded in hex or base64")
+}
+
+// seal scrubs and encrypts the JSON data as configured.
+func (p *persistProtection) seal(b []byte) ([]byte, error) {
+	if p == nil {
+		return b, nil
+	}
+
+	if p.scrub {
+		v, err := scrubJSON(b)
+		if err != nil {
+			return nil, err
+		}
+		b = v
+	}
+
+	if p.aead == nil {
+		return b, nil
+	}
+
+	nonce := make([]byte, p.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	r := append(append([]byte{}, sealedMagic...), nonce...)
+
+	return p.aead.Seal(r, nonce, b, sealedMagic), nil
+}
+
+// open decrypts the data sealed. The data which is not encrypted is returned
+// as it is.
+func (p *persistProtection) open(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, sealedMagic) {
+		return b, nil
+	}
+
+	if p == nil || p.aead == nil {
+		return nil, fmt.Errorf("the data is encrypted, but no persist key is given")
+	}
+
+	b = b[len(sealedMagic):]
+	if len(b) < p.aead.NonceSize() {
+		return nil, fmt.Errorf("the encrypted data is truncated")
+	}
+
+	n := p.aead.NonceSize()
+	r, err := p.aead.Open(nil, b[:n], b[n:], sealedMagic)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt the data: %v", err)
+	}
+
+	return r, nil
+}
+
+// readFile reads the file written by writeFile.
+func (p *persistProtection) readFile(file string) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.open(b)
+}
+
+// writeFile seals the JSON data and writes it to the file, which is only
+// readable by the owner. The data is written to a temporary file which
+// replaces the file once it is synced, so that the file is never left
+// written partially.
+func (p *persistProtection) writeFile(file string, b []byte) error {
+	b, err := p.seal(b)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	tmp := f.Name()
+	if err = writeSync(f, b, 0600); err == nil {
+		err = os.Rename(tmp, file)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+	}
+
+	return err
+}
+
+// writeSync writes the data to the file, sets its permission and syncs it
+// before closing it.
+func writeSync(f *os.File, b []byte, perm os.FileMode) error {
+	_, err := f.Write(b)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPersistProtectionRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := newPersistProtection(true, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scrubbed, err := newPersistProtection(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte(`{"body":"/approve token: 0123456789abcdef0123456789abcdef by a@b.com"}`)
+	scrubbedData := []byte(`{"body":"/approve token: [REDACTED] by [REDACTED]"}`)
+
+	cases := []struct {
+		name      string
+		write     *persistProtection
+		read      *persistProtection
+		sealed    bool
+		expect    []byte
+		expectErr bool
+	}{
+		{
+			name:   "not protected",
+			expect: data,
+		},
+		{
+			name:   "scrubbed",
+			write:  scrubbed,
+			expect: scrubbedData,
+		},
+		{
+			name:   "scrubbing skipped",
+			write:  scrubbed.withoutScrub(),
+			expect: data,
+		},
+		{
+			name:   "encrypted and scrubbed",
+			write:  encrypted,
+			read:   encrypted,
+			sealed: true,
+			expect: scrubbedData,
+		},
+		{
+			name:   "encrypted without scrubbing",
+			write:  encrypted.withoutScrub(),
+			read:   encrypted,
+			sealed: true,
+			expect: data,
+		},
+		{
+			name:   "not encrypted but read with the key",
+			read:   encrypted,
+			expect: data,
+		},
+		{
+			name:      "encrypted but read without the key",
+			write:     encrypted,
+			sealed:    true,
+			expectErr: true,
+		},
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		file := filepath.Join(dir, "data", c.name)
+
+		// Write twice to replace the existing file.
+		for j := 0; j < 2; j++ {
+			if err := c.write.writeFile(file, data); err != nil {
+				t.Errorf("%s: write: %v", c.name, err)
+			}
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Errorf("%s: stat: %v", c.name, err)
+			continue
+		}
+		if m := info.Mode().Perm(); m != 0600 {
+			t.Errorf("%s: expect mode 0600, but got %o", c.name, m)
+		}
+
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Errorf("%s: read raw: %v", c.name, err)
+			continue
+		}
+		if v := bytes.HasPrefix(raw, sealedMagic); v != c.sealed {
+			t.Errorf("%s: expect sealed %t, but got %t", c.name, c.sealed, v)
+		}
+
+		b, err := c.read.readFile(file)
+		if (err != nil) != c.expectErr {
+			t.Errorf("%s: expect error %t, but got %v", c.name, c.expectErr, err)
+		}
+		if err == nil && !bytes.Equal(b, c.expect) {
+			t.Errorf("%s: expect %s, but got %s", c.name, c.expect, b)
+		}
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(cases) {
+		t.Errorf("expect %d files without the temporary ones, but got %d", len(cases), len(files))
+	}
+}
+
+func TestPersistProtectionTampered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(keyFile, []byte(strings.Repeat("cd", 32)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := newPersistProtection(false, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := p.seal([]byte(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "truncated",
+			data: sealed[:len(sealedMagic)+2],
+		},
+		{
+			name: "modified",
+			data: append(append([]byte{}, sealed[:len(sealed)-1]...), sealed[len(sealed)-1]^1),
+		},
+	}
+
+	for _, c := range cases {
+		if _, err := p.open(c.data); err == nil {
+			t.Errorf("%s: expect the error of opening the data", c.name)
+		}
+	}
+}
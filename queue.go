@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/metrics"
+)
+
+// prJob is a pending handling of a PR.
+type prJob struct {
+	org  string
+	repo string
+	pr   prInfo
+	cfg  *botConfig
+	log  *logrus.Entry
+
+	// attempt is the number of the retries of the job.
+	attempt int
+
+	// refresh regenerates the notification even if nothing has changed.
+	refresh bool
+}
+
+func (j *prJob) key() string {
+	return fmt.Sprintf("%s/%s/%d", j.org, j.repo, j.pr.number)
+}
+
+type queuedPR struct {
+	// job is the latest one which replaces the earlier ones not run yet.
+	job prJob
+
+	scheduled bool
+	running   bool
+	// dirty means the PR changed while it was being handled, so it should be
+	// handled once more after that.
+	dirty bool
+}
+
+// prQueue coalesces the events of a PR arriving within the window into one
+// handling, and handles each PR serially. The events of different PRs are
+// handled concurrently. The PRs failing with transient errors are handled
+// again after a backoff, up to maxRetries times.
+type prQueue struct {
+	window     time.Duration
+	maxRetries int
+	run        func(*prJob) error
+
+	lock  sync.Mutex
+	items map[string]*queuedPR
+	wg    sync.WaitGroup
+}
+
+func newPRQueue(window time.Duration, maxRetries int, run func(*prJob) error) *prQueue {
+	return &prQueue{
+		window:     window,
+		maxRetries: maxRetries,
+		run:        run,
+		items:      map[string]*queuedPR{},
+	}
+}
+
+// enqueue schedules the job to run after the window unless the PR has been
+// scheduled already, in which case the job replaces the scheduled one.
+func (q *prQueue) enqueue(job prJob) {
+	k := job.key()
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	item, ok := q.items[k]
+	if !ok {
+		item = &queuedPR{}
+		q.items[k] = item
+	}
+	// The refresh asked for by the job replaced is kept.
+	if item.scheduled {
+		job.refresh = job.refresh || item.job.refresh
+	}
+	item.job = job
+
+	if item.running {
+		item.dirty = true
+		return
+	}
+
+	if !item.scheduled {
+		q.schedule(k, item, q.window)
+	}
+}
+
+// schedule must be called with the lock held.
+func (q *prQueue) schedule(k string, item *queuedPR, delay time.Duration) {
+	item.scheduled = true
+	q.wg.Add(1)
+	time.AfterFunc(delay, func() {
+		defer q.wg.Done()
+		q.process(k)
+	})
+}
+
+func (q *prQueue) process(k string) {
+	q.lock.Lock()
+	item := q.items[k]
+	item.scheduled = false
+	item.running = true
+	job := item.job
+	q.lock.Unlock()
+
+	err := q.run(&job)
+	if err != nil {
+		job.log.WithError(err).Error("handle the PR")
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	item.running = false
+
+	switch {
+	case item.dirty:
+		// The new event triggers a handling anyway.
+		item.dirty = false
+		q.schedule(k, item, q.window)
+
+	case job.attempt < q.maxRetries && isTransient(err):
+		job.attempt++
+		item.job = job
+		q.schedule(k, item, retryDelay(job.attempt))
+
+		metrics.PRRequeued(job.org, job.repo)
+		job.log.Infof("Retry handling the PR in %s, attempt %d.", retryDelay(job.attempt), job.attempt)
+
+	default:
+		delete(q.items, k)
+	}
+}
+
+// pending returns the number of the PRs scheduled or being handled.
+func (q *prQueue) pending() int {
+	if q == nil {
+		return 0
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.items)
+}
+
+// wait waits until all the PRs scheduled have been handled.
+func (q *prQueue) wait() {
+	q.wg.Wait()
+}
+
+// resumeQueued queues the PRs which were waiting in the queue when the robot
+// stopped last time.
+func (bot *robot) resumeQueued() {
+	_, c := bot.cfgAgent.GetConfig()
+
+	for _, v := range bot.snapshots.list() {
+		if !v.Queued || v.Closed {
+			continue
+		}
+
+		log := logrus.WithFields(logrus.Fields{"org": v.Org, "repo": v.Repo, "number": v.Number})
+
+		pr, err := bot.cli.cli.GetGiteePullRequest(v.Org, v.Repo, int32(v.Number))
+		if err != nil {
+			log.WithError(err).Error("get the queued PR")
+			continue
+		}
+
+		info := prInfoFromPR(&pr)
+		cfg, err := bot.getEventConfig(c, v.Org, v.Repo, info.base)
+		if err != nil {
+			log.WithError(err).Error("get the config of the queued PR")
+			continue
+		}
+		if cfg == nil {
+			bot.snapshots.setQueued(v.Org, v.Repo, info, false)
+			continue
+		}
+
+		bot.queue.enqueue(prJob{org: v.Org, repo: v.Repo, pr: info, cfg: cfg, log: log})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// commentReaction is an emoji reaction to a comment.
+type commentReaction struct {
+	Content string `json:"content"`
+	User    struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+// ListCommentReactions lists the reactions to the comment of the PRs, which
+// the client of the library doesn't support.
+func (c giteeClient) ListCommentReactions(org, repo string, commentID int32) ([]commentReaction, error) {
+	u := fmt.Sprintf(
+		"%s/repos/%s/%s/pulls/comments/%d/reactions",
+		giteeAPIEndpoint, url.PathEscape(org), url.PathEscape(repo), commentID,
+	)
+
+	resp, err := c.do(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("list reactions: %s %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	var r []commentReaction
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("list reactions: %v", err)
+	}
+
+	return r, nil
+}
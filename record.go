@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensourceways/community-robot-lib/giteeclient"
+	sdk "github.com/opensourceways/go-gitee/gitee"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	eventKindPR   = "pr"
+	eventKindNote = "note"
+
+	// responsesFile is the file in the record directory which keeps the
+	// responses of the Gitee API in the order they were got.
+	responsesFile = "responses.json"
+)
+
+// eventRecorder persists the webhook events and the responses of the Gitee
+// API to a directory, so that the handling of the events can be reproduced
+// by replaying them with replayClient.
+type eventRecorder struct {
+	dir     string
+	protect *persistProtection
+
+	lock      sync.Mutex
+	seq       int
+	responses map[string][]json.RawMessage
+}
+
+func newEventRecorder(dir string, protect *persistProtection) (*eventRecorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &eventRecorder{dir: dir, protect: protect, responses: map[string][]json.RawMessage{}}, nil
+}
+
+func (r *eventRecorder) recordEvent(kind string, e interface{}) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		logrus.WithError(err).Error("record event")
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.seq++
+	name := fmt.Sprintf("%d-%06d-%s.json", time.Now().Unix(), r.seq, kind)
+	if err := r.protect.writeFile(filepath.Join(r.dir, name), b); err != nil {
+		logrus.WithError(err).Error("record event")
+	}
+}
+
+func (r *eventRecorder) recordResponse(key string, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		logrus.WithError(err).Errorf("record response of %s", key)
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.responses[key] = append(r.responses[key], b)
+
+	b, err = json.Marshal(r.responses)
+	if err == nil {
+		err = r.protect.writeFile(filepath.Join(r.dir, responsesFile), b)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("save responses")
+	}
+}
+
+func callKey(method string, args ...interface{}) string {
+	v := make([]string, len(args))
+	for i, a := range args {
+		v[i] = fmt.Sprint(a)
+	}
+
+	return method + " " + strings.Join(v, "/")
+}
+
+// recordingClient records the successful responses of the read calls.
+type recordingClient struct {
+	iClient
+	r *eventRecorder
+}
+
+func (c recordingClient) GetPullRequestChangesPage(org, repo string, number int32, page, perPage int) ([]sdk.PullRequestFiles, error) {
+	v, err := c.iClient.GetPullRequestChangesPage(org, repo, number, page, perPage)
+	if err == nil {
+		c.r.recordResponse(callKey("GetPullRequestChangesPage", org, repo, number, page, perPage), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) GetPRLabels(org, repo string, number int32) ([]sdk.Label, error) {
+	v, err := c.iClient.GetPRLabels(org, repo, number)
+	if err == nil {
+		c.r.recordResponse(callKey("GetPRLabels", org, repo, number), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) ListPRComments(org, repo string, number int32) ([]sdk.PullRequestComments, error) {
+	v, err := c.iClient.ListPRComments(org, repo, number)
+	if err == nil {
+		c.r.recordResponse(callKey("ListPRComments", org, repo, number), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) GetBot() (sdk.User, error) {
+	v, err := c.iClient.GetBot()
+	if err == nil {
+		c.r.recordResponse(callKey("GetBot"), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) GetGiteePullRequest(org, repo string, number int32) (sdk.PullRequest, error) {
+	v, err := c.iClient.GetGiteePullRequest(org, repo, number)
+	if err == nil {
+		c.r.recordResponse(callKey("GetGiteePullRequest", org, repo, number), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) GetUserPermissionsOfRepo(org, repo, login string) (sdk.ProjectMemberPermission, error) {
+	v, err := c.iClient.GetUserPermissionsOfRepo(org, repo, login)
+	if err == nil {
+		c.r.recordResponse(callKey("GetUserPermissionsOfRepo", org, repo, login), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) GetPullRequests(org, repo string, opts giteeclient.ListPullRequestOpt) ([]sdk.PullRequest, error) {
+	v, err := c.iClient.GetPullRequests(org, repo, opts)
+	if err == nil {
+		c.r.recordResponse(callKey("GetPullRequests", org, repo, opts.State), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) GetPathContent(org, repo, path, ref string) (sdk.Content, error) {
+	v, err := c.iClient.GetPathContent(org, repo, path, ref)
+	if err == nil {
+		c.r.recordResponse(callKey("GetPathContent", org, repo, path, ref), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) GetPRCommits(org, repo string, number int32) ([]sdk.PullRequestCommits, error) {
+	v, err := c.iClient.GetPRCommits(org, repo, number)
+	if err == nil {
+		c.r.recordResponse(callKey("GetPRCommits", org, repo, number), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) ListPROperationLogs(org, repo string, number int32) ([]sdk.OperateLog, error) {
+	v, err := c.iClient.ListPROperationLogs(org, repo, number)
+	if err == nil {
+		c.r.recordResponse(callKey("ListPROperationLogs", org, repo, number), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) GetPRComment(org, repo string, commentID int32) (sdk.PullRequestComments, error) {
+	v, err := c.iClient.GetPRComment(org, repo, commentID)
+	if err == nil {
+		c.r.recordResponse(callKey("GetPRComment", org, repo, commentID), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) GetRepoTree(org, repo, ref string) ([]treeEntry, error) {
+	v, err := c.iClient.GetRepoTree(org, repo, ref)
+	if err == nil {
+		c.r.recordResponse(callKey("GetRepoTree", org, repo, ref), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) GetRepos(org string) ([]sdk.Project, error) {
+	v, err := c.iClient.GetRepos(org)
+	if err == nil {
+		c.r.recordResponse(callKey("GetRepos", org), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) GetRepoLabels(owner, repo string) ([]sdk.Label, error) {
+	v, err := c.iClient.GetRepoLabels(owner, repo)
+	if err == nil {
+		c.r.recordResponse(callKey("GetRepoLabels", owner, repo), v)
+	}
+	return v, err
+}
+
+func (c recordingClient) ListCommentReactions(org, repo string, commentID int32) ([]commentReaction, error) {
+	v, err := c.iClient.ListCommentReactions(org, repo, commentID)
+	if err == nil {
+		c.r.recordResponse(callKey("ListCommentReactions", org, repo, commentID), v)
+	}
+	return v, err
+}
+
+// replayClient answers the read calls with the recorded responses in order,
+// repeating the last one when they run out, and only logs the write calls.
+type replayClient struct {
+	lock      sync.Mutex
+	responses map[string][]json.RawMessage
+}
+
+func newReplayClient(dir string, protect *persistProtection) (*replayClient, error) {
+	c := &replayClient{responses: map[string][]json.RawMessage{}}
+
+	b, err := protect.readFile(filepath.Join(dir, responsesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &c.responses); err != nil {
+		return nil, fmt.Errorf("load responses: %v", err)
+	}
+
+	return c, nil
+}
+
+func (c *replayClient) replay(v interface{}, method string, args ...interface{}) error {
+	key := callKey(method, args...)
+
+	c.lock.Lock()
+	rs := c.responses[key]
+	if len(rs) > 1 {
+		c.responses[key] = rs[1:]
+	}
+	c.lock.Unlock()
+
+	if len(rs) == 0 {
+		return fmt.Errorf("no recorded response of %s", key)
+	}
+
+	return json.Unmarshal(rs[0], v)
+}
+
+func (c *replayClient) write(method string, args ...interface{}) error {
+	logrus.Infof("replay: %s", callKey(method, args...))
+	return nil
+}
+
+func (c *replayClient) GetPullRequestChangesPage(org, repo string, number int32, page, perPage int) ([]sdk.PullRequestFiles, error) {
+	var v []sdk.PullRequestFiles
+	err := c.replay(&v, "GetPullRequestChangesPage", org, repo, number, page, perPage)
+	return v, err
+}
+
+func (c *replayClient) GetPRLabels(org, repo string, number int32) ([]sdk.Label, error) {
+	var v []sdk.Label
+	err := c.replay(&v, "GetPRLabels", org, repo, number)
+	return v, err
+}
+
+func (c *replayClient) ListPRComments(org, repo string, number int32) ([]sdk.PullRequestComments, error) {
+	var v []sdk.PullRequestComments
+	err := c.replay(&v, "ListPRComments", org, repo, number)
+	return v, err
+}
+
+func (c *replayClient) GetBot() (sdk.User, error) {
+	var v sdk.User
+	err := c.replay(&v, "GetBot")
+	return v, err
+}
+
+func (c *replayClient) GetGiteePullRequest(org, repo string, number int32) (sdk.PullRequest, error) {
+	var v sdk.PullRequest
+	err := c.replay(&v, "GetGiteePullRequest", org, repo, number)
+	return v, err
+}
+
+func (c *replayClient) GetUserPermissionsOfRepo(org, repo, login string) (sdk.ProjectMemberPermission, error) {
+	var v sdk.ProjectMemberPermission
+	err := c.replay(&v, "GetUserPermissionsOfRepo", org, repo, login)
+	return v, err
+}
+
+func (c *replayClient) GetPullRequests(org, repo string, opts giteeclient.ListPullRequestOpt) ([]sdk.PullRequest, error) {
+	var v []sdk.PullRequest
+	err := c.replay(&v, "GetPullRequests", org, repo, opts.State)
+	return v, err
+}
+
+func (c *replayClient) GetPathContent(org, repo, path, ref string) (sdk.Content, error) {
+	var v sdk.Content
+	err := c.replay(&v, "GetPathContent", org, repo, path, ref)
+	return v, err
+}
+
+func (c *replayClient) GetPRCommits(org, repo string, number int32) ([]sdk.PullRequestCommits, error) {
+	var v []sdk.PullRequestCommits
+	err := c.replay(&v, "GetPRCommits", org, repo, number)
+	return v, err
+}
+
+func (c *replayClient) ListPROperationLogs(org, repo string, number int32) ([]sdk.OperateLog, error) {
+	var v []sdk.OperateLog
+	err := c.replay(&v, "ListPROperationLogs", org, repo, number)
+	return v, err
+}
+
+func (c *replayClient) GetPRComment(org, repo string, commentID int32) (sdk.PullRequestComments, error) {
+	var v sdk.PullRequestComments
+	err := c.replay(&v, "GetPRComment", org, repo, commentID)
+	return v, err
+}
+
+func (c *replayClient) GetRepoTree(org, repo, ref string) ([]treeEntry, error) {
+	var v []treeEntry
+	err := c.replay(&v, "GetRepoTree", org, repo, ref)
+	return v, err
+}
+
+func (c *replayClient) GetRepos(org string) ([]sdk.Project, error) {
+	var v []sdk.Project
+	err := c.replay(&v, "GetRepos", org)
+	return v, err
+}
+
+func (c *replayClient) GetRepoLabels(owner, repo string) ([]sdk.Label, error) {
+	var v []sdk.Label
+	err := c.replay(&v, "GetRepoLabels", owner, repo)
+	return v, err
+}
+
+func (c *replayClient) ListCommentReactions(org, repo string, commentID int32) ([]commentReaction, error) {
+	var v []commentReaction
+	err := c.replay(&v, "ListCommentReactions", org, repo, commentID)
+	return v, err
+}
+
+func (c *replayClient) DeletePRComment(org, repo string, ID int32) error {
+	return c.write("DeletePRComment", org, repo, ID)
+}
+
+func (c *replayClient) UpdatePRComment(org, repo string, commentID int32, comment string) error {
+	return c.write("UpdatePRComment", org, repo, commentID, comment)
+}
+
+func (c *replayClient) CreatePRComment(org, repo string, number int32, comment string) error {
+	return c.write("CreatePRComment", org, repo, number, comment)
+}
+
+func (c *replayClient) AddPRLabel(org, repo string, number int32, label string) error {
+	return c.write("AddPRLabel", org, repo, number, label)
+}
+
+func (c *replayClient) RemovePRLabel(org, repo string, number int32, label string) error {
+	return c.write("RemovePRLabel", org, repo, number, label)
+}
+
+func (c *replayClient) CreateRepoLabel(org, repo, label, color string) error {
+	return c.write("CreateRepoLabel", org, repo, label, color)
+}
+
+func (c *replayClient) CreateIssue(org, repo, title, body string) (sdk.Issue, error) {
+	return sdk.Issue{}, c.write("CreateIssue", org, repo, title, body)
+}
+
+func (c *replayClient) AssignPR(owner, repo string, number int32, logins []string) error {
+	return c.write("AssignPR", owner, repo, number, logins)
+}
+
+func (c *replayClient) CreateCheckRun(org, repo string, run checkRun) error {
+	return c.write("CreateCheckRun", org, repo, run)
+}
+
+// replayEvents handles the recorded events in the directory in order.
+func (bot *robot) replayEvents(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*-*-*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	_, cfg := bot.cfgAgent.GetConfig()
+
+	for _, f := range files {
+		b, err := bot.persist.readFile(f)
+		if err != nil {
+			return err
+		}
+
+		log := logrus.WithField("event", filepath.Base(f))
+
+		switch {
+		case strings.HasSuffix(f, "-"+eventKindPR+".json"):
+			e := new(sdk.PullRequestEvent)
+			if err = json.Unmarshal(b, e); err == nil {
+				err = bot.handlePREvent(e, cfg, log)
+			}
+
+		case strings.HasSuffix(f, "-"+eventKindNote+".json"):
+			e := new(sdk.NoteEvent)
+			if err = json.Unmarshal(b, e); err == nil {
+				err = bot.handleNoteEvent(e, cfg, log)
+			}
+
+		default:
+			continue
+		}
+
+		if err != nil {
+			log.WithError(err).Error("replay event")
+		}
+	}
+
+	return nil
+}
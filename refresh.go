@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const refreshPathPrefix = "/v1/refresh/"
+
+// refreshCommandReg matches "/approve refresh" which regenerates the
+// notification.
+var refreshCommandReg = regexp.MustCompile(`(?mi)^/approve[\t ]+refresh[\t ]*$`)
+
+// handleRefreshCommand regenerates the notification for the members of the
+// OWNERS files, which are the reviewers, the root approvers and the approvers
+// of the files changed by the PR.
+func (bot *robot) handleRefreshCommand(c *noteCommand) error {
+	ok, err := bot.isOwnersMember(c.org, c.repo, c.pr, c.cfg, c.commenter)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return c.reply(bot, "Only the members of the OWNERS files can refresh the notification.")
+	}
+
+	key := fmt.Sprintf("%s/%s/%d/%s", c.org, c.repo, c.pr.number, c.commenter)
+	if allowed, _ := bot.throttle.allow(key, c.cfg.MaxCommandsPerHour, time.Now()); !allowed {
+		return nil
+	}
+
+	return bot.refresh(c.org, c.repo, c.pr, c.cfg, c.log)
+}
+
+func (bot *robot) isOwnersMember(org, repo string, pr prInfo, cfg *botConfig, login string) (bool, error) {
+	oc, err := bot.loadOwners(org, repo, pr.base, cfg.forAuthor(pr.author))
+	if err != nil {
+		return false, err
+	}
+
+	login = strings.ToLower(login)
+	if oc.AllReviewers().Has(login) || oc.TopLevelApprovers().Has(login) {
+		return true, nil
+	}
+
+	changes, err := bot.cli.GetPullRequestChanges(org, repo, pr.number)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range changes {
+		if oc.Approvers(changes[i].Filename).Has(login) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// refresh handles the PR and regenerates its notification from the current
+// state even if nothing has changed.
+func (bot *robot) refresh(org, repo string, pr prInfo, cfg *botConfig, log *logrus.Entry) error {
+	return bot.submit(prJob{org: org, repo: repo, pr: pr, cfg: cfg, log: log, refresh: true})
+}
+
+// refreshHandler serves POST /v1/refresh/{org}/{repo}/{number}, which is for
+// the admins to regenerate the notification, such as after the templates
+// changed. The request must carry the refresh token, since it makes the robot
+// write to the PR. It responds 202 if the PR is queued rather than handled.
+func (bot *robot) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !bearerTokenMatches(r, bot.refreshToken) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	org, repo, number, err := parsePRPath(refreshPathPrefix, r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	v, err := bot.cli.cli.GetGiteePullRequest(org, repo, int32(number))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	pr := prInfoFromPR(&v)
+
+	_, c := bot.cfgAgent.GetConfig()
+	cfg, err := bot.getConfig(c, org, repo, pr.base)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log := logrus.WithFields(logrus.Fields{"org": org, "repo": repo, "number": number})
+	if err := bot.refresh(org, repo, pr, cfg, log); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if bot.queue != nil {
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// adminOnly serves the request by the handler only if it carries the admin
+// token.
+func (bot *robot) adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return bearerOnly(h, bot.adminToken)
+}
+
+// bearerOnly serves the request by the handler only if it carries any of the
+// tokens as the bearer token.
+func bearerOnly(h http.HandlerFunc, tokens ...[]byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, token := range tokens {
+			if bearerTokenMatches(r, token) {
+				h(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}
+}
+
+// bearerTokenMatches reports whether the request carries the token as the
+// bearer token. No request matches an empty token.
+func bearerTokenMatches(r *http.Request, token []byte) bool {
+	v := r.Header.Get("Authorization")
+	if len(token) == 0 || !strings.HasPrefix(v, "Bearer ") {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(v, "Bearer ")), token) == 1
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+// requiredOwners is the part of an OWNERS file declaring the logins who must
+// approve the changes under its directory, whoever else approves them.
+// required_reviewers is taken as an alias of required_approvers.
+type requiredOwners struct {
+	RequiredApprovers []string `json:"required_approvers"`
+	RequiredReviewers []string `json:"required_reviewers"`
+}
+
+func (r *requiredOwners) logins() []string {
+	return sets.NewString(r.RequiredApprovers...).Insert(r.RequiredReviewers...).List()
+}
+
+const (
+	// requiredOwnersCacheSize is the max number of the commits whose
+	// required approvers are cached, beyond which the least recently used
+	// ones are evicted.
+	requiredOwnersCacheSize = 200
+)
+
+// repoRequiredOwners is the required approvers of the OWNERS files of a repo
+// at a commit. The keys of files are the directories of the OWNERS files, and
+// an OWNERS file is read when a PR changes the files under it.
+type repoRequiredOwners struct {
+	dirs sets.String
+
+	lock  sync.Mutex
+	files map[string][]string
+
+	// usedAt is guarded by the lock of the cache.
+	usedAt time.Time
+}
+
+// requiredOwnersCache caches the required approvers of the OWNERS files of
+// the repos at the base commits of the PRs. The tree and each OWNERS file of
+// a commit are fetched once even if the PRs are handled concurrently, and
+// the fetches don't block the PRs of the other repos or commits.
+type requiredOwnersCache struct {
+	cli   iClient
+	calls singleflight.Group
+
+	lock  sync.Mutex
+	items map[string]*repoRequiredOwners
+}
+
+func newRequiredOwnersCache(cli iClient) *requiredOwnersCache {
+	return &requiredOwnersCache{cli: cli, items: map[string]*repoRequiredOwners{}}
+}
+
+// get returns the required approvers declared by the OWNERS files of the
+// directories of the files and of their parent directories, keyed by the
+// directories.
+func (c *requiredOwnersCache) get(org, repo, ref string, files []string) (map[string][]string, error) {
+	k := fmt.Sprintf("%s/%s@%s", org, repo, ref)
+
+	v, err := c.load(org, repo, ref, k)
+	if err != nil {
+		return nil, err
+	}
+
+	r := map[string][]string{}
+	for _, fn := range files {
+		for dir := ownersDir(fn); ; dir = ownersDir(dir) {
+			if _, done := r[dir]; !done && v.dirs.Has(dir) {
+				logins, err := c.read(org, repo, ref, k, v, dir)
+				if err != nil {
+					return nil, err
+				}
+				r[dir] = logins
+			}
+
+			if dir == "" {
+				break
+			}
+		}
+	}
+
+	for dir, logins := range r {
+		if len(logins) == 0 {
+			delete(r, dir)
+		}
+	}
+
+	return r, nil
+}
+
+// load returns the OWNERS files of the repo at the commit, listing its tree
+// if it is not cached.
+func (c *requiredOwnersCache) load(org, repo, ref, k string) (*repoRequiredOwners, error) {
+	c.lock.Lock()
+	v, ok := c.items[k]
+	if ok {
+		v.usedAt = time.Now()
+	}
+	c.lock.Unlock()
+
+	if ok {
+		return v, nil
+	}
+
+	r, err, _ := c.calls.Do("tree:"+k, func() (interface{}, error) {
+		// It may be listed just now by the call which this one missed.
+		c.lock.Lock()
+		v, ok := c.items[k]
+		c.lock.Unlock()
+
+		if ok {
+			return v, nil
+		}
+
+		tree, err := c.cli.GetRepoTree(org, repo, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		v = &repoRequiredOwners{dirs: sets.NewString(), files: map[string][]string{}}
+		for _, e := range tree {
+			if e.Type == "blob" && isOwnersFile(e.Path) {
+				v.dirs.Insert(ownersDir(e.Path))
+			}
+		}
+
+		c.add(k, v)
+
+		return v, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.(*repoRequiredOwners), nil
+}
+
+func (c *requiredOwnersCache) add(k string, v *repoRequiredOwners) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for len(c.items) >= requiredOwnersCacheSize {
+		oldest := ""
+		for key, item := range c.items {
+			if oldest == "" || item.usedAt.Before(c.items[oldest].usedAt) {
+				oldest = key
+			}
+		}
+		delete(c.items, oldest)
+	}
+
+	v.usedAt = time.Now()
+	c.items[k] = v
+}
+
+func (c *requiredOwnersCache) read(org, repo, ref, k string, v *repoRequiredOwners, dir string) ([]string, error) {
+	v.lock.Lock()
+	logins, ok := v.files[dir]
+	v.lock.Unlock()
+
+	if ok {
+		return logins, nil
+	}
+
+	r, err, _ := c.calls.Do("file:"+k+":"+dir, func() (interface{}, error) {
+		file := path.Join(dir, "OWNERS")
+		content, err := c.cli.GetPathContent(org, repo, file, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := base64.StdEncoding.DecodeString(content.Content)
+		if err != nil {
+			return nil, err
+		}
+
+		o := new(requiredOwners)
+		if err := yaml.Unmarshal(b, o); err != nil {
+			return nil, fmt.Errorf("parse %s: %v", file, err)
+		}
+
+		logins := o.logins()
+
+		v.lock.Lock()
+		v.files[dir] = logins
+		v.lock.Unlock()
+
+		return logins, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.([]string), nil
+}
+
+// ownersDir returns the parent directory of the file, which is empty for the
+// root directory of the repo.
+func ownersDir(file string) string {
+	if dir := path.Dir(file); dir != "." && dir != "/" {
+		return dir
+	}
+
+	return ""
+}
+
+// requiredOwnersRequirements returns a requirement for each login required by
+// the OWNERS files to approve the files changed by the PR. The OWNERS files
+// are read at the base commit, so that a PR can't drop its own requirements.
+// It fails closed, returning a requirement which can't be met if the OWNERS
+// files are unavailable.
+func (bot *robot) requiredOwnersRequirements(org, repo string, pr prInfo, files []string, log *logrus.Entry) []approvers.Requirement {
+	ref := pr.baseSHA
+	if ref == "" {
+		ref = pr.base
+	}
+
+	required, err := bot.required.get(org, repo, ref, files)
+	if err != nil {
+		log.WithError(err).Error("get the required approvers of the OWNERS files")
+
+		return []approvers.Requirement{{
+			Description: "The required approvers of the OWNERS files are unavailable now",
+		}}
+	}
+
+	dirs := make([]string, 0, len(required))
+	for dir := range required {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var reqs []approvers.Requirement
+	for _, dir := range dirs {
+		owners := path.Join(dir, "OWNERS")
+		for _, login := range required[dir] {
+			reqs = append(reqs, approvers.Requirement{
+				Description: fmt.Sprintf("%s requires %s to approve the changes under it", owners, login),
+				Approvers:   sets.NewString(login),
+			})
+		}
+	}
+
+	return reqs
+}
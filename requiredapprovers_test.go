@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	sdk "github.com/opensourceways/go-gitee/gitee"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+// fakeOwnersClient serves the tree and the OWNERS files of a repo.
+type fakeOwnersClient struct {
+	iClient
+
+	files   map[string]string
+	treeErr error
+	fileErr error
+
+	treeCalls int32
+}
+
+func (c *fakeOwnersClient) GetRepoTree(org, repo, ref string) ([]treeEntry, error) {
+	atomic.AddInt32(&c.treeCalls, 1)
+
+	if c.treeErr != nil {
+		return nil, c.treeErr
+	}
+
+	var r []treeEntry
+	for p := range c.files {
+		r = append(r, treeEntry{Path: p, Type: "blob"})
+	}
+	return r, nil
+}
+
+func (c *fakeOwnersClient) GetPathContent(org, repo, path, ref string) (sdk.Content, error) {
+	if c.fileErr != nil {
+		return sdk.Content{}, c.fileErr
+	}
+
+	v, ok := c.files[path]
+	if !ok {
+		return sdk.Content{}, fmt.Errorf("%s not found", path)
+	}
+	return sdk.Content{Content: base64.StdEncoding.EncodeToString([]byte(v))}, nil
+}
+
+func TestRequiredOwnersRequirements(t *testing.T) {
+	owners := map[string]string{
+		"OWNERS":            "approvers:\n- root\n",
+		"api/OWNERS":        "approvers:\n- root\nrequired_approvers:\n- api-owner\n",
+		"api/v1/OWNERS":     "required_reviewers:\n- v1-owner\n- api-owner\n",
+		"docs/OWNERS":       "approvers:\n- writer\n",
+		"broken/OWNERS":     "required_approvers: [",
+		"api/v1/ignore.txt": "",
+	}
+
+	cases := []struct {
+		name    string
+		files   []string
+		treeErr error
+		fileErr error
+
+		// required are the logins required, and unavailable means the
+		// requirement which can't be met is returned.
+		required    []string
+		unavailable bool
+	}{
+		{
+			name:  "no required approvers",
+			files: []string{"README.md", "docs/a.md"},
+		},
+		{
+			name:     "required approvers of the directory",
+			files:    []string{"api/a.go"},
+			required: []string{"api-owner"},
+		},
+		{
+			name:     "required reviewers as an alias and of the parent directory",
+			files:    []string{"api/v1/a.go"},
+			required: []string{"api-owner", "api-owner", "v1-owner"},
+		},
+		{
+			name:     "files under different directories",
+			files:    []string{"docs/a.md", "api/a.go", "README.md"},
+			required: []string{"api-owner"},
+		},
+		{
+			name:        "tree unavailable",
+			files:       []string{"README.md"},
+			treeErr:     fmt.Errorf("timeout"),
+			unavailable: true,
+		},
+		{
+			name:        "OWNERS file unavailable",
+			files:       []string{"api/a.go"},
+			fileErr:     fmt.Errorf("timeout"),
+			unavailable: true,
+		},
+		{
+			name:        "OWNERS file invalid",
+			files:       []string{"broken/a.go"},
+			unavailable: true,
+		},
+	}
+
+	log := logrus.NewEntry(logrus.New())
+	pr := prInfo{number: 1, base: "master", baseSHA: "abc"}
+
+	for _, c := range cases {
+		cli := &fakeOwnersClient{files: owners, treeErr: c.treeErr, fileErr: c.fileErr}
+		bot := &robot{required: newRequiredOwnersCache(cli)}
+
+		reqs := bot.requiredOwnersRequirements("org", "repo", pr, c.files, log)
+
+		if c.unavailable {
+			if len(reqs) != 1 || reqs[0].Approvers.Len() != 0 {
+				t.Errorf("%s: expect a requirement which can't be met, but got %v", c.name, reqs)
+			}
+		} else {
+			var logins []string
+			for _, r := range reqs {
+				logins = append(logins, r.Approvers.List()...)
+			}
+			if !sets.NewString(logins...).Equal(sets.NewString(c.required...)) || len(logins) != len(c.required) {
+				t.Errorf("%s: expect %v required, but got %v", c.name, c.required, logins)
+			}
+		}
+
+		// The requirements are enforced whoever else approves, and they
+		// can't be met at all if the OWNERS files are unavailable.
+		ap := approvers.NewApprovers(approvers.NewOwners(log, c.files, emptyOwnersRepo{}, 1))
+		ap.Requirements = reqs
+		ap.AddApprover("root", "", false)
+		if n := len(ap.UnmetRequirements()); n != len(reqs) {
+			t.Errorf("%s: expect %d unmet requirements, but got %d", c.name, len(reqs), n)
+		}
+
+		for _, login := range c.required {
+			ap.AddApprover(login, "", false)
+		}
+		if met := len(ap.UnmetRequirements()) == 0; met == c.unavailable {
+			t.Errorf("%s: expect the requirements met %t with the required approvers", c.name, !c.unavailable)
+		}
+	}
+}
+
+func TestRequiredOwnersCacheFetchesTreeOnce(t *testing.T) {
+	cli := &fakeOwnersClient{files: map[string]string{"OWNERS": "required_approvers:\n- root\n"}}
+	c := newRequiredOwnersCache(cli)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := c.get("org", "repo", "abc", []string{"a.go"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := c.get("org", "repo", "def", []string{"a.go"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once for each commit.
+	if n := atomic.LoadInt32(&cli.treeCalls); n != 2 {
+		t.Errorf("expect the tree listed twice, but got %d", n)
+	}
+}
+
+type emptyOwnersRepo struct{}
+
+func (emptyOwnersRepo) Approvers(string) sets.String            { return sets.NewString() }
+func (emptyOwnersRepo) LeafApprovers(string) sets.String        { return sets.NewString() }
+func (emptyOwnersRepo) FindApproverOwnersForFile(string) string { return "" }
+func (emptyOwnersRepo) IsNoParentOwners(string) bool            { return false }
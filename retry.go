@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	retryBaseDelay = 5 * time.Second
+	retryMaxDelay  = 5 * time.Minute
+)
+
+// transientErrMarks are the parts of the messages of the errors which may not
+// happen again on a retry. Most errors of the Gitee API and the owners cache
+// are formatted into strings on the way up, so they can't be told by type.
+var transientErrMarks = []string{
+	"timeout",
+	"deadline exceeded",
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"unexpected eof",
+	"too many requests",
+	"500 internal server error",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+	"unavailable",
+}
+
+// isTransient reports whether the handling failed with the error may succeed
+// on a retry.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	s := strings.ToLower(err.Error())
+	for _, v := range transientErrMarks {
+		if strings.Contains(s, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retryDelay returns the delay before the attempt-th retry, which doubles
+// on each attempt up to retryMaxDelay.
+func retryDelay(attempt int) time.Duration {
+	d := retryBaseDelay
+	for i := 1; i < attempt; i++ {
+		if d *= 2; d >= retryMaxDelay {
+			return retryMaxDelay
+		}
+	}
+
+	return d
+}
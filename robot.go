@@ -2,73 +2,353 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/opensourceways/community-robot-lib/config"
+	"github.com/opensourceways/community-robot-lib/giteeclient"
 	"github.com/opensourceways/community-robot-lib/robot-gitee-framework"
 	sdk "github.com/opensourceways/go-gitee/gitee"
 	"github.com/opensourceways/repo-owners-cache/grpc/client"
 	"github.com/sirupsen/logrus"
+	"k8s.io/test-infra/prow/labels"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+	"github.com/opensourceways/robot-gitee-approve/metrics"
 )
 
 const botName = "approve"
 
 type iClient interface {
-	GetPullRequestChanges(org, repo string, number int32) ([]sdk.PullRequestFiles, error)
+	GetPullRequestChangesPage(org, repo string, number int32, page, perPage int) ([]sdk.PullRequestFiles, error)
 	GetPRLabels(org, repo string, number int32) ([]sdk.Label, error)
 	ListPRComments(org, repo string, number int32) ([]sdk.PullRequestComments, error)
+	GetPRComment(org, repo string, commentID int32) (sdk.PullRequestComments, error)
 	DeletePRComment(org, repo string, ID int32) error
+	UpdatePRComment(org, repo string, commentID int32, comment string) error
 	CreatePRComment(org, repo string, number int32, comment string) error
 	GetBot() (sdk.User, error)
+	GetGiteePullRequest(org, repo string, number int32) (sdk.PullRequest, error)
 	AddPRLabel(org, repo string, number int32, label string) error
 	RemovePRLabel(org, repo string, number int32, label string) error
+	GetUserPermissionsOfRepo(org, repo, login string) (sdk.ProjectMemberPermission, error)
+	GetPullRequests(org, repo string, opts giteeclient.ListPullRequestOpt) ([]sdk.PullRequest, error)
+	ListPROperationLogs(org, repo string, number int32) ([]sdk.OperateLog, error)
+	GetPRCommits(org, repo string, number int32) ([]sdk.PullRequestCommits, error)
+	GetPathContent(org, repo, path, ref string) (sdk.Content, error)
+	AssignPR(owner, repo string, number int32, logins []string) error
+	CreateCheckRun(org, repo string, run checkRun) error
+	ListCommentReactions(org, repo string, commentID int32) ([]commentReaction, error)
+	GetRepoTree(org, repo, ref string) ([]treeEntry, error)
+	GetRepos(org string) ([]sdk.Project, error)
+	GetRepoLabels(owner, repo string) ([]sdk.Label, error)
+	CreateRepoLabel(org, repo, label, color string) error
+	CreateIssue(org, repo, title, body string) (sdk.Issue, error)
 }
 
-func newRobot(cli iClient, cacheCli *client.Client, botName string) *robot {
-	return &robot{cli: ghclient{cli}, cacheCli: cacheCli, botName: botName}
+func newRobot(cli iClient, cacheCli *client.Client, cfgAgent *config.ConfigAgent, snapshots *snapshotStore, settings *settingStore) *robot {
+	return &robot{
+		cli:       newGHClient(cli),
+		cacheCli:  cacheCli,
+		cfgAgent:  cfgAgent,
+		snapshots: snapshots,
+		settings:  settings,
+		throttle:  newCommandThrottle(),
+		activity:  newActivityCache(cli),
+		capacity:  newCapacityCache(cli),
+		breakers:  newRepoBreakers(),
+		hints:     newCommentHints(),
+		suggested: &suggestionLoadStore{items: map[string]*prSuggestion{}},
+		required:  newRequiredOwnersCache(cli),
+		fetched:   newFetchedOwnersCache(),
+
+		ownersNotices: newOwnersUnavailableNotices(),
+	}
 }
 
 type robot struct {
-	cacheCli *client.Client
-	cli      ghclient
-	botName  string
+	cacheCli  *client.Client
+	cli       ghclient
+	cfgAgent  *config.ConfigAgent
+	snapshots *snapshotStore
+	settings  *settingStore
+	throttle  *commandThrottle
+	activity  *activityCache
+	capacity  *capacityCache
+	breakers  *repoBreakers
+	hints     *commentHints
+	suggested *suggestionLoadStore
+	required  *requiredOwnersCache
+	fetched   *fetchedOwnersCache
+	tracer    *tracer
+	recorder  *eventRecorder
+	watchdog  *watchdog
+	queue     *prQueue
+	writer    *commentWriter
+	audit     *auditLog
+
+	// ownersNotices remembers the PRs told that the OWNERS files are
+	// unavailable.
+	ownersNotices *ownersUnavailableNotices
+
+	// spool is set for the frontend, which writes the events to it for the
+	// worker instead of handling them.
+	spool *eventSpool
+
+	// persist protects the data written to the disk.
+	persist *persistProtection
+
+	// verifySecret signs the verdicts of the verification before merging.
+	verifySecret []byte
+
+	// refreshToken authenticates the requests to refresh the notifications.
+	refreshToken []byte
+
+	// adminToken authenticates the requests to the admin endpoints, which
+	// read the private repos or make API calls on every request.
+	adminToken []byte
+
+	// ownersToken authenticates the queries of the approvers by the robots
+	// of the other orgs for the federation.
+	ownersToken []byte
 }
 
 func (bot *robot) NewConfig() config.Config {
 	return &configuration{}
 }
 
-func (bot *robot) getConfig(cfg config.Config, org, repo string) (*botConfig, error) {
+// getConfig returns the config of the repo applied to the target branch.
+func (bot *robot) getConfig(cfg config.Config, org, repo, branch string) (*botConfig, error) {
 	c, ok := cfg.(*configuration)
 	if !ok {
 		return nil, fmt.Errorf("can't convert to configuration")
 	}
 
-	if bc := c.configFor(org, repo); bc != nil {
-		return bc, nil
+	bc, err := c.configFor(org, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if bc == nil && c.unconfiguredRepoAction() == unconfiguredRepoDefaultPolicy {
+		bc = &c.DefaultPolicy
+	}
+
+	if bc == nil {
+		return nil, fmt.Errorf("no config for this repo:%s/%s", org, repo)
+	}
+
+	r := bot.settings.apply(org, repo, bc).forBranch(branch)
+	if len(c.RenamedLogins) > 0 {
+		v := *r
+		v.renamedLogins = c.RenamedLogins
+		r = &v
 	}
 
-	return nil, fmt.Errorf("no config for this repo:%s/%s", org, repo)
+	return r, nil
 }
 
+// getEventConfig is like getConfig but returns nil without error if the event
+// should be ignored because the repo is not configured.
+func (bot *robot) getEventConfig(cfg config.Config, org, repo, branch string) (*botConfig, error) {
+	c, ok := cfg.(*configuration)
+	if !ok {
+		return nil, fmt.Errorf("can't convert to configuration")
+	}
+
+	bc, err := c.configFor(org, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if bc == nil {
+		action := c.unconfiguredRepoAction()
+		metrics.UnconfiguredRepoEvent(org, repo, action)
+
+		if action == unconfiguredRepoIgnore {
+			return nil, nil
+		}
+	}
+
+	return bot.getConfig(cfg, org, repo, branch)
+}
+
+// RegisterEventHandler registers the handlers of the webhook endpoint of the
+// framework, which doesn't verify the deliveries. It serves the orgs without
+// a webhook secret only, as it did before the verification was introduced,
+// and the events of the other orgs are delivered by the verified webhook of
+// webhookGuard only, or by the spool which the webhook of the frontend
+// writes to.
 func (bot *robot) RegisterEventHandler(f framework.HandlerRegitster) {
-	f.RegisterPullRequestHandler(bot.handlePREvent)
-	f.RegisterNoteEventHandler(bot.handleNoteEvent)
+	f.RegisterPullRequestHandler(func(e *sdk.PullRequestEvent, c config.Config, log *logrus.Entry) error {
+		org, _ := e.GetOrgRepo()
+		if err := unverifiedWebhookAllowed(c, org); err != nil {
+			return err
+		}
+
+		return bot.onPREvent(e, c, log)
+	})
+
+	f.RegisterNoteEventHandler(func(e *sdk.NoteEvent, c config.Config, log *logrus.Entry) error {
+		org, _ := e.GetOrgRepo()
+		if err := unverifiedWebhookAllowed(c, org); err != nil {
+			return err
+		}
+
+		return bot.onNoteEvent(e, c, log)
+	})
+}
+
+// unverifiedWebhookAllowed returns an error if the deliveries of the org
+// must be verified, so that the unverified ones are not handled.
+func unverifiedWebhookAllowed(c config.Config, org string) error {
+	cfg, ok := c.(*configuration)
+	if !ok {
+		return fmt.Errorf("can't convert to configuration")
+	}
+
+	if cfg.verifiesWebhook(org) {
+		return fmt.Errorf("ignore the unverified delivery of %s, which has a webhook secret", org)
+	}
+
+	return nil
+}
+
+// onPREvent receives the PR event from either webhook or the spool.
+func (bot *robot) onPREvent(e *sdk.PullRequestEvent, c config.Config, log *logrus.Entry) error {
+	if bot.spool != nil {
+		return bot.spool.put(eventKindPR, e)
+	}
+	if bot.recorder != nil {
+		bot.recorder.recordEvent(eventKindPR, e)
+	}
+
+	org, repo := e.GetOrgRepo()
+	span, log := bot.startDeliverySpan(eventKindPR, org, repo, int(e.GetPullRequest().GetNumber()), log)
+	err := bot.watchdog.track(func() error { return bot.handlePREvent(e, c, log) })
+	span.finish(err)
+
+	return err
+}
+
+// onNoteEvent receives the note event from either webhook or the spool.
+func (bot *robot) onNoteEvent(e *sdk.NoteEvent, c config.Config, log *logrus.Entry) error {
+	if bot.spool != nil {
+		return bot.spool.put(eventKindNote, e)
+	}
+	if bot.recorder != nil {
+		bot.recorder.recordEvent(eventKindNote, e)
+	}
+
+	org, repo := e.GetOrgRepo()
+	span, log := bot.startDeliverySpan(eventKindNote, org, repo, int(e.GetPullRequest().GetNumber()), log)
+	err := bot.watchdog.track(func() error { return bot.handleNoteEvent(e, c, log) })
+	span.finish(err)
+
+	return err
 }
 
 func (bot *robot) handlePREvent(e *sdk.PullRequestEvent, c config.Config, log *logrus.Entry) error {
+	org, repo := e.GetOrgRepo()
+
 	action := sdk.GetPullRequestAction(e)
-	if !(action == sdk.ActionOpen || action == sdk.PRActionChangedSourceBranch) {
+	if action == sdk.ActionClose {
+		bot.snapshots.close(org, repo, int(e.GetPullRequest().GetNumber()))
+		bot.hints.forget(org, repo, int(e.GetPullRequest().GetNumber()))
+
 		return nil
 	}
 
-	org, repo := e.GetOrgRepo()
+	trigger := prEventTrigger(e)
+	if trigger == "" {
+		return nil
+	}
 
-	cfg, err := bot.getConfig(c, org, repo)
-	if err != nil {
+	cfg, err := bot.getEventConfig(c, org, repo, e.GetPullRequest().GetBase().GetRef())
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	if trigger == triggerReview {
+		metrics.CommentClassified(org, repo, approve.CommentReviewApproval)
+	}
+
+	pr := prInfoFromHook(e.GetPullRequest())
+	// The push is recorded even if it doesn't trigger the handling, so that
+	// the approvals before it are invalidated on the next handling.
+	if action == sdk.PRActionChangedSourceBranch {
+		bot.snapshots.setPushed(org, repo, pr, pushedAt(e.GetPullRequest()))
+	}
+
+	if trigger == triggerReopen {
+		bot.snapshots.reopen(org, repo, pr.number)
+	}
+
+	if !cfg.Triggers.enabled(trigger) {
+		return nil
+	}
+
+	// The labels may have been changed while the PR was closed, and the
+	// OWNERS files of the new target branch apply to the PR retargeted, so
+	// they are handled from scratch with the notification regenerated.
+	if trigger == triggerReopen || trigger == triggerTargetBranchChanged {
+		return bot.refresh(org, repo, pr, cfg, log)
+	}
+
+	if action == sdk.PRActionUpdatedLabel && !bot.labelsMatter(org, repo, e.GetPullRequest(), cfg) {
+		return nil
+	}
+
+	if err := bot.handleEvent(org, repo, pr, cfg, log); err != nil {
 		return err
 	}
 
-	return bot.handle(org, repo, e.GetPullRequest(), cfg, log)
+	if action == sdk.ActionOpen && cfg.AutoAssignApprovers {
+		_, err = bot.assignApprovers(org, repo, pr, cfg, log)
+	}
+
+	if action == sdk.ActionOpen && cfg.AutoCCApprovers && err == nil {
+		err = bot.ccApprovers(org, repo, pr, cfg, log)
+	}
+
+	if (action == sdk.ActionOpen || action == sdk.PRActionChangedSourceBranch) && cfg.LintOwnersOnPR && err == nil {
+		err = bot.commentOwnersLint(org, repo, pr, cfg, log)
+	}
+
+	return err
+}
+
+// pushedAt returns when the source branch of the PR was pushed, which is
+// when the PR was updated by the push, or now if it is unknown.
+func pushedAt(pr *sdk.PullRequestHook) time.Time {
+	if t, err := time.Parse(time.RFC3339, pr.UpdatedAt); err == nil {
+		return t
+	}
+
+	return time.Now()
+}
+
+// labelsMatter reports whether the change of labels needs a re-evaluation.
+// That is when the labels may change the policy, or when the approved label
+// was added or removed by others since the robot handled the PR last time,
+// so that it is restored or removed again to converge.
+func (bot *robot) labelsMatter(org, repo string, pr *sdk.PullRequestHook, cfg *botConfig) bool {
+	if len(cfg.LabelPolicies) > 0 || cfg.RequireLgtmBeforeApprove {
+		return true
+	}
+
+	approved, ok := bot.snapshots.approved(org, repo, int(pr.GetNumber()))
+	if !ok {
+		return true
+	}
+
+	hasLabel := false
+	for _, l := range pr.Labels {
+		if l.Name == labels.Approved {
+			hasLabel = true
+			break
+		}
+	}
+
+	return hasLabel != approved
 }
 
 func (bot *robot) handleNoteEvent(e *sdk.NoteEvent, c config.Config, log *logrus.Entry) error {
@@ -78,14 +358,109 @@ func (bot *robot) handleNoteEvent(e *sdk.NoteEvent, c config.Config, log *logrus
 
 	org, repo := e.GetOrgRepo()
 
-	cfg, err := bot.getConfig(c, org, repo)
+	pr := prInfoFromHook(e.GetPullRequest())
+	cfg, err := bot.getEventConfig(c, org, repo, pr.base)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	botName, err := bot.cli.BotName()
 	if err != nil {
 		return err
 	}
 
-	if bot.botName == e.GetCommenter() || !isApproveCommand(e.GetComment().GetBody(), false) {
+	// Whether lgtm acts as approve is overridden for the automation
+	// authors, which handle resolves too.
+	lgtmActsAsApprove := cfg.forAuthor(pr.author).LgtmActsAsApprove
+
+	commenter := e.GetCommenter()
+	metrics.CommentClassified(org, repo, approve.ClassifyComment(
+		botName, commenter, e.GetComment().GetBody(), lgtmActsAsApprove, cfg.commandAliases,
+	))
+
+	if botName == commenter {
 		return nil
 	}
 
-	return bot.handle(org, repo, e.GetPullRequest(), cfg, log)
+	if v := e.GetComment(); v != nil {
+		bot.hints.observe(org, repo, pr.number, int(v.Id))
+	}
+
+	body := cfg.commandAliases.Expand(approve.SanitizeCommandText(e.GetComment().GetBody()))
+	number := e.GetPRNumber()
+	routed, err := bot.routeNoteCommand(&noteCommand{
+		org:       org,
+		repo:      repo,
+		pr:        pr,
+		cfg:       cfg,
+		botName:   botName,
+		commenter: commenter,
+		log:       log,
+	}, body)
+	if routed || err != nil {
+		return err
+	}
+
+	found, cancel := parseApproveCommands(body, lgtmActsAsApprove)
+	if !found || !cfg.Triggers.enabled(triggerNote) {
+		return nil
+	}
+
+	// The cancellations are not throttled, so that the approved label is
+	// removed promptly.
+	if cancel {
+		return bot.handleEvent(org, repo, pr, cfg, log)
+	}
+
+	key := fmt.Sprintf("%s/%s/%d/%s", org, repo, number, commenter)
+	if allowed, warn := bot.throttle.allow(key, cfg.MaxCommandsPerHour, time.Now()); !allowed {
+		if !warn {
+			return nil
+		}
+
+		return bot.cli.withDryRun(cfg.DryRun).CreateComment(org, repo, int(number), fmt.Sprintf(
+			"@%s You have issued too many approve commands on this pull-request within an hour. "+
+				"The new ones are ignored for now, please try again later.", commenter,
+		))
+	}
+
+	return bot.handleEvent(org, repo, pr, cfg, log)
+}
+
+// handleEvent handles the PR, or queues it to be handled together with the
+// events of the PR following shortly if the debounce is enabled.
+func (bot *robot) handleEvent(org, repo string, pr prInfo, cfg *botConfig, log *logrus.Entry) error {
+	return bot.submit(prJob{org: org, repo: repo, pr: pr, cfg: cfg, log: log})
+}
+
+// submit runs the job, or queues it if the queue is enabled.
+func (bot *robot) submit(job prJob) error {
+	if bot.queue != nil {
+		bot.snapshots.setQueued(job.org, job.repo, job.pr, true)
+		bot.queue.enqueue(job)
+
+		return nil
+	}
+
+	return bot.runJob(&job)
+}
+
+// runJob handles the PR unless the circuit breaker of the repo is tripped.
+func (bot *robot) runJob(job *prJob) error {
+	if !bot.breakers.allow(job.org, job.repo, time.Now()) {
+		job.log.Warn("Skip the event because the circuit breaker of the repo is tripped.")
+
+		return nil
+	}
+
+	err := bot.handle(job.org, job.repo, job.pr, job.cfg, job.refresh, job.log)
+	bot.breakers.record(job.org, job.repo, &job.cfg.CircuitBreaker, err, time.Now())
+
+	// The PR failed to be handled is left queued, so that it is handled
+	// again after a restart.
+	if err == nil && bot.queue != nil {
+		bot.snapshots.setQueued(job.org, job.repo, job.pr, false)
+	}
+
+	return err
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// migration converts the persisted data from one schema version to the next.
+type migration func(json.RawMessage) (json.RawMessage, error)
+
+// versioned is the envelope of the persisted data.
+type versioned struct {
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// migrate upgrades the persisted data to the latest version. migrations[i]
+// converts the data of version i+1 to version i+2, so the latest version is
+// len(migrations)+1. The data written before versioning was introduced has
+// no envelope and is regarded as version 1. It returns whether the data was
+// migrated.
+func migrate(b []byte, migrations []migration) (json.RawMessage, bool, error) {
+	latest := len(migrations) + 1
+
+	v := versioned{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, false, err
+	}
+
+	if v.Version == 0 {
+		v.Version = 1
+		v.Data = b
+	}
+
+	if v.Version > latest {
+		return nil, false, fmt.Errorf(
+			"the schema version %d is newer than the supported one %d", v.Version, latest,
+		)
+	}
+
+	data := v.Data
+	for i := v.Version; i < latest; i++ {
+		r, err := migrations[i-1](data)
+		if err != nil {
+			return nil, false, fmt.Errorf("migrate from version %d: %v", i, err)
+		}
+		data = r
+	}
+
+	return data, v.Version != latest, nil
+}
+
+// marshalVersioned wraps the data with the envelope of the latest version.
+func marshalVersioned(data interface{}, migrations []migration) ([]byte, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(versioned{Version: len(migrations) + 1, Data: b})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+const seriesPathPrefix = "/v1/series/"
+
+// prSeries extracts the topic of the PRs, so that the PRs of the same topic,
+// such as the parts of a change split for review, are regarded as a series.
+// Each pattern is a regular expression whose first group is the topic.
+type prSeries struct {
+	// BodyPattern is matched against the PR body, such as
+	// "(?m)^Topic:[\t ]*(\S+)".
+	BodyPattern string `json:"body_pattern,omitempty"`
+
+	// BranchPattern is matched against the source branch of the PR, such as
+	// "^(.+)/part-\d+$". It is used if the body doesn't match.
+	BranchPattern string `json:"branch_pattern,omitempty"`
+}
+
+func (s *prSeries) validate() error {
+	for _, p := range []string{s.BodyPattern, s.BranchPattern} {
+		if p == "" {
+			continue
+		}
+
+		reg, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("invalid pattern of series %s: %v", p, err)
+		}
+
+		if reg.NumSubexp() < 1 {
+			return fmt.Errorf("pattern of series %s must have a group of the topic", p)
+		}
+	}
+
+	return nil
+}
+
+// topic returns the topic of the PR, or empty if it is not a part of series.
+func (s *prSeries) topic(pr prInfo) string {
+	for _, v := range []struct{ pattern, text string }{
+		{s.BodyPattern, pr.body},
+		{s.BranchPattern, pr.headRef},
+	} {
+		if v.pattern == "" || v.text == "" {
+			continue
+		}
+
+		// The patterns have been validated.
+		if m := regexp.MustCompile(v.pattern).FindStringSubmatch(v.text); len(m) > 1 && m[1] != "" {
+			return m[1]
+		}
+	}
+
+	return ""
+}
+
+// seriesOf returns the topic of the PR and the approval state of the other
+// open PRs of the series as they were handled last time.
+func (bot *robot) seriesOf(org, repo string, pr prInfo, cfg *botConfig) (string, []approvers.Dependency) {
+	topic := cfg.Series.topic(pr)
+	if topic == "" {
+		return "", nil
+	}
+
+	var r []approvers.Dependency
+	for _, v := range bot.snapshots.series(org, repo, topic) {
+		if v.Number != pr.number {
+			r = append(r, approvers.Dependency{Number: v.Number, Approved: v.Approved})
+		}
+	}
+
+	return topic, r
+}
+
+// seriesPR is the approval state of a PR of a series.
+type seriesPR struct {
+	Number       int      `json:"number"`
+	URL          string   `json:"url,omitempty"`
+	Approved     bool     `json:"approved"`
+	PendingPaths []string `json:"pending_paths,omitempty"`
+}
+
+// seriesRollup is the combined approval state of the open PRs of a series.
+type seriesRollup struct {
+	Topic string `json:"topic"`
+	// Approved reports whether all the PRs of the series are approved.
+	Approved bool       `json:"approved"`
+	PRs      []seriesPR `json:"prs"`
+}
+
+// seriesHandler serves GET /v1/series/{org}/{repo}/{topic}.
+func (bot *robot) seriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	v := strings.SplitN(strings.Trim(strings.TrimPrefix(r.URL.Path, seriesPathPrefix), "/"), "/", 3)
+	if len(v) != 3 || v[2] == "" {
+		http.Error(w, fmt.Sprintf("the path should be %s{org}/{repo}/{topic}", seriesPathPrefix), http.StatusBadRequest)
+		return
+	}
+
+	prs := bot.snapshots.series(v[0], v[1], v[2])
+	if len(prs) == 0 {
+		http.Error(w, "no open PR of the series", http.StatusNotFound)
+		return
+	}
+
+	rollup := seriesRollup{Topic: v[2], Approved: true}
+	for i := range prs {
+		p := &prs[i]
+		rollup.Approved = rollup.Approved && p.Approved
+		rollup.PRs = append(rollup.PRs, seriesPR{
+			Number:       p.Number,
+			URL:          p.URL,
+			Approved:     p.Approved,
+			PendingPaths: p.PendingPaths,
+		})
+	}
+
+	writeJSON(w, rollup)
+}
+
+// series returns the snapshots of the open PRs of the topic in the order of
+// their numbers.
+func (s *snapshotStore) series(org, repo, topic string) []prSnapshot {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var r []prSnapshot
+	for _, v := range s.items {
+		if v.Org == org && v.Repo == repo && v.Topic == topic && !v.Closed {
+			r = append(r, *v)
+		}
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Number < r[j].Number
+	})
+
+	return r
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// settingDefault resets an option to the value of the config file.
+	settingDefault = "default"
+
+	permissionAdmin = "admin"
+)
+
+var setCommandReg = regexp.MustCompile(`(?mi)^/approve[\t ]+set[\t ]+([^\s]+)[\t ]+([^\s]+)[\t ]*$`)
+
+// settingMigrations are the migrations of the schema of repo settings.
+var settingMigrations = []migration{}
+
+// repoOptions are the options which repo admins can set by comments, along
+// with the functions to apply a value of the option to the config.
+var repoOptions = map[string]func(*botConfig, string) error{
+	"issue-required":        boolOption(func(c *botConfig) *bool { return &c.IssueRequired }),
+	"require-self-approval": setSelfApproval,
+	"lgtm-acts-as-approve":  boolOption(func(c *botConfig) *bool { return &c.LgtmActsAsApprove }),
+	"block-on-dependencies": boolOption(func(c *botConfig) *bool { return &c.BlockOnDependencies }),
+	"minimal-mode":          boolOption(func(c *botConfig) *bool { return &c.MinimalMode }),
+	"max-commands-per-hour": func(c *botConfig, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%s is not a non-negative integer", v)
+		}
+		c.MaxCommandsPerHour = n
+		return nil
+	},
+}
+
+func boolOption(field func(*botConfig) *bool) func(*botConfig, string) error {
+	return func(c *botConfig, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s is not a boolean", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+// setSelfApproval sets require_self_approval for all the files, which
+// replaces the paths if any.
+func setSelfApproval(c *botConfig, v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%s is not a boolean", v)
+	}
+	c.RequireSelfApproval = selfApproval{Required: b}
+	return nil
+}
+
+// setting is a value of repo option set by a comment.
+type setting struct {
+	Value     string    `json:"value"`
+	UpdatedBy string    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// settingStore keeps the options set by comments for each repo, and persists
+// them to the file if it is given.
+type settingStore struct {
+	lock    sync.RWMutex
+	file    string
+	protect *persistProtection
+	items   map[string]map[string]setting
+}
+
+func newSettingStore(file string, protect *persistProtection) (*settingStore, error) {
+	s := &settingStore{file: file, protect: protect, items: map[string]map[string]setting{}}
+	if file == "" {
+		return s, nil
+	}
+
+	b, err := protect.readFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	if len(b) == 0 {
+		return s, nil
+	}
+
+	data, _, err := migrate(b, settingMigrations)
+	if err == nil {
+		err = json.Unmarshal(data, &s.items)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load settings from %s: %v", file, err)
+	}
+
+	return s, nil
+}
+
+// set validates and saves the value of the option. The value of default
+// removes the setting.
+func (s *settingStore) set(org, repo, option, value, by string) error {
+	apply, ok := repoOptions[option]
+	if !ok {
+		return fmt.Errorf("unknown option %s, the supported ones are: %s", option, strings.Join(supportedRepoOptions(), ", "))
+	}
+
+	if value != settingDefault {
+		if err := apply(&botConfig{}, value); err != nil {
+			return err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	k := org + "/" + repo
+	if value == settingDefault {
+		delete(s.items[k], option)
+	} else {
+		if s.items[k] == nil {
+			s.items[k] = map[string]setting{}
+		}
+		s.items[k][option] = setting{Value: value, UpdatedBy: by, UpdatedAt: time.Now()}
+	}
+
+	s.save()
+
+	return nil
+}
+
+// apply returns the config with the settings of the repo merged over it.
+func (s *settingStore) apply(org, repo string, cfg *botConfig) *botConfig {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	items := s.items[org+"/"+repo]
+	if len(items) == 0 {
+		return cfg
+	}
+
+	c := *cfg
+	for option, v := range items {
+		if apply, ok := repoOptions[option]; ok {
+			if err := apply(&c, v.Value); err != nil {
+				logrus.WithError(err).Errorf("apply setting %s of %s/%s", option, org, repo)
+			}
+		}
+	}
+
+	return &c
+}
+
+// save must be called with the lock held.
+func (s *settingStore) save() {
+	if s.file == "" {
+		return
+	}
+
+	b, err := marshalVersioned(s.items, settingMigrations)
+	if err == nil {
+		err = s.protect.writeFile(s.file, b)
+	}
+
+	if err != nil {
+		logrus.WithError(err).Errorf("save settings to %s", s.file)
+	}
+}
+
+func supportedRepoOptions() []string {
+	v := make([]string, 0, len(repoOptions))
+	for k := range repoOptions {
+		v = append(v, k)
+	}
+	sort.Strings(v)
+
+	return v
+}
+
+// handleSetCommand handles the command of "/approve set option value" which
+// only the repo admins can issue.
+func (bot *robot) handleSetCommand(c *noteCommand, option, value string) error {
+	p, err := bot.cli.cli.GetUserPermissionsOfRepo(c.org, c.repo, c.commenter)
+	if err != nil {
+		return err
+	}
+
+	if p.Permission != permissionAdmin {
+		return c.reply(bot, "Only the admins of this repository can change its options.")
+	}
+
+	option = strings.ToLower(option)
+	value = strings.ToLower(value)
+	if err := bot.settings.set(c.org, c.repo, option, value, c.commenter); err != nil {
+		return c.reply(bot, fmt.Sprintf("The option is not changed: %v.", err))
+	}
+
+	c.log.WithFields(logrus.Fields{
+		"org": c.org, "repo": c.repo, "option": option, "value": value, "by": c.commenter,
+	}).Info("Repo option set by comment")
+
+	return c.reply(bot, fmt.Sprintf("The option `%s` of this repository is set to `%s`.", option, value))
+}
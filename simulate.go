@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+const simulatePathPrefix = "/v1/simulate/"
+
+// simulateRequest is the proposed change of OWNERS files. The keys of Owners
+// are the directories of the OWNERS files, and null removes the OWNERS file.
+type simulateRequest struct {
+	Owners map[string]*approvers.OwnersEntry `json:"owners"`
+}
+
+// simulateHandler serves POST /v1/simulate/{org}/{repo}/{number} which
+// reports how the approval requirements of the PR would change with the
+// proposed OWNERS files.
+func (bot *robot) simulateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, repo, number, err := parsePRPath(simulatePathPrefix, r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req simulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s, err := bot.simulate(org, repo, number, req.Owners)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, s)
+}
+
+func (bot *robot) simulate(org, repo string, number int, proposed map[string]*approvers.OwnersEntry) (approve.Simulation, error) {
+	log := logrus.WithFields(logrus.Fields{"org": org, "repo": repo, "number": number})
+
+	v, err := bot.cli.cli.GetGiteePullRequest(org, repo, int32(number))
+	if err != nil {
+		return approve.Simulation{}, err
+	}
+	pr := prInfoFromPR(&v)
+
+	_, c := bot.cfgAgent.GetConfig()
+	cfg, err := bot.getConfig(c, org, repo, pr.base)
+	if err != nil {
+		return approve.Simulation{}, err
+	}
+
+	cfg = cfg.forAuthor(pr.author)
+	oc, err := bot.loadOwners(org, repo, pr.base, cfg)
+	if err != nil {
+		return approve.Simulation{}, err
+	}
+
+	state := bot.newState(org, repo, pr, cfg, oc, log)
+	opts := transformConfig(org, cfg)
+
+	return approve.Simulate(log, &bot.cli, oc, proposed, &opts, state)
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+)
+
+// prSnapshot records the milestones of the approval process of a PR.
+type prSnapshot struct {
+	Org    string `json:"org"`
+	Repo   string `json:"repo"`
+	Number int    `json:"number"`
+	URL    string `json:"url,omitempty"`
+
+	Approved     bool     `json:"approved,omitempty"`
+	PendingPaths []string `json:"pending_paths,omitempty"`
+	Closed       bool     `json:"closed,omitempty"`
+	// ClosedAt is when the PR was closed, after which the snapshot is kept
+	// for snapshotClosedRetention only.
+	ClosedAt time.Time `json:"closed_at,omitempty"`
+
+	OpenedAt          time.Time `json:"opened_at"`
+	FirstApprovalAt   time.Time `json:"first_approval_at,omitempty"`
+	ApprovedAt        time.Time `json:"approved_at,omitempty"`
+	RequiredApprovers int       `json:"required_approvers,omitempty"`
+
+	// Approvals are the evidences of the current approvals.
+	Approvals []approve.ApprovalRecord `json:"approvals,omitempty"`
+
+	// Approvers are the logins whose approval is currently counted.
+	Approvers []string `json:"approvers,omitempty"`
+	// Fingerprint identifies the state of the PR when it was handled last
+	// time, so that handling it again is skipped if nothing has changed.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// HandledAt is when the PR was handled last time.
+	HandledAt time.Time `json:"handled_at,omitempty"`
+	// Topic is the topic of the series which the PR is a part of.
+	Topic string `json:"topic,omitempty"`
+	// Queued means the PR is waiting in the queue to be handled, which is
+	// resumed after a restart.
+	Queued bool `json:"queued,omitempty"`
+	// PushedAt is when the source branch was pushed last time, which
+	// pushed the head PushedHead.
+	PushedAt   time.Time `json:"pushed_at,omitempty"`
+	PushedHead string    `json:"pushed_head,omitempty"`
+	// ApprovedLabelAt is when the robot changed the approved label last
+	// time, by which its changes are told from those of the other robots.
+	ApprovedLabelAt time.Time `json:"approved_label_at,omitempty"`
+	// ApprovalsExpireAt is when the earliest of the approvals expires, and
+	// ExpiredApprovers are those whose approvals have expired.
+	ApprovalsExpireAt time.Time `json:"approvals_expire_at,omitempty"`
+	ExpiredApprovers  []string  `json:"expired_approvers,omitempty"`
+	// InputsAt is when the inputs of the PR from outside, such as the
+	// requirements of federation, were loaded last time.
+	InputsAt time.Time `json:"inputs_at,omitempty"`
+}
+
+// snapshotMigrations are the migrations of the schema of snapshots. Append a
+// migration whenever the schema changes in an incompatible way.
+var snapshotMigrations = []migration{
+	// Version 2 only puts the snapshots into the versioned envelope.
+	func(data json.RawMessage) (json.RawMessage, error) { return data, nil },
+	// Version 3 records when the PRs were closed, which is taken as when
+	// they were handled last time for the PRs closed before.
+	migrateSnapshotsClosedAt,
+}
+
+const (
+	// snapshotSaveInterval is how often the snapshots changed are saved,
+	// and the snapshots to be evicted are evicted.
+	snapshotSaveInterval = 5 * time.Second
+	// snapshotClosedRetention is how long the snapshot of a PR is kept
+	// after it is closed, which is the period the funnels cover.
+	snapshotClosedRetention = 30 * 24 * time.Hour
+	// snapshotMaxItems is the max number of the snapshots kept, beyond which
+	// the closed ones and then the open ones handled least recently are
+	// evicted, except those queued.
+	snapshotMaxItems = 50000
+)
+
+func migrateSnapshotsClosedAt(data json.RawMessage) (json.RawMessage, error) {
+	items := map[string]map[string]interface{}{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+
+	for _, v := range items {
+		if closed, _ := v["closed"].(bool); !closed {
+			continue
+		}
+		if _, ok := v["closed_at"]; ok {
+			continue
+		}
+
+		if t, ok := v["handled_at"]; ok {
+			v["closed_at"] = t
+		} else {
+			v["closed_at"] = v["opened_at"]
+		}
+	}
+
+	return json.Marshal(items)
+}
+
+func snapshotKey(org, repo string, number int) string {
+	return fmt.Sprintf("%s/%s/%d", org, repo, number)
+}
+
+// snapshotStore keeps the snapshots in memory and, if a file is given,
+// persists them to it. The updates only mark the snapshots changed, which are
+// saved in the background every snapshotSaveInterval outside the lock, so
+// that the events are not blocked on writing the file.
+type snapshotStore struct {
+	lock    sync.RWMutex
+	file    string
+	protect *persistProtection
+	items   map[string]*prSnapshot
+	dirty   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newSnapshotStore(file string, protect *persistProtection) (*snapshotStore, error) {
+	s := &snapshotStore{
+		file:    file,
+		protect: protect,
+		items:   map[string]*prSnapshot{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if file == "" {
+		return s, nil
+	}
+
+	b, err := protect.readFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	if len(b) == 0 {
+		return s, nil
+	}
+
+	data, migrated, err := migrate(b, snapshotMigrations)
+	if err != nil {
+		return nil, fmt.Errorf("load snapshots from %s: %v", file, err)
+	}
+
+	if err := json.Unmarshal(data, &s.items); err != nil {
+		return nil, fmt.Errorf("load snapshots from %s: %v", file, err)
+	}
+
+	if migrated {
+		s.dirty = true
+		s.flush(time.Now())
+	}
+
+	return s, nil
+}
+
+// start saves the snapshots changed and evicts those to be evicted
+// periodically until shutdown is called.
+func (s *snapshotStore) start() {
+	go func() {
+		defer close(s.done)
+
+		t := time.NewTicker(snapshotSaveInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+				s.flush(time.Now())
+			}
+		}
+	}()
+}
+
+// shutdown stops saving periodically, and saves the snapshots changed.
+func (s *snapshotStore) shutdown() {
+	close(s.stop)
+	<-s.done
+
+	s.flush(time.Now())
+}
+
+func (s *snapshotStore) record(org, repo string, pr prInfo, topic string, r approve.Result) {
+	if r.Skipped {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	v, ok := s.item(org, repo, pr)
+
+	changed := !ok || v.Approved != r.Approved || v.URL != pr.htmlURL ||
+		!sets.NewString(v.PendingPaths...).Equal(sets.NewString(r.UnapprovedFiles...)) ||
+		!sets.NewString(v.Approvers...).Equal(sets.NewString(r.Approvers...)) ||
+		v.Fingerprint != r.Fingerprint || v.Topic != topic
+	v.URL = pr.htmlURL
+	v.Topic = topic
+	v.Approved = r.Approved
+	v.PendingPaths = r.UnapprovedFiles
+	v.Approvers = r.Approvers
+	v.Fingerprint = r.Fingerprint
+	v.HandledAt = time.Now()
+
+	if !v.ApprovalsExpireAt.Equal(r.ApprovalsExpireAt) ||
+		!sets.NewString(v.ExpiredApprovers...).Equal(sets.NewString(r.ExpiredApprovers...)) {
+		v.ApprovalsExpireAt = r.ApprovalsExpireAt
+		v.ExpiredApprovers = r.ExpiredApprovers
+		changed = true
+	}
+
+	if !approvalsEqual(v.Approvals, r.Approvals) {
+		v.Approvals = r.Approvals
+		changed = true
+	}
+
+	if v.FirstApprovalAt.IsZero() && !r.FirstApprovalAt.IsZero() {
+		v.FirstApprovalAt = r.FirstApprovalAt
+		changed = true
+	}
+
+	if r.Approved && v.ApprovedAt.IsZero() {
+		v.ApprovedAt = time.Now()
+		v.RequiredApprovers = r.RequiredApprovers
+		changed = true
+	}
+
+	if changed {
+		s.dirty = true
+	}
+}
+
+// item returns the snapshot of the PR, creating it if it doesn't exist, and
+// whether it existed. It must be called with the lock held.
+func (s *snapshotStore) item(org, repo string, pr prInfo) (*prSnapshot, bool) {
+	k := snapshotKey(org, repo, pr.number)
+	if v, ok := s.items[k]; ok {
+		return v, true
+	}
+
+	openedAt, _ := time.Parse(time.RFC3339, pr.createdAt)
+	v := &prSnapshot{Org: org, Repo: repo, Number: pr.number, URL: pr.htmlURL, OpenedAt: openedAt}
+	s.items[k] = v
+
+	return v, false
+}
+
+// setQueued sets whether the PR is waiting in the queue.
+func (s *snapshotStore) setQueued(org, repo string, pr prInfo, queued bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	v, ok := s.item(org, repo, pr)
+	if !ok || v.Queued != queued {
+		v.Queued = queued
+		s.dirty = true
+	}
+}
+
+// setPushed records the push of the source branch of the PR at the time. The
+// push of the same head, such as by a redelivered event, is ignored.
+func (s *snapshotStore) setPushed(org, repo string, pr prInfo, at time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	v, _ := s.item(org, repo, pr)
+	if v.PushedHead == pr.headSHA || at.Before(v.PushedAt) {
+		return
+	}
+
+	v.PushedAt = at
+	v.PushedHead = pr.headSHA
+	s.dirty = true
+}
+
+// setInputsLoaded records that the inputs of the PR from outside were loaded
+// at the time. It is saved along with the next change, since losing it only
+// makes them loaded again.
+func (s *snapshotStore) setInputsLoaded(org, repo string, pr prInfo, at time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	v, _ := s.item(org, repo, pr)
+	v.InputsAt = at
+}
+
+// setApprovedLabelChanged records that the robot changed the approved label
+// of the PR at the time.
+func (s *snapshotStore) setApprovedLabelChanged(org, repo string, pr prInfo, at time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	v, _ := s.item(org, repo, pr)
+	v.ApprovedLabelAt = at
+	s.dirty = true
+}
+
+// get returns the snapshot of the PR and whether it exists.
+func (s *snapshotStore) get(org, repo string, number int) (prSnapshot, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	v, ok := s.items[snapshotKey(org, repo, number)]
+	if !ok {
+		return prSnapshot{}, false
+	}
+
+	return *v, true
+}
+
+func approvalsEqual(a, b []approve.ApprovalRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// approved returns whether the PR was approved when it was handled last time,
+// and whether it has been handled.
+func (s *snapshotStore) approved(org, repo string, number int) (bool, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	v, ok := s.items[snapshotKey(org, repo, number)]
+	if !ok || (v.HandledAt.IsZero() && v.Queued) {
+		return false, false
+	}
+
+	return v.Approved, true
+}
+
+// close marks the PR as closed.
+func (s *snapshotStore) close(org, repo string, number int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if v, ok := s.items[snapshotKey(org, repo, number)]; ok && !v.Closed {
+		v.Closed = true
+		v.ClosedAt = time.Now()
+		s.dirty = true
+	}
+}
+
+// reopen marks the snapshot of the PR reopened as open again.
+func (s *snapshotStore) reopen(org, repo string, number int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if v, ok := s.items[snapshotKey(org, repo, number)]; ok && v.Closed {
+		v.Closed = false
+		v.ClosedAt = time.Time{}
+		s.dirty = true
+	}
+}
+
+func (s *snapshotStore) list() []prSnapshot {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	r := make([]prSnapshot, 0, len(s.items))
+	for _, v := range s.items {
+		r = append(r, *v)
+	}
+
+	return r
+}
+
+// flush evicts the snapshots to be evicted at now, and saves the snapshots if
+// they have changed. The file is written outside the lock.
+func (s *snapshotStore) flush(now time.Time) {
+	s.lock.Lock()
+
+	s.evict(now)
+
+	if !s.dirty || s.file == "" {
+		s.lock.Unlock()
+		return
+	}
+
+	b, err := marshalVersioned(s.items, snapshotMigrations)
+	s.dirty = false
+
+	s.lock.Unlock()
+
+	if err == nil {
+		err = s.protect.writeFile(s.file, b)
+	}
+
+	if err != nil {
+		logrus.WithError(err).Errorf("save snapshots to %s", s.file)
+
+		// Retry on the next flush.
+		s.lock.Lock()
+		s.dirty = true
+		s.lock.Unlock()
+	}
+}
+
+// evict removes the snapshots of the PRs closed longer than
+// snapshotClosedRetention, and then the least recent ones beyond
+// snapshotMaxItems. It must be called with the lock held.
+func (s *snapshotStore) evict(now time.Time) {
+	for k, v := range s.items {
+		if v.Closed && now.Sub(v.ClosedAt) > snapshotClosedRetention {
+			delete(s.items, k)
+			s.dirty = true
+		}
+	}
+
+	n := len(s.items) - snapshotMaxItems
+	if n <= 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(s.items))
+	for k, v := range s.items {
+		if !v.Queued {
+			keys = append(keys, k)
+		}
+	}
+
+	// The closed ones go first, and then those handled least recently.
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := s.items[keys[i]], s.items[keys[j]]
+		if a.Closed != b.Closed {
+			return a.Closed
+		}
+		return a.lastActiveAt().Before(b.lastActiveAt())
+	})
+
+	if n > len(keys) {
+		n = len(keys)
+	}
+	if n == 0 {
+		return
+	}
+	for _, k := range keys[:n] {
+		delete(s.items, k)
+	}
+	s.dirty = true
+}
+
+// lastActiveAt returns when the PR was closed, handled or opened at last.
+func (v *prSnapshot) lastActiveAt() time.Time {
+	t := v.OpenedAt
+	for _, u := range []time.Time{v.HandledAt, v.ClosedAt} {
+		if u.After(t) {
+			t = u
+		}
+	}
+	return t
+}
+
+// repoFunnel is the approval funnel of a repo.
+type repoFunnel struct {
+	PRs      int `json:"prs"`
+	Approved int `json:"approved"`
+
+	// AvgSecondsToFirstApproval is the average time from opening a PR to
+	// its first approval.
+	AvgSecondsToFirstApproval float64 `json:"avg_seconds_to_first_approval"`
+	// AvgSecondsToApproved is the average time from opening a PR to it
+	// being fully approved.
+	AvgSecondsToApproved float64 `json:"avg_seconds_to_approved"`
+	// MultiApproverPercent is the percentage of approved PRs which needed
+	// more than one approver.
+	MultiApproverPercent float64 `json:"multi_approver_percent"`
+}
+
+func computeFunnels(snapshots []prSnapshot) map[string]*repoFunnel {
+	type acc struct {
+		firstApprovals int
+		firstApproval  time.Duration
+		approved       time.Duration
+		multi          int
+	}
+
+	funnels := map[string]*repoFunnel{}
+	accs := map[string]*acc{}
+
+	for i := range snapshots {
+		v := &snapshots[i]
+		k := v.Org + "/" + v.Repo
+
+		f, ok := funnels[k]
+		if !ok {
+			f = &repoFunnel{}
+			funnels[k] = f
+			accs[k] = &acc{}
+		}
+		a := accs[k]
+
+		f.PRs++
+
+		if v.OpenedAt.IsZero() {
+			continue
+		}
+
+		if !v.FirstApprovalAt.IsZero() {
+			a.firstApprovals++
+			a.firstApproval += v.FirstApprovalAt.Sub(v.OpenedAt)
+		}
+
+		if !v.ApprovedAt.IsZero() {
+			f.Approved++
+			a.approved += v.ApprovedAt.Sub(v.OpenedAt)
+			if v.RequiredApprovers > 1 {
+				a.multi++
+			}
+		}
+	}
+
+	for k, f := range funnels {
+		a := accs[k]
+		if a.firstApprovals > 0 {
+			f.AvgSecondsToFirstApproval = a.firstApproval.Seconds() / float64(a.firstApprovals)
+		}
+		if f.Approved > 0 {
+			f.AvgSecondsToApproved = a.approved.Seconds() / float64(f.Approved)
+			f.MultiApproverPercent = float64(a.multi) * 100 / float64(f.Approved)
+		}
+	}
+
+	return funnels
+}
+
+func (s *snapshotStore) funnelHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, computeFunnels(s.list()))
+}
+
+// writeInternalError logs the error and responds without it, since the
+// errors of the clients may carry what the caller shouldn't see, such as the
+// requests made by the robot.
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	logrus.WithError(err).WithField("path", r.URL.Path).Error("Error serving the request.")
+
+	http.Error(w, "internal error, see the logs of the robot", http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.WithError(err).Error("write json response")
+	}
+}
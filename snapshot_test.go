@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+)
+
+func TestSnapshotStoreEvict(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+
+	cases := []struct {
+		name  string
+		items map[string]*prSnapshot
+		extra int
+		kept  []string
+	}{
+		{
+			name: "closed within the retention",
+			items: map[string]*prSnapshot{
+				"a": {Closed: true, ClosedAt: ago(snapshotClosedRetention - time.Hour)},
+				"b": {OpenedAt: ago(2 * snapshotClosedRetention)},
+			},
+			kept: []string{"a", "b"},
+		},
+		{
+			name: "closed beyond the retention",
+			items: map[string]*prSnapshot{
+				"a": {Closed: true, ClosedAt: ago(snapshotClosedRetention + time.Hour)},
+				"b": {OpenedAt: ago(2 * snapshotClosedRetention)},
+			},
+			kept: []string{"b"},
+		},
+		{
+			name: "over the cap evicts the closed first",
+			items: map[string]*prSnapshot{
+				"a": {Closed: true, ClosedAt: ago(time.Minute)},
+				"b": {HandledAt: ago(time.Hour)},
+			},
+			extra: 1,
+			kept:  []string{"b"},
+		},
+		{
+			name: "over the cap evicts the least recently handled",
+			items: map[string]*prSnapshot{
+				"a": {HandledAt: ago(time.Minute)},
+				"b": {HandledAt: ago(time.Hour)},
+				"c": {OpenedAt: ago(time.Hour), HandledAt: ago(2 * time.Hour)},
+			},
+			extra: 2,
+			kept:  []string{"a"},
+		},
+		{
+			name: "over the cap keeps the queued",
+			items: map[string]*prSnapshot{
+				"a": {HandledAt: ago(time.Minute)},
+				"b": {Queued: true},
+			},
+			extra: 1,
+			kept:  []string{"b"},
+		},
+	}
+
+	for _, c := range cases {
+		s := &snapshotStore{items: map[string]*prSnapshot{}}
+
+		// The filler is the most recent, so that only the items of the case
+		// are evicted.
+		for i := 0; i < snapshotMaxItems-len(c.items)+c.extra; i++ {
+			s.items[fmt.Sprintf("filler/%d", i)] = &prSnapshot{HandledAt: now}
+		}
+		for k, v := range c.items {
+			s.items[k] = v
+		}
+
+		s.evict(now)
+
+		for k := range c.items {
+			_, ok := s.items[k]
+			if want := contains(c.kept, k); ok != want {
+				t.Errorf("%s: expect %s kept %t, but got %t", c.name, k, want, ok)
+			}
+		}
+		if evicted := len(c.items) - len(c.kept); evicted > 0 && !s.dirty {
+			t.Errorf("%s: expect the store changed after evicting", c.name)
+		}
+	}
+}
+
+func contains(items []string, v string) bool {
+	for _, i := range items {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSnapshotStorePersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Recent enough not to be evicted on loading.
+	handledAt := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	openedAt := handledAt.Add(-time.Hour)
+
+	legacy := map[string]interface{}{
+		"org/repo/1": map[string]interface{}{
+			"org": "org", "repo": "repo", "number": 1,
+			"closed": true, "opened_at": openedAt, "handled_at": handledAt,
+		},
+		"org/repo/2": map[string]interface{}{
+			"org": "org", "repo": "repo", "number": 2, "opened_at": openedAt,
+		},
+		"org/repo/3": map[string]interface{}{
+			"org": "org", "repo": "repo", "number": 3,
+			"closed": true, "opened_at": openedAt,
+		},
+	}
+	b, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := json.Marshal(versioned{Version: 2, Data: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		data     []byte
+		closedAt map[int]time.Time
+	}{
+		{
+			name:     "unversioned",
+			data:     b,
+			closedAt: map[int]time.Time{1: handledAt, 2: {}, 3: openedAt},
+		},
+		{
+			name:     "version 2",
+			data:     v2,
+			closedAt: map[int]time.Time{1: handledAt, 2: {}, 3: openedAt},
+		},
+	}
+
+	for _, c := range cases {
+		file := filepath.Join(dir, c.name)
+		if err := ioutil.WriteFile(file, c.data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := newSnapshotStore(file, nil)
+		if err != nil {
+			t.Errorf("%s: load: %v", c.name, err)
+			continue
+		}
+
+		for n, want := range c.closedAt {
+			v, ok := s.get("org", "repo", n)
+			if !ok {
+				t.Errorf("%s: expect the snapshot of %d", c.name, n)
+			} else if !v.ClosedAt.Equal(want) {
+				t.Errorf("%s: expect %d closed at %s, but got %s", c.name, n, want, v.ClosedAt)
+			}
+		}
+
+		// The migrated snapshots are saved at the latest version.
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := versioned{}
+		if err := json.Unmarshal(raw, &e); err != nil || e.Version != len(snapshotMigrations)+1 {
+			t.Errorf("%s: expect version %d, but got %d (%v)", c.name, len(snapshotMigrations)+1, e.Version, err)
+		}
+
+		// The changes are saved by flush only.
+		s.record("org", "repo", prInfo{number: 4}, "", approve.Result{Approved: true})
+		if v, err := newSnapshotStore(file, nil); err != nil || len(v.items) != len(c.closedAt) {
+			t.Errorf("%s: expect the change not saved yet", c.name)
+		}
+
+		s.flush(time.Now())
+		if v, err := newSnapshotStore(file, nil); err != nil {
+			t.Errorf("%s: reload: %v", c.name, err)
+		} else if approved, ok := v.approved("org", "repo", 4); !ok || !approved {
+			t.Errorf("%s: expect the change saved", c.name)
+		}
+	}
+}
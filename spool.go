@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sdk "github.com/opensourceways/go-gitee/gitee"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	roleAll      = "all"
+	roleFrontend = "frontend"
+	roleWorker   = "worker"
+
+	// spoolFailedDir is the sub directory of the spool which the events
+	// failing spoolMaxAttempts times are moved to, for them to be inspected
+	// or replayed by --replay-events.
+	spoolFailedDir   = "failed"
+	spoolMaxAttempts = 5
+)
+
+// eventSpool is the directory shared by the frontend, which receives the
+// webhook events, and the worker, which handles them. An event is written
+// to it before the delivery is acknowledged, so that no event is dropped
+// while the worker is being redeployed, and removed by the worker once it
+// is handled. The events are named as those recorded by eventRecorder, in
+// the order they were received.
+type eventSpool struct {
+	dir     string
+	protect *persistProtection
+
+	lock sync.Mutex
+	seq  int
+}
+
+func newEventSpool(dir string, protect *persistProtection) (*eventSpool, error) {
+	if err := os.MkdirAll(filepath.Join(dir, spoolFailedDir), 0755); err != nil {
+		return nil, err
+	}
+
+	return &eventSpool{dir: dir, protect: protect}, nil
+}
+
+// put writes the event to the spool. The name begins with the time in
+// nanoseconds, so that the events received after a restart of the frontend,
+// which restarts the sequence, are still sorted after the earlier ones.
+func (s *eventSpool) put(kind string, e interface{}) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.seq++
+	name := fmt.Sprintf("%d-%06d-%s.json", time.Now().UnixNano(), s.seq, kind)
+
+	return s.protect.writeFile(filepath.Join(s.dir, name), b)
+}
+
+// pending returns the events in the spool in the order they were received.
+func (s *eventSpool) pending() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*-*-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// spoolWorker handles the events in the spool one by one in order, polling
+// it for the new ones.
+type spoolWorker struct {
+	bot      *robot
+	spool    *eventSpool
+	interval time.Duration
+	attempts map[string]int
+
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+func newSpoolWorker(bot *robot, spool *eventSpool, interval time.Duration) *spoolWorker {
+	return &spoolWorker{
+		bot:      bot,
+		spool:    spool,
+		interval: interval,
+		attempts: map[string]int{},
+		stopCh:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (w *spoolWorker) start() {
+	go func() {
+		defer close(w.done)
+
+		t := time.NewTicker(w.interval)
+		defer t.Stop()
+
+		for {
+			w.drain()
+
+			select {
+			case <-w.stopCh:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// stop stops polling the spool after the event being handled is done. The
+// events left are handled when the worker starts next time.
+func (w *spoolWorker) stop() {
+	close(w.stopCh)
+	<-w.done
+}
+
+func (w *spoolWorker) stopping() bool {
+	select {
+	case <-w.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain handles the events in the spool. An event is removed once it is
+// handled, and kept to be retried in the next round if it fails, until it
+// has failed spoolMaxAttempts times.
+func (w *spoolWorker) drain() {
+	files, err := w.spool.pending()
+	if err != nil {
+		logrus.WithError(err).Error("list the spooled events")
+		return
+	}
+
+	for _, f := range files {
+		if w.stopping() {
+			return
+		}
+
+		log := logrus.WithField("event", filepath.Base(f))
+
+		if err := w.handle(f, log); err != nil {
+			w.attempts[f]++
+			if w.attempts[f] < spoolMaxAttempts {
+				log.WithError(err).Warn("handle the spooled event, will retry")
+				continue
+			}
+
+			log.WithError(err).Error("handle the spooled event, give up")
+
+			err = os.Rename(f, filepath.Join(w.spool.dir, spoolFailedDir, filepath.Base(f)))
+		} else {
+			err = os.Remove(f)
+		}
+
+		delete(w.attempts, f)
+
+		if err != nil {
+			log.WithError(err).Error("remove the spooled event")
+		}
+	}
+}
+
+func (w *spoolWorker) handle(f string, log *logrus.Entry) error {
+	b, err := w.spool.protect.readFile(f)
+	if err != nil {
+		return err
+	}
+
+	_, cfg := w.bot.cfgAgent.GetConfig()
+
+	switch {
+	case strings.HasSuffix(f, "-"+eventKindPR+".json"):
+		e := new(sdk.PullRequestEvent)
+		if err := json.Unmarshal(b, e); err != nil {
+			return err
+		}
+
+		return w.bot.onPREvent(e, cfg, log)
+
+	case strings.HasSuffix(f, "-"+eventKindNote+".json"):
+		e := new(sdk.NoteEvent)
+		if err := json.Unmarshal(b, e); err != nil {
+			return err
+		}
+
+		return w.bot.onNoteEvent(e, cfg, log)
+
+	default:
+		return fmt.Errorf("unknown kind of the event")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/opensourceways/community-robot-lib/config"
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+)
+
+const statusPathPrefix = "/v1/approve/"
+
+// statusHandler serves GET /v1/approve/{org}/{repo}/{number}?viewer={login}.
+func (bot *robot) statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, repo, number, err := parsePRPath(statusPathPrefix, r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s, err := bot.status(org, repo, number, r.URL.Query().Get("viewer"))
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, s)
+}
+
+// parsePRPath parses the path of {prefix}{org}/{repo}/{number}.
+func parsePRPath(prefix, p string) (string, string, int, error) {
+	v := strings.Split(strings.Trim(strings.TrimPrefix(p, prefix), "/"), "/")
+	if len(v) != 3 {
+		return "", "", 0, fmt.Errorf("the path should be %s{org}/{repo}/{number}", prefix)
+	}
+
+	n, err := strconv.Atoi(v[2])
+	if err != nil || n <= 0 {
+		return "", "", 0, fmt.Errorf("invalid PR number: %s", v[2])
+	}
+
+	return v[0], v[1], n, nil
+}
+
+// status evaluates the approval status of a PR without changing it.
+func (bot *robot) status(org, repo string, number int, viewer string) (approve.Status, error) {
+	v, err := bot.cli.cli.GetGiteePullRequest(org, repo, int32(number))
+	if err != nil {
+		return approve.Status{}, err
+	}
+
+	_, c := bot.cfgAgent.GetConfig()
+
+	return bot.evaluateStatus(c, org, repo, prInfoFromPR(&v), viewer)
+}
+
+// evaluateStatus evaluates the approval status of the PR under the config.
+func (bot *robot) evaluateStatus(c config.Config, org, repo string, pr prInfo, viewer string) (approve.Status, error) {
+	log := logrus.WithFields(logrus.Fields{"org": org, "repo": repo, "number": pr.number})
+
+	cfg, err := bot.getConfig(c, org, repo, pr.base)
+	if err != nil {
+		return approve.Status{}, err
+	}
+
+	return bot.statusOf(org, repo, pr, cfg, viewer, log)
+}
+
+func (bot *robot) statusOf(org, repo string, pr prInfo, cfg *botConfig, viewer string, log *logrus.Entry) (approve.Status, error) {
+	cfg = cfg.forAuthor(pr.author)
+	oc, err := bot.loadOwners(org, repo, pr.base, cfg)
+	if err != nil {
+		return approve.Status{}, err
+	}
+
+	state := bot.newState(org, repo, pr, cfg, oc, log)
+	opts := transformConfig(org, cfg)
+
+	return approve.GetStatus(log, &bot.cli, oc, &opts, state, viewer)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// suggestionLoadWindow is how long a suggestion counts in the load of the
+// approver suggested.
+const suggestionLoadWindow = 7 * 24 * time.Hour
+
+// suggestionLoadMigrations are the migrations of the schema of the suggestion
+// load.
+var suggestionLoadMigrations = []migration{}
+
+// prSuggestion is the latest approvers suggested for a PR.
+type prSuggestion struct {
+	Org    string `json:"org"`
+	Repo   string `json:"repo"`
+	Number int    `json:"number"`
+
+	// Approvers are the lower case logins suggested.
+	Approvers []string `json:"approvers"`
+	// SuggestedAt is when the approvers were suggested first.
+	SuggestedAt time.Time `json:"suggested_at"`
+}
+
+// suggestionLoadStore records the approvers suggested for every PR, so that
+// the least loaded approvers can be suggested first. A PR counts once for
+// each approver suggested for it, however many times it is handled, and
+// only within suggestionLoadWindow. The records are persisted to the file if
+// it is given.
+type suggestionLoadStore struct {
+	lock    sync.Mutex
+	file    string
+	protect *persistProtection
+	items   map[string]*prSuggestion
+}
+
+func newSuggestionLoadStore(file string, protect *persistProtection) (*suggestionLoadStore, error) {
+	s := &suggestionLoadStore{file: file, protect: protect, items: map[string]*prSuggestion{}}
+	if file == "" {
+		return s, nil
+	}
+
+	b, err := protect.readFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	if len(b) == 0 {
+		return s, nil
+	}
+
+	data, _, err := migrate(b, suggestionLoadMigrations)
+	if err == nil {
+		err = json.Unmarshal(data, &s.items)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load suggestion load from %s: %v", file, err)
+	}
+
+	return s, nil
+}
+
+// record records the approvers suggested for the PR. The time is kept if the
+// approvers are the same as the last ones.
+func (s *suggestionLoadStore) record(org, repo string, number int, suggested []string) {
+	logins := sets.NewString()
+	for _, v := range suggested {
+		logins.Insert(strings.ToLower(v))
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	k := snapshotKey(org, repo, number)
+	v, ok := s.items[k]
+	if ok && sets.NewString(v.Approvers...).Equal(logins) {
+		return
+	}
+
+	if logins.Len() == 0 {
+		if ok {
+			delete(s.items, k)
+			s.save()
+		}
+		return
+	}
+
+	s.items[k] = &prSuggestion{
+		Org:         org,
+		Repo:        repo,
+		Number:      number,
+		Approvers:   logins.List(),
+		SuggestedAt: time.Now(),
+	}
+	s.save()
+}
+
+// load returns the number of the other PRs of the repo which each approver
+// is suggested for recently, keyed by the lower case login.
+func (s *suggestionLoadStore) load(org, repo string, number int) map[string]int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r := map[string]int{}
+	for _, v := range s.items {
+		if v.Org != org || v.Repo != repo || v.Number == number ||
+			time.Since(v.SuggestedAt) > suggestionLoadWindow {
+			continue
+		}
+
+		for _, login := range v.Approvers {
+			r[login]++
+		}
+	}
+
+	return r
+}
+
+// save drops the stale records and persists the rest. It must be called
+// with the lock held.
+func (s *suggestionLoadStore) save() {
+	for k, v := range s.items {
+		if time.Since(v.SuggestedAt) > suggestionLoadWindow {
+			delete(s.items, k)
+		}
+	}
+
+	if s.file == "" {
+		return
+	}
+
+	b, err := marshalVersioned(s.items, suggestionLoadMigrations)
+	if err == nil {
+		err = s.protect.writeFile(s.file, b)
+	}
+
+	if err != nil {
+		logrus.WithError(err).Errorf("save suggestion load to %s", s.file)
+	}
+}
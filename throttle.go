@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	throttleWindow   = time.Hour
+	throttleSweepLen = 10000
+)
+
+// commandThrottle limits how many commands a user can issue on a PR within
+// the window.
+type commandThrottle struct {
+	lock   sync.Mutex
+	hits   map[string][]time.Time
+	warned map[string]time.Time
+}
+
+func newCommandThrottle() *commandThrottle {
+	return &commandThrottle{
+		hits:   map[string][]time.Time{},
+		warned: map[string]time.Time{},
+	}
+}
+
+// allow records a command identified by the key and reports whether it is
+// allowed. If it is not, warn reports whether the user should be told, which
+// happens once per window.
+func (t *commandThrottle) allow(key string, max int, now time.Time) (allowed bool, warn bool) {
+	if max <= 0 {
+		return true, false
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.hits) > throttleSweepLen {
+		t.sweep(now)
+	}
+
+	hits := prune(t.hits[key], now)
+	if len(hits) >= max {
+		t.hits[key] = hits
+
+		if w, ok := t.warned[key]; ok && now.Sub(w) < throttleWindow {
+			return false, false
+		}
+		t.warned[key] = now
+
+		return false, true
+	}
+
+	t.hits[key] = append(hits, now)
+
+	return true, false
+}
+
+func (t *commandThrottle) sweep(now time.Time) {
+	for k, v := range t.hits {
+		if v = prune(v, now); len(v) == 0 {
+			delete(t.hits, k)
+		} else {
+			t.hits[k] = v
+		}
+	}
+
+	for k, w := range t.warned {
+		if now.Sub(w) >= throttleWindow {
+			delete(t.warned, k)
+		}
+	}
+}
+
+// prune drops the hits out of the window.
+func prune(hits []time.Time, now time.Time) []time.Time {
+	i := 0
+	for ; i < len(hits) && now.Sub(hits[i]) >= throttleWindow; i++ {
+	}
+
+	return hits[i:]
+}
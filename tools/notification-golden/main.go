@@ -0,0 +1,249 @@
+// notification-golden renders the approval notification of representative
+// scenarios and compares them with the golden files, so that any change to
+// the rendering of the message is intentional and reviewed.
+//
+//	go run ./tools/notification-golden          # check
+//	go run ./tools/notification-golden -update  # regenerate the golden files
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+	"github.com/opensourceways/robot-gitee-approve/approve/approvers"
+)
+
+const (
+	org        = "org"
+	repo       = "repo"
+	branch     = "master"
+	author     = "author"
+	prURL      = "https://gitee.com/org/repo/pulls/1"
+	commandURL = "https://gitee.com/org/community/blob/master/command.md"
+)
+
+// owners are the OWNERS files of the repo used by all the scenarios.
+var owners = map[string]*approvers.OwnersEntry{
+	"":     {Approvers: []string{"root"}},
+	"docs": {Approvers: []string{"doc-approver"}},
+	"pkg":  {Approvers: []string{"pkg-approver", "pkg-lead"}},
+}
+
+var files = []string{"docs/guide.md", "pkg/server.go"}
+
+type scenario struct {
+	name string
+	// setup adds the approvals and options to the approvers.
+	setup func(ap *approvers.Approvers)
+	// minimal renders the message of the minimal mode instead.
+	minimal bool
+	// owners replaces the OWNERS files of the repo if set.
+	owners map[string]*approvers.OwnersEntry
+}
+
+var scenarios = []scenario{
+	{
+		name: "no-approval",
+		setup: func(ap *approvers.Approvers) {
+			ap.AddAuthorSelfApprover(author, prURL+"#", false)
+		},
+	},
+	{
+		name: "partially-approved",
+		setup: func(ap *approvers.Approvers) {
+			ap.AddAuthorSelfApprover(author, prURL+"#", false)
+			ap.AddApprover("pkg-approver", prURL+"#note_1", false)
+		},
+	},
+	{
+		name: "fully-approved",
+		setup: func(ap *approvers.Approvers) {
+			ap.AddAuthorSelfApprover(author, prURL+"#", false)
+			ap.AddApprover("root", prURL+"#note_2", false)
+		},
+	},
+	{
+		name: "no-issue",
+		setup: func(ap *approvers.Approvers) {
+			ap.RequireIssue = true
+			ap.AddApprover("root", prURL+"#note_3", true)
+		},
+	},
+	{
+		name: "issue-missing",
+		setup: func(ap *approvers.Approvers) {
+			ap.RequireIssue = true
+			ap.AddApprover("root", prURL+"#note_4", false)
+		},
+	},
+	{
+		name: "cancel",
+		setup: func(ap *approvers.Approvers) {
+			ap.AddAuthorSelfApprover(author, prURL+"#", false)
+			ap.AddApprover("pkg-lead", prURL+"#note_5", false)
+			ap.RemoveApprover("pkg-lead")
+		},
+	},
+	{
+		name: "partial-approval",
+		setup: func(ap *approvers.Approvers) {
+			ap.AddAuthorSelfApprover(author, prURL+"#", false)
+			ap.AddPartialApprover("root", prURL+"#note_7", false, []string{"pkg"})
+		},
+	},
+	{
+		name: "self-approve-off",
+		setup: func(ap *approvers.Approvers) {
+			ap.AddAssignees(author)
+			ap.AddApprover("doc-approver", prURL+"#note_6", false)
+		},
+	},
+	{
+		name: "min-approvers",
+		setup: func(ap *approvers.Approvers) {
+			ap.MinApprovers = 2
+			ap.AddAuthorSelfApprover(author, prURL+"#", false)
+			ap.AddApprover("root", prURL+"#note_2", false)
+		},
+	},
+	{
+		name: "conditional",
+		setup: func(ap *approvers.Approvers) {
+			ap.RequireIssue = true
+			ap.ConditionalLabel = "approved-with-conditions"
+			ap.AddApprover("root", prURL+"#note_2", true)
+		},
+	},
+	{
+		name: "no-parent-owners",
+		setup: func(ap *approvers.Approvers) {
+			ap.AddAuthorSelfApprover(author, prURL+"#", false)
+			ap.AddApprover("root", prURL+"#note_2", false)
+		},
+		owners: map[string]*approvers.OwnersEntry{
+			"":     {Approvers: []string{"root"}},
+			"docs": {Approvers: []string{"doc-approver"}},
+			"pkg":  {Approvers: []string{"pkg-approver", "pkg-lead"}, NoParentOwners: true},
+		},
+	},
+	{
+		name: "owners-change",
+		setup: func(ap *approvers.Approvers) {
+			ap.AddAuthorSelfApprover(author, prURL+"#", false)
+			ap.AddApprover("root", prURL+"#note_2", false)
+			ap.OwnersFileChanges = []string{"pkg/OWNERS"}
+			ap.Requirements = []approvers.Requirement{{
+				Description: "changes of OWNERS files (pkg/OWNERS)",
+				Approvers:   sets.NewString("maintainer"),
+			}}
+		},
+	},
+	{
+		name: "series",
+		setup: func(ap *approvers.Approvers) {
+			ap.AddAuthorSelfApprover(author, prURL+"#", false)
+			ap.AddApprover("doc-approver", prURL+"#note_1", false)
+			ap.SeriesTopic = "split-server"
+			ap.Series = []approvers.Dependency{{Number: 2, Approved: true}, {Number: 3}}
+		},
+	},
+	{
+		name: "minimal",
+		setup: func(ap *approvers.Approvers) {
+			ap.AddAuthorSelfApprover(author, prURL+"#", false)
+			ap.AddApprover("pkg-approver", prURL+"#note_1", false)
+		},
+		minimal: true,
+	},
+}
+
+type emptyRepo struct{}
+
+func (emptyRepo) Approvers(path string) sets.String     { return sets.NewString() }
+func (emptyRepo) LeafApprovers(path string) sets.String { return sets.NewString() }
+func (emptyRepo) FindApproverOwnersForFile(string) string {
+	return ""
+}
+func (emptyRepo) IsNoParentOwners(path string) bool { return false }
+
+func render(s *scenario) (string, error) {
+	log := logrus.NewEntry(logrus.StandardLogger())
+	repoOwners := owners
+	if s.owners != nil {
+		repoOwners = s.owners
+	}
+	o := approvers.NewOwners(log, files, approvers.NewOverlayRepo(emptyRepo{}, repoOwners), 1)
+
+	ap := approvers.NewApprovers(o)
+	ap.Author = author
+	ap.DiffURL = prURL + "/files"
+	ap.ManuallyApproved = func() bool { return false }
+	s.setup(&ap)
+
+	linkURL, _ := url.Parse("https://gitee.com")
+	opts := approve.RenderOptions{Style: approve.NotificationFull, LinkURL: linkURL, CommandURL: commandURL}
+	if s.minimal {
+		opts.Style = approve.NotificationMinimal
+	}
+
+	msg, err := approve.RenderNotification(
+		approve.ApprovalState{Approvers: ap, Org: org, Repo: repo, Branch: branch, PRURL: prURL}, opts,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return msg + "\n", nil
+}
+
+func main() {
+	update := flag.Bool("update", false, "regenerate the golden files.")
+	dir := flag.String("dir", "tools/notification-golden/golden", "the directory of the golden files.")
+	flag.Parse()
+
+	failed := false
+	for i := range scenarios {
+		s := &scenarios[i]
+		file := filepath.Join(*dir, s.name+".md")
+
+		got, err := render(s)
+		if err != nil {
+			fmt.Printf("%s: %v\n", s.name, err)
+			failed = true
+			continue
+		}
+
+		if *update {
+			if err := ioutil.WriteFile(file, []byte(got), 0644); err != nil {
+				fmt.Printf("%s: %v\n", s.name, err)
+				failed = true
+			}
+			continue
+		}
+
+		want, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Printf("%s: %v\n", s.name, err)
+			failed = true
+			continue
+		}
+
+		if !bytes.Equal(want, []byte(got)) {
+			fmt.Printf("%s: the notification differs from %s, run with -update if the change is intended\n", s.name, file)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// traceFlushInterval is how often the finished spans are exported.
+	traceFlushInterval = 5 * time.Second
+	// traceMaxPending is the max number of the finished spans waiting to be
+	// exported, beyond which the new ones are dropped.
+	traceMaxPending = 10000
+
+	// traceIDField and spanIDField are the fields of the log entry carrying
+	// the span which the spans started with the entry are the children of.
+	// So the logs of a delivery are correlated with its trace too.
+	traceIDField = "trace-id"
+	spanIDField  = "span-id"
+)
+
+// traceSpan is a timed operation of the handling of a webhook delivery, such
+// as the handling of the PR, a call to the Gitee API or an OWNERS lookup.
+type traceSpan struct {
+	TraceID  string            `json:"trace_id"`
+	SpanID   string            `json:"span_id"`
+	ParentID string            `json:"parent_id,omitempty"`
+	Name     string            `json:"name"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Attrs    map[string]string `json:"attributes,omitempty"`
+	Error    string            `json:"error,omitempty"`
+
+	t   *tracer
+	key string
+}
+
+// tracer traces the handling of the webhook deliveries, and exports the
+// spans to the sink periodically. The spans in progress are kept by the
+// org/repo/number of the PR, so that the calls made for the PR, which don't
+// carry the span, are traced as the children of the latest one.
+type tracer struct {
+	w io.Writer
+	// url is the OTLP/HTTP endpoint of the collector, which is posted to
+	// instead of writing to w.
+	url string
+
+	lock    sync.Mutex
+	active  map[string][]*traceSpan
+	pending []*traceSpan
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newTracer opens the sink, which is stdout, file:<path> or an http(s) url of
+// the OpenTelemetry collector receiving OTLP/HTTP in json. The spans are
+// written to stdout and the file as lines of json.
+func newTracer(sink string) (*tracer, error) {
+	t := &tracer{
+		active: map[string][]*traceSpan{},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	switch {
+	case sink == "stdout":
+		t.w = os.Stdout
+
+	case strings.HasPrefix(sink, "file:"):
+		f, err := os.OpenFile(strings.TrimPrefix(sink, "file:"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		t.w = f
+
+	case strings.HasPrefix(sink, "http://") || strings.HasPrefix(sink, "https://"):
+		if _, err := url.Parse(sink); err != nil {
+			return nil, fmt.Errorf("invalid url of the trace sink")
+		}
+		t.url = sink
+
+	default:
+		return nil, fmt.Errorf("unknown trace sink: %s", sink)
+	}
+
+	return t, nil
+}
+
+func traceKey(org, repo string, number int) string {
+	return fmt.Sprintf("%s/%s/%d", org, repo, number)
+}
+
+func newTraceID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		// It hardly fails, and a weak id is fine for tracing.
+		return fmt.Sprintf("%0*x", n*2, time.Now().UnixNano())[:n*2]
+	}
+	return hex.EncodeToString(b)
+}
+
+// startDeliverySpan starts the root span of the handling of a webhook
+// delivery of the PR.
+func (bot *robot) startDeliverySpan(kind, org, repo string, number int, log *logrus.Entry) (*traceSpan, *logrus.Entry) {
+	id, _ := log.Data["event-id"].(string)
+
+	return bot.tracer.startSpan("webhook.delivery", org, repo, number, map[string]string{
+		"event_kind":  kind,
+		"delivery_id": id,
+	}, log)
+}
+
+// startSpan starts a span of the PR, which is the child of the span carried
+// by the log entry if any, or else the root of a new trace. The entry
+// returned carries the new span.
+func (t *tracer) startSpan(name, org, repo string, number int, attrs map[string]string, log *logrus.Entry) (*traceSpan, *logrus.Entry) {
+	if t == nil {
+		return nil, log
+	}
+
+	s := &traceSpan{
+		SpanID: newTraceID(8),
+		Name:   name,
+		Start:  time.Now(),
+		Attrs: map[string]string{
+			"org":    org,
+			"repo":   repo,
+			"number": strconv.Itoa(number),
+		},
+		t:   t,
+		key: traceKey(org, repo, number),
+	}
+	for k, v := range attrs {
+		s.Attrs[k] = v
+	}
+
+	if v, ok := log.Data[traceIDField].(string); ok && v != "" {
+		s.TraceID = v
+		s.ParentID, _ = log.Data[spanIDField].(string)
+	} else {
+		s.TraceID = newTraceID(16)
+	}
+
+	t.lock.Lock()
+	t.active[s.key] = append(t.active[s.key], s)
+	t.lock.Unlock()
+
+	return s, log.WithFields(logrus.Fields{traceIDField: s.TraceID, spanIDField: s.SpanID})
+}
+
+// finish ends the span with the error of the operation.
+func (s *traceSpan) finish(err error) {
+	if s == nil {
+		return
+	}
+
+	s.End = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	t := s.t
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	spans := t.active[s.key]
+	for i := range spans {
+		if spans[i] == s {
+			spans = append(spans[:i], spans[i+1:]...)
+			break
+		}
+	}
+	if len(spans) == 0 {
+		delete(t.active, s.key)
+	} else {
+		t.active[s.key] = spans
+	}
+
+	t.add(s)
+}
+
+// record traces the call of the PR which started at start as the child of
+// the latest span in progress of the PR, or of the repo if number is 0. The
+// calls made out of the handling of the deliveries are not traced.
+func (t *tracer) record(name, org, repo string, number int, start time.Time, err error) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var parent *traceSpan
+	if number > 0 {
+		if spans := t.active[traceKey(org, repo, number)]; len(spans) > 0 {
+			parent = spans[len(spans)-1]
+		}
+	} else {
+		prefix := org + "/" + repo + "/"
+		for k, spans := range t.active {
+			if !strings.HasPrefix(k, prefix) {
+				continue
+			}
+			if v := spans[len(spans)-1]; parent == nil || v.Start.After(parent.Start) {
+				parent = v
+			}
+		}
+	}
+
+	if parent == nil {
+		return
+	}
+
+	s := &traceSpan{
+		TraceID:  parent.TraceID,
+		SpanID:   newTraceID(8),
+		ParentID: parent.SpanID,
+		Name:     name,
+		Start:    start,
+		End:      time.Now(),
+		Attrs:    map[string]string{"org": org, "repo": repo},
+	}
+	if number > 0 {
+		s.Attrs["number"] = strconv.Itoa(number)
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	t.add(s)
+}
+
+// add queues the finished span to be exported. The lock must be held.
+func (t *tracer) add(s *traceSpan) {
+	if len(t.pending) < traceMaxPending {
+		t.pending = append(t.pending, s)
+	}
+}
+
+func (t *tracer) start() {
+	go func() {
+		defer close(t.done)
+
+		tk := time.NewTicker(traceFlushInterval)
+		defer tk.Stop()
+
+		for {
+			select {
+			case <-t.stop:
+				t.flush()
+				return
+			case <-tk.C:
+				t.flush()
+			}
+		}
+	}()
+}
+
+// shutdown exports the spans finished, and stops.
+func (t *tracer) shutdown() {
+	close(t.stop)
+	<-t.done
+}
+
+func (t *tracer) flush() {
+	t.lock.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.lock.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	if t.url != "" {
+		if err := t.post(spans); err != nil {
+			logrus.WithError(err).WithField("spans", len(spans)).Error("export the spans")
+		}
+		return
+	}
+
+	for _, s := range spans {
+		b, err := json.Marshal(s)
+		if err != nil {
+			logrus.WithError(err).Error("marshal the span")
+			continue
+		}
+
+		if _, err := t.w.Write(append(b, '\n')); err != nil {
+			logrus.WithError(err).Error("write the span")
+			return
+		}
+	}
+}
+
+// post exports the spans to the collector in the json encoding of OTLP.
+func (t *tracer) post(spans []*traceSpan) error {
+	type attr struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+
+	newAttr := func(k, v string) attr {
+		a := attr{Key: k}
+		a.Value.StringValue = v
+		return a
+	}
+
+	type status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+
+	type span struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId,omitempty"`
+		Name         string `json:"name"`
+		Kind         int    `json:"kind"`
+		Start        string `json:"startTimeUnixNano"`
+		End          string `json:"endTimeUnixNano"`
+		Attributes   []attr `json:"attributes"`
+		Status       status `json:"status"`
+	}
+
+	items := make([]span, len(spans))
+	for i, s := range spans {
+		v := span{
+			TraceID:      s.TraceID,
+			SpanID:       s.SpanID,
+			ParentSpanID: s.ParentID,
+			Name:         s.Name,
+			// SPAN_KIND_INTERNAL
+			Kind:  1,
+			Start: strconv.FormatInt(s.Start.UnixNano(), 10),
+			End:   strconv.FormatInt(s.End.UnixNano(), 10),
+			// STATUS_CODE_OK
+			Status: status{Code: 1},
+		}
+		for k, a := range s.Attrs {
+			v.Attributes = append(v.Attributes, newAttr(k, a))
+		}
+		if s.Error != "" {
+			// STATUS_CODE_ERROR
+			v.Status = status{Code: 2, Message: s.Error}
+		}
+		items[i] = v
+	}
+
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []attr{newAttr("service.name", botName)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": botName},
+						"spans": items,
+					},
+				},
+			},
+		},
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	return postJSON(t.url, b)
+}
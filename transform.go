@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strconv"
 	"time"
 
 	sdk "github.com/opensourceways/go-gitee/gitee"
@@ -20,10 +21,18 @@ func transformPRChanges(changes []sdk.PullRequestFiles) []github.PullRequestChan
 	for i := range changes {
 		v := &changes[i]
 
+		// Gitee reports the numbers of lines in strings, and they are 0 if
+		// they can't be parsed.
+		additions, _ := strconv.Atoi(v.Additions)
+		deletions, _ := strconv.Atoi(v.Deletions)
+
 		res[i] = github.PullRequestChange{
-			SHA:      v.Sha,
-			Filename: v.Filename,
-			Status:   v.Status,
+			SHA:       v.Sha,
+			Filename:  v.Filename,
+			Status:    v.Status,
+			Additions: additions,
+			Deletions: deletions,
+			Changes:   additions + deletions,
 		}
 	}
 
@@ -92,10 +101,98 @@ func transformUser(user *sdk.UserBasic) github.User {
 	}
 }
 
+// prInfo is the information of a PR which is needed to handle it.
+type prInfo struct {
+	number    int
+	body      string
+	author    string
+	htmlURL   string
+	base      string
+	headRef   string
+	headSHA   string
+	baseSHA   string
+	createdAt string
+	assignees []string
+}
+
+func prInfoFromHook(pr *sdk.PullRequestHook) prInfo {
+	as := pr.GetAssignees()
+	assignees := make([]string, 0, len(as))
+	for i := range as {
+		assignees = append(assignees, as[i].GetLogin())
+	}
+
+	return prInfo{
+		number:    int(pr.GetNumber()),
+		body:      pr.GetBody(),
+		author:    pr.GetUser().GetLogin(),
+		htmlURL:   pr.GetHtmlURL(),
+		base:      pr.GetBase().GetRef(),
+		headRef:   pr.GetHead().GetRef(),
+		headSHA:   pr.GetHead().GetSha(),
+		baseSHA:   pr.GetBase().GetSha(),
+		createdAt: pr.CreatedAt,
+		assignees: assignees,
+	}
+}
+
+func prInfoFromPR(pr *sdk.PullRequest) prInfo {
+	assignees := make([]string, 0, len(pr.Assignees))
+	for i := range pr.Assignees {
+		assignees = append(assignees, pr.Assignees[i].Login)
+	}
+
+	info := prInfo{
+		number:    int(pr.Number),
+		body:      pr.Body,
+		author:    pr.User.GetLogin(),
+		htmlURL:   pr.HtmlUrl,
+		createdAt: pr.CreatedAt,
+		assignees: assignees,
+	}
+	if pr.Base != nil {
+		info.base = pr.Base.Ref
+		info.baseSHA = pr.Base.Sha
+	}
+	if pr.Head != nil {
+		info.headRef = pr.Head.Ref
+		info.headSHA = pr.Head.Sha
+	}
+
+	return info
+}
+
 func transformConfig(org string, cfg *botConfig) plugins.Approve {
 	return plugins.Approve{
-		Repos:               []string{org},
-		RequireSelfApproval: &cfg.RequireSelfApproval,
-		IgnoreReviewState:   &cfg.ignoreReviewState,
+		Repos:                        []string{org},
+		IssueRequired:                cfg.IssueRequired,
+		AssociatedIssuePattern:       cfg.AssociatedIssuePattern,
+		LgtmActsAsApprove:            cfg.LgtmActsAsApprove,
+		ReactionActsAsApprove:        cfg.ReactionActsAsApprove,
+		SuggestionStrategy:           cfg.SuggestionStrategy,
+		SuggestionSeed:               cfg.SuggestionSeed,
+		RequireApprovalAfterLastPush: cfg.RequireApprovalAfterLastPush,
+		InvalidateApprovalsOnPush:    cfg.InvalidateApprovalsOnPush,
+		SiblingRobots:                cfg.SiblingRobots,
+		ApprovalTTL:                  time.Duration(cfg.ApprovalTTLHours) * time.Hour,
+		RequireSelfApproval:          &cfg.RequireSelfApproval.Required,
+		SelfApprovalRequiredPaths:    cfg.RequireSelfApproval.Paths,
+		IgnoreReviewState:            &cfg.ignoreReviewState,
+		BlockOnDependencies:          cfg.StackedPRs && cfg.BlockOnDependencies,
+		FileStatusPolicies:           cfg.FileStatusPolicies,
+		SplitNotification:            cfg.SplitNotification,
+		LabelPolicies:                cfg.LabelPolicies,
+		SizeRequirements:             cfg.SizeRequirements,
+		IgnoredPaths:                 cfg.IgnoredPaths,
+		RequireLgtmBeforeApprove:     cfg.RequireLgtmBeforeApprove,
+		PathRequirements:             cfg.PathRequirements,
+		OwnersFileChangeRequires:     cfg.OwnersFileChangeRequires,
+		MinimalMode:                  cfg.MinimalMode,
+		MinApprovers:                 cfg.MinApprovers,
+		ConditionalApprovedLabel:     cfg.ConditionalApprovedLabel,
+		RenamedLogins:                cfg.renamedLogins,
+		CommandAliases:               cfg.commandAliases,
+		MissingApproversPolicy:       cfg.MissingApproversPolicy,
+		FallbackApprovers:            cfg.FallbackApprovers,
 	}
 }
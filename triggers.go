@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	sdk "github.com/opensourceways/go-gitee/gitee"
+)
+
+const (
+	triggerOpen                = "open"
+	triggerSourceBranchChanged = "source_branch_changed"
+	triggerNote                = "note"
+	triggerReview              = "review"
+	triggerAssigneeChanged     = "assignee_changed"
+	triggerLabelChanged        = "label_changed"
+	triggerReopen              = "reopen"
+	triggerTargetBranchChanged = "target_branch_changed"
+
+	// The actions of the PR events of Gitee which sdk.GetPullRequestAction
+	// doesn't tell.
+	prActionAssign   = "assign"
+	prActionUnassign = "unassign"
+	prActionApproved = "approved"
+	prActionReopen   = "reopen"
+)
+
+// configCommandReg matches "/approve config" which replies with the events
+// triggering the robot on the repo.
+var configCommandReg = regexp.MustCompile(`(?mi)^/approve[\t ]+config[\t ]*$`)
+
+// eventTriggers switches the events which trigger the handling of the PRs,
+// so that the expensive ones can be disabled on the busy repos. An unset
+// switch keeps the default.
+type eventTriggers struct {
+	// Open is the PR opened. The default is true.
+	Open *bool `json:"open,omitempty"`
+
+	// SourceBranchChanged is the push to the source branch of the PR. The
+	// default is true.
+	SourceBranchChanged *bool `json:"source_branch_changed,omitempty"`
+
+	// Note is the comment with the approve commands. The other commands,
+	// such as "/approve status", are always handled. The default is true.
+	Note *bool `json:"note,omitempty"`
+
+	// Review is the review of the PR passed on Gitee. The default is false.
+	Review *bool `json:"review,omitempty"`
+
+	// AssigneeChanged is the PR assigned or unassigned. The default is
+	// false.
+	AssigneeChanged *bool `json:"assignee_changed,omitempty"`
+
+	// LabelChanged is the change of the labels of the PR, which is handled
+	// only when the labels matter. The default is true.
+	LabelChanged *bool `json:"label_changed,omitempty"`
+
+	// Reopen is the PR reopened, whose labels may have been changed while it
+	// was closed. The default is true.
+	Reopen *bool `json:"reopen,omitempty"`
+
+	// TargetBranchChanged is the PR retargeted to another branch, whose
+	// OWNERS files apply then. The default is true.
+	TargetBranchChanged *bool `json:"target_branch_changed,omitempty"`
+}
+
+// all returns the triggers with whether each is enabled, in order.
+func (t *eventTriggers) all() []struct {
+	name    string
+	enabled bool
+} {
+	get := func(v *bool, def bool) bool {
+		if v == nil {
+			return def
+		}
+		return *v
+	}
+
+	return []struct {
+		name    string
+		enabled bool
+	}{
+		{triggerOpen, get(t.Open, true)},
+		{triggerSourceBranchChanged, get(t.SourceBranchChanged, true)},
+		{triggerNote, get(t.Note, true)},
+		{triggerReview, get(t.Review, false)},
+		{triggerAssigneeChanged, get(t.AssigneeChanged, false)},
+		{triggerLabelChanged, get(t.LabelChanged, true)},
+		{triggerReopen, get(t.Reopen, true)},
+		{triggerTargetBranchChanged, get(t.TargetBranchChanged, true)},
+	}
+}
+
+func (t *eventTriggers) enabled(name string) bool {
+	for _, v := range t.all() {
+		if v.name == name {
+			return v.enabled
+		}
+	}
+
+	return false
+}
+
+// prEventTrigger returns the trigger of the PR event, or empty if the event
+// triggers nothing.
+func prEventTrigger(e *sdk.PullRequestEvent) string {
+	switch sdk.GetPullRequestAction(e) {
+	case sdk.ActionOpen:
+		return triggerOpen
+	case sdk.PRActionChangedSourceBranch:
+		return triggerSourceBranchChanged
+	case sdk.PRActionUpdatedLabel:
+		return triggerLabelChanged
+	case sdk.PRActionChangedTargetBranch:
+		return triggerTargetBranchChanged
+	}
+
+	switch e.GetAction() {
+	case prActionAssign, prActionUnassign:
+		return triggerAssigneeChanged
+	case prActionApproved:
+		return triggerReview
+	case prActionReopen:
+		return triggerReopen
+	}
+
+	return ""
+}
+
+// handleConfigCommand replies with the events triggering the robot on the
+// repo in effect.
+func (bot *robot) handleConfigCommand(c *noteCommand) error {
+	var on, off []string
+	for _, v := range c.cfg.Triggers.all() {
+		if v.enabled {
+			on = append(on, "`"+v.name+"`")
+		} else {
+			off = append(off, "`"+v.name+"`")
+		}
+	}
+
+	msg := fmt.Sprintf("The events triggering the robot on this repository: %s.", strings.Join(on, ", "))
+	if len(off) > 0 {
+		msg += fmt.Sprintf(" Disabled: %s.", strings.Join(off, ", "))
+	}
+
+	return c.reply(bot, msg)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/approve"
+)
+
+const verifyPath = "/verify-before-merge"
+
+// verifyRequest is the PR which is about to be merged.
+type verifyRequest struct {
+	Org    string `json:"org"`
+	Repo   string `json:"repo"`
+	Number int    `json:"number"`
+
+	// HeadSHA is the commit to merge. The PR is not approved if it has been
+	// updated since then.
+	HeadSHA string `json:"head_sha,omitempty"`
+}
+
+// verdict is the approval of a PR verified right before merging. Signature is
+// the hex HMAC-SHA256 of the payload with the shared secret.
+type verdict struct {
+	Org             string   `json:"org"`
+	Repo            string   `json:"repo"`
+	Number          int      `json:"number"`
+	HeadSHA         string   `json:"head_sha"`
+	Approved        bool     `json:"approved"`
+	Reason          string   `json:"reason,omitempty"`
+	Approvers       []string `json:"approvers"`
+	UnapprovedFiles []string `json:"unapproved_files"`
+	IssuedAt        int64    `json:"issued_at"`
+	Signature       string   `json:"signature"`
+}
+
+// payload is what the signature signs, which is
+// org/repo/number:head_sha:approved:issued_at.
+func (v *verdict) payload() string {
+	return fmt.Sprintf("%s/%s/%d:%s:%t:%d", v.Org, v.Repo, v.Number, v.HeadSHA, v.Approved, v.IssuedAt)
+}
+
+func (v *verdict) sign(secret []byte) {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(v.payload()))
+	v.Signature = hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyHandler serves POST /verify-before-merge which the merge robot calls
+// right before merging a PR. The approval is evaluated again from the live
+// comments and OWNERS files instead of the labels, which may have been
+// changed since the PR was approved, and the verdict is signed.
+func (bot *robot) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req verifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Org == "" || req.Repo == "" || req.Number <= 0 {
+		http.Error(w, "org, repo and number are required", http.StatusBadRequest)
+		return
+	}
+
+	v, err := bot.verify(&req)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	v.sign(bot.verifySecret)
+
+	writeJSON(w, v)
+}
+
+func (bot *robot) verify(req *verifyRequest) (*verdict, error) {
+	log := logrus.WithFields(logrus.Fields{"org": req.Org, "repo": req.Repo, "number": req.Number})
+
+	p, err := bot.cli.cli.GetGiteePullRequest(req.Org, req.Repo, int32(req.Number))
+	if err != nil {
+		return nil, err
+	}
+	pr := prInfoFromPR(&p)
+
+	v := &verdict{
+		Org:      req.Org,
+		Repo:     req.Repo,
+		Number:   req.Number,
+		HeadSHA:  pr.headSHA,
+		IssuedAt: time.Now().Unix(),
+	}
+
+	if req.HeadSHA != "" && req.HeadSHA != pr.headSHA {
+		v.Reason = fmt.Sprintf("the head of the PR is %s instead of %s", pr.headSHA, req.HeadSHA)
+
+		return v, nil
+	}
+
+	_, c := bot.cfgAgent.GetConfig()
+	cfg, err := bot.getConfig(c, req.Org, req.Repo, pr.base)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg = cfg.forAuthor(pr.author)
+	oc, err := bot.loadOwners(req.Org, req.Repo, pr.base, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	state := bot.newState(req.Org, req.Repo, pr, cfg, oc, log)
+	opts := transformConfig(req.Org, cfg)
+
+	r, err := approve.Verify(log, &bot.cli, oc, &opts, state)
+	if err != nil {
+		return nil, err
+	}
+
+	v.Approved = r.Approved
+	v.Approvers = r.Approvers
+	v.UnapprovedFiles = r.UnapprovedFiles
+	if !r.Approved {
+		v.Reason = "the approval requirements are not met"
+	}
+
+	log.WithField("approved", v.Approved).Info("Verified the PR before merging")
+
+	return v, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/metrics"
+)
+
+// watchdog detects that the events keep being received but none of them
+// has been processed successfully for the timeout, which usually means the
+// handling hangs, such as on the owners cache. The robot is reported as not
+// ready until an event is processed again.
+type watchdog struct {
+	timeout time.Duration
+
+	lock sync.Mutex
+	// pendingSince is when the earliest event received after the latest
+	// successful one arrived.
+	pendingSince time.Time
+	stalled      bool
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newWatchdog(timeout time.Duration) *watchdog {
+	return &watchdog{timeout: timeout, stopCh: make(chan struct{})}
+}
+
+// track runs the handler of an event and records the result.
+func (w *watchdog) track(handler func() error) error {
+	if w == nil {
+		return handler()
+	}
+
+	w.received(time.Now())
+
+	err := handler()
+	if err == nil {
+		w.processed()
+	}
+
+	return err
+}
+
+func (w *watchdog) received(now time.Time) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.pendingSince.IsZero() {
+		w.pendingSince = now
+	}
+}
+
+func (w *watchdog) processed() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.pendingSince = time.Time{}
+
+	if w.stalled {
+		w.stalled = false
+		metrics.SetEventProcessingStalled(false)
+		logrus.Info("Events are processed again, the robot is ready.")
+	}
+}
+
+func (w *watchdog) check(now time.Time) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.stalled || w.pendingSince.IsZero() || now.Sub(w.pendingSince) < w.timeout {
+		return
+	}
+
+	w.stalled = true
+	metrics.SetEventProcessingStalled(true)
+	logrus.WithFields(logrus.Fields{
+		"alert":         true,
+		"pending_since": w.pendingSince.Format(time.RFC3339),
+	}).Errorf("No event has been processed successfully for %s, the robot is not ready.", w.timeout)
+}
+
+func (w *watchdog) ready() bool {
+	if w == nil {
+		return true
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return !w.stalled
+}
+
+func (w *watchdog) start() {
+	w.wg.Add(1)
+
+	go func() {
+		defer w.wg.Done()
+
+		t := time.NewTicker(w.timeout / 4)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-w.stopCh:
+				return
+			case now := <-t.C:
+				w.check(now)
+			}
+		}
+	}()
+}
+
+func (w *watchdog) stop() {
+	close(w.stopCh)
+	w.wg.Wait()
+}
+
+// readyHandler serves the readiness probe.
+func (w *watchdog) readyHandler(rw http.ResponseWriter, r *http.Request) {
+	if !w.ready() {
+		http.Error(rw, "event processing stalled", http.StatusServiceUnavailable)
+		return
+	}
+
+	rw.Write([]byte("ok"))
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"container/heap"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sdk "github.com/opensourceways/go-gitee/gitee"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	webhookPath = "/v1/webhook"
+
+	giteeEventPR   = "Merge Request Hook"
+	giteeEventNote = "Note Hook"
+
+	// webhookReplayWindow is how long a delivery is valid after it was
+	// signed, and the deliveries seen within it are remembered.
+	webhookReplayWindow = 5 * time.Minute
+	// webhookSeenDeliveries is the max number of deliveries remembered.
+	webhookSeenDeliveries = 10000
+)
+
+// webhookGuard serves the webhook of Gitee with the signature of every
+// delivery of the orgs having a webhook secret verified, rejecting the
+// deliveries replayed, and dispatches the events to the robot. The webhook
+// of such an org must be configured in the signature mode of Gitee, which
+// signs the timestamp of the delivery with the secret. The deliveries of the
+// other orgs are dispatched unverified as the webhook of the framework does.
+type webhookGuard struct {
+	bot  *robot
+	seen *deliveryCache
+
+	wg sync.WaitGroup
+}
+
+func newWebhookGuard(bot *robot) *webhookGuard {
+	return &webhookGuard{bot: bot, seen: newDeliveryCache(webhookSeenDeliveries)}
+}
+
+func (g *webhookGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		org    string
+		handle func(*logrus.Entry) error
+	)
+
+	_, c := g.bot.cfgAgent.GetConfig()
+
+	switch kind := r.Header.Get("X-Gitee-Event"); kind {
+	case giteeEventPR:
+		e := new(sdk.PullRequestEvent)
+		err = json.Unmarshal(payload, e)
+		org, _ = e.GetOrgRepo()
+		handle = func(log *logrus.Entry) error { return g.bot.onPREvent(e, c, log) }
+
+	case giteeEventNote:
+		e := new(sdk.NoteEvent)
+		err = json.Unmarshal(payload, e)
+		org, _ = e.GetOrgRepo()
+		handle = func(log *logrus.Entry) error { return g.bot.onNoteEvent(e, c, log) }
+
+	default:
+		// The other events are not handled, but the delivery is fine.
+		return
+	}
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	cfg, ok := c.(*configuration)
+	if !ok {
+		http.Error(w, "can't convert to configuration", http.StatusInternalServerError)
+		return
+	}
+
+	if status, err := g.verify(cfg, org, r.Header, time.Now()); err != nil {
+		logrus.WithField("org", org).WithError(err).Warn("Reject the webhook delivery.")
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"event-type": r.Header.Get("X-Gitee-Event"),
+		"event-id":   r.Header.Get("X-Gitee-Timestamp"),
+	})
+
+	// The frontend acknowledges the delivery only after the event is spooled,
+	// so that a failure is reported to Gitee instead of the event being lost.
+	if g.bot.spool != nil {
+		if err := handle(log); err != nil {
+			log.WithError(err).Error("spool the event")
+			http.Error(w, "failed to spool the event", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Gitee expects the response shortly, so handle the event after that as
+	// the framework does.
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+
+		if err := handle(log); err != nil {
+			log.WithError(err).Error("handle the event")
+		}
+	}()
+}
+
+// verify verifies the signature of the delivery for the org, and that it is
+// neither stale nor seen before, if the org has a webhook secret. It returns
+// the status code to respond with if it fails.
+//
+// The org, which chooses the secret, is read from the payload before it is
+// verified. A forged org chooses the secret of that org, which the sender
+// must know, and the orgs not configured for the robot are rejected, so that
+// the secret of * is not used for any org the sender claims.
+func (g *webhookGuard) verify(cfg *configuration, org string, h http.Header, now time.Time) (int, error) {
+	if !cfg.configuresOrg(org) {
+		return http.StatusForbidden, fmt.Errorf("the org %q is not configured", org)
+	}
+
+	if !cfg.verifiesWebhook(org) {
+		return 0, nil
+	}
+
+	secret, err := cfg.webhookSecret(org)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	token, timestamp := h.Get("X-Gitee-Token"), h.Get("X-Gitee-Timestamp")
+
+	ms, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid timestamp: %q", timestamp)
+	}
+
+	if !hmac.Equal([]byte(token), []byte(signWebhook(secret, timestamp))) {
+		return http.StatusUnauthorized, fmt.Errorf("invalid signature")
+	}
+
+	t := time.Unix(0, ms*int64(time.Millisecond))
+	if now.Sub(t) > webhookReplayWindow || t.Sub(now) > webhookReplayWindow {
+		return http.StatusUnauthorized, fmt.Errorf("the delivery signed at %s is stale", t.Format(time.RFC3339))
+	}
+
+	if !g.seen.add(org+"/"+timestamp+"/"+token, t.Add(webhookReplayWindow), now) {
+		return http.StatusConflict, fmt.Errorf("the delivery is replayed")
+	}
+
+	return 0, nil
+}
+
+// wait waits until the events being handled are done.
+func (g *webhookGuard) wait() {
+	g.wg.Wait()
+}
+
+// signWebhook returns the signature of Gitee, which is
+// base64(hmac-sha256(secret, timestamp + "\n" + secret)).
+func signWebhook(secret []byte, timestamp string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "\n"))
+	mac.Write(secret)
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// configuresOrg reports whether any repo of the org is configured for the
+// robot, which is every org if the unconfigured repos are not ignored.
+func (c *configuration) configuresOrg(org string) bool {
+	if org == "" {
+		return false
+	}
+
+	if c.unconfiguredRepoAction() != unconfiguredRepoIgnore {
+		return true
+	}
+
+	for i := range c.ConfigItems {
+		for _, r := range c.ConfigItems[i].Repos {
+			if r == org || strings.HasPrefix(r, org+"/") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// webhookSecretFile returns the file of the secret of the webhooks of the
+// org, and whether there is one.
+func (c *configuration) webhookSecretFile(org string) (string, bool) {
+	if file, ok := c.WebhookSecretFiles[org]; ok {
+		return file, true
+	}
+
+	file, ok := c.WebhookSecretFiles["*"]
+	return file, ok
+}
+
+// verifiesWebhook reports whether the deliveries of the org must be verified,
+// which is the case once a webhook secret is configured for it.
+func (c *configuration) verifiesWebhook(org string) bool {
+	_, ok := c.webhookSecretFile(org)
+	return ok
+}
+
+// webhookSecret returns the secret of the webhooks of the org.
+func (c *configuration) webhookSecret(org string) ([]byte, error) {
+	file, ok := c.webhookSecretFile(org)
+	if !ok {
+		return nil, fmt.Errorf("no webhook secret for the org: %s", org)
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read the webhook secret of %s: %v", org, err)
+	}
+
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return nil, fmt.Errorf("the webhook secret of %s is empty", org)
+	}
+
+	return []byte(s), nil
+}
+
+// deliveryCache remembers the deliveries until they expire, up to size,
+// evicting the ones expiring first. The deliveries are kept in a map for the
+// lookup and in a heap ordered by the expiry for the eviction, so that adding
+// one costs O(log n).
+type deliveryCache struct {
+	size int
+
+	lock   sync.Mutex
+	expiry deliveryHeap
+	items  map[string]struct{}
+}
+
+type delivery struct {
+	key     string
+	expires time.Time
+}
+
+func newDeliveryCache(size int) *deliveryCache {
+	return &deliveryCache{size: size, items: map[string]struct{}{}}
+}
+
+// add records the delivery which expires at expires, and reports whether it
+// is new.
+func (d *deliveryCache) add(key string, expires, now time.Time) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, ok := d.items[key]; ok {
+		return false
+	}
+
+	// Forget the deliveries expired, which are rejected as stale anyway.
+	for len(d.expiry) > 0 && now.After(d.expiry[0].expires) {
+		delete(d.items, heap.Pop(&d.expiry).(delivery).key)
+	}
+
+	for len(d.expiry) >= d.size {
+		delete(d.items, heap.Pop(&d.expiry).(delivery).key)
+	}
+
+	d.items[key] = struct{}{}
+	heap.Push(&d.expiry, delivery{key: key, expires: expires})
+
+	return true
+}
+
+// deliveryHeap is a min-heap of the deliveries by the expiry.
+type deliveryHeap []delivery
+
+func (h deliveryHeap) Len() int           { return len(h) }
+func (h deliveryHeap) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+func (h deliveryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *deliveryHeap) Push(x interface{}) {
+	*h = append(*h, x.(delivery))
+}
+
+func (h *deliveryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	v := old[n-1]
+	*h = old[:n-1]
+	return v
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	libconfig "github.com/opensourceways/community-robot-lib/config"
+)
+
+func TestWebhookGuardVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretFile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &configuration{
+		ConfigItems: []botConfig{
+			{RepoFilter: libconfig.RepoFilter{Repos: []string{"org"}}},
+			{RepoFilter: libconfig.RepoFilter{Repos: []string{"other/repo"}}},
+			{RepoFilter: libconfig.RepoFilter{Repos: []string{"nosecret"}}},
+			{RepoFilter: libconfig.RepoFilter{Repos: []string{"broken"}}},
+		},
+		WebhookSecretFiles: map[string]string{
+			"org":    secretFile,
+			"other":  secretFile,
+			"broken": filepath.Join(dir, "missing"),
+		},
+	}
+
+	now := time.Unix(1600000000, 0)
+	stamp := func(t time.Time) string {
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	}
+	header := func(token, timestamp string) http.Header {
+		h := http.Header{}
+		h.Set("X-Gitee-Token", token)
+		h.Set("X-Gitee-Timestamp", timestamp)
+		return h
+	}
+	signed := func(t time.Time) http.Header {
+		ts := stamp(t)
+		return header(signWebhook([]byte("s3cret"), ts), ts)
+	}
+
+	cases := []struct {
+		name   string
+		org    string
+		header http.Header
+		status int
+	}{
+		{
+			name:   "signed",
+			org:    "org",
+			header: signed(now),
+		},
+		{
+			name:   "org of a configured repo",
+			org:    "other",
+			header: signed(now.Add(-time.Second)),
+		},
+		{
+			name:   "replayed",
+			org:    "org",
+			header: signed(now),
+			status: http.StatusConflict,
+		},
+		{
+			name:   "wrong secret",
+			org:    "org",
+			header: header(signWebhook([]byte("guess"), stamp(now)), stamp(now)),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "signature of another timestamp",
+			org:    "org",
+			header: header(signWebhook([]byte("s3cret"), stamp(now)), stamp(now.Add(time.Second))),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "stale",
+			org:    "org",
+			header: signed(now.Add(-webhookReplayWindow - time.Second)),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "from the future",
+			org:    "org",
+			header: signed(now.Add(webhookReplayWindow + time.Second)),
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "invalid timestamp",
+			org:    "org",
+			header: header(signWebhook([]byte("s3cret"), "now"), "now"),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unsigned",
+			org:    "org",
+			header: http.Header{},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "org not configured",
+			org:    "unknown",
+			header: signed(now.Add(2 * time.Second)),
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "empty org",
+			org:    "",
+			header: signed(now.Add(3 * time.Second)),
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "org without secret",
+			org:    "nosecret",
+			header: http.Header{},
+		},
+		{
+			name:   "org with an unreadable secret",
+			org:    "broken",
+			header: signed(now.Add(4 * time.Second)),
+			status: http.StatusInternalServerError,
+		},
+	}
+
+	g := &webhookGuard{seen: newDeliveryCache(webhookSeenDeliveries)}
+
+	for _, c := range cases {
+		status, err := g.verify(cfg, c.org, c.header, now)
+		if status != c.status {
+			t.Errorf("%s: expect status %d, but got %d (%v)", c.name, c.status, status, err)
+		}
+		if (err == nil) != (c.status == 0) {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		}
+	}
+}
+
+func TestDeliveryCache(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	d := newDeliveryCache(2)
+
+	cases := []struct {
+		key     string
+		expires time.Duration
+		at      time.Time
+		isNew   bool
+	}{
+		{key: "a", expires: 2, at: now, isNew: true},
+		{key: "a", expires: 2, at: now, isNew: false},
+		{key: "b", expires: 1, at: now, isNew: true},
+		// Evicts b, which expires first.
+		{key: "c", expires: 3, at: now, isNew: true},
+		{key: "a", expires: 2, at: now, isNew: false},
+		// b is forgotten, and adding it evicts a.
+		{key: "b", expires: 4, at: now, isNew: true},
+		{key: "c", expires: 3, at: now, isNew: false},
+		// c has expired and is forgotten, so b is kept.
+		{key: "d", expires: 5, at: now.Add(3*time.Minute + time.Second), isNew: true},
+		{key: "b", expires: 4, at: now.Add(3*time.Minute + time.Second), isNew: false},
+		{key: "c", expires: 6, at: now.Add(3*time.Minute + time.Second), isNew: true},
+	}
+
+	for i, c := range cases {
+		if v := d.add(c.key, now.Add(c.expires*time.Minute), c.at); v != c.isNew {
+			t.Errorf("case %d: expect %t for %s, but got %t", i, c.isNew, c.key, v)
+		}
+	}
+}
+
+func TestUnverifiedWebhookAllowed(t *testing.T) {
+	cases := []struct {
+		name    string
+		secrets map[string]string
+		org     string
+		allowed bool
+	}{
+		{
+			name:    "no secret at all",
+			org:     "org",
+			allowed: true,
+		},
+		{
+			name:    "secret of another org",
+			secrets: map[string]string{"other": "secret"},
+			org:     "org",
+			allowed: true,
+		},
+		{
+			name:    "secret of the org",
+			secrets: map[string]string{"org": "secret"},
+			org:     "org",
+		},
+		{
+			name:    "secret of all the orgs",
+			secrets: map[string]string{"*": "secret"},
+			org:     "org",
+		},
+	}
+
+	for _, c := range cases {
+		err := unverifiedWebhookAllowed(&configuration{WebhookSecretFiles: c.secrets}, c.org)
+		if (err == nil) != c.allowed {
+			t.Errorf("%s: expect allowed %t, but got %v", c.name, c.allowed, err)
+		}
+	}
+}
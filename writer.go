@@ -0,0 +1,289 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	sdk "github.com/opensourceways/go-gitee/gitee"
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensourceways/robot-gitee-approve/metrics"
+)
+
+const (
+	commentCreate = "create"
+	commentUpdate = "update"
+	commentDelete = "delete"
+
+	// commentWriteRetries is the max number of the retries of a write of
+	// comments failing with a transient error.
+	commentWriteRetries = 2
+)
+
+// commentOp is a write of comments waiting to be made.
+type commentOp struct {
+	kind    string
+	org     string
+	repo    string
+	number  int32
+	id      int32
+	comment string
+}
+
+func (op *commentOp) lane() string {
+	return op.org + "/" + op.repo
+}
+
+// commentWriter makes the writes of comments asynchronously, so that the slow
+// comment APIs don't block the handling of events. The writes of a repo are
+// made in order by the same worker, which keeps the order of the writes of
+// every PR, and the writes queued are made in batches spaced by interval to
+// smooth the bursts under the rate limits. Listing the comments of a PR waits
+// for the pending writes of its repo, so that the handling sees the comments
+// it made before.
+type commentWriter struct {
+	iClient
+
+	interval time.Duration
+	workers  []*writerWorker
+	wg       sync.WaitGroup
+}
+
+// writerWorker makes the writes of the repos assigned to it.
+type writerWorker struct {
+	lock sync.Mutex
+	// idle is signaled whenever a write is done.
+	idle *sync.Cond
+	ops  []commentOp
+	// pending is the number of the writes of each repo which are queued or
+	// being made.
+	pending map[string]int
+	stopped bool
+
+	wakeCh chan struct{}
+}
+
+func newCommentWriter(cli iClient, workers int, interval time.Duration) *commentWriter {
+	w := &commentWriter{iClient: cli, interval: interval}
+
+	for i := 0; i < workers; i++ {
+		v := &writerWorker{pending: map[string]int{}, wakeCh: make(chan struct{}, 1)}
+		v.idle = sync.NewCond(&v.lock)
+		w.workers = append(w.workers, v)
+	}
+
+	return w
+}
+
+func (w *commentWriter) start() {
+	for _, v := range w.workers {
+		w.wg.Add(1)
+		go func(v *writerWorker) {
+			defer w.wg.Done()
+			w.run(v)
+		}(v)
+	}
+}
+
+// stop makes the writes queued and waits for them to be done.
+func (w *commentWriter) stop() {
+	for _, v := range w.workers {
+		v.lock.Lock()
+		v.stopped = true
+		v.lock.Unlock()
+
+		v.wake()
+	}
+
+	w.wg.Wait()
+}
+
+func (w *commentWriter) worker(lane string) *writerWorker {
+	h := fnv.New32a()
+	h.Write([]byte(lane))
+
+	return w.workers[h.Sum32()%uint32(len(w.workers))]
+}
+
+func (w *commentWriter) enqueue(op commentOp) {
+	w.worker(op.lane()).add(op)
+}
+
+// pendingWrites returns the number of the writes queued or being made.
+func (w *commentWriter) pendingWrites() int {
+	if w == nil {
+		return 0
+	}
+
+	n := 0
+	for _, v := range w.workers {
+		v.lock.Lock()
+		for _, c := range v.pending {
+			n += c
+		}
+		v.lock.Unlock()
+	}
+
+	return n
+}
+
+func (w *commentWriter) run(v *writerWorker) {
+	for {
+		batch, stopped := v.take()
+		if len(batch) == 0 {
+			if stopped {
+				return
+			}
+
+			<-v.wakeCh
+			continue
+		}
+
+		for i := range batch {
+			if i > 0 && w.interval > 0 {
+				time.Sleep(w.interval)
+			}
+
+			w.write(&batch[i])
+			v.done(batch[i].lane())
+		}
+	}
+}
+
+// write makes the write, retrying it if it fails with a transient error. The
+// error is logged only because the handling which queued it has finished.
+func (w *commentWriter) write(op *commentOp) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		switch op.kind {
+		case commentCreate:
+			err = w.iClient.CreatePRComment(op.org, op.repo, op.number, op.comment)
+		case commentUpdate:
+			err = w.iClient.UpdatePRComment(op.org, op.repo, op.id, op.comment)
+		case commentDelete:
+			err = w.iClient.DeletePRComment(op.org, op.repo, op.id)
+		}
+
+		if err == nil || attempt >= commentWriteRetries || !isTransient(err) {
+			break
+		}
+
+		time.Sleep(retryDelay(attempt + 1))
+	}
+
+	if err != nil {
+		metrics.CommentWriteFailed(op.org, op.repo, op.kind)
+
+		logrus.WithFields(logrus.Fields{
+			"org": op.org, "repo": op.repo, "number": op.number, "comment_id": op.id,
+		}).WithError(err).Errorf("%s comment", op.kind)
+	}
+}
+
+// add queues the write. A write replacing the content of a comment which has
+// not been made yet replaces the queued one, and deleting a comment drops the
+// queued writes replacing its content.
+func (v *writerWorker) add(op commentOp) {
+	v.lock.Lock()
+
+	lane := op.lane()
+	merged := false
+
+	if op.kind == commentUpdate || op.kind == commentDelete {
+		kept := v.ops[:0]
+		for _, q := range v.ops {
+			if q.kind != commentUpdate || q.id != op.id || q.lane() != lane {
+				kept = append(kept, q)
+				continue
+			}
+
+			if op.kind == commentUpdate && !merged {
+				q.comment = op.comment
+				kept = append(kept, q)
+				merged = true
+			} else {
+				v.pending[lane]--
+			}
+		}
+		v.ops = kept
+	}
+
+	if !merged {
+		v.ops = append(v.ops, op)
+		v.pending[lane]++
+	}
+
+	v.lock.Unlock()
+
+	v.wake()
+}
+
+// take returns all the writes queued and whether the worker is stopped.
+func (v *writerWorker) take() ([]commentOp, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	batch := v.ops
+	v.ops = nil
+
+	return batch, v.stopped
+}
+
+func (v *writerWorker) done(lane string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.pending[lane]--; v.pending[lane] <= 0 {
+		delete(v.pending, lane)
+	}
+
+	v.idle.Broadcast()
+}
+
+// waitFor waits until the writes of the repo queued or being made are done.
+func (v *writerWorker) waitFor(lane string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for v.pending[lane] > 0 {
+		v.idle.Wait()
+	}
+}
+
+func (v *writerWorker) wake() {
+	select {
+	case v.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (w *commentWriter) ListPRComments(org, repo string, number int32) ([]sdk.PullRequestComments, error) {
+	lane := org + "/" + repo
+	w.worker(lane).waitFor(lane)
+
+	return w.iClient.ListPRComments(org, repo, number)
+}
+
+// GetPRComment waits for the pending writes like ListPRComments.
+func (w *commentWriter) GetPRComment(org, repo string, commentID int32) (sdk.PullRequestComments, error) {
+	lane := org + "/" + repo
+	w.worker(lane).waitFor(lane)
+
+	return w.iClient.GetPRComment(org, repo, commentID)
+}
+
+func (w *commentWriter) DeletePRComment(org, repo string, ID int32) error {
+	w.enqueue(commentOp{kind: commentDelete, org: org, repo: repo, id: ID})
+	return nil
+}
+
+func (w *commentWriter) UpdatePRComment(org, repo string, commentID int32, comment string) error {
+	w.enqueue(commentOp{kind: commentUpdate, org: org, repo: repo, id: commentID, comment: comment})
+	return nil
+}
+
+func (w *commentWriter) CreatePRComment(org, repo string, number int32, comment string) error {
+	w.enqueue(commentOp{kind: commentCreate, org: org, repo: repo, number: number, comment: comment})
+	return nil
+}